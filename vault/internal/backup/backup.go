@@ -0,0 +1,285 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	// Magic prefixes every backup archive, so foreign files are rejected early
+	Magic = "VLTBAK"
+	// Version is the current version of the archive layout
+	Version = 1
+
+	saltLength = 16
+	keyLength  = 32
+	// scrypt cost parameters, as recommended for interactive logins in https://pkg.go.dev/golang.org/x/crypto/scrypt
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	ErrPassphraseEmpty     = errors.New("backup passphrase is empty")
+	ErrInvalidArchive      = errors.New("invalid backup archive")
+	ErrUnsupportedVersion  = errors.New("unsupported backup archive version")
+	ErrDecryptionFailed    = errors.New("backup archive could not be decrypted: wrong passphrase or corrupted archive")
+	ErrIntegrityCheck      = errors.New("backup archive failed integrity check")
+	ErrRestoreKeyConflict  = errors.New("key in backup archive already exists in store")
+	ErrRestoreCipherInUse  = errors.New("target store is not empty and uses a different cipher")
+	ErrRestoreFailed       = errors.New("restore failed. the store was left as it was")
+	ErrBackupRetrieveStore = errors.New("error while retrieving store contents")
+)
+
+// Archive is the plaintext content of a vault backup: the raw store entries and the key material needed to read them.
+type Archive struct {
+	Version   int               `json:"version"`
+	CreatedAt int64             `json:"created_at"`
+	Cipher    map[string]string `json:"cipher"`
+	Entries   map[string]string `json:"entries"`
+	Checksum  string            `json:"checksum"`
+}
+
+// RestoreOptions controls how an archive is written into a store
+type RestoreOptions struct {
+	// Force deletes the entries of the target store the archive doesn't hold, and overrides the others, so the archive
+	// fully replaces its contents
+	Force bool
+}
+
+// New snapshots the store and cipher managed by m into an Archive. Wrapped values are left out: restoring them would
+// let values already unwrapped be unwrapped again. The transit keyring is kept, as what it encrypted can't be decrypted
+// without it.
+func New(ctx context.Context, m *tokenize.Manager) (*Archive, error) {
+	entries, err := m.Store().RetrieveAll(tokenize.System(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBackupRetrieveStore, err.Error())
+	}
+	for k := range entries {
+		if keys.IsUnder(k, tokenize.WrapPrefix) {
+			delete(entries, k)
+		}
+	}
+
+	a := &Archive{
+		Version:   Version,
+		CreatedAt: time.Now().Unix(),
+		Cipher:    m.Cipher(),
+		Entries:   entries,
+	}
+	a.Checksum = a.checksum()
+	return a, nil
+}
+
+// checksum computes a stable sha256 digest over the archive cipher and entries
+func (a *Archive) checksum() string {
+	h := sha256.New()
+	for _, section := range []map[string]string{a.Cipher, a.Entries} {
+		keys := make([]string, 0, len(section))
+		for k := range section {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(section[k]), section[k])
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Seal compresses and encrypts the archive with a key derived from passphrase.
+// Layout: magic | version | salt | nonce | AES-256-GCM(gzip(json(archive))), with the header authenticated as additional data.
+func (a *Archive) Seal(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseEmpty
+	}
+
+	// compress the json representation of the archive
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if err := json.NewEncoder(zw).Encode(a); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(Magic)+1+saltLength+len(nonce))
+	header = append(header, Magic...)
+	header = append(header, byte(Version))
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	return gcm.Seal(header, nonce, compressed.Bytes(), header), nil
+}
+
+// Open decrypts, decompresses and verifies a sealed archive
+func Open(sealed, passphrase []byte) (*Archive, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseEmpty
+	}
+
+	prefix := len(Magic) + 1 + saltLength
+	if len(sealed) < prefix || string(sealed[:len(Magic)]) != Magic {
+		return nil, ErrInvalidArchive
+	}
+	if int(sealed[len(Magic)]) != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, sealed[len(Magic)])
+	}
+	salt := sealed[len(Magic)+1 : prefix]
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < prefix+gcm.NonceSize() {
+		return nil, ErrInvalidArchive
+	}
+	header := sealed[:prefix+gcm.NonceSize()]
+	nonce := header[prefix:]
+
+	compressed, err := gcm.Open(nil, nonce, sealed[len(header):], header)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArchive, err.Error())
+	}
+	defer zr.Close()
+
+	a := &Archive{}
+	if err = json.NewDecoder(zr).Decode(a); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArchive, err.Error())
+	}
+
+	if a.Checksum != a.checksum() {
+		return nil, ErrIntegrityCheck
+	}
+	return a, nil
+}
+
+// newGCM derives the archive key from passphrase and salt, and returns the AEAD cipher built from it
+func newGCM(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Restore writes the archive entries into the store managed by m, and installs the archived cipher so the restored tokens can be detokenized.
+// The archive is checked before anything is changed, and its entries are written in a single batch, together with the
+// deletion of the existing entries when forced: the restore applies whole, or the store is left as it was and the
+// previous cipher is installed again. The entries are written to the store directly, so no events are published:
+// subscribers to the changes of keys, e.g. webhooks, see none of the restore, and have to read the store again after it.
+func (a *Archive) Restore(ctx context.Context, m *tokenize.Manager, opts RestoreOptions) error {
+	if a.Checksum != a.checksum() {
+		return ErrIntegrityCheck
+	}
+	if err := tokenize.ValidateCipher(a.Cipher); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+
+	// the archive replaces the reserved keys too
+	ctx = tokenize.System(ctx)
+	s := m.Store()
+	existing, err := s.RetrieveAll(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBackupRetrieveStore, err.Error())
+	}
+
+	var ops []store.Op
+	if opts.Force {
+		// existing entries the archive doesn't replace are deleted, unless changed meanwhile
+		for k, v := range existing {
+			if _, ok := a.Entries[k]; !ok || keys.IsUnder(k, tokenize.WrapPrefix) {
+				v := v
+				ops = append(ops, store.Op{Kind: store.OpDelete, ID: k, Expect: &v})
+			}
+		}
+	} else {
+		// refuse to mix the archive with existing tokens encrypted under another cipher, or to override existing keys
+		if len(existing) > 0 && !sameCipher(a, m) {
+			return ErrRestoreCipherInUse
+		}
+		for k := range a.Entries {
+			if _, ok := existing[k]; ok {
+				return fmt.Errorf("%w: %s", ErrRestoreKeyConflict, k)
+			}
+		}
+	}
+	for k, v := range a.Entries {
+		// archives of earlier versions may hold wrapped values
+		if keys.IsUnder(k, tokenize.WrapPrefix) {
+			continue
+		}
+		op := store.Op{Kind: store.OpStore, ID: k, Token: v}
+		if current, ok := existing[k]; ok {
+			op.Kind, op.Expect = store.OpPatch, &current
+		}
+		ops = append(ops, op)
+	}
+
+	previous := m.Cipher()
+	if err = m.SetCipher(a.Cipher); err != nil {
+		return err
+	}
+	if err = s.Batch(ctx, ops); err != nil {
+		if len(previous) > 0 {
+			if rerr := m.SetCipher(previous); rerr != nil {
+				return fmt.Errorf("%w: %w, and the previous cipher could not be installed again: %s", ErrRestoreFailed, err, rerr)
+			}
+		}
+		return fmt.Errorf("%w: %w", ErrRestoreFailed, err)
+	}
+	return nil
+}
+
+// sameCipher reports whether the manager's current cipher matches the archive's cipher
+func sameCipher(a *Archive, m *tokenize.Manager) bool {
+	current := m.Cipher()
+	for _, k := range []string{tokenize.EnvKeyAESCipher, tokenize.EnvKeyInitializationVector} {
+		if current[k] != a.Cipher[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+// Package cache keeps the values read from a store in a bounded LRU cache, so repeated reads of the same keys, e.g.
+// detokenizing the same tokens, don't each make a round trip to a remote store.
+//
+// Entries expire after a TTL, and are invalidated by every write going through the cache. Writes made by other vault
+// instances sharing the store are announced by an Invalidator, so the caches of every instance stay coherent.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/rs/xid"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSize is the number of entries cached, if no size is configured
+	DefaultSize = 10000
+	// DefaultTTL is how long an entry is cached, if no TTL is configured
+	DefaultTTL = time.Minute
+)
+
+// Config configures a cache
+type Config struct {
+	// Size is the number of entries cached, beyond which the least recently used are evicted
+	Size int
+	// TTL is how long an entry is cached. It bounds how stale a value can be if an invalidation is missed.
+	TTL time.Duration
+	// Invalidator announces writes to the other instances sharing the store, if set
+	Invalidator Invalidator
+}
+
+// Stats are the counts of a cache since it was created
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// entry is a cached value
+type entry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// Store is a store.Store caching the values read from the store it wraps
+type Store struct {
+	s      store.Store
+	cfg    Config
+	origin string
+	now    func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	// gen is bumped by every invalidation, so reads that raced with one don't cache what they read
+	gen   uint64
+	stats Stats
+
+	unsubscribe func()
+}
+
+// New wraps s with a cache configured by cfg
+func New(s store.Store, cfg Config) *Store {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultSize
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	return &Store{
+		s:      s,
+		cfg:    cfg,
+		origin: xid.New().String(),
+		now:    time.Now,
+		lru:    list.New(),
+		items:  make(map[string]*list.Element, cfg.Size),
+	}
+}
+
+// Stats returns the counts of the cache
+func (c *Store) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
+
+// get returns the cached value of key, if it is cached and fresh, and the current generation
+func (c *Store) get(key string) (string, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			return e.value, true, c.gen
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	return "", false, c.gen
+}
+
+// put caches value at key, unless it was invalidated since generation gen
+func (c *Store) put(key, value string, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.lru.PushFront(&entry{key: key, value: value, expires: c.now().Add(c.cfg.TTL)})
+	for c.lru.Len() > c.cfg.Size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops an element of the lru. The lock must be held.
+func (c *Store) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
+
+// invalidate drops keys, or every entry if all
+func (c *Store) invalidate(keys []string, all bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if all {
+		c.lru.Init()
+		c.items = make(map[string]*list.Element, c.cfg.Size)
+		return
+	}
+	for _, k := range keys {
+		if el, ok := c.items[k]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// written invalidates keys after a write, here and in the other instances
+func (c *Store) written(ctx context.Context, keys []string, all bool) {
+	c.invalidate(keys, all)
+	if c.cfg.Invalidator != nil {
+		c.cfg.Invalidator.Publish(ctx, Invalidation{Origin: c.origin, Keys: keys, All: all})
+	}
+}
+
+// Connect connects the wrapped store, then listens to the invalidations of the other instances
+func (c *Store) Connect(ctx context.Context) (bool, error) {
+	ok, err := c.s.Connect(ctx)
+	if err != nil || !ok || c.cfg.Invalidator == nil || c.unsubscribe != nil {
+		return ok, err
+	}
+	c.unsubscribe, err = c.cfg.Invalidator.Subscribe(func(inv Invalidation) {
+		if inv.Origin != c.origin {
+			c.invalidate(inv.Keys, inv.All)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Store) Store(ctx context.Context, id string, token any) error {
+	err := c.s.Store(ctx, id, token)
+	c.written(ctx, []string{id}, false)
+	return err
+}
+
+// Retrieve returns the cached value of id, or reads it through from the wrapped store and caches it
+func (c *Store) Retrieve(ctx context.Context, id string) (string, error) {
+	v, ok, gen := c.get(id)
+	if ok {
+		return v, nil
+	}
+	v, err := c.s.Retrieve(ctx, id)
+	if err != nil {
+		return v, err
+	}
+	c.put(id, v, gen)
+	return v, nil
+}
+
+// RetrieveAll isn't cached, as it would hold the whole store
+func (c *Store) RetrieveAll(ctx context.Context) (map[string]string, error) {
+	return c.s.RetrieveAll(ctx)
+}
+
+// Scan isn't cached, as its pages depend on the state of the whole store
+func (c *Store) Scan(ctx context.Context, opts store.ScanOptions) (*store.Page, error) {
+	return c.s.Scan(ctx, opts)
+}
+
+func (c *Store) Delete(ctx context.Context, id string) (bool, error) {
+	ok, err := c.s.Delete(ctx, id)
+	c.written(ctx, []string{id}, false)
+	return ok, err
+}
+
+func (c *Store) Patch(ctx context.Context, id string, token any) (bool, error) {
+	ok, err := c.s.Patch(ctx, id, token)
+	c.written(ctx, []string{id}, false)
+	return ok, err
+}
+
+func (c *Store) Batch(ctx context.Context, ops []store.Op) error {
+	err := c.s.Batch(ctx, ops)
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.ID
+	}
+	c.written(ctx, keys, false)
+	return err
+}
+
+func (c *Store) Flush(ctx context.Context) (bool, error) {
+	ok, err := c.s.Flush(ctx)
+	c.written(ctx, nil, true)
+	return ok, err
+}
+
+// Close stops listening to invalidations, and closes the wrapped store
+func (c *Store) Close(ctx context.Context) error {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+		c.unsubscribe = nil
+	}
+	c.invalidate(nil, true)
+	return c.s.Close(ctx)
+}
+
+func (c *Store) Ping(ctx context.Context) (bool, error) {
+	return store.Ping(ctx, c.s)
+}
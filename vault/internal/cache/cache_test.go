@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type CacheTestSuite struct {
+	suite.Suite
+	base *countingStore
+}
+
+// countingStore counts the reads that reach the store it wraps
+type countingStore struct {
+	*store.Map
+	reads int
+}
+
+func (c *countingStore) Retrieve(ctx context.Context, id string) (string, error) {
+	c.reads++
+	return c.Map.Retrieve(ctx, id)
+}
+
+func (suite *CacheTestSuite) SetupTest() {
+	suite.base = &countingStore{Map: store.NewSyncMap(context.Background(), vlog.New(true))}
+}
+
+func (suite *CacheTestSuite) TestReadThrough() {
+	ctx := context.Background()
+	c := New(suite.base, Config{Size: 2, TTL: time.Minute})
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+	suite.Require().NoError(c.Store(ctx, "a", "1"))
+	suite.Require().NoError(c.Store(ctx, "b", "2"))
+	suite.Require().NoError(c.Store(ctx, "c", "3"))
+
+	// repeated reads are served by the cache
+	for i := 0; i < 3; i++ {
+		v, err := c.Retrieve(ctx, "a")
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equal("1", v)
+	}
+	suite.Require().Equal(1, suite.base.reads)
+
+	// the least recently used entry is evicted beyond the size
+	c.Retrieve(ctx, "b")
+	c.Retrieve(ctx, "a")
+	c.Retrieve(ctx, "c")
+	suite.Require().Equal(Stats{Hits: 3, Misses: 3, Evictions: 1, Entries: 2}, c.Stats())
+	c.Retrieve(ctx, "b")
+	suite.Require().Equal(4, suite.base.reads)
+
+	// writes invalidate what they touch
+	_, err := c.Patch(ctx, "c", "30")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	v, err := c.Retrieve(ctx, "c")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("30", v)
+	_, err = c.Delete(ctx, "c")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	_, err = c.Retrieve(ctx, "c")
+	suite.Require().Error(err)
+
+	// entries expire after the TTL
+	reads := suite.base.reads
+	now = now.Add(2 * time.Minute)
+	c.Retrieve(ctx, "b")
+	suite.Require().Equal(reads+1, suite.base.reads)
+}
+
+func (suite *CacheTestSuite) TestRacingInvalidation() {
+	ctx := context.Background()
+	c := New(suite.base, Config{})
+	suite.Require().NoError(c.Store(ctx, "a", "1"))
+
+	// a read that started before a write doesn't cache what it read
+	_, _, gen := c.get("a")
+	c.written(ctx, []string{"a"}, false)
+	c.put("a", "stale", gen)
+	_, ok, _ := c.get("a")
+	suite.Require().False(ok)
+}
+
+func (suite *CacheTestSuite) TestRedisInvalidation() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	mr := miniredis.RunT(suite.T())
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	inv := NewRedisInvalidator(func() redis.UniversalClient { return client }, "", log)
+
+	// two instances cache the same store
+	one, two := New(suite.base, Config{Invalidator: inv}), New(suite.base, Config{Invalidator: inv})
+	for _, c := range []*Store{one, two} {
+		_, err := c.Connect(ctx)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		defer c.Close(ctx)
+	}
+	suite.Require().NoError(one.Store(ctx, "a", "1"))
+	v, err := two.Retrieve(ctx, "a")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("1", v)
+
+	// a write through one reaches the cache of the other
+	_, err = one.Patch(ctx, "a", "2")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Eventually(func() bool {
+		v, err := two.Retrieve(ctx, "a")
+		return err == nil && v == "2"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, err = one.Flush(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Eventually(func() bool {
+		return two.Stats().Entries == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestCacheSuite tests the Cache suite
+func TestCacheSuite(t *testing.T) {
+	suite.Run(t, new(CacheTestSuite))
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultChannel is the Redis channel invalidations are published on, if none is configured
+const DefaultChannel = "vault:cache:invalidate"
+
+// Invalidation announces keys written by an instance, or a flush of every key
+type Invalidation struct {
+	// Origin identifies the instance that wrote the keys, which already invalidated them
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	All    bool     `json:"all,omitempty"`
+}
+
+// Invalidator carries invalidations between the instances sharing a store
+type Invalidator interface {
+	// Publish announces an invalidation to every instance. Failures are only logged: entries still expire.
+	Publish(ctx context.Context, inv Invalidation)
+	// Subscribe calls fn with every invalidation announced, until the returned func is called
+	Subscribe(fn func(Invalidation)) (func(), error)
+}
+
+// RedisInvalidator carries invalidations over Redis pub/sub
+type RedisInvalidator struct {
+	client  func() redis.UniversalClient
+	channel string
+	logger  *vlog.Logger
+}
+
+// NewRedisInvalidator carries invalidations on channel, or DefaultChannel if empty, of the client returned by client.
+// The client is only asked for once the cache connects, so it can be one the wrapped store creates when it connects.
+func NewRedisInvalidator(client func() redis.UniversalClient, channel string, logger *vlog.Logger) *RedisInvalidator {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	return &RedisInvalidator{client: client, channel: channel, logger: logger}
+}
+
+func (r *RedisInvalidator) Publish(ctx context.Context, inv Invalidation) {
+	b, err := json.Marshal(inv)
+	if err == nil {
+		err = r.client().Publish(ctx, r.channel, b).Err()
+	}
+	if err != nil {
+		r.logger.Logger().Error().Msgf("error publishing cache invalidation on %s: %s", r.channel, err)
+	}
+}
+
+func (r *RedisInvalidator) Subscribe(fn func(Invalidation)) (func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := r.client().Subscribe(ctx, r.channel)
+	// wait for the subscription to be confirmed, so no invalidation published after Subscribe returns is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		cancel()
+		sub.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range sub.Channel() {
+			var inv Invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				r.logger.Logger().Error().Msgf("error decoding cache invalidation on %s: %s", r.channel, err)
+				continue
+			}
+			fn(inv)
+		}
+	}()
+	return func() {
+		cancel()
+		sub.Close()
+		<-done
+	}, nil
+}
@@ -0,0 +1,465 @@
+// Package cluster replicates a store across vault nodes with Raft. Every node holds the whole store in memory, and
+// serves reads from it. Writes are committed to the Raft log by the leader, which followers forward them to, and are
+// applied by every node in log order. The log and its snapshots are kept on disk, so nodes recover their store on
+// restart.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ApplyTimeout bounds how long a write waits to be committed
+	ApplyTimeout = 10 * time.Second
+	// retainSnapshots is the number of snapshots kept on disk
+	retainSnapshots = 2
+)
+
+var (
+	ErrNoLeader      = errors.New("cluster has no leader")
+	ErrNotLeader     = errors.New("node is not the cluster leader")
+	ErrPeerUnknown   = errors.New("address of the cluster leader is unknown")
+	ErrConfigInvalid = errors.New("invalid cluster config. node id, raft address and api address are required")
+	ErrSecretMissing = errors.New("invalid cluster config. a cluster secret is required, to authenticate the nodes to each other")
+)
+
+// Config configures a cluster node
+type Config struct {
+	// NodeID identifies the node in the cluster. It must be stable across restarts.
+	NodeID string
+	// RaftAddr is the address the node replicates on, e.g. 10.0.0.1:8300. It is advertised to the other nodes, so it
+	// can't be an unspecified address like 0.0.0.0:8300.
+	RaftAddr string
+	// APIAddr is the address of the node's vault service, e.g. http://10.0.0.1:8080, which followers forward writes to
+	APIAddr string
+	// Dir holds the Raft log and snapshots of the node
+	Dir string
+	// Bootstrap starts a new cluster with the node as its only member, unless the node already has state
+	Bootstrap bool
+	// Secret is shared by the nodes of the cluster. It authenticates the writes and joins they forward to each other, and
+	// the Raft connections between them, which are encrypted with TLS. It is required.
+	Secret string
+}
+
+// Peer is a member of the cluster
+type Peer struct {
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_addr"`
+	APIAddr  string `json:"api_addr"`
+}
+
+// Status is the view of the cluster from a node
+type Status struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	Leader string `json:"leader"`
+	Peers  []Peer `json:"peers"`
+}
+
+// Options customize how a node is built. They are mostly meant for tests, which run clusters in process.
+type Options func(*Node)
+
+// WithTransport replicates over t, rather than over TLS on Config.RaftAddr
+func WithTransport(t raft.Transport) Options {
+	return func(n *Node) {
+		n.transport = t
+	}
+}
+
+// WithRaftStores keeps the Raft log, state and snapshots in the stores given, rather than in Config.Dir
+func WithRaftStores(logs raft.LogStore, stable raft.StableStore, snaps raft.SnapshotStore) Options {
+	return func(n *Node) {
+		n.logs, n.stable, n.snaps = logs, stable, snaps
+	}
+}
+
+// WithForwarder forwards requests to the leader with f, rather than over HTTP
+func WithForwarder(f Forwarder) Options {
+	return func(n *Node) {
+		n.forward = f
+	}
+}
+
+// WithRaftConfig adjusts the Raft config of the node, e.g. its timeouts
+func WithRaftConfig(fn func(*raft.Config)) Options {
+	return func(n *Node) {
+		n.tune = fn
+	}
+}
+
+// Node is a member of a cluster. It is a store.Store, replicated to every node of the cluster.
+type Node struct {
+	cfg       Config
+	log       *vlog.Logger
+	raft      *raft.Raft
+	fsm       *fsm
+	transport raft.Transport
+	logs      raft.LogStore
+	stable    raft.StableStore
+	snaps     raft.SnapshotStore
+	forward   Forwarder
+	tune      func(*raft.Config)
+	closers   []io.Closer
+	done      chan struct{}
+}
+
+// New starts the node configured by cfg. A node that neither bootstraps nor has state waits to be joined to a cluster.
+func New(ctx context.Context, cfg Config, logger *vlog.Logger, opts ...Options) (*Node, error) {
+	if cfg.NodeID == "" || cfg.RaftAddr == "" || cfg.APIAddr == "" {
+		return nil, ErrConfigInvalid
+	}
+	if cfg.Secret == "" {
+		return nil, ErrSecretMissing
+	}
+	n := &Node{cfg: cfg, log: logger, fsm: newFSM(ctx, logger), done: make(chan struct{})}
+	for i := 0; i < len(opts); i++ {
+		opts[i](n)
+	}
+	if n.forward == nil {
+		n.forward = HTTPForwarder(cfg.Secret)
+	}
+	if err := n.open(); err != nil {
+		n.closeAll()
+		return nil, err
+	}
+
+	rc := raft.DefaultConfig()
+	rc.LocalID = raft.ServerID(cfg.NodeID)
+	rc.Logger = hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Warn, Output: os.Stderr})
+	if n.tune != nil {
+		n.tune(rc)
+	}
+
+	r, err := raft.NewRaft(rc, n.fsm, n.logs, n.stable, n.snaps, n.transport)
+	if err != nil {
+		n.closeAll()
+		return nil, err
+	}
+	n.raft = r
+
+	if cfg.Bootstrap {
+		existing, err := raft.HasExistingState(n.logs, n.stable, n.snaps)
+		if err != nil {
+			n.Close(ctx)
+			return nil, err
+		}
+		if !existing {
+			servers := []raft.Server{{ID: rc.LocalID, Address: n.transport.LocalAddr()}}
+			if err = r.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil {
+				n.Close(ctx)
+				return nil, err
+			}
+		}
+	}
+
+	go n.announce()
+	logger.Logger().Info().Msgf("started cluster node %s replicating on %s", cfg.NodeID, n.transport.LocalAddr())
+	return n, nil
+}
+
+// open creates the transport and Raft stores not given as options, on disk and over TCP with TLS
+func (n *Node) open() error {
+	if n.logs == nil {
+		if err := os.MkdirAll(n.cfg.Dir, 0700); err != nil {
+			return err
+		}
+		bolt, err := raftboltdb.NewBoltStore(filepath.Join(n.cfg.Dir, "raft.db"))
+		if err != nil {
+			return err
+		}
+		n.closers = append(n.closers, bolt)
+		n.logs, n.stable = bolt, bolt
+		if n.snaps, err = raft.NewFileSnapshotStore(n.cfg.Dir, retainSnapshots, os.Stderr); err != nil {
+			return err
+		}
+	}
+	if n.transport == nil {
+		stream, err := newStreamLayer(n.cfg.RaftAddr, n.cfg.Secret, n.log)
+		if err != nil {
+			return err
+		}
+		t := raft.NewNetworkTransport(stream, 3, 10*time.Second, os.Stderr)
+		n.closers = append(n.closers, t)
+		n.transport = t
+	}
+	return nil
+}
+
+// closeAll closes the transport and stores the node opened
+func (n *Node) closeAll() {
+	for i := len(n.closers) - 1; i >= 0; i-- {
+		n.closers[i].Close()
+	}
+	n.closers = nil
+}
+
+// announce records the API address of the node in the cluster whenever it becomes leader, so followers know where to
+// forward writes. Followers are recorded by the leader as they join.
+func (n *Node) announce() {
+	for {
+		select {
+		case <-n.done:
+			return
+		case leader := <-n.raft.LeaderCh():
+			if !leader {
+				continue
+			}
+			if p, ok := n.fsm.peer(n.cfg.NodeID); ok && p == n.self() {
+				continue
+			}
+			if err := n.apply(command{Kind: cmdPeer, Peer: ptr(n.self())}).Err(); err != nil {
+				n.log.Logger().Error().Msgf("error recording the address of cluster node %s: %s", n.cfg.NodeID, err)
+			}
+		}
+	}
+}
+
+// self is the node as a peer
+func (n *Node) self() Peer {
+	return Peer{ID: n.cfg.NodeID, RaftAddr: string(n.transport.LocalAddr()), APIAddr: n.cfg.APIAddr}
+}
+
+// Secret returns the secret shared by the nodes of the cluster
+func (n *Node) Secret() string {
+	return n.cfg.Secret
+}
+
+// IsLeader reports whether the node is the cluster leader
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Status returns the view of the cluster from the node
+func (n *Node) Status() (*Status, error) {
+	s := &Status{ID: n.cfg.NodeID, State: n.raft.State().String()}
+	_, leader := n.raft.LeaderWithID()
+	s.Leader = string(leader)
+
+	future := n.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	for _, srv := range future.Configuration().Servers {
+		p, _ := n.fsm.peer(string(srv.ID))
+		p.ID, p.RaftAddr = string(srv.ID), string(srv.Address)
+		s.Peers = append(s.Peers, p)
+	}
+	return s, nil
+}
+
+// Join adds p to the cluster as a voter. Followers forward joins to the leader.
+func (n *Node) Join(ctx context.Context, p Peer) error {
+	if p.ID == "" || p.RaftAddr == "" || p.APIAddr == "" {
+		return ErrConfigInvalid
+	}
+	if !n.IsLeader() {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return n.forwardTo(ctx, JoinPath, b).Err()
+	}
+
+	// a node rejoining from a new address replaces its old self
+	future := n.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	for _, srv := range future.Configuration().Servers {
+		if srv.ID == raft.ServerID(p.ID) && srv.Address == raft.ServerAddress(p.RaftAddr) {
+			return n.apply(command{Kind: cmdPeer, Peer: &p}).Err()
+		}
+		if srv.ID == raft.ServerID(p.ID) || srv.Address == raft.ServerAddress(p.RaftAddr) {
+			if err := n.raft.RemoveServer(srv.ID, 0, ApplyTimeout).Error(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := n.raft.AddVoter(raft.ServerID(p.ID), raft.ServerAddress(p.RaftAddr), 0, ApplyTimeout).Error(); err != nil {
+		return err
+	}
+	n.log.Logger().Info().Msgf("cluster node %s joined from %s", p.ID, p.RaftAddr)
+	return n.apply(command{Kind: cmdPeer, Peer: &p}).Err()
+}
+
+// JoinCluster asks the node of the vault service at addr to add this node to its cluster, retrying until ctx is done
+func (n *Node) JoinCluster(ctx context.Context, addr string) error {
+	b, err := json.Marshal(n.self())
+	if err != nil {
+		return err
+	}
+	for {
+		err = n.forward(ctx, Peer{APIAddr: addr}, JoinPath, b).Err()
+		if err == nil {
+			return nil
+		}
+		n.log.Logger().Debug().Msgf("error joining cluster through %s, retrying: %s", addr, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("joining cluster through %s: %w", addr, err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// ApplyForwarded commits a write forwarded by a follower. It fails if the node isn't the leader anymore.
+func (n *Node) ApplyForwarded(b []byte) *Reply {
+	var c command
+	if err := json.Unmarshal(b, &c); err != nil {
+		return replyOf(false, err)
+	}
+	if !n.IsLeader() {
+		return replyOf(false, ErrNotLeader)
+	}
+	return n.apply(c)
+}
+
+// apply commits c to the log, and returns the outcome of applying it
+func (n *Node) apply(c command) *Reply {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return replyOf(false, err)
+	}
+	future := n.raft.Apply(b, ApplyTimeout)
+	if err = future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			err = fmt.Errorf("%w: %s", ErrNotLeader, err)
+		}
+		return replyOf(false, err)
+	}
+	reply, ok := future.Response().(*Reply)
+	if !ok {
+		return replyOf(false, fmt.Errorf("unexpected outcome of applying %s", c.Kind))
+	}
+	reply.Index = future.Index()
+	return reply
+}
+
+// write commits c on the leader, which is either the node or the one it forwards c to
+func (n *Node) write(ctx context.Context, c command) (bool, error) {
+	if n.IsLeader() {
+		r := n.apply(c)
+		return r.OK, r.Err()
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return false, err
+	}
+	r := n.forwardTo(ctx, ApplyPath, b)
+	if r.Index > 0 {
+		n.await(ctx, r.Index)
+	}
+	return r.OK, r.Err()
+}
+
+// await waits until the node applied the log up to index, so reads following a forwarded write see it. It gives up
+// after ApplyTimeout, leaving reads stale.
+func (n *Node) await(ctx context.Context, index uint64) {
+	deadline := time.Now().Add(ApplyTimeout)
+	for n.raft.AppliedIndex() < index && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.done:
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+// forwardTo forwards a request to the leader
+func (n *Node) forwardTo(ctx context.Context, path string, b []byte) *Reply {
+	_, id := n.raft.LeaderWithID()
+	if id == "" {
+		return replyOf(false, ErrNoLeader)
+	}
+	p, ok := n.fsm.peer(string(id))
+	if !ok {
+		return replyOf(false, fmt.Errorf("%w: %s", ErrPeerUnknown, id))
+	}
+	return n.forward(ctx, p, path, b)
+}
+
+// Connect is a no-op: the node joined its cluster when it was created
+func (n *Node) Connect(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (n *Node) Store(ctx context.Context, id string, token any) error {
+	tokenStr, ok := token.(string)
+	if !ok {
+		return errors.New(store.ErrTokenTypeNotString)
+	}
+	_, err := n.write(ctx, command{Kind: cmdStore, ID: id, Token: tokenStr})
+	return err
+}
+
+func (n *Node) Retrieve(ctx context.Context, id string) (string, error) {
+	return n.fsm.store.Retrieve(ctx, id)
+}
+
+func (n *Node) RetrieveAll(ctx context.Context) (map[string]string, error) {
+	return n.fsm.store.RetrieveAll(ctx)
+}
+
+func (n *Node) Scan(ctx context.Context, opts store.ScanOptions) (*store.Page, error) {
+	return n.fsm.store.Scan(ctx, opts)
+}
+
+func (n *Node) Delete(ctx context.Context, id string) (bool, error) {
+	return n.write(ctx, command{Kind: cmdDelete, ID: id})
+}
+
+func (n *Node) Patch(ctx context.Context, id string, token any) (bool, error) {
+	tokenStr, ok := token.(string)
+	if !ok {
+		return false, errors.New(store.ErrTokenTypeNotString)
+	}
+	return n.write(ctx, command{Kind: cmdPatch, ID: id, Token: tokenStr})
+}
+
+func (n *Node) Batch(ctx context.Context, ops []store.Op) error {
+	_, err := n.write(ctx, command{Kind: cmdBatch, Ops: ops})
+	return err
+}
+
+func (n *Node) Flush(ctx context.Context) (bool, error) {
+	return n.write(ctx, command{Kind: cmdFlush})
+}
+
+// Close leaves the node's cluster running without it, and releases its log and transport
+func (n *Node) Close(ctx context.Context) error {
+	select {
+	case <-n.done:
+		return nil
+	default:
+		close(n.done)
+	}
+	err := n.raft.Shutdown().Error()
+	n.closeAll()
+	return err
+}
+
+// Ping checks that the cluster has a leader, without which it can't take writes
+func (n *Node) Ping(ctx context.Context) (bool, error) {
+	if _, id := n.raft.LeaderWithID(); id == "" {
+		return false, ErrNoLeader
+	}
+	return true, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ClusterTestSuite struct {
+	suite.Suite
+	mu    sync.Mutex
+	nodes map[string]*Node
+}
+
+// forward delivers forwarded requests to the nodes of the suite, as their services would over HTTP
+func (suite *ClusterTestSuite) forward(ctx context.Context, peer Peer, path string, b []byte) *Reply {
+	suite.mu.Lock()
+	var n *Node
+	for _, node := range suite.nodes {
+		if node.cfg.APIAddr == peer.APIAddr {
+			n = node
+		}
+	}
+	suite.mu.Unlock()
+	if n == nil {
+		return replyOf(false, fmt.Errorf("no node at %s", peer.APIAddr))
+	}
+	if path == JoinPath {
+		var p Peer
+		if err := json.Unmarshal(b, &p); err != nil {
+			return replyOf(false, err)
+		}
+		err := n.Join(ctx, p)
+		return replyOf(err == nil, err)
+	}
+	return n.ApplyForwarded(b)
+}
+
+// SetupTest starts an in-process cluster of three nodes, replicating over in-memory transports
+func (suite *ClusterTestSuite) SetupTest() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	suite.nodes = map[string]*Node{}
+
+	transports := map[string]*raft.InmemTransport{}
+	for _, id := range []string{"n1", "n2", "n3"} {
+		_, t := raft.NewInmemTransport(raft.ServerAddress(id))
+		for other, o := range transports {
+			t.Connect(raft.ServerAddress(other), o)
+			o.Connect(raft.ServerAddress(id), t)
+		}
+		transports[id] = t
+	}
+
+	fast := WithRaftConfig(func(c *raft.Config) {
+		c.HeartbeatTimeout = 100 * time.Millisecond
+		c.ElectionTimeout = 100 * time.Millisecond
+		c.LeaderLeaseTimeout = 100 * time.Millisecond
+		c.CommitTimeout = 5 * time.Millisecond
+	})
+	for _, id := range []string{"n1", "n2", "n3"} {
+		logs := raft.NewInmemStore()
+		cfg := Config{NodeID: id, RaftAddr: id, APIAddr: "http://" + id, Bootstrap: id == "n1", Secret: "s3cr3t"}
+		n, err := New(ctx, cfg, log, WithTransport(transports[id]), WithRaftStores(logs, logs, raft.NewInmemSnapshotStore()), WithForwarder(suite.forward), fast)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.mu.Lock()
+		suite.nodes[id] = n
+		suite.mu.Unlock()
+	}
+	suite.Require().Eventually(suite.nodes["n1"].IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	// nodes join through any member, which forwards to the leader
+	joinCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	suite.Require().NoError(suite.nodes["n2"].JoinCluster(joinCtx, "http://n1"))
+	suite.Require().NoError(suite.nodes["n3"].JoinCluster(joinCtx, "http://n2"))
+}
+
+func (suite *ClusterTestSuite) TearDownTest() {
+	for _, n := range suite.nodes {
+		n.Close(context.Background())
+	}
+}
+
+// converged waits until every running node holds value at key
+func (suite *ClusterTestSuite) converged(key, value string) {
+	for id, n := range suite.nodes {
+		suite.Require().Eventuallyf(func() bool {
+			v, err := n.Retrieve(context.Background(), key)
+			return err == nil && v == value
+		}, 5*time.Second, 10*time.Millisecond, "expected node %s to hold %s=%s", id, key, value)
+	}
+}
+
+func (suite *ClusterTestSuite) TestReplication() {
+	ctx := context.Background()
+	status, err := suite.nodes["n3"].Status()
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(status.Peers, 3)
+	suite.Require().Equal("n1", status.Leader)
+
+	// writes to followers are forwarded to the leader, and read back from the follower right away
+	suite.Require().NoError(suite.nodes["n3"].Store(ctx, "app/db", "t1"))
+	v, err := suite.nodes["n3"].Retrieve(ctx, "app/db")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("t1", v)
+	suite.converged("app/db", "t1")
+
+	// failures committed on the leader keep their identity on the follower
+	err = suite.nodes["n2"].Batch(ctx, []store.Op{{Kind: store.OpStore, ID: "app/db", Token: "t2"}})
+	suite.Require().ErrorIs(err, store.ErrBatchKeyExists)
+	stale := "t0"
+	err = suite.nodes["n2"].Batch(ctx, []store.Op{{Kind: store.OpPatch, ID: "app/db", Token: "t2", Expect: &stale}})
+	suite.Require().ErrorIs(err, store.ErrBatchConflict)
+
+	ok, err := suite.nodes["n2"].Patch(ctx, "app/db", "t2")
+	suite.Require().True(ok)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.converged("app/db", "t2")
+}
+
+func (suite *ClusterTestSuite) TestFailover() {
+	ctx := context.Background()
+	suite.Require().NoError(suite.nodes["n1"].Store(ctx, "app/a", "1"))
+	suite.converged("app/a", "1")
+
+	// the leader goes away, and the others elect a new one, which takes writes
+	suite.Require().NoError(suite.nodes["n1"].Close(ctx))
+	suite.mu.Lock()
+	delete(suite.nodes, "n1")
+	suite.mu.Unlock()
+	suite.Require().Eventually(func() bool {
+		return suite.nodes["n2"].IsLeader() || suite.nodes["n3"].IsLeader()
+	}, 5*time.Second, 10*time.Millisecond)
+	suite.Require().Eventually(func() bool {
+		ok, _ := suite.nodes["n2"].Ping(ctx)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	suite.Require().Eventually(func() bool {
+		return suite.nodes["n3"].Store(ctx, "app/b", "2") == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	suite.converged("app/b", "2")
+	suite.converged("app/a", "1")
+}
+
+func (suite *ClusterTestSuite) TestSnapshot() {
+	ctx := context.Background()
+	suite.Require().NoError(suite.nodes["n2"].Store(ctx, "app/a", "1"))
+	suite.converged("app/a", "1")
+
+	// a snapshot restores the store, and the addresses of peers
+	n := suite.nodes["n1"]
+	snap, err := n.fsm.Snapshot()
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	sink := &memorySink{}
+	suite.Require().NoError(snap.Persist(sink))
+
+	restored := newFSM(ctx, vlog.New(true))
+	suite.Require().NoError(restored.Restore(sink))
+	v, err := restored.store.Retrieve(ctx, "app/a")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("1", v)
+	p, ok := restored.peer("n3")
+	suite.Require().True(ok)
+	suite.Require().Equal("http://n3", p.APIAddr)
+}
+
+func (suite *ClusterTestSuite) TestConfig() {
+	// nodes don't start without a secret, as the cluster routes would be open to anyone
+	_, err := New(context.Background(), Config{NodeID: "n4", RaftAddr: "n4", APIAddr: "http://n4"}, vlog.New(true))
+	suite.Require().ErrorIs(err, ErrSecretMissing)
+	_, err = New(context.Background(), Config{NodeID: "n4", Secret: "s3cr3t"}, vlog.New(true))
+	suite.Require().ErrorIs(err, ErrConfigInvalid)
+}
+
+// memorySink is a snapshot sink in memory, which can be read back
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "memory" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+// TestClusterSuite tests the Cluster suite
+func TestClusterSuite(t *testing.T) {
+	suite.Run(t, new(ClusterTestSuite))
+}
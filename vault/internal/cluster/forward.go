@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ApplyPath is the route of the vault service followers forward writes to
+	ApplyPath = "/v1/sys/raft/apply"
+	// JoinPath is the route of the vault service nodes join the cluster through
+	JoinPath = "/v1/sys/raft/join"
+	// HeaderSecret carries the secret shared by the nodes of the cluster
+	HeaderSecret = "X-Vault-Cluster-Secret"
+)
+
+// Forwarder sends the request body b to the route path of the vault service of peer, and returns its reply
+type Forwarder func(ctx context.Context, peer Peer, path string, b []byte) *Reply
+
+// HTTPForwarder forwards requests to the vault service of peers over HTTP, authenticated by secret
+func HTTPForwarder(secret string) Forwarder {
+	client := &http.Client{Timeout: ApplyTimeout + 5*time.Second}
+	return func(ctx context.Context, peer Peer, path string, b []byte) *Reply {
+		addr := strings.TrimSuffix(peer.APIAddr, "/")
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+path, bytes.NewReader(b))
+		if err != nil {
+			return replyOf(false, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(HeaderSecret, secret)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return replyOf(false, err)
+		}
+		defer resp.Body.Close()
+
+		var body struct {
+			Resp  *Reply   `json:"resp"`
+			Error []string `json:"error"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return replyOf(false, fmt.Errorf("decoding reply of %s: %w", addr+path, err))
+		}
+		if body.Resp == nil {
+			return replyOf(false, fmt.Errorf("%s replied %d: %s", addr+path, resp.StatusCode, strings.Join(body.Error, "; ")))
+		}
+		return body.Resp
+	}
+}
@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+// kinds of command
+const (
+	cmdStore  = "store"
+	cmdDelete = "delete"
+	cmdPatch  = "patch"
+	cmdBatch  = "batch"
+	cmdFlush  = "flush"
+	// cmdPeer records the addresses of a node
+	cmdPeer = "peer"
+)
+
+// command is an entry of the Raft log: a write to the store, or to the membership of the cluster
+type command struct {
+	Kind  string     `json:"kind"`
+	ID    string     `json:"id,omitempty"`
+	Token string     `json:"token,omitempty"`
+	Ops   []store.Op `json:"ops,omitempty"`
+	Peer  *Peer      `json:"peer,omitempty"`
+}
+
+// sentinels are the errors that keep their identity across nodes, so callers can tell them apart with errors.Is
+// wherever the write was committed
+var sentinels = []error{
+	store.ErrBatchKeyExists,
+	store.ErrBatchKeyNotFound,
+	store.ErrBatchConflict,
+	store.ErrBatchOpInvalid,
+	ErrNoLeader,
+	ErrNotLeader,
+	ErrPeerUnknown,
+	ErrConfigInvalid,
+}
+
+// Reply is the outcome of a command, as sent back to the node that forwarded it
+type Reply struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Sentinel is 1 + the index in sentinels of the error the command failed with, if any
+	Sentinel int `json:"sentinel,omitempty"`
+	// Index is the index of the command in the log
+	Index uint64 `json:"index,omitempty"`
+}
+
+// replyOf returns the reply of a command that returned ok and err
+func replyOf(ok bool, err error) *Reply {
+	r := &Reply{OK: ok}
+	if err == nil {
+		return r
+	}
+	r.Error = err.Error()
+	for i, s := range sentinels {
+		if errors.Is(err, s) {
+			r.Sentinel = i + 1
+			break
+		}
+	}
+	return r
+}
+
+// Err returns the error of the command, if it failed
+func (r *Reply) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	if r.Sentinel > 0 && r.Sentinel <= len(sentinels) {
+		return &remoteError{msg: r.Error, sentinel: sentinels[r.Sentinel-1]}
+	}
+	return errors.New(r.Error)
+}
+
+// remoteError is an error returned by another node, which still matches its sentinel
+type remoteError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.sentinel
+}
+
+// fsm applies the commands of the log to an in-memory store
+type fsm struct {
+	ctx   context.Context
+	store *store.Map
+	mu    sync.RWMutex
+	peers map[string]Peer
+}
+
+func newFSM(ctx context.Context, logger *vlog.Logger) *fsm {
+	return &fsm{ctx: ctx, store: store.NewSyncMap(ctx, logger), peers: map[string]Peer{}}
+}
+
+// peer returns the addresses of the node id, if known
+func (f *fsm) peer(id string) (Peer, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	p, ok := f.peers[id]
+	return p, ok
+}
+
+// Apply applies a committed command, and returns its *Reply
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var c command
+	if err := json.Unmarshal(l.Data, &c); err != nil {
+		return replyOf(false, err)
+	}
+	ctx := f.ctx
+	switch c.Kind {
+	case cmdStore:
+		err := f.store.Store(ctx, c.ID, c.Token)
+		return replyOf(err == nil, err)
+	case cmdDelete:
+		return replyOf(f.store.Delete(ctx, c.ID))
+	case cmdPatch:
+		return replyOf(f.store.Patch(ctx, c.ID, c.Token))
+	case cmdBatch:
+		err := f.store.Batch(ctx, c.Ops)
+		return replyOf(err == nil, err)
+	case cmdFlush:
+		return replyOf(f.store.Flush(ctx))
+	case cmdPeer:
+		if c.Peer == nil {
+			return replyOf(false, ErrConfigInvalid)
+		}
+		f.mu.Lock()
+		f.peers[c.Peer.ID] = *c.Peer
+		f.mu.Unlock()
+		return replyOf(true, nil)
+	default:
+		return replyOf(false, fmt.Errorf("unknown command %q", c.Kind))
+	}
+}
+
+// state is what a snapshot holds
+type state struct {
+	Entries map[string]string `json:"entries"`
+	Peers   map[string]Peer   `json:"peers"`
+}
+
+// Snapshot captures the store and peers, for the log before it to be compacted
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	entries, err := f.store.RetrieveAll(f.ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	peers := make(map[string]Peer, len(f.peers))
+	for id, p := range f.peers {
+		peers[id] = p
+	}
+	return &snapshot{state{Entries: entries, Peers: peers}}, nil
+}
+
+// Restore replaces the store and peers with those of a snapshot
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var s state
+	if err := json.NewDecoder(rc).Decode(&s); err != nil {
+		return err
+	}
+
+	if _, err := f.store.Flush(f.ctx); err != nil {
+		return err
+	}
+	ops := make([]store.Op, 0, len(s.Entries))
+	for k, v := range s.Entries {
+		ops = append(ops, store.Op{Kind: store.OpStore, ID: k, Token: v})
+	}
+	if len(ops) > 0 {
+		if err := f.store.Batch(f.ctx, ops); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.peers = s.Peers
+	if f.peers == nil {
+		f.peers = map[string]Peer{}
+	}
+	return nil
+}
+
+// snapshot is a point in time state of the fsm
+type snapshot struct {
+	state state
+}
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.state); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *snapshot) Release() {}
@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// HandshakeTimeout bounds how long a connection between nodes takes to be encrypted and authenticated
+	HandshakeTimeout = 10 * time.Second
+)
+
+const (
+	// authLabel is the label of the keying material exported from the TLS session of a connection, which both ends
+	// sign with the cluster secret
+	authLabel = "vault-raft-auth"
+	// authSize is the size of the keying material, and of its signatures
+	authSize = sha256.Size
+)
+
+var (
+	ErrNotAdvertisable = errors.New("raft address is not advertisable. it must be an address the other nodes can reach, not an unspecified one")
+	ErrPeerAuth        = errors.New("cluster node failed to prove it holds the cluster secret")
+	ErrLayerClosed     = errors.New("raft stream layer closed")
+)
+
+// streamLayer carries the Raft traffic of a node over TCP, wrapped in TLS. Nodes don't share a CA: each one presents a
+// certificate of its own, generated on start, and both ends of every connection then prove they hold the cluster secret
+// by signing keying material exported from the TLS session. The signatures are bound to the session, so they can't be
+// replayed, and a man in the middle, which holds a session of its own with each end, can't forward them.
+type streamLayer struct {
+	ln        net.Listener
+	advertise net.Addr
+	secret    []byte
+	config    *tls.Config
+	log       *vlog.Logger
+	// conns holds the connections authenticated, until Accept returns them
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// newStreamLayer listens for the other nodes of the cluster on addr, which it advertises to them
+func newStreamLayer(addr string, secret string, logger *vlog.Logger) (*streamLayer, error) {
+	advertise, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if advertise.IP == nil || advertise.IP.IsUnspecified() {
+		return nil, ErrNotAdvertisable
+	}
+	cert, err := selfSigned()
+	if err != nil {
+		return nil, fmt.Errorf("error generating the certificate of the raft transport: %w", err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// a node listening on any free port advertises the one it was given
+	if advertise.Port == 0 {
+		advertise.Port = ln.Addr().(*net.TCPAddr).Port
+	}
+
+	s := &streamLayer{
+		ln:        ln,
+		advertise: advertise,
+		secret:    []byte(secret),
+		log:       logger,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+		// the peer's certificate is not verified: peers are authenticated by the cluster secret instead
+		config: &tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true, MinVersion: tls.VersionTLS13},
+	}
+	go s.serve()
+	return s, nil
+}
+
+// serve accepts connections until the layer is closed, authenticating each one apart, so a slow or silent peer
+// doesn't hold up the others
+func (s *streamLayer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.log.Logger().Error().Msgf("error accepting raft connection: %s", err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		go func() {
+			tc, err := s.handshake(tls.Server(conn, s.config), false)
+			if err != nil {
+				s.log.Logger().Warn().Msgf("refused raft connection from %s: %s", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			select {
+			case s.conns <- tc:
+			case <-s.done:
+				tc.Close()
+			}
+		}()
+	}
+}
+
+// handshake encrypts conn, and authenticates its peer, within HandshakeTimeout
+func (s *streamLayer) handshake(conn *tls.Conn, client bool) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	material, err := state.ExportKeyingMaterial(authLabel, nil, authSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// the client proves itself first, so the server reveals nothing to peers not holding the secret
+	mine, theirs := s.sign("server", material), s.sign("client", material)
+	if client {
+		mine, theirs = theirs, mine
+		if _, err = conn.Write(mine); err != nil {
+			return nil, err
+		}
+	}
+	got := make([]byte, authSize)
+	if _, err = io.ReadFull(conn, got); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(got, theirs) {
+		return nil, ErrPeerAuth
+	}
+	if !client {
+		if _, err = conn.Write(mine); err != nil {
+			return nil, err
+		}
+	}
+	return conn, conn.SetDeadline(time.Time{})
+}
+
+// sign signs the keying material of a connection as role, the end of the connection signing it
+func (s *streamLayer) sign(role string, material []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(role))
+	mac.Write(material)
+	return mac.Sum(nil)
+}
+
+// Dial connects to the node at address, and authenticates it
+func (s *streamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := s.handshake(tls.Client(conn, s.config), true)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to raft node %s: %w", address, err)
+	}
+	return tc, nil
+}
+
+// Accept returns the next connection authenticated
+func (s *streamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-s.conns:
+		return conn, nil
+	case <-s.done:
+		return nil, ErrLayerClosed
+	}
+}
+
+// Close stops accepting connections
+func (s *streamLayer) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.ln.Close()
+	})
+	return err
+}
+
+// Addr returns the address advertised to the other nodes
+func (s *streamLayer) Addr() net.Addr {
+	return s.advertise
+}
+
+// selfSigned generates the certificate a node presents to the others
+func selfSigned() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "vault-raft"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
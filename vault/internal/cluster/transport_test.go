@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type TransportTestSuite struct {
+	suite.Suite
+	log *vlog.Logger
+}
+
+func (suite *TransportTestSuite) SetupTest() {
+	suite.log = vlog.New(true)
+}
+
+// layer starts a stream layer on a free port of the loopback interface, holding secret
+func (suite *TransportTestSuite) layer(secret string) *streamLayer {
+	s, err := newStreamLayer("127.0.0.1:0", secret, suite.log)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.T().Cleanup(func() { s.Close() })
+	return s
+}
+
+// accepted returns the next connection s accepts within a second, or nil
+func (suite *TransportTestSuite) accepted(s *streamLayer) net.Conn {
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := s.Accept()
+		if err == nil {
+			conns <- conn
+		}
+	}()
+	select {
+	case conn := <-conns:
+		return conn
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func (suite *TransportTestSuite) TestStreamLayer() {
+	server, client := suite.layer("s3cr3t"), suite.layer("s3cr3t")
+	addr := raft.ServerAddress(server.Addr().String())
+
+	// a silent peer doesn't hold up the others
+	silent, err := net.Dial("tcp", string(addr))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer silent.Close()
+
+	conn, err := client.Dial(addr, time.Second)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer conn.Close()
+	accepted := suite.accepted(server)
+	suite.Require().NotNil(accepted, "expected the connection to be accepted")
+	defer accepted.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(accepted, b)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("ping", string(b))
+
+	// peers holding another secret are refused, both ways
+	intruder := suite.layer("guess")
+	_, err = intruder.Dial(addr, time.Second)
+	suite.Require().Error(err)
+	suite.Require().Nil(suite.accepted(server), "expected the intruder to be refused")
+	_, err = client.Dial(raft.ServerAddress(intruder.Addr().String()), time.Second)
+	suite.Require().Error(err)
+
+	// peers speaking plain TCP never get an authenticated connection
+	plain, err := net.Dial("tcp", string(addr))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer plain.Close()
+	_, _ = plain.Write([]byte("AppendEntries"))
+	suite.Require().Nil(suite.accepted(server), "expected plain TCP to be refused")
+
+	_, err = newStreamLayer("0.0.0.0:0", "s3cr3t", suite.log)
+	suite.Require().ErrorIs(err, ErrNotAdvertisable)
+}
+
+func (suite *TransportTestSuite) TestReplication() {
+	ctx := context.Background()
+	fast := WithRaftConfig(func(c *raft.Config) {
+		c.HeartbeatTimeout = 100 * time.Millisecond
+		c.ElectionTimeout = 100 * time.Millisecond
+		c.LeaderLeaseTimeout = 100 * time.Millisecond
+		c.CommitTimeout = 5 * time.Millisecond
+	})
+
+	// two nodes replicating over the TLS transport, on disk
+	var nodes []*Node
+	for _, id := range []string{"n1", "n2"} {
+		cfg := Config{NodeID: id, RaftAddr: "127.0.0.1:0", APIAddr: "http://" + id, Dir: filepath.Join(suite.T().TempDir(), id), Bootstrap: id == "n1", Secret: "s3cr3t"}
+		n, err := New(ctx, cfg, suite.log, fast)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		defer n.Close(ctx)
+		nodes = append(nodes, n)
+	}
+	suite.Require().Eventually(nodes[0].IsLeader, 5*time.Second, 10*time.Millisecond)
+	suite.Require().NoError(nodes[0].Join(ctx, nodes[1].self()))
+
+	suite.Require().NoError(nodes[0].Store(ctx, "app/db", "t1"))
+	suite.Require().Eventually(func() bool {
+		v, err := nodes[1].Retrieve(ctx, "app/db")
+		return err == nil && v == "t1"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestTransportSuite tests the Transport suite
+func TestTransportSuite(t *testing.T) {
+	suite.Run(t, new(TransportTestSuite))
+}
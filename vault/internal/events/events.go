@@ -0,0 +1,208 @@
+// Package events announces the changes of the keys of the vault, so applications can reload the secrets they use once
+// they are patched or deleted.
+//
+// Managers publish an Event on their Bus for every key they create, patch, delete or expire. The bus fans it out to
+// its subscribers, i.e. the webhooks configured and the clients of the event stream, and keeps the latest events, so
+// subscribers that reconnect can resume from the last one they received. Events never carry tokens nor values.
+package events
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type is what happened to a key
+type Type string
+
+const (
+	Created Type = "created"
+	Patched Type = "patched"
+	Deleted Type = "deleted"
+	// Expired keys were deleted as they outlived their TTL, e.g. wrapped values that weren't unwrapped in time
+	Expired Type = "expired"
+)
+
+const (
+	// DefaultHistory is the number of latest events a bus keeps for subscribers to resume from
+	DefaultHistory = 1024
+	// DefaultBuffer is the number of events a subscriber can fall behind by before it is dropped
+	DefaultBuffer = 256
+)
+
+var (
+	ErrTypeInvalid = errors.New("invalid event type. expected created, patched, deleted or expired")
+	// ErrLagging is the reason subscribers that fell behind by more than their buffer are dropped. They can resume from
+	// the last event they received.
+	ErrLagging = errors.New("subscriber fell behind the events, and was dropped")
+)
+
+// Event is the change of a key
+type Event struct {
+	// ID increases with every event of a bus, including across restarts, unless the clock goes back
+	ID   uint64 `json:"id"`
+	Type Type   `json:"type"`
+	// Namespace is the namespace of the key, empty for the root one
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	// Revision is the revision the key was written at, or deleted from if known
+	Revision int64     `json:"revision,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// ParseTypes parses comma separated event types, e.g. patched,deleted
+func ParseTypes(s string) ([]Type, error) {
+	var types []Type
+	for _, term := range strings.Split(s, ",") {
+		t := Type(strings.TrimSpace(term))
+		switch t {
+		case "":
+			continue
+		case Created, Patched, Deleted, Expired:
+			types = append(types, t)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrTypeInvalid, term)
+		}
+	}
+	return types, nil
+}
+
+// Filter selects the events of the keys of a namespace starting with a prefix, of some types or, if none, all of them
+type Filter struct {
+	Namespace string
+	Prefix    string
+	Types     []Type
+}
+
+// Match reports whether ev is selected by f
+func (f Filter) Match(ev Event) bool {
+	if ev.Namespace != f.Namespace || !strings.HasPrefix(ev.Key, f.Prefix) {
+		return false
+	}
+	return len(f.Types) == 0 || slices.Contains(f.Types, ev.Type)
+}
+
+// Bus fans the events published out to the subscribers they match. Publishing never blocks on subscribers.
+type Bus struct {
+	mu   sync.Mutex
+	last uint64
+	// history holds up to size of the latest events, oldest first
+	history []Event
+	size    int
+	subs    map[*Subscription]struct{}
+	now     func() time.Time
+}
+
+// NewBus creates a bus keeping the latest history events, or DefaultHistory if not positive
+func NewBus(history int) *Bus {
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	b := &Bus{size: history, subs: map[*Subscription]struct{}{}, now: time.Now}
+	// IDs start from the time the bus is created, so they keep increasing when the service restarts, and subscribers
+	// resuming from an event of a previous run aren't mistaken for being ahead
+	b.last = uint64(b.now().UnixMicro())
+	return b
+}
+
+// Publish stamps ev with the next ID and the current time, and delivers it to the subscribers it matches. Subscribers
+// whose buffer is full are dropped with ErrLagging. It returns the event published.
+func (b *Bus) Publish(ev Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last++
+	ev.ID, ev.Time = b.last, b.now().UTC()
+	b.history = append(b.history, ev)
+	if len(b.history) > b.size {
+		b.history = b.history[len(b.history)-b.size:]
+	}
+
+	for s := range b.subs {
+		if !s.filter.Match(ev) {
+			continue
+		}
+		select {
+		case s.c <- ev:
+		default:
+			b.drop(s, ErrLagging)
+		}
+	}
+	return ev
+}
+
+// Subscribe subscribes to the events matching f, with a buffer of buffer events, or DefaultBuffer if not positive.
+// With after, the ID of the last event received by a previous subscription, the events matching f since are replayed
+// first, as far back as the history of the bus goes.
+func (b *Bus) Subscribe(f Filter, after uint64, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	s := &Subscription{filter: f}
+	if after > 0 {
+		for _, ev := range b.history {
+			if ev.ID > after && f.Match(ev) {
+				replay = append(replay, ev)
+			}
+		}
+		// events past the history, or of another bus, can't be replayed
+		oldest := b.last + 1
+		if len(b.history) > 0 {
+			oldest = b.history[0].ID
+		}
+		s.Missed = after+1 < oldest || after > b.last
+	}
+
+	s.c = make(chan Event, max(buffer, len(replay)))
+	for _, ev := range replay {
+		s.c <- ev
+	}
+	s.C = s.c
+	s.bus = b
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// drop unsubscribes s for err. The events already buffered can still be received.
+func (b *Bus) drop(s *Subscription, err error) {
+	if _, ok := b.subs[s]; !ok {
+		return
+	}
+	delete(b.subs, s)
+	s.err = err
+	close(s.c)
+}
+
+// Subscription receives the events matching its filter on C, until it is closed, or dropped for lagging
+type Subscription struct {
+	// C is closed once the subscription is closed, or dropped
+	C <-chan Event
+	// Missed reports that events since the one the subscription resumed from are no longer known, so the subscriber
+	// should reload whatever it watches
+	Missed bool
+
+	c      chan Event
+	filter Filter
+	bus    *Bus
+	err    error
+}
+
+// Close unsubscribes s. Closing a subscription more than once is a no-op.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s, nil)
+}
+
+// Err returns why s was dropped once C is closed: ErrLagging, or nil if it was closed
+func (s *Subscription) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,153 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type EventsTestSuite struct {
+	suite.Suite
+	log *vlog.Logger
+}
+
+func (suite *EventsTestSuite) SetupTest() {
+	suite.log = vlog.New(true)
+}
+
+func (suite *EventsTestSuite) TestParse() {
+	types, err := ParseTypes("patched, deleted")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]Type{Patched, Deleted}, types)
+	_, err = ParseTypes("patched,renamed")
+	suite.Require().ErrorIs(err, ErrTypeInvalid)
+
+	hook, err := ParseWebhook("https://app.internal/reload?team=a;prefix=app/;types=patched,deleted;namespace=payments")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("https://app.internal/reload?team=a", hook.URL)
+	suite.Require().Equal(Filter{Namespace: "payments", Prefix: "app/", Types: []Type{Patched, Deleted}}, hook.Filter)
+
+	for _, bad := range []string{"", "app.internal/reload", "ftp://app.internal", "https://app.internal;color=red"} {
+		_, err := ParseWebhook(bad)
+		suite.Require().ErrorIsf(err, ErrWebhookInvalid, "expected %q to be refused", bad)
+	}
+}
+
+func (suite *EventsTestSuite) TestBus() {
+	bus := NewBus(3)
+	all := bus.Subscribe(Filter{}, 0, 10)
+	defer all.Close()
+	app := bus.Subscribe(Filter{Prefix: "app/", Types: []Type{Patched}}, 0, 10)
+	defer app.Close()
+
+	first := bus.Publish(Event{Type: Created, Key: "app/db"})
+	bus.Publish(Event{Type: Patched, Key: "app/db", Revision: 2})
+	bus.Publish(Event{Type: Patched, Key: "web/db", Revision: 2})
+	bus.Publish(Event{Type: Patched, Namespace: "payments", Key: "app/db", Revision: 2})
+	suite.Require().NotZero(first.ID)
+	suite.Require().False(first.Time.IsZero())
+
+	// subscribers only receive the events of their namespace they select, in order
+	suite.Require().Len(all.C, 3)
+	suite.Require().Equal(first.ID, (<-all.C).ID)
+	suite.Require().Len(app.C, 1)
+	ev := <-app.C
+	suite.Require().Equal(Event{ID: first.ID + 1, Type: Patched, Key: "app/db", Revision: 2, Time: ev.Time}, ev)
+
+	// resuming replays what followed the last event received, as far back as the history goes
+	resumed := bus.Subscribe(Filter{}, first.ID+1, 0)
+	suite.Require().False(resumed.Missed)
+	suite.Require().Len(resumed.C, 1)
+	suite.Require().Equal("web/db", (<-resumed.C).Key)
+	resumed.Close()
+	resumed.Close()
+	suite.Require().True(bus.Subscribe(Filter{}, first.ID-1, 0).Missed)
+	suite.Require().True(bus.Subscribe(Filter{}, first.ID+100, 0).Missed)
+
+	// a subscriber falling behind is dropped, and can resume from the last event it received
+	slow := bus.Subscribe(Filter{}, 0, 1)
+	last := bus.Publish(Event{Type: Deleted, Key: "app/db"})
+	bus.Publish(Event{Type: Deleted, Key: "web/db"})
+	suite.Require().Equal(last.ID, (<-slow.C).ID)
+	_, ok := <-slow.C
+	suite.Require().False(ok)
+	suite.Require().ErrorIs(slow.Err(), ErrLagging)
+	resumed = bus.Subscribe(Filter{}, last.ID, 0)
+	suite.Require().Equal("web/db", (<-resumed.C).Key)
+}
+
+func (suite *EventsTestSuite) TestWebhook() {
+	var mu sync.Mutex
+	var delivered []Event
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		// the first attempt fails, and is retried
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if !Verify("s3cr3t", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var ev Event
+		suite.Require().NoError(json.Unmarshal(body, &ev))
+		suite.Require().Equal(string(ev.Type), r.Header.Get(HeaderEvent))
+		delivered = append(delivered, ev)
+	}))
+	defer srv.Close()
+
+	bus := NewBus(0)
+	hooks := []Webhook{{URL: srv.URL, Filter: Filter{Prefix: "app/"}}}
+	subscribed := func() bool {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		return len(bus.subs) == 1
+	}
+	stop := Dispatch(context.Background(), bus, hooks, Config{Secret: "s3cr3t", Backoff: 10 * time.Millisecond}, suite.log)
+	suite.Require().Eventually(subscribed, time.Second, 5*time.Millisecond)
+
+	bus.Publish(Event{Type: Created, Key: "app/db"})
+	bus.Publish(Event{Type: Deleted, Key: "web/db"})
+	bus.Publish(Event{Type: Patched, Key: "app/db", Revision: 2})
+	suite.Require().Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	stop()
+	suite.Require().Equal(Created, delivered[0].Type)
+	suite.Require().Equal(Patched, delivered[1].Type)
+	suite.Require().Equal(3, attempts)
+
+	// deliveries signed with another secret are refused, and not retried
+	stop = Dispatch(context.Background(), bus, hooks, Config{Secret: "wrong", Backoff: 10 * time.Millisecond}, suite.log)
+	defer stop()
+	suite.Require().Eventually(subscribed, time.Second, 5*time.Millisecond)
+	bus.Publish(Event{Type: Deleted, Key: "app/db"})
+	suite.Require().Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts == 4
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	suite.Require().Equal(4, attempts)
+	suite.Require().Len(delivered, 2)
+}
+
+// TestEventsSuite tests the Events suite
+func TestEventsSuite(t *testing.T) {
+	suite.Run(t, new(EventsTestSuite))
+}
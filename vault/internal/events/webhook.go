@@ -0,0 +1,226 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderEvent holds the type of the event delivered
+	HeaderEvent = "X-Vault-Event"
+	// HeaderEventID holds the ID of the event delivered, the same across its attempts, so receivers can skip repeats
+	HeaderEventID = "X-Vault-Event-Id"
+	// HeaderTimestamp holds the unix time an attempt was sent at, which the signature covers, so receivers can refuse
+	// replayed deliveries
+	HeaderTimestamp = "X-Vault-Timestamp"
+	// HeaderSignature holds sha256=<hex HMAC-SHA256 of <timestamp>.<body> under the webhook secret>
+	HeaderSignature = "X-Vault-Signature"
+
+	// DefaultAttempts is how many times a delivery is attempted, if not configured
+	DefaultAttempts = 5
+	// DefaultBackoff is how long the first retry of a delivery waits. Every retry waits twice as long as the last.
+	DefaultBackoff = time.Second
+	// MaxBackoff caps how long a retry waits
+	MaxBackoff = time.Minute
+	// DefaultTimeout is how long an attempt waits for the receiver to answer
+	DefaultTimeout = 10 * time.Second
+)
+
+var (
+	ErrWebhookInvalid = errors.New("invalid webhook. expected <url>[;prefix=<prefix>][;types=<type>[,<type>...]][;namespace=<namespace>]")
+	// errPermanent marks failed deliveries that retrying won't fix, i.e. the receiver refused the event
+	errPermanent = errors.New("delivery refused")
+)
+
+// Webhook is a URL the events matching Filter are posted to, as JSON
+type Webhook struct {
+	URL    string
+	Filter Filter
+}
+
+// ParseWebhook parses a webhook from its URL, followed by its filter options, e.g.
+// https://app.internal/reload;prefix=app/;types=patched,deleted
+func ParseWebhook(s string) (Webhook, error) {
+	terms := strings.Split(strings.TrimSpace(s), ";")
+	u, err := url.Parse(terms[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("%w: %q", ErrWebhookInvalid, s)
+	}
+
+	hook := Webhook{URL: terms[0]}
+	for _, term := range terms[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(term), "=")
+		switch k {
+		case "prefix":
+			hook.Filter.Prefix = v
+		case "namespace":
+			hook.Filter.Namespace = v
+		case "types":
+			if hook.Filter.Types, err = ParseTypes(v); err != nil {
+				return Webhook{}, err
+			}
+		default:
+			return Webhook{}, fmt.Errorf("%w: %q", ErrWebhookInvalid, s)
+		}
+	}
+	return hook, nil
+}
+
+// Config configures the delivery of webhooks
+type Config struct {
+	// Secret signs every delivery, if set
+	Secret string
+	// Attempts is how many times a delivery is attempted before it is given up on
+	Attempts int
+	// Backoff is how long the first retry waits
+	Backoff time.Duration
+	// Client sends the deliveries. Defaults to a client timing out after DefaultTimeout.
+	Client *http.Client
+}
+
+// Sign returns the signature of a delivery of body sent at timestamp, as set in HeaderSignature
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of a delivery of body sent at timestamp, in constant time
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Dispatch posts the events of bus to every webhook of hooks, until ctx is done or the returned func is called, which
+// waits for deliveries in flight. Every webhook receives its events in order, one at a time, so one that is slow or
+// down doesn't hold the others back. Failed deliveries are retried with exponential backoff, unless the receiver
+// refused them with a 4xx status other than 408 and 429.
+func Dispatch(ctx context.Context, bus *Bus, hooks []Webhook, cfg Config, logger *vlog.Logger) func() {
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = DefaultAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DefaultBackoff
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{}, len(hooks))
+	for _, hook := range hooks {
+		d := &dispatcher{hook: hook, cfg: cfg, logger: logger}
+		go func() {
+			defer func() { done <- struct{}{} }()
+			d.run(ctx, bus)
+		}()
+	}
+	return func() {
+		cancel()
+		for range hooks {
+			<-done
+		}
+	}
+}
+
+// dispatcher delivers the events of a webhook
+type dispatcher struct {
+	hook   Webhook
+	cfg    Config
+	logger *vlog.Logger
+}
+
+// run delivers the events matching the webhook until ctx is done. Falling behind drops its subscription, which is
+// resumed from the last event received, as far back as the history of the bus goes.
+func (d *dispatcher) run(ctx context.Context, bus *Bus) {
+	var last uint64
+	for {
+		sub := bus.Subscribe(d.hook.Filter, last, DefaultBuffer)
+		if sub.Missed {
+			d.logger.Logger().Error().Msgf("webhook %s fell behind the event history, and missed events after %d", d.hook.URL, last)
+		}
+		for open := true; open; {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case ev, ok := <-sub.C:
+				if open = ok; ok {
+					last = ev.ID
+					d.deliver(ctx, ev)
+				}
+			}
+		}
+		d.logger.Logger().Warn().Msgf("webhook %s resuming after event %d: %s", d.hook.URL, last, sub.Err())
+	}
+}
+
+// deliver posts ev until it is delivered, it is refused, attempts run out, or ctx is done
+func (d *dispatcher) deliver(ctx context.Context, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		d.logger.Logger().Error().Msgf("error encoding event %d: %s", ev.ID, err)
+		return
+	}
+
+	wait := d.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		err = d.post(ctx, ev, body)
+		if err == nil {
+			return
+		}
+		if attempt >= d.cfg.Attempts || errors.Is(err, errPermanent) {
+			d.logger.Logger().Error().Msgf("giving up delivering event %d to webhook %s after %d attempts: %s", ev.ID, d.hook.URL, attempt, err)
+			return
+		}
+		d.logger.Logger().Debug().Msgf("retrying event %d to webhook %s in %s: %s", ev.ID, d.hook.URL, wait, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, MaxBackoff)
+	}
+}
+
+// post makes a single attempt at delivering ev, encoded as body
+func (d *dispatcher) post(ctx context.Context, ev Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(ev.Type))
+	req.Header.Set(HeaderEventID, strconv.FormatUint(ev.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if d.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(d.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", errPermanent, resp.Status)
+	default:
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+}
@@ -0,0 +1,234 @@
+package export
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	FormatEnv        = "env"
+	FormatDotenv     = "dotenv"
+	FormatJSON       = "json"
+	FormatK8sSecret  = "k8s-secret"
+	k8sNameMaxLength = 253
+)
+
+var (
+	ErrFormatInvalid = errors.New("export format is invalid. options: env, dotenv, json, k8s-secret")
+	ErrDetokenize    = errors.New("error detokenizing child key")
+	ErrNameCollision = errors.New("distinct keys map to the same exported name")
+)
+
+// ValidateFormat ensures format is one of the supported export formats
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatEnv, FormatDotenv, FormatJSON, FormatK8sSecret:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrFormatInvalid, format)
+	}
+}
+
+// Secret is a single detokenized child of an ID
+type Secret struct {
+	Key   string
+	Value string
+}
+
+// Reveal detokenizes every child key stored under id, in key order
+func Reveal(ctx context.Context, m *tokenize.Manager, id string) ([]*Secret, error) {
+	token, err := m.GetChildrenByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]*Secret, 0, len(token.Data))
+	for i := 0; i < len(token.Data); i++ {
+		child := token.Data[i]
+		key, err := tokenize.ChildKey(id, child.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrDetokenize, child.Key, err)
+		}
+		found, plain, err := m.Detokenize(ctx, key, child.Value)
+		if err != nil || !found {
+			return nil, fmt.Errorf("%w %s: %v", ErrDetokenize, child.Key, err)
+		}
+		secrets = append(secrets, &Secret{Key: child.Key, Value: plain})
+	}
+	return secrets, nil
+}
+
+// EnvName maps a child key to an environment variable name: path delimiters and other characters that aren't valid in
+// variable names become underscores, and the result is upper cased.
+func EnvName(key string) string {
+	key = strings.ReplaceAll(key, tokenize.KeyDelimiter, "_")
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z':
+			b.WriteRune(r - 'a' + 'A')
+		case r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// names maps the key of every secret to its exported name with rename, in order. Distinct keys renamed alike, e.g.
+// db/pass and db_pass as env vars, would overwrite one another, so they fail with ErrNameCollision.
+func names(secrets []*Secret, rename func(string) string) ([]string, error) {
+	renamed := make([]string, len(secrets))
+	keys := make(map[string]string, len(secrets))
+	for i, s := range secrets {
+		name := rename(s.Key)
+		if key, ok := keys[name]; ok && key != s.Key {
+			return nil, fmt.Errorf("%w: %s and %s are both exported as %s", ErrNameCollision, key, s.Key, name)
+		}
+		keys[name] = s.Key
+		renamed[i] = name
+	}
+	return renamed, nil
+}
+
+// Environ returns the secrets as "NAME=value" pairs appended to base, in the form expected by exec.Cmd.Env. Secrets
+// override variables of the same name in base.
+func Environ(base []string, prefix string, secrets []*Secret) ([]string, error) {
+	renamed, err := names(secrets, func(key string) string { return EnvName(prefix + key) })
+	if err != nil {
+		return nil, err
+	}
+	overridden := make(map[string]bool, len(secrets))
+	env := make([]string, 0, len(secrets))
+	for i, s := range secrets {
+		overridden[renamed[i]] = true
+		env = append(env, renamed[i]+"="+s.Value)
+	}
+
+	environ := make([]string, 0, len(base)+len(env))
+	for _, kv := range base {
+		if name, _, _ := strings.Cut(kv, "="); overridden[name] {
+			continue
+		}
+		environ = append(environ, kv)
+	}
+	return append(environ, env...), nil
+}
+
+// k8sKey maps a child key to a valid Kubernetes Secret data key, which only allows alphanumerics, '-', '_' and '.'
+func k8sKey(key string) string {
+	key = strings.ReplaceAll(key, tokenize.KeyDelimiter, ".")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+// k8sName maps an ID to a valid Kubernetes object name: lower case alphanumerics, '-' and '.'
+func k8sName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, id)
+	name = strings.Trim(name, "-.")
+	if len(name) > k8sNameMaxLength {
+		name = name[:k8sNameMaxLength]
+	}
+	return name
+}
+
+// shellQuote single quotes s so it is safe to eval in a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Render writes the secrets of id to w in the given format. Keys exported under the same name fail with
+// ErrNameCollision, before anything is written.
+func Render(w io.Writer, format, id string, secrets []*Secret) error {
+	switch format {
+	case FormatEnv:
+		renamed, err := names(secrets, EnvName)
+		if err != nil {
+			return err
+		}
+		for i, s := range secrets {
+			if _, err = fmt.Fprintf(w, "export %s=%s\n", renamed[i], shellQuote(s.Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatDotenv:
+		renamed, err := names(secrets, EnvName)
+		if err != nil {
+			return err
+		}
+		env := make(map[string]string, len(secrets))
+		for i, s := range secrets {
+			env[renamed[i]] = s.Value
+		}
+		content, err := godotenv.Marshal(env)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, content)
+		return err
+	case FormatJSON:
+		doc := make(map[string]string, len(secrets))
+		for _, s := range secrets {
+			doc[s.Key] = s.Value
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]any{"id": id, "data": doc})
+	case FormatK8sSecret:
+		renamed, err := names(secrets, k8sKey)
+		if err != nil {
+			return err
+		}
+		data := make(map[string]string, len(secrets))
+		for i, s := range secrets {
+			data[renamed[i]] = base64.StdEncoding.EncodeToString([]byte(s.Value))
+		}
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var b strings.Builder
+		b.WriteString("apiVersion: v1\nkind: Secret\nmetadata:\n")
+		fmt.Fprintf(&b, "  name: %q\n", k8sName(id))
+		b.WriteString("type: Opaque\ndata:\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "  %q: %s\n", k, data[k])
+		}
+		_, err = io.WriteString(w, b.String())
+		return err
+	default:
+		return fmt.Errorf("%w: %s", ErrFormatInvalid, format)
+	}
+}
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"testing"
+)
+
+type ExportTestSuite struct {
+	suite.Suite
+	tableEnvNames map[string]string
+	log           *vlog.Logger
+}
+
+var (
+	varTableEnvNames = map[string]string{
+		"db_password": "DB_PASSWORD",
+		"api-key":     "API_KEY",
+		tokenize.GetCombinedKey("db", "password"): "DB_PASSWORD",
+		"2fa.seed":   "_2FA_SEED",
+		"Mixed.Case": "MIXED_CASE",
+	}
+)
+
+func (suite *ExportTestSuite) SetupTest() {
+	suite.tableEnvNames = varTableEnvNames
+	suite.log = vlog.New(true)
+}
+
+func (suite *ExportTestSuite) TestEnvName() {
+	for k, v := range suite.tableEnvNames {
+		suite.Require().Equalf(v, EnvName(k), "expected %s, but got %s\n", v, EnvName(k))
+	}
+}
+
+func (suite *ExportTestSuite) TestRevealAndRender() {
+	ctx := context.Background()
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	m := tokenize.NewManager(ctx, suite.log, tokenize.WithStore(store.NewSyncMap(ctx, suite.log)), tokenize.WithCipherLoc(cipherLoc))
+	_, err := m.Tokenize(ctx, tokenize.GetCombinedKey("myapp", "db_password"), "it's a secret")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	_, err = m.Tokenize(ctx, tokenize.GetCombinedKey("otherapp", "db_password"), "not exported")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	secrets, err := Reveal(ctx, m, "myapp")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(secrets, 1)
+	suite.Require().Equal("it's a secret", secrets[0].Value)
+
+	var b bytes.Buffer
+	suite.Require().NoError(Render(&b, FormatEnv, "myapp", secrets))
+	suite.Require().Equal("export DB_PASSWORD='it'\\''s a secret'\n", b.String())
+
+	b.Reset()
+	suite.Require().NoError(Render(&b, FormatK8sSecret, "MyApp", secrets))
+	suite.Require().Contains(b.String(), `name: "myapp"`)
+	suite.Require().Contains(b.String(), base64.StdEncoding.EncodeToString([]byte("it's a secret")))
+
+	suite.Require().ErrorIs(Render(&b, "yaml", "myapp", secrets), ErrFormatInvalid)
+}
+
+func (suite *ExportTestSuite) TestEnviron() {
+	secrets := []*Secret{{Key: "db_password", Value: "hunter2"}, {Key: "port", Value: "5432"}}
+	env, err := Environ([]string{"PATH=/usr/bin", "APP_PORT=80"}, "app_", secrets)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"PATH=/usr/bin", "APP_DB_PASSWORD=hunter2", "APP_PORT=5432"}, env)
+}
+
+func (suite *ExportTestSuite) TestNameCollision() {
+	secrets := []*Secret{{Key: tokenize.GetCombinedKey("db", "pass"), Value: "one"}, {Key: "db_pass", Value: "two"}}
+	_, err := Environ(nil, "", secrets)
+	suite.Require().ErrorIs(err, ErrNameCollision)
+
+	var b bytes.Buffer
+	for _, format := range []string{FormatEnv, FormatDotenv} {
+		suite.Require().ErrorIsf(Render(&b, format, "myapp", secrets), ErrNameCollision, "expected %s to refuse the collision", format)
+	}
+	suite.Require().Empty(b.String())
+	// JSON keeps the keys as they are
+	suite.Require().NoError(Render(&b, FormatJSON, "myapp", secrets))
+
+	secrets = []*Secret{{Key: "db.pass", Value: "one"}, {Key: tokenize.GetCombinedKey("db", "pass"), Value: "two"}}
+	suite.Require().ErrorIs(Render(&b, FormatK8sSecret, "myapp", secrets), ErrNameCollision)
+}
+
+// TestExportSuite tests the Export suite
+func TestExportSuite(t *testing.T) {
+	suite.Run(t, new(ExportTestSuite))
+}
@@ -0,0 +1,319 @@
+// Package generate mints secrets, so they are created by the vault rather than chosen by people, and go straight from
+// the random source into a token.
+//
+// Every type draws from crypto/rand. Passwords follow a Policy: their length, the characters they are made of, and
+// whether they include symbols; they hold at least one character of every class of their charset. Key pairs are
+// PEM encoded, the private key as PKCS #8 and the public key, which isn't secret, as PKIX.
+package generate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"math/big"
+	"strings"
+)
+
+// Type is the kind of secret generated
+type Type string
+
+const (
+	Password Type = "password"
+	// Hex is Length random bytes, hex encoded
+	Hex Type = "hex"
+	// Base64 is Length random bytes, standard base64 encoded
+	Base64 Type = "base64"
+	// UUID is a random, version 4, UUID
+	UUID    Type = "uuid"
+	Ed25519 Type = "ed25519"
+	RSA     Type = "rsa"
+)
+
+const (
+	// DefaultLength is the number of characters of passwords, and of random bytes of hex and base64 secrets, if not set
+	DefaultLength = 32
+	// MinPasswordLength and MaxLength bound the Length of a policy
+	MinPasswordLength = 8
+	MaxLength         = 1024
+	// DefaultBits is the size of RSA keys, if not set
+	DefaultBits = 2048
+)
+
+// Charsets passwords are commonly made of. Policies may use any other set of characters.
+const (
+	Lowercase    = "abcdefghijklmnopqrstuvwxyz"
+	Uppercase    = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Digits       = "0123456789"
+	Letters      = Lowercase + Uppercase
+	Alphanumeric = Letters + Digits
+	// Symbols are added to the charset of passwords with symbols. They are safe in shells and URLs once quoted.
+	Symbols = "!#$%&*+-=?@^_~"
+)
+
+var (
+	ErrTypeInvalid   = errors.New("invalid secret type. expected password, hex, base64, uuid, ed25519 or rsa")
+	ErrPolicyInvalid = errors.New("invalid generation policy")
+)
+
+// charsets names the common charsets, for ParseCharset
+var charsets = map[string]string{
+	"lowercase":    Lowercase,
+	"uppercase":    Uppercase,
+	"digits":       Digits,
+	"letters":      Letters,
+	"alphanumeric": Alphanumeric,
+}
+
+// Policy configures the secrets generated. Only the fields of the type generated may be set.
+type Policy struct {
+	// Length is the number of characters of passwords, or of random bytes of hex and base64 secrets
+	Length int
+	// Charset holds the characters of passwords, Alphanumeric if empty
+	Charset string
+	// Symbols adds Symbols to the charset of passwords
+	Symbols bool
+	// Bits is the size of RSA keys: 2048, 3072 or 4096
+	Bits int
+}
+
+// Secret is a secret generated. Public is the public key of key pairs.
+type Secret struct {
+	Type   Type
+	Value  string
+	Public string
+}
+
+// ParseType parses the type of a secret
+func ParseType(s string) (Type, error) {
+	switch t := Type(strings.ToLower(strings.TrimSpace(s))); t {
+	case Password, Hex, Base64, UUID, Ed25519, RSA:
+		return t, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrTypeInvalid, s)
+}
+
+// ParseCharset returns the characters of the charset named s, e.g. digits, or s itself, for any other set of characters
+func ParseCharset(s string) string {
+	if c, ok := charsets[strings.ToLower(s)]; ok {
+		return c
+	}
+	return s
+}
+
+// Validate checks that p is a valid policy of secrets of type t, and fills in its defaults
+func (p *Policy) Validate(t Type) error {
+	invalid := func(format string, a ...any) error {
+		return fmt.Errorf("%w: %s", ErrPolicyInvalid, fmt.Sprintf(format, a...))
+	}
+	if t != Password && (p.Charset != "" || p.Symbols) {
+		return invalid("charset and symbols only apply to passwords")
+	}
+	if t != Password && t != Hex && t != Base64 && p.Length != 0 {
+		return invalid("length doesn't apply to %s secrets", t)
+	}
+	if t != RSA && p.Bits != 0 {
+		return invalid("bits only apply to rsa keys")
+	}
+
+	switch t {
+	case Password:
+		if p.Length == 0 {
+			p.Length = DefaultLength
+		}
+		if p.Length < MinPasswordLength || p.Length > MaxLength {
+			return invalid("password length must be between %d and %d", MinPasswordLength, MaxLength)
+		}
+		if p.Charset == "" {
+			p.Charset = Alphanumeric
+		}
+		if len(uniq(p.charset())) < 2 {
+			return invalid("charset must hold at least 2 distinct characters")
+		}
+		for _, c := range p.Charset {
+			if c > '~' || c < '!' {
+				return invalid("charset must only hold printable ASCII characters, other than space")
+			}
+		}
+	case Hex, Base64:
+		if p.Length == 0 {
+			p.Length = DefaultLength
+		}
+		if p.Length < 1 || p.Length > MaxLength {
+			return invalid("length must be between 1 and %d bytes", MaxLength)
+		}
+	case RSA:
+		if p.Bits == 0 {
+			p.Bits = DefaultBits
+		}
+		if p.Bits != 2048 && p.Bits != 3072 && p.Bits != 4096 {
+			return invalid("rsa keys must be of 2048, 3072 or 4096 bits")
+		}
+	case UUID, Ed25519:
+	default:
+		return fmt.Errorf("%w: %q", ErrTypeInvalid, t)
+	}
+	return nil
+}
+
+// charset returns the characters passwords of p are drawn from
+func (p *Policy) charset() string {
+	if p.Symbols {
+		return p.Charset + Symbols
+	}
+	return p.Charset
+}
+
+// New generates a secret of type t following p
+func New(t Type, p Policy) (*Secret, error) {
+	if err := p.Validate(t); err != nil {
+		return nil, err
+	}
+	s := &Secret{Type: t}
+	var err error
+	switch t {
+	case Password:
+		s.Value, err = password(p.Length, uniq(p.charset()))
+	case Hex:
+		var b []byte
+		if b, err = random(p.Length); err == nil {
+			s.Value = hex.EncodeToString(b)
+		}
+	case Base64:
+		var b []byte
+		if b, err = random(p.Length); err == nil {
+			s.Value = base64.StdEncoding.EncodeToString(b)
+		}
+	case UUID:
+		s.Value, err = uuid()
+	case Ed25519:
+		var pub ed25519.PublicKey
+		var priv ed25519.PrivateKey
+		if pub, priv, err = ed25519.GenerateKey(rand.Reader); err == nil {
+			s.Value, s.Public, err = encodeKeyPair(priv, pub)
+		}
+	case RSA:
+		var priv *rsa.PrivateKey
+		if priv, err = rsa.GenerateKey(rand.Reader, p.Bits); err == nil {
+			s.Value, s.Public, err = encodeKeyPair(priv, &priv.PublicKey)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Minted is a secret generated and tokenized under Key
+type Minted struct {
+	*Secret
+	Key      string
+	Token    string
+	Revision int64
+}
+
+// Mint generates a secret of type t following p, and tokenizes it under key with m. Key must not exist yet.
+func Mint(ctx context.Context, m *tokenize.Manager, key string, t Type, p Policy) (*Minted, error) {
+	s, err := New(t, p)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := m.TokenizeBatch(ctx, []tokenize.Entry{{Key: key, Value: s.Value}}, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Minted{Secret: s, Key: key, Token: receipts[0].Token, Revision: receipts[0].Revision}, nil
+}
+
+// random returns n random bytes
+func random(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// password draws n characters of charset uniformly, until they hold a character of every class charset holds
+func password(n int, charset string) (string, error) {
+	classes := classesOf(charset)
+	max := big.NewInt(int64(len(charset)))
+	b := make([]byte, n)
+	for {
+		for i := range b {
+			idx, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			b[i] = charset[idx.Int64()]
+		}
+		if hasEvery(string(b), classes) {
+			return string(b), nil
+		}
+	}
+}
+
+// classesOf returns the classes of characters charset holds some of: lowercase letters, uppercase letters, digits and
+// symbols
+func classesOf(charset string) []string {
+	var classes []string
+	for _, class := range []string{Lowercase, Uppercase, Digits, Symbols} {
+		if strings.ContainsAny(charset, class) {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+// hasEvery reports whether s holds a character of every class of classes
+func hasEvery(s string, classes []string) bool {
+	for _, class := range classes {
+		if !strings.ContainsAny(s, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// uniq drops the repeated characters of s, so no character is drawn more often than another
+func uniq(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if !strings.ContainsRune(b.String(), c) {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// uuid returns a random, version 4, UUID
+func uuid() (string, error) {
+	b, err := random(16)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// encodeKeyPair PEM encodes priv as PKCS #8, and pub as PKIX
+func encodeKeyPair(priv, pub any) (string, string, error) {
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", "", err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})), nil
+}
@@ -0,0 +1,133 @@
+package generate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type GenerateTestSuite struct {
+	suite.Suite
+}
+
+func (suite *GenerateTestSuite) TestPassword() {
+	s, err := New(Password, Policy{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(s.Value, DefaultLength)
+	suite.Require().Regexp(`^[a-zA-Z0-9]+$`, s.Value)
+
+	// passwords hold a character of every class of their charset
+	for i := 0; i < 50; i++ {
+		s, err = New(Password, Policy{Length: 8, Symbols: true})
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Len(s.Value, 8)
+		for _, class := range []string{Lowercase, Uppercase, Digits, Symbols} {
+			suite.Require().Truef(strings.ContainsAny(s.Value, class), "expected %q to hold one of %q", s.Value, class)
+		}
+	}
+
+	s, err = New(Password, Policy{Length: 12, Charset: ParseCharset("digits")})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Regexp(`^[0-9]{12}$`, s.Value)
+	s, err = New(Password, Policy{Length: 10, Charset: ParseCharset("xyz")})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Regexp(`^[xyz]{10}$`, s.Value)
+
+	for _, p := range []Policy{{Length: 7}, {Length: MaxLength + 1}, {Charset: "aaa"}, {Charset: "ab c"}, {Bits: 2048}} {
+		_, err = New(Password, p)
+		suite.Require().ErrorIsf(err, ErrPolicyInvalid, "expected %+v to be refused", p)
+	}
+}
+
+func (suite *GenerateTestSuite) TestTypes() {
+	for _, name := range []string{"password", "HEX", " base64", "uuid", "ed25519", "rsa"} {
+		_, err := ParseType(name)
+		suite.Require().NoErrorf(err, "expected no errors parsing %s, but got this %v\n", name, err)
+	}
+	_, err := ParseType("dsa")
+	suite.Require().ErrorIs(err, ErrTypeInvalid)
+
+	s, err := New(Hex, Policy{Length: 16})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	b, err := hex.DecodeString(s.Value)
+	suite.Require().NoError(err)
+	suite.Require().Len(b, 16)
+
+	s, err = New(Base64, Policy{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	b, err = base64.StdEncoding.DecodeString(s.Value)
+	suite.Require().NoError(err)
+	suite.Require().Len(b, DefaultLength)
+
+	s, err = New(UUID, Policy{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Regexp(regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), s.Value)
+	suite.Require().Empty(s.Public)
+
+	s, err = New(Ed25519, Policy{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	priv, pub := suite.keyPair(s)
+	suite.Require().True(priv.(ed25519.PrivateKey).Public().(ed25519.PublicKey).Equal(pub))
+
+	s, err = New(RSA, Policy{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	priv, pub = suite.keyPair(s)
+	suite.Require().Equal(DefaultBits, priv.(*rsa.PrivateKey).N.BitLen())
+	suite.Require().True(priv.(*rsa.PrivateKey).PublicKey.Equal(pub))
+
+	for t, p := range map[Type]Policy{UUID: {Length: 8}, Hex: {Symbols: true}, RSA: {Bits: 1024}, Ed25519: {Bits: 2048}, Base64: {Length: -1}} {
+		_, err = New(t, p)
+		suite.Require().ErrorIsf(err, ErrPolicyInvalid, "expected %+v to be refused for %s", p, t)
+	}
+}
+
+// keyPair decodes the key pair of s
+func (suite *GenerateTestSuite) keyPair(s *Secret) (any, any) {
+	block, _ := pem.Decode([]byte(s.Value))
+	suite.Require().NotNil(block)
+	suite.Require().Equal("PRIVATE KEY", block.Type)
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	block, _ = pem.Decode([]byte(s.Public))
+	suite.Require().NotNil(block)
+	suite.Require().Equal("PUBLIC KEY", block.Type)
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	return priv, pub
+}
+
+func (suite *GenerateTestSuite) TestMint() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	m := tokenize.NewManager(ctx, log, tokenize.WithStore(store.NewSyncMap(ctx, log)), tokenize.WithCipherLoc(filepath.Join(suite.T().TempDir(), ".cipher")))
+
+	minted, err := Mint(ctx, m, "app/db/password", Password, Policy{Length: 20})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(tokenize.FirstRevision, minted.Revision)
+	suite.Require().NotEqual(minted.Value, minted.Token)
+	found, value, err := m.Detokenize(ctx, "app/db/password", minted.Token)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().True(found)
+	suite.Require().Equal(minted.Value, value)
+
+	// existing secrets aren't overwritten
+	_, err = Mint(ctx, m, "app/db/password", Password, Policy{})
+	suite.Require().ErrorIs(err, store.ErrBatchKeyExists)
+}
+
+// TestGenerateSuite tests the Generate suite
+func TestGenerateSuite(t *testing.T) {
+	suite.Run(t, new(GenerateTestSuite))
+}
@@ -0,0 +1,275 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatDotenv = "dotenv"
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+)
+
+var (
+	ErrFormatInvalid     = errors.New("import format is invalid. options: dotenv, json, csv")
+	ErrIDRequired        = errors.New("a parent id is required for this import format")
+	ErrCSVHeaderInvalid  = errors.New("csv header must be \"id,key,value\", or \"key,value\" when a parent id is set")
+	ErrJSONNotObject     = errors.New("json import must be an object")
+	ErrValueNull         = errors.New("null values cannot be imported")
+	ErrImportAborted     = errors.New("atomic import aborted: some rows are invalid. nothing was imported")
+	ErrImportRolledBack  = errors.New("atomic import failed while writing. nothing was imported")
+	ErrDuplicateInImport = errors.New("key appears more than once in import. accepted only the first one")
+)
+
+// Row is a single secret to be imported, addressed by the paths of its parent ID and of its child key
+type Row struct {
+	ID    string
+	Key   string
+	Value string
+}
+
+// CombinedKey returns the store key the row is tokenized under. Invalid paths are returned as is, and rejected on validation.
+func (r *Row) CombinedKey() string {
+	key, err := tokenize.ChildKey(r.ID, r.Key)
+	if err != nil {
+		return r.ID + tokenize.KeyDelimiter + r.Key
+	}
+	return key
+}
+
+// Skipped records a row that was not imported, and why
+type Skipped struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Report summarizes the outcome of an import
+type Report struct {
+	Imported []string   `json:"imported"`
+	Skipped  []*Skipped `json:"skipped"`
+}
+
+// Options configures an import
+type Options struct {
+	// Atomic imports all rows or none of them
+	Atomic bool
+}
+
+// ResolveFormat returns format if set, otherwise infers it from the extension of the file at loc
+func ResolveFormat(format, loc string) (string, error) {
+	if len(format) == 0 {
+		switch strings.ToLower(filepath.Ext(loc)) {
+		case ".json":
+			format = FormatJSON
+		case ".csv":
+			format = FormatCSV
+		default:
+			format = FormatDotenv
+		}
+	}
+	switch format {
+	case FormatDotenv, FormatJSON, FormatCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrFormatInvalid, format)
+	}
+}
+
+// Parse reads rows from r in the given format. id is the parent ID the rows are imported under; it is required for dotenv,
+// optional for csv and json. When id is empty, json top-level keys and the first csv column are used as parent IDs.
+func Parse(r io.Reader, format, id string) ([]*Row, error) {
+	switch format {
+	case FormatDotenv:
+		return ParseDotenv(r, id)
+	case FormatJSON:
+		return ParseJSON(r, id)
+	case FormatCSV:
+		return ParseCSV(r, id)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrFormatInvalid, format)
+	}
+}
+
+// ParseDotenv parses a dotenv file, importing every variable as a child key of id
+func ParseDotenv(r io.Reader, id string) ([]*Row, error) {
+	if len(id) == 0 {
+		return nil, ErrIDRequired
+	}
+
+	env, err := godotenv.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*Row, 0, len(env))
+	for k, v := range env {
+		rows = append(rows, &Row{ID: id, Key: keys.Escape(k), Value: v})
+	}
+	sortRows(rows)
+	return rows, nil
+}
+
+// ParseJSON parses a json object, flattening nested objects and arrays into child keys with GetCombinedKey
+func ParseJSON(r io.Reader, id string) ([]*Row, error) {
+	var doc map[string]any
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, ErrJSONNotObject
+		}
+		return nil, err
+	}
+
+	var rows []*Row
+	if len(id) > 0 {
+		if err := flatten(id, nil, doc, &rows); err != nil {
+			return nil, err
+		}
+	} else {
+		// every top level key is a parent ID of its own
+		for parent, v := range doc {
+			child, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%w: value of top level key %s must be an object when no parent id is set", ErrJSONNotObject, parent)
+			}
+			if err := flatten(keys.Escape(parent), nil, child, &rows); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sortRows(rows)
+	return rows, nil
+}
+
+// flatten walks v depth first, appending a row for every leaf value
+func flatten(id string, path []string, v any, rows *[]*Row) error {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			if err := flatten(id, append(path[:len(path):len(path)], k), child, rows); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, child := range val {
+			if err := flatten(id, append(path[:len(path):len(path)], strconv.Itoa(i)), child, rows); err != nil {
+				return err
+			}
+		}
+	case nil:
+		return fmt.Errorf("%w: %s", ErrValueNull, id+tokenize.KeyDelimiter+tokenize.GetCombinedKey(path...))
+	default:
+		*rows = append(*rows, &Row{ID: id, Key: tokenize.GetCombinedKey(path...), Value: fmt.Sprint(val)})
+	}
+	return nil
+}
+
+// ParseCSV parses csv with an "id,key,value" header, or a "key,value" header when id is set
+func ParseCSV(r io.Reader, id string) ([]*Row, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := strings.Join(records[0], ",")
+	var rows []*Row
+	switch {
+	case header == "id,key,value":
+		for _, rec := range records[1:] {
+			parent := rec[0]
+			if len(id) > 0 {
+				parent = id + tokenize.KeyDelimiter + rec[0]
+			}
+			rows = append(rows, &Row{ID: parent, Key: rec[1], Value: rec[2]})
+		}
+	case header == "key,value" && len(id) > 0:
+		for _, rec := range records[1:] {
+			rows = append(rows, &Row{ID: id, Key: rec[0], Value: rec[1]})
+		}
+	default:
+		return nil, ErrCSVHeaderInvalid
+	}
+	return rows, nil
+}
+
+func sortRows(rows []*Row) {
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].CombinedKey() < rows[j].CombinedKey()
+	})
+}
+
+// Import validates every row with Manager.ValidateKeys and tokenizes the valid ones. Duplicates and keys already present
+// in the store are skipped and reported. With Options.Atomic, a single invalid row aborts the whole import, and the valid
+// rows are committed in a single batch, so a failure while writing leaves the store untouched.
+func Import(ctx context.Context, m *tokenize.Manager, rows []*Row, opts Options) (*Report, error) {
+	report := &Report{Imported: []string{}, Skipped: []*Skipped{}}
+	seen := make(map[string]bool, len(rows))
+	var valid []*Row
+
+	for _, row := range rows {
+		key := row.CombinedKey()
+		if seen[key] {
+			report.Skipped = append(report.Skipped, &Skipped{Key: key, Reason: ErrDuplicateInImport.Error()})
+			continue
+		}
+		seen[key] = true
+
+		token := &model.Tokenize{ID: row.ID, Data: []model.Child{{Key: row.Key, Value: row.Value}}}
+		if resp, ok := m.ValidateKeys(ctx, token); !ok {
+			for i := 0; i < len(resp); i++ {
+				report.Skipped = append(report.Skipped, &Skipped{Key: resp[i].Key, Reason: resp[i].Err.Error()})
+			}
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	if opts.Atomic && len(report.Skipped) > 0 {
+		return report, ErrImportAborted
+	}
+
+	// an atomic import is committed in a single batch
+	if opts.Atomic {
+		entries := make([]tokenize.Entry, 0, len(valid))
+		for _, row := range valid {
+			entries = append(entries, tokenize.Entry{Key: row.CombinedKey(), Value: row.Value})
+		}
+		if _, err := m.TokenizeBatch(ctx, entries, false); err != nil {
+			return report, fmt.Errorf("%w: %s", ErrImportRolledBack, err.Error())
+		}
+		for _, e := range entries {
+			report.Imported = append(report.Imported, e.Key)
+		}
+		return report, nil
+	}
+
+	for _, row := range valid {
+		key := row.CombinedKey()
+		if _, err := m.Tokenize(ctx, key, row.Value); err != nil {
+			report.Skipped = append(report.Skipped, &Skipped{Key: key, Reason: err.Error()})
+			continue
+		}
+		report.Imported = append(report.Imported, key)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type ImporterTestSuite struct {
+	suite.Suite
+	log *vlog.Logger
+}
+
+var (
+	varDotenv = `DB_PASSWORD=hunter2
+API_KEY="A1B2C3D4E5F6G7H8"
+`
+	varJSON = `{"db": {"user": "admin", "password": "hunter2"}, "replicas": ["r1", "r2"], "port": 5432}`
+	varCSV  = `id,key,value
+app,db,hunter2
+app,api,A1B2C3D4
+billing,card,4111111111111111
+app,db,duplicate
+`
+)
+
+func (suite *ImporterTestSuite) SetupTest() {
+	suite.log = vlog.New(true)
+}
+
+func (suite *ImporterTestSuite) manager(ctx context.Context) *tokenize.Manager {
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	return tokenize.NewManager(ctx, suite.log, tokenize.WithStore(store.NewSyncMap(ctx, suite.log)), tokenize.WithCipherLoc(cipherLoc))
+}
+
+func (suite *ImporterTestSuite) TestParse() {
+	rows, err := Parse(strings.NewReader(varDotenv), FormatDotenv, "app")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(rows, 2)
+	suite.Require().Equal(tokenize.GetCombinedKey("app", "API_KEY"), rows[0].CombinedKey())
+	suite.Require().Equal("A1B2C3D4E5F6G7H8", rows[0].Value)
+
+	_, err = Parse(strings.NewReader(varDotenv), FormatDotenv, "")
+	suite.Require().ErrorIs(err, ErrIDRequired)
+
+	rows, err = Parse(strings.NewReader(varJSON), FormatJSON, "app")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	keys := []string{}
+	for _, row := range rows {
+		keys = append(keys, row.CombinedKey())
+	}
+	suite.Require().Contains(keys, tokenize.GetCombinedKey("app", "db", "password"))
+	suite.Require().Contains(keys, tokenize.GetCombinedKey("app", "replicas", "1"))
+	suite.Require().Contains(keys, tokenize.GetCombinedKey("app", "port"))
+
+	rows, err = Parse(strings.NewReader(varCSV), FormatCSV, "")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(rows, 4)
+
+	_, err = Parse(strings.NewReader("a,b\n1,2\n"), FormatCSV, "")
+	suite.Require().ErrorIs(err, ErrCSVHeaderInvalid)
+}
+
+func (suite *ImporterTestSuite) TestImport() {
+	ctx := context.Background()
+	m := suite.manager(ctx)
+	_, err := m.Tokenize(ctx, tokenize.GetCombinedKey("billing", "card"), "already here")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	rows, err := Parse(strings.NewReader(varCSV), FormatCSV, "")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	// atomic import aborts without writing anything
+	report, err := Import(ctx, m, rows, Options{Atomic: true})
+	suite.Require().ErrorIs(err, ErrImportAborted)
+	suite.Require().Len(report.Skipped, 2)
+	_, err = m.GetTokenByID(ctx, tokenize.GetCombinedKey("app", "api"))
+	suite.Require().Error(err)
+
+	// non atomic import skips the duplicate and the existing key
+	report, err = Import(ctx, m, rows, Options{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().ElementsMatch([]string{tokenize.GetCombinedKey("app", "db"), tokenize.GetCombinedKey("app", "api")}, report.Imported)
+	suite.Require().Len(report.Skipped, 2)
+
+	// rows can't be nested under one another, whether imported at once or not
+	nested := []*Row{{ID: "nest", Key: "db", Value: "1"}, {ID: "nest", Key: "db/password", Value: "2"}}
+	_, err = Import(ctx, m, nested, Options{Atomic: true})
+	suite.Require().ErrorIs(err, ErrImportRolledBack)
+	suite.Require().ErrorContains(err, tokenize.ErrKeyUnderValue.Error())
+	_, err = m.GetTokenByID(ctx, "nest/db")
+	suite.Require().Error(err)
+	report, err = Import(ctx, m, nested, Options{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"nest/db"}, report.Imported)
+	suite.Require().Len(report.Skipped, 1)
+	_, err = Import(ctx, m, []*Row{{ID: "over", Key: "db/password", Value: "1"}, {ID: "over", Key: "db", Value: "2"}}, Options{Atomic: true})
+	suite.Require().ErrorIs(err, ErrImportRolledBack)
+	suite.Require().ErrorContains(err, tokenize.ErrKeyUnderValue.Error())
+	report, err = Import(ctx, m, []*Row{{ID: "over", Key: "db/password", Value: "1"}, {ID: "over", Key: "db", Value: "2"}}, Options{})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"over/db/password"}, report.Imported)
+	suite.Require().Len(report.Skipped, 1)
+	suite.Require().Contains(report.Skipped[0].Reason, tokenize.ErrKeyOverValue.Error())
+}
+
+// TestImporterSuite tests the Importer suite
+func TestImporterSuite(t *testing.T) {
+	suite.Run(t, new(ImporterTestSuite))
+}
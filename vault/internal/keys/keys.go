@@ -0,0 +1,208 @@
+// Package keys implements the hierarchical key model of the vault. A key is a path of segments, e.g. app/db/password.
+// Segments are escaped before being joined, so a segment may contain the delimiter, or anything else, without
+// corrupting the hierarchy: the segment "a/b" is encoded as "a%2Fb".
+package keys
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// Delimiter separates the segments of an encoded key path
+	Delimiter = "/"
+	// LegacyDelimiter separated parent IDs and child keys before paths were introduced. See SplitStored.
+	LegacyDelimiter = "__"
+)
+
+var (
+	ErrPathEmpty        = errors.New("key path is empty")
+	ErrSegmentEmpty     = errors.New("key path contains an empty segment")
+	ErrSegmentEscape    = errors.New("key path contains an invalid escape sequence")
+	ErrDocumentConflict = errors.New("key path is both a value and a parent of other values")
+	ErrDocumentValue    = errors.New("document values must be strings, numbers, booleans, objects or arrays")
+)
+
+// Escape escapes a single segment, so it can be safely joined into a path
+func Escape(segment string) string {
+	return strings.NewReplacer("%", "%25", Delimiter, "%2F").Replace(segment)
+}
+
+// Unescape reverses Escape
+func Unescape(segment string) (string, error) {
+	s, err := url.PathUnescape(segment)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrSegmentEscape, segment)
+	}
+	return s, nil
+}
+
+// Join escapes every segment and joins them into an encoded path
+func Join(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = Escape(s)
+	}
+	return strings.Join(escaped, Delimiter)
+}
+
+// Split splits an encoded path into its unescaped segments
+func Split(path string) ([]string, error) {
+	if len(path) == 0 {
+		return nil, ErrPathEmpty
+	}
+
+	parts := strings.Split(path, Delimiter)
+	segments := make([]string, len(parts))
+	for i, p := range parts {
+		if len(p) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrSegmentEmpty, path)
+		}
+		s, err := Unescape(p)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = s
+	}
+	return segments, nil
+}
+
+// IsLegacy reports whether a stored key was written before paths were introduced, i.e. joined with LegacyDelimiter
+func IsLegacy(key string) bool {
+	return !strings.Contains(key, Delimiter) && strings.Contains(key, LegacyDelimiter)
+}
+
+// SplitStored splits a key as stored, i.e. a parent ID followed by at least one child segment. Stored keys written
+// before paths were introduced contain no Delimiter, and are split on LegacyDelimiter instead.
+func SplitStored(key string) ([]string, error) {
+	if IsLegacy(key) {
+		return strings.Split(key, LegacyDelimiter), nil
+	}
+	return Split(key)
+}
+
+// Normalize validates an encoded path, and returns it in canonical form
+func Normalize(path string) (string, error) {
+	segments, err := Split(path)
+	if err != nil {
+		return "", err
+	}
+	return Join(segments...), nil
+}
+
+// Concat joins encoded paths into a single encoded path, e.g. Concat("app", "db/password") is "app/db/password"
+func Concat(paths ...string) (string, error) {
+	var segments []string
+	for _, p := range paths {
+		s, err := Split(p)
+		if err != nil {
+			return "", err
+		}
+		segments = append(segments, s...)
+	}
+	if len(segments) == 0 {
+		return "", ErrPathEmpty
+	}
+	return Join(segments...), nil
+}
+
+// IsUnder reports whether the encoded path is prefix itself, or nested under it
+func IsUnder(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+Delimiter)
+}
+
+// Parent returns the encoded path of the parent of path. It returns false if path has a single segment.
+func Parent(path string) (string, bool) {
+	i := strings.LastIndex(path, Delimiter)
+	if i < 0 {
+		return "", false
+	}
+	return path[:i], true
+}
+
+// Rel returns path relative to prefix. It returns false if path isn't nested under prefix.
+func Rel(prefix, path string) (string, bool) {
+	if !strings.HasPrefix(path, prefix+Delimiter) {
+		return "", false
+	}
+	return strings.TrimPrefix(path, prefix+Delimiter), true
+}
+
+// Flatten walks a nested document depth first, returning every leaf value keyed by its encoded path. Array elements
+// are keyed by their index.
+func Flatten(doc map[string]any) (map[string]string, error) {
+	flat := map[string]string{}
+	if err := flatten(nil, doc, flat); err != nil {
+		return nil, err
+	}
+	return flat, nil
+}
+
+func flatten(path []string, v any, flat map[string]string) error {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, child := range val {
+			if len(k) == 0 {
+				return fmt.Errorf("%w: empty key under %s", ErrSegmentEmpty, Join(path...))
+			}
+			if err := flatten(append(path[:len(path):len(path)], k), child, flat); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, child := range val {
+			if err := flatten(append(path[:len(path):len(path)], fmt.Sprint(i)), child, flat); err != nil {
+				return err
+			}
+		}
+	case nil:
+		return fmt.Errorf("%w: %s is null", ErrDocumentValue, Join(path...))
+	case string:
+		flat[Join(path...)] = val
+	default:
+		flat[Join(path...)] = fmt.Sprint(val)
+	}
+	return nil
+}
+
+// Nest is the inverse of Flatten: it builds a nested document from values keyed by encoded path. Arrays flattened by
+// Flatten come back as objects keyed by index.
+func Nest[T any](flat map[string]T) (map[string]any, error) {
+	paths := make([]string, 0, len(flat))
+	for p := range flat {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	doc := map[string]any{}
+	for _, p := range paths {
+		segments, err := Split(p)
+		if err != nil {
+			return nil, err
+		}
+		node := doc
+		for i, s := range segments {
+			if i == len(segments)-1 {
+				if _, ok := node[s]; ok {
+					return nil, fmt.Errorf("%w: %s", ErrDocumentConflict, p)
+				}
+				node[s] = flat[p]
+				break
+			}
+			child, ok := node[s]
+			if !ok {
+				child = map[string]any{}
+				node[s] = child
+			}
+			next, ok := child.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrDocumentConflict, Join(segments[:i+1]...))
+			}
+			node = next
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,106 @@
+package keys
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type KeysTestSuite struct {
+	suite.Suite
+}
+
+var (
+	varTableJoin = []struct {
+		segments []string
+		path     string
+	}{
+		{[]string{"app", "db", "password"}, "app/db/password"},
+		{[]string{"app", "DB__PASSWORD"}, "app/DB__PASSWORD"},
+		{[]string{"app", "a/b"}, "app/a%2Fb"},
+		{[]string{"app", "100%"}, "app/100%25"},
+	}
+)
+
+func (suite *KeysTestSuite) TestJoinAndSplit() {
+	for _, tt := range varTableJoin {
+		suite.Require().Equal(tt.path, Join(tt.segments...))
+		segments, err := Split(tt.path)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equal(tt.segments, segments)
+	}
+
+	_, err := Split("app//password")
+	suite.Require().ErrorIs(err, ErrSegmentEmpty)
+	_, err = Split("app/%zz")
+	suite.Require().ErrorIs(err, ErrSegmentEscape)
+	_, err = Split("")
+	suite.Require().ErrorIs(err, ErrPathEmpty)
+}
+
+func (suite *KeysTestSuite) TestSplitStored() {
+	segments, err := SplitStored("app__db")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"app", "db"}, segments)
+
+	// keys containing the legacy delimiter below a path are left alone
+	segments, err = SplitStored("app/DB__PASSWORD")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"app", "DB__PASSWORD"}, segments)
+	suite.Require().True(IsLegacy("app__db"))
+	suite.Require().False(IsLegacy("app/DB__PASSWORD"))
+	suite.Require().False(IsLegacy("app"))
+}
+
+func (suite *KeysTestSuite) TestConcatAndRel() {
+	path, err := Concat("app", "db/password")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("app/db/password", path)
+
+	rel, ok := Rel("app", path)
+	suite.Require().True(ok)
+	suite.Require().Equal("db/password", rel)
+	_, ok = Rel("ap", path)
+	suite.Require().False(ok)
+
+	parent, ok := Parent(path)
+	suite.Require().True(ok)
+	suite.Require().Equal("app/db", parent)
+	_, ok = Parent("app")
+	suite.Require().False(ok)
+}
+
+func (suite *KeysTestSuite) TestFlattenAndNest() {
+	doc := map[string]any{
+		"db": map[string]any{
+			"password": "hunter2",
+			"port":     5432,
+		},
+		"a/b":   "escaped",
+		"hosts": []any{"one", "two"},
+	}
+	flat, err := Flatten(doc)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(map[string]string{
+		"db/password": "hunter2",
+		"db/port":     "5432",
+		"a%2Fb":       "escaped",
+		"hosts/0":     "one",
+		"hosts/1":     "two",
+	}, flat)
+
+	nested, err := Nest(flat)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(map[string]any{"password": "hunter2", "port": "5432"}, nested["db"])
+	suite.Require().Equal("escaped", nested["a/b"])
+
+	_, err = Nest(map[string]string{"db": "x", "db/password": "y"})
+	suite.Require().ErrorIs(err, ErrDocumentConflict)
+
+	_, err = Flatten(map[string]any{"db": nil})
+	suite.Require().ErrorIs(err, ErrDocumentValue)
+}
+
+// TestKeysSuite tests the Keys suite
+func TestKeysSuite(t *testing.T) {
+	suite.Run(t, new(KeysTestSuite))
+}
@@ -0,0 +1,128 @@
+// Package labels validates the labels attached to tokens, and matches them against selectors such as
+// "env=prod,team!=payments,pii,!legacy".
+package labels
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// MaxKeyLength is the maximum length of a label key
+const MaxKeyLength = 63
+
+var (
+	ErrKeyInvalid      = errors.New("label key must be 1 to 63 characters of letters, digits, '.', '_', '-' or '/'")
+	ErrSelectorInvalid = errors.New("label selector is invalid")
+)
+
+// Operator is the comparison of a Requirement
+type Operator int
+
+const (
+	// Exists matches labels holding the key, e.g. "pii"
+	Exists Operator = iota
+	// NotExists matches labels not holding the key, e.g. "!legacy"
+	NotExists
+	// Equals matches labels holding the key with the value, e.g. "env=prod"
+	Equals
+	// NotEquals matches labels not holding the key with the value, e.g. "env!=prod"
+	NotEquals
+)
+
+// Requirement is a single condition of a Selector
+type Requirement struct {
+	Key   string
+	Op    Operator
+	Value string
+}
+
+// Selector matches labels meeting all of its requirements. The empty selector matches everything.
+type Selector []Requirement
+
+// Validate checks that every label key is well-formed
+func Validate(labels map[string]string) error {
+	for k := range labels {
+		if !validKey(k) {
+			return fmt.Errorf("%w: %q", ErrKeyInvalid, k)
+		}
+	}
+	return nil
+}
+
+// Parse parses a comma separated list of requirements
+func Parse(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var req Requirement
+		switch {
+		case strings.Contains(term, "!="):
+			req.Op = NotEquals
+			req.Key, req.Value, _ = strings.Cut(term, "!=")
+		case strings.Contains(term, "=="):
+			req.Op = Equals
+			req.Key, req.Value, _ = strings.Cut(term, "==")
+		case strings.Contains(term, "="):
+			req.Op = Equals
+			req.Key, req.Value, _ = strings.Cut(term, "=")
+		case strings.HasPrefix(term, "!"):
+			req.Op = NotExists
+			req.Key = strings.TrimPrefix(term, "!")
+		default:
+			req.Op = Exists
+			req.Key = term
+		}
+		req.Key, req.Value = strings.TrimSpace(req.Key), strings.TrimSpace(req.Value)
+		if !validKey(req.Key) {
+			return nil, fmt.Errorf("%w: %q", ErrSelectorInvalid, term)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// Matches reports whether labels meet every requirement of the selector
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		v, ok := labels[req.Key]
+		switch req.Op {
+		case Exists:
+			if !ok {
+				return false
+			}
+		case NotExists:
+			if ok {
+				return false
+			}
+		case Equals:
+			if !ok || v != req.Value {
+				return false
+			}
+		case NotEquals:
+			if ok && v == req.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validKey reports whether k is a well-formed label key
+func validKey(k string) bool {
+	if len(k) == 0 || len(k) > MaxKeyLength {
+		return false
+	}
+	for _, c := range k {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("._-/", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package labels
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type LabelsTestSuite struct {
+	suite.Suite
+}
+
+var (
+	varTableMatches = []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env==prod, team", true},
+		{"env!=prod", false},
+		{"env=dev", false},
+		{"pii", false},
+		{"!pii", true},
+		{"!team", false},
+		{"region!=eu", true},
+	}
+)
+
+func (suite *LabelsTestSuite) TestMatches() {
+	labels := map[string]string{"env": "prod", "team": "payments"}
+	for _, tt := range varTableMatches {
+		sel, err := Parse(tt.selector)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equalf(tt.matches, sel.Matches(labels), "unexpected match of %q", tt.selector)
+	}
+}
+
+func (suite *LabelsTestSuite) TestInvalid() {
+	_, err := Parse("env=prod,=x")
+	suite.Require().ErrorIs(err, ErrSelectorInvalid)
+	_, err = Parse("a b")
+	suite.Require().ErrorIs(err, ErrSelectorInvalid)
+	suite.Require().ErrorIs(Validate(map[string]string{"": "x"}), ErrKeyInvalid)
+	suite.Require().NoError(Validate(map[string]string{"example.com/owner": "x"}))
+}
+
+// TestLabelsSuite tests the Labels suite
+func TestLabelsSuite(t *testing.T) {
+	suite.Run(t, new(LabelsTestSuite))
+}
@@ -0,0 +1,202 @@
+// Package mask partially reveals detokenized values, e.g. as ****1234, according to masking policies selected per
+// caller role or per request.
+package mask
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// Char replaces the masked characters of a value
+const Char = "*"
+
+// redacted replaces fully redacted values. It is of fixed length, so it doesn't leak the length of the value.
+const redacted = "********"
+
+var (
+	ErrPolicyInvalid    = errors.New("invalid masking policy. expected one of full, redact, email, first:<n>, last:<n>")
+	ErrPolicyNotAllowed = errors.New("masking policy reveals more than the caller's role allows")
+	ErrRolesInvalid     = errors.New("invalid role masking policies. expected <role>=<policy>[,<role>=<policy>...]")
+)
+
+// Kind is the kind of a masking Policy
+type Kind int
+
+const (
+	// Full reveals the whole value
+	Full Kind = iota
+	// Last reveals the last N characters, e.g. ****1234
+	Last
+	// First reveals the first N characters, e.g. 4111****
+	First
+	// Email reveals the first character of the local part of an email address, and its domain, e.g. a***@example.com
+	Email
+	// Redact reveals nothing
+	Redact
+)
+
+// Policy masks values
+type Policy struct {
+	Kind Kind
+	N    int
+}
+
+var (
+	// PolicyFull reveals values whole
+	PolicyFull = Policy{Kind: Full}
+	// PolicyRedact reveals nothing of values
+	PolicyRedact = Policy{Kind: Redact}
+)
+
+// Parse parses a policy from its text, e.g. last:4
+func Parse(s string) (Policy, error) {
+	name, arg, hasArg := strings.Cut(strings.TrimSpace(s), ":")
+	switch strings.ToLower(name) {
+	case "full":
+		return PolicyFull, noArg(s, hasArg)
+	case "redact":
+		return PolicyRedact, noArg(s, hasArg)
+	case "email":
+		return Policy{Kind: Email}, noArg(s, hasArg)
+	case "last", "first":
+		n, err := strconv.Atoi(arg)
+		if !hasArg || err != nil || n < 0 {
+			return Policy{}, fmt.Errorf("%w: %q", ErrPolicyInvalid, s)
+		}
+		if strings.ToLower(name) == "last" {
+			return Policy{Kind: Last, N: n}, nil
+		}
+		return Policy{Kind: First, N: n}, nil
+	default:
+		return Policy{}, fmt.Errorf("%w: %q", ErrPolicyInvalid, s)
+	}
+}
+
+// noArg fails policies that take no argument but were given one
+func noArg(s string, hasArg bool) error {
+	if hasArg {
+		return fmt.Errorf("%w: %q", ErrPolicyInvalid, s)
+	}
+	return nil
+}
+
+func (p Policy) String() string {
+	switch p.Kind {
+	case Full:
+		return "full"
+	case Last:
+		return "last:" + strconv.Itoa(p.N)
+	case First:
+		return "first:" + strconv.Itoa(p.N)
+	case Email:
+		return "email"
+	default:
+		return "redact"
+	}
+}
+
+// Apply masks v
+func (p Policy) Apply(v string) string {
+	r := []rune(v)
+	switch p.Kind {
+	case Full:
+		return v
+	case Last:
+		// short values are masked whole rather than revealed whole
+		if p.N >= len(r) {
+			return strings.Repeat(Char, len(r))
+		}
+		return strings.Repeat(Char, len(r)-p.N) + string(r[len(r)-p.N:])
+	case First:
+		if p.N >= len(r) {
+			return strings.Repeat(Char, len(r))
+		}
+		return string(r[:p.N]) + strings.Repeat(Char, len(r)-p.N)
+	case Email:
+		local, domain, ok := strings.Cut(v, "@")
+		l := []rune(local)
+		if !ok || len(l) == 0 {
+			return redacted
+		}
+		return string(l[:1]) + strings.Repeat(Char, len(l)-1) + "@" + domain
+	default:
+		return redacted
+	}
+}
+
+// rank orders the kinds of policy by how little they reveal
+func (p Policy) rank() int {
+	switch p.Kind {
+	case Full:
+		return 0
+	case Redact:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// AtLeastAsStrict reports whether p never reveals more than q
+func (p Policy) AtLeastAsStrict(q Policy) bool {
+	switch {
+	case p == q, p.rank() > q.rank():
+		return true
+	case p.rank() < q.rank():
+		return false
+	}
+	// partial reveals only compare with one another if they reveal the same end of the value
+	return p.Kind == q.Kind && p.N <= q.N
+}
+
+// Policies holds the masking policy of every caller role, and the one of callers without a known role
+type Policies struct {
+	Default Policy
+	Roles   map[string]Policy
+}
+
+// ParseRoles parses the masking policies of roles, e.g. support=last:4,auditor=redact,admin=full
+func ParseRoles(s string) (map[string]Policy, error) {
+	roles := map[string]Policy{}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		role, policy, ok := strings.Cut(term, "=")
+		if !ok || strings.TrimSpace(role) == "" {
+			return nil, fmt.Errorf("%w: %q", ErrRolesInvalid, term)
+		}
+		p, err := Parse(policy)
+		if err != nil {
+			return nil, err
+		}
+		roles[strings.TrimSpace(role)] = p
+	}
+	return roles, nil
+}
+
+// Resolve returns the policy applying to a caller with role, that requested the policy requested, if any. A request
+// can only make its role's policy stricter. Nil Policies reveal values whole.
+func (ps *Policies) Resolve(role, requested string) (Policy, error) {
+	p := PolicyFull
+	if ps != nil {
+		var ok bool
+		if p, ok = ps.Roles[role]; !ok {
+			p = ps.Default
+		}
+	}
+	if requested == "" {
+		return p, nil
+	}
+
+	req, err := Parse(requested)
+	if err != nil {
+		return Policy{}, err
+	}
+	if !req.AtLeastAsStrict(p) {
+		return Policy{}, fmt.Errorf("%w: %s is not allowed for role %q, whose policy is %s", ErrPolicyNotAllowed, req, role, p)
+	}
+	return req, nil
+}
@@ -0,0 +1,76 @@
+package mask
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type MaskTestSuite struct {
+	suite.Suite
+}
+
+var (
+	varTableApply = []struct {
+		policy string
+		value  string
+		masked string
+	}{
+		{"full", "4111111111111234", "4111111111111234"},
+		{"last:4", "4111111111111234", "************1234"},
+		{"first:4", "4111111111111234", "4111************"},
+		{"last:4", "123", "***"},
+		{"last:0", "abc", "***"},
+		{"email", "ada@example.com", "a**@example.com"},
+		{"email", "not an email", "********"},
+		{"redact", "hunter2", "********"},
+		{"LAST:2", "héllo", "***lo"},
+	}
+)
+
+func (suite *MaskTestSuite) TestApply() {
+	for _, tt := range varTableApply {
+		p, err := Parse(tt.policy)
+		suite.Require().NoErrorf(err, "expected no errors parsing %s, but got this %v\n", tt.policy, err)
+		suite.Require().Equalf(tt.masked, p.Apply(tt.value), "unexpected masking of %s with %s", tt.value, tt.policy)
+	}
+
+	for _, bad := range []string{"", "last", "last:x", "first:-1", "full:1", "partial"} {
+		_, err := Parse(bad)
+		suite.Require().ErrorIsf(err, ErrPolicyInvalid, "expected %q to be refused", bad)
+	}
+}
+
+func (suite *MaskTestSuite) TestResolve() {
+	roles, err := ParseRoles("support=last:4, auditor=redact,admin=full")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	ps := &Policies{Default: PolicyRedact, Roles: roles}
+
+	p, err := ps.Resolve("support", "")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(Policy{Kind: Last, N: 4}, p)
+
+	// requests may only reveal less than their role
+	p, err = ps.Resolve("support", "last:2")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(Policy{Kind: Last, N: 2}, p)
+	_, err = ps.Resolve("support", "full")
+	suite.Require().ErrorIs(err, ErrPolicyNotAllowed)
+	_, err = ps.Resolve("support", "first:4")
+	suite.Require().ErrorIs(err, ErrPolicyNotAllowed)
+	p, err = ps.Resolve("admin", "email")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(Email, p.Kind)
+
+	// callers without a known role get the default
+	p, err = ps.Resolve("intruder", "")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(PolicyRedact, p)
+
+	_, err = ParseRoles("support")
+	suite.Require().ErrorIs(err, ErrRolesInvalid)
+}
+
+// TestMaskSuite tests the Mask suite
+func TestMaskSuite(t *testing.T) {
+	suite.Run(t, new(MaskTestSuite))
+}
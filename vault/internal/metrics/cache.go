@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"github.com/dark-enstein/vault/internal/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheStats reports the hits, misses, evictions and entries of a store cache, as returned by stats when scraped. The
+// hit rate is hits / (hits + misses).
+func (m *Metrics) CacheStats(stats func() cache.Stats) {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "cache", name), help, nil, nil)
+	}
+	m.reg.MustRegister(&cacheCollector{
+		stats:     stats,
+		hits:      desc("hits_total", "Number of store reads served by the cache."),
+		misses:    desc("misses_total", "Number of store reads the cache had to read through."),
+		evictions: desc("evictions_total", "Number of entries evicted from the cache to make room."),
+		entries:   desc("entries", "Number of entries in the cache."),
+	})
+}
+
+// cacheCollector collects the stats of a cache on every scrape
+type cacheCollector struct {
+	stats                            func() cache.Stats
+	hits, misses, evictions, entries *prometheus.Desc
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.entries
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(s.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Entries))
+}
@@ -0,0 +1,163 @@
+// Package metrics exposes the activity of the vault service to Prometheus: requests and their latency per handler,
+// tokenize and detokenize outcomes, store operation latency per backend, store cache hits, and the number of tokens held.
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Namespace prefixes the name of every metric
+const Namespace = "vault"
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Metrics holds the collectors of a service, in a registry of its own
+type Metrics struct {
+	reg           *prometheus.Registry
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	tokens        *prometheus.CounterVec
+	storeDuration *prometheus.HistogramVec
+	storeErrors   *prometheus.CounterVec
+}
+
+// New creates the collectors, along with the standard Go runtime and process ones
+func New() *Metrics {
+	m := &Metrics{
+		reg: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests served, per handler, method and status code.",
+		}, []string{"handler", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests, per handler and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"handler", "method"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "token_operations_total",
+			Help:      "Number of values tokenized or detokenized, per operation and result.",
+		}, []string{"op", "result"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "store",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of store operations, per backend and operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"backend", "op"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "store",
+			Name:      "errors_total",
+			Help:      "Number of failed store operations, per backend and operation.",
+		}, []string{"backend", "op"}),
+	}
+	m.reg.MustRegister(m.requests, m.duration, m.tokens, m.storeDuration, m.storeErrors,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{})
+}
+
+// Instrument counts the requests served by h on route, and observes their latency. Nil Metrics leave h as it is.
+func (m *Metrics) Instrument(route string, h func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		m.duration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveTokens counts n values that went through op. It is a tokenize.Observer.
+func (m *Metrics) ObserveTokens(op string, n int, err error) {
+	if m == nil {
+		return
+	}
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+	m.tokens.WithLabelValues(op, result).Add(float64(n))
+}
+
+// CountTokens reports the number of tokens held, as counted by count when scraped
+func (m *Metrics) CountTokens(count func(ctx context.Context) (int, error)) {
+	m.reg.MustRegister(&tokenCollector{
+		desc:  prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "tokens"), "Number of tokens held by the store.", nil, nil),
+		count: count,
+	})
+}
+
+// scrapeTimeout bounds the time spent counting tokens on a scrape
+var scrapeTimeout = 5 * time.Second
+
+// tokenCollector counts the tokens held on every scrape, so the count is never stale
+type tokenCollector struct {
+	desc  *prometheus.Desc
+	count func(ctx context.Context) (int, error)
+}
+
+func (c *tokenCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *tokenCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+	n, err := c.count(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n))
+}
+
+// statusWriter records the status code written. It unwraps to the writer it wraps, so http.ResponseController can
+// still reach its flushing and full duplex support.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/cache"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+}
+
+// scrape returns the metrics exposed by m
+func (suite *MetricsTestSuite) scrape(m *Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	b, err := io.ReadAll(rec.Body)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	return string(b)
+}
+
+func (suite *MetricsTestSuite) TestInstrument() {
+	m := New()
+	h := m.Instrument("/detokenize", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/detokenize", nil))
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/detokenize", nil))
+	m.Instrument("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hi"))
+	})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/new", nil))
+
+	m.ObserveTokens("tokenize", 3, nil)
+	m.ObserveTokens("detokenize", 1, errors.New("no match"))
+
+	out := suite.scrape(m)
+	suite.Require().Contains(out, `vault_http_requests_total{code="400",handler="/detokenize",method="POST"} 2`)
+	suite.Require().Contains(out, `vault_http_requests_total{code="200",handler="/new",method="GET"} 1`)
+	suite.Require().Contains(out, `vault_http_request_duration_seconds_count{handler="/detokenize",method="POST"} 2`)
+	suite.Require().Contains(out, `vault_token_operations_total{op="tokenize",result="success"} 3`)
+	suite.Require().Contains(out, `vault_token_operations_total{op="detokenize",result="failure"} 1`)
+
+	// nil metrics leave handlers alone
+	var none *Metrics
+	suite.Require().NotNil(none.Instrument("/new", h))
+}
+
+func (suite *MetricsTestSuite) TestStore() {
+	ctx := context.Background()
+	m := New()
+	s := m.Store(store.NewSyncMap(ctx, vlog.New(true)), "map")
+	suite.Require().NoError(s.Store(ctx, "app/a", "t"))
+	_, err := s.Retrieve(ctx, "app/missing")
+	suite.Require().Error(err)
+
+	m.CountTokens(func(ctx context.Context) (int, error) {
+		page, err := s.Scan(ctx, store.ScanOptions{KeysOnly: true})
+		if err != nil {
+			return 0, err
+		}
+		return len(page.Keys), nil
+	})
+
+	out := suite.scrape(m)
+	suite.Require().Contains(out, `vault_store_operation_duration_seconds_count{backend="map",op="store"} 1`)
+	suite.Require().Contains(out, `vault_store_errors_total{backend="map",op="retrieve"} 1`)
+	suite.Require().Contains(out, "vault_tokens 1")
+}
+
+func (suite *MetricsTestSuite) TestCacheStats() {
+	ctx := context.Background()
+	m := New()
+	c := cache.New(store.NewSyncMap(ctx, vlog.New(true)), cache.Config{})
+	m.CacheStats(c.Stats)
+	suite.Require().NoError(c.Store(ctx, "app/a", "t"))
+	c.Retrieve(ctx, "app/a")
+	c.Retrieve(ctx, "app/a")
+
+	out := suite.scrape(m)
+	suite.Require().Contains(out, "vault_cache_hits_total 1")
+	suite.Require().Contains(out, "vault_cache_misses_total 1")
+	suite.Require().Contains(out, "vault_cache_entries 1")
+}
+
+// TestMetricsSuite tests the Metrics suite
+func TestMetricsSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
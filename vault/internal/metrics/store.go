@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"time"
+)
+
+// Store wraps s, a store of the given backend, to observe the latency and failures of its operations
+func (m *Metrics) Store(s store.Store, backend string) store.Store {
+	return &instrumentedStore{s: s, m: m, backend: backend}
+}
+
+// instrumentedStore observes every operation of the store it wraps
+type instrumentedStore struct {
+	s       store.Store
+	m       *Metrics
+	backend string
+}
+
+// observe records an operation started at start, that failed with err if any
+func (i *instrumentedStore) observe(op string, start time.Time, err error) {
+	i.m.storeDuration.WithLabelValues(i.backend, op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		i.m.storeErrors.WithLabelValues(i.backend, op).Inc()
+	}
+}
+
+func (i *instrumentedStore) Connect(ctx context.Context) (ok bool, err error) {
+	defer func(start time.Time) { i.observe("connect", start, err) }(time.Now())
+	return i.s.Connect(ctx)
+}
+
+func (i *instrumentedStore) Store(ctx context.Context, id string, token any) (err error) {
+	defer func(start time.Time) { i.observe("store", start, err) }(time.Now())
+	return i.s.Store(ctx, id, token)
+}
+
+func (i *instrumentedStore) Retrieve(ctx context.Context, id string) (v string, err error) {
+	defer func(start time.Time) { i.observe("retrieve", start, err) }(time.Now())
+	return i.s.Retrieve(ctx, id)
+}
+
+func (i *instrumentedStore) RetrieveAll(ctx context.Context) (all map[string]string, err error) {
+	defer func(start time.Time) { i.observe("retrieve_all", start, err) }(time.Now())
+	return i.s.RetrieveAll(ctx)
+}
+
+func (i *instrumentedStore) Scan(ctx context.Context, opts store.ScanOptions) (page *store.Page, err error) {
+	defer func(start time.Time) { i.observe("scan", start, err) }(time.Now())
+	return i.s.Scan(ctx, opts)
+}
+
+func (i *instrumentedStore) Delete(ctx context.Context, id string) (ok bool, err error) {
+	defer func(start time.Time) { i.observe("delete", start, err) }(time.Now())
+	return i.s.Delete(ctx, id)
+}
+
+func (i *instrumentedStore) Patch(ctx context.Context, id string, token any) (ok bool, err error) {
+	defer func(start time.Time) { i.observe("patch", start, err) }(time.Now())
+	return i.s.Patch(ctx, id, token)
+}
+
+func (i *instrumentedStore) Batch(ctx context.Context, ops []store.Op) (err error) {
+	defer func(start time.Time) { i.observe("batch", start, err) }(time.Now())
+	return i.s.Batch(ctx, ops)
+}
+
+func (i *instrumentedStore) Flush(ctx context.Context) (ok bool, err error) {
+	defer func(start time.Time) { i.observe("flush", start, err) }(time.Now())
+	return i.s.Flush(ctx)
+}
+
+func (i *instrumentedStore) Close(ctx context.Context) (err error) {
+	defer func(start time.Time) { i.observe("close", start, err) }(time.Now())
+	return i.s.Close(ctx)
+}
+
+func (i *instrumentedStore) Ping(ctx context.Context) (ok bool, err error) {
+	defer func(start time.Time) { i.observe("ping", start, err) }(time.Now())
+	return store.Ping(ctx, i.s)
+}
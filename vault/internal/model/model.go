@@ -1,28 +1,42 @@
 package model
 
+import "time"
+
+// Child is a key and its value. Revision is set on the children of responses, and ExpectedRevision may be set on patches
+// to only apply them if the key is still at that revision. Labels are free-form metadata used to select tokens; patches
+// keep them unless new ones are given.
 type Child struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key              string            `json:"key"`
+	Value            string            `json:"value"`
+	Revision         int64             `json:"revision,omitempty"`
+	ExpectedRevision int64             `json:"expected_revision,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
 }
 
+// Tokenize addresses its children by path relative to ID, e.g. "db/password". Children may also be passed as a nested
+// Document, which is flattened into Data.
 type Tokenize struct {
-	ID   string  `json:"id"`
-	Data []Child `json:"data"`
+	ID       string         `json:"id"`
+	Data     []Child        `json:"data"`
+	Document map[string]any `json:"document,omitempty"`
 }
 
 type TokenizeResponse struct {
-	ID   string  `json:"id"`
-	Data []Child `json:"data"`
+	ID       string         `json:"id"`
+	Data     []Child        `json:"data"`
+	Document map[string]any `json:"document,omitempty"`
 }
 
 type Detokenize struct {
-	ID   string  `json:"id"`
-	Data []Child `json:"data"`
+	ID       string         `json:"id"`
+	Data     []Child        `json:"data"`
+	Document map[string]any `json:"document,omitempty"`
 }
 
 type DetokenizeResponse struct {
-	ID   string          `json:"id"`
-	Data []*ChildReceipt `json:"data"`
+	ID       string          `json:"id"`
+	Data     []*ChildReceipt `json:"data"`
+	Document map[string]any  `json:"document,omitempty"`
 }
 
 type ChildReceipt struct {
@@ -30,13 +44,194 @@ type ChildReceipt struct {
 	Value *ChildResp `json:"value"`
 }
 
-type ChildResp struct {
+// StreamLine is a line of a streamed detokenize request: a token, and the key it is stored under. Key is relative to ID
+// if one is given.
+type StreamLine struct {
+	ID    string `json:"id,omitempty"`
+	Key   string `json:"key"`
+	Token string `json:"token"`
+}
+
+// StreamResult is a line of a streamed detokenize response, for the request line numbered Line. Lines that failed hold
+// the Error instead of the Datum; a Line of 0 reports the failure of the stream itself.
+type StreamResult struct {
+	Line  int    `json:"line"`
+	ID    string `json:"id,omitempty"`
+	Key   string `json:"key,omitempty"`
 	Found bool   `json:"found"`
-	Datum string `json:"datum"`
+	Datum string `json:"datum,omitempty"`
+	Error string `json:"error,omitempty"`
 }
 
+// ChildResp is a detokenized value. Revision is the revision of the key the token was found at, for a later
+// conditional patch or delete.
+type ChildResp struct {
+	Found    bool   `json:"found"`
+	Datum    string `json:"datum"`
+	Revision int64  `json:"revision,omitempty"`
+}
+
+// All holds a page of tokens grouped by ID, or only their keys, and the cursor of the next page if there is one
 type All struct {
 	Tokens []*Tokenize `json:"tokens"`
+	Keys   []string    `json:"keys,omitempty"`
+	Next   string      `json:"next,omitempty"`
+}
+
+// Path is the body of writes to a token path, holding either a single Value, or a Document nested under the path
+type Path struct {
+	Value            *string           `json:"value,omitempty"`
+	Document         map[string]any    `json:"document,omitempty"`
+	ExpectedRevision int64             `json:"expected_revision,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+}
+
+// PathResponse holds the token stored at a path and its revision, or the document of tokens nested under it
+type PathResponse struct {
+	Path     string `json:"path"`
+	Token    string `json:"token,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+	Document any    `json:"document,omitempty"`
+}
+
+// Transform is a JSON document whose fields selected by the JSONPaths are to be transformed. ID identifies the document:
+// its fields are stored under it, e.g. order-17/customer/email.
+type Transform struct {
+	ID       string   `json:"id"`
+	Document any      `json:"document"`
+	Paths    []string `json:"paths"`
+}
+
+// TransformResponse holds the transformed document, and the fields transformed, relative to ID
+type TransformResponse struct {
+	ID       string   `json:"id"`
+	Document any      `json:"document"`
+	Fields   []string `json:"fields"`
+}
+
+// Health is the outcome of the health checks of the service. Status is ok only if every check is.
+type Health struct {
+	Status string            `json:"status"`
+	Checks map[string]*Check `json:"checks"`
+}
+
+// Check is the outcome of a single health check, and how long it took
+type Check struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+// Wrap asks for the value of the token of key under ID to be wrapped for TTL, e.g. 10m
+type Wrap struct {
+	ID    string `json:"id"`
+	Key   string `json:"key"`
+	Token string `json:"token"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// WrapResponse holds the single-use wrapping token handing off a value, and when it expires
+type WrapResponse struct {
+	WrapToken string    `json:"wrap_token"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Unwrap asks for the value wrapped under WrapToken
+type Unwrap struct {
+	WrapToken string `json:"wrap_token"`
+}
+
+// UnwrapResponse holds an unwrapped value, and the key under ID it was read from
+type UnwrapResponse struct {
+	ID    string `json:"id"`
+	Key   string `json:"key"`
+	Datum string `json:"datum"`
+}
+
+// Generate asks for a secret of Type to be generated, following the policy of the fields of its type, and tokenized
+// under Key under ID. The value is only returned with Reveal.
+type Generate struct {
+	ID      string `json:"id"`
+	Key     string `json:"key"`
+	Type    string `json:"type"`
+	Length  int    `json:"length,omitempty"`
+	Charset string `json:"charset,omitempty"`
+	Symbols bool   `json:"symbols,omitempty"`
+	Bits    int    `json:"bits,omitempty"`
+	Reveal  bool   `json:"reveal,omitempty"`
+}
+
+// GenerateResponse holds the token of a secret generated, and its revision. Public holds the public key of key pairs,
+// and Datum the value, if it was asked to be revealed.
+type GenerateResponse struct {
+	ID       string `json:"id"`
+	Key      string `json:"key"`
+	Type     string `json:"type"`
+	Token    string `json:"token"`
+	Revision int64  `json:"revision"`
+	Public   string `json:"public,omitempty"`
+	Datum    string `json:"datum,omitempty"`
+}
+
+// TransitKey asks for a transit key of Type to be created: aes256-gcm, the default, or ed25519
+type TransitKey struct {
+	Type string `json:"type,omitempty"`
+}
+
+// TransitKeyResponse describes a transit key, and its versions. PublicKey holds the public key of ed25519 versions.
+type TransitKeyResponse struct {
+	Name          string              `json:"name"`
+	Type          string              `json:"type"`
+	LatestVersion int                 `json:"latest_version"`
+	Versions      []TransitKeyVersion `json:"versions"`
+}
+
+type TransitKeyVersion struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	PublicKey string    `json:"public_key,omitempty"`
+}
+
+// TransitEncrypt asks for the base64 encoded Plaintext to be encrypted with the transit key Key
+type TransitEncrypt struct {
+	Key       string `json:"key"`
+	Plaintext string `json:"plaintext"`
+}
+
+// TransitDecrypt asks for Ciphertext to be decrypted with the transit key Key, or rewrapped under its latest version
+type TransitDecrypt struct {
+	Key        string `json:"key"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// TransitSign asks for the base64 encoded Input to be signed with the transit key Key
+type TransitSign struct {
+	Key   string `json:"key"`
+	Input string `json:"input"`
+}
+
+// TransitVerify asks whether Signature is a signature of the base64 encoded Input by the transit key Key
+type TransitVerify struct {
+	Key       string `json:"key"`
+	Input     string `json:"input"`
+	Signature string `json:"signature"`
+}
+
+// TransitResponse holds the result of a transit operation: a ciphertext, a base64 encoded plaintext, or a signature,
+// and the version of the key that made it
+type TransitResponse struct {
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Plaintext  string `json:"plaintext,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	KeyVersion int    `json:"key_version,omitempty"`
+}
+
+type TransitVerifyResponse struct {
+	Valid bool `json:"valid"`
+}
+
+type Backup struct {
+	Passphrase string `json:"passphrase"`
 }
 
 type Resp interface {
@@ -0,0 +1,282 @@
+// Package namespace isolates the keyspaces of tenants sharing a store. Every namespace is a view of the store that
+// only sees, and only writes, the keys under its own Prefix. The root namespace sees every key but those of the other
+// namespaces.
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Root is the namespace used when none is given
+	Root = ""
+	// Prefix is the path namespaced keys are stored under, followed by the name of their namespace
+	Prefix = "_ns"
+)
+
+var (
+	ErrNameInvalid = errors.New("invalid namespace. expected 1 to 63 lowercase letters, digits, '-' or '_', starting with a letter or digit")
+	ErrKeyReserved = errors.New("keys under " + Prefix + " are reserved for namespaces")
+)
+
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Validate checks that name is a valid namespace name. The root namespace is valid.
+func Validate(name string) error {
+	if name == Root || nameRe.MatchString(name) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrNameInvalid, name)
+}
+
+// CipherLoc returns where the data key of the namespace name is kept, next to the root one at root. Every namespace
+// has a key of its own, so one tenant's key can't decrypt another's tokens.
+func CipherLoc(root, name string) string {
+	if name == Root {
+		return root
+	}
+	return root + "." + name
+}
+
+// Store returns the view of s of the namespace name. The view of the root namespace owns s: connecting and closing it
+// connects and closes s. The views of other namespaces share s, which must be connected by its owner.
+func Store(s store.Store, name string) (store.Store, error) {
+	if err := Validate(name); err != nil {
+		return nil, err
+	}
+	if name == Root {
+		return &rootStore{s: s}, nil
+	}
+	return &namespacedStore{s: s, prefix: keys.Join(Prefix, name) + keys.Delimiter}, nil
+}
+
+// Of returns the namespace the key key of the store belongs to, Root for keys of no other namespace
+func Of(key string) string {
+	rest, ok := strings.CutPrefix(key, Prefix+keys.Delimiter)
+	if !ok {
+		return Root
+	}
+	name, _, _ := strings.Cut(rest, keys.Delimiter)
+	return name
+}
+
+// reserved reports whether key belongs to a namespace
+func reserved(key string) bool {
+	return key == Prefix || strings.HasPrefix(key, Prefix+keys.Delimiter)
+}
+
+// rootStore is the view of the root namespace. It hides the keys of other namespaces, and refuses to touch them.
+type rootStore struct {
+	s store.Store
+}
+
+func (r *rootStore) Connect(ctx context.Context) (bool, error) {
+	return r.s.Connect(ctx)
+}
+
+func (r *rootStore) Store(ctx context.Context, id string, token any) error {
+	if reserved(id) {
+		return ErrKeyReserved
+	}
+	return r.s.Store(ctx, id, token)
+}
+
+func (r *rootStore) Retrieve(ctx context.Context, id string) (string, error) {
+	if reserved(id) {
+		return "", ErrKeyReserved
+	}
+	return r.s.Retrieve(ctx, id)
+}
+
+func (r *rootStore) RetrieveAll(ctx context.Context) (map[string]string, error) {
+	all, err := r.s.RetrieveAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for k := range all {
+		if reserved(k) {
+			delete(all, k)
+		}
+	}
+	return all, nil
+}
+
+func (r *rootStore) Scan(ctx context.Context, opts store.ScanOptions) (*store.Page, error) {
+	page, err := r.s.Scan(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	visible := page.Keys[:0]
+	for _, k := range page.Keys {
+		if reserved(k) {
+			delete(page.Values, k)
+			continue
+		}
+		visible = append(visible, k)
+	}
+	page.Keys = visible
+	return page, nil
+}
+
+func (r *rootStore) Delete(ctx context.Context, id string) (bool, error) {
+	if reserved(id) {
+		return false, ErrKeyReserved
+	}
+	return r.s.Delete(ctx, id)
+}
+
+func (r *rootStore) Patch(ctx context.Context, id string, token any) (bool, error) {
+	if reserved(id) {
+		return false, ErrKeyReserved
+	}
+	return r.s.Patch(ctx, id, token)
+}
+
+func (r *rootStore) Batch(ctx context.Context, ops []store.Op) error {
+	for _, op := range ops {
+		if reserved(op.ID) {
+			return ErrKeyReserved
+		}
+	}
+	return r.s.Batch(ctx, ops)
+}
+
+// Flush deletes the keys of the root namespace, leaving those of the other namespaces
+func (r *rootStore) Flush(ctx context.Context) (bool, error) {
+	all, err := r.s.RetrieveAll(ctx)
+	if err != nil {
+		return false, err
+	}
+	var namespaced bool
+	for k := range all {
+		if reserved(k) {
+			namespaced = true
+			break
+		}
+	}
+	if !namespaced {
+		return r.s.Flush(ctx)
+	}
+	return flush(ctx, r.s, all, func(k string) bool { return !reserved(k) })
+}
+
+func (r *rootStore) Close(ctx context.Context) error {
+	return r.s.Close(ctx)
+}
+
+func (r *rootStore) Ping(ctx context.Context) (bool, error) {
+	return store.Ping(ctx, r.s)
+}
+
+// namespacedStore is the view of a namespace other than the root one. It prefixes every key it is given, and strips
+// the prefix from every key it returns.
+type namespacedStore struct {
+	s      store.Store
+	prefix string
+}
+
+// Connect doesn't connect the shared store, as connecting some stores twice resets them
+func (n *namespacedStore) Connect(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (n *namespacedStore) Store(ctx context.Context, id string, token any) error {
+	return n.s.Store(ctx, n.prefix+id, token)
+}
+
+func (n *namespacedStore) Retrieve(ctx context.Context, id string) (string, error) {
+	return n.s.Retrieve(ctx, n.prefix+id)
+}
+
+func (n *namespacedStore) RetrieveAll(ctx context.Context) (map[string]string, error) {
+	all, err := n.s.RetrieveAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	own := make(map[string]string)
+	for k, v := range all {
+		if strings.HasPrefix(k, n.prefix) {
+			own[strings.TrimPrefix(k, n.prefix)] = v
+		}
+	}
+	return own, nil
+}
+
+func (n *namespacedStore) Scan(ctx context.Context, opts store.ScanOptions) (*store.Page, error) {
+	opts.Prefix = n.prefix + opts.Prefix
+	page, err := n.s.Scan(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range page.Keys {
+		page.Keys[i] = strings.TrimPrefix(k, n.prefix)
+	}
+	if page.Values != nil {
+		values := make(map[string]string, len(page.Values))
+		for k, v := range page.Values {
+			values[strings.TrimPrefix(k, n.prefix)] = v
+		}
+		page.Values = values
+	}
+	return page, nil
+}
+
+func (n *namespacedStore) Delete(ctx context.Context, id string) (bool, error) {
+	return n.s.Delete(ctx, n.prefix+id)
+}
+
+func (n *namespacedStore) Patch(ctx context.Context, id string, token any) (bool, error) {
+	return n.s.Patch(ctx, n.prefix+id, token)
+}
+
+func (n *namespacedStore) Batch(ctx context.Context, ops []store.Op) error {
+	prefixed := make([]store.Op, len(ops))
+	for i, op := range ops {
+		op.ID = n.prefix + op.ID
+		prefixed[i] = op
+	}
+	return n.s.Batch(ctx, prefixed)
+}
+
+// Flush deletes the keys of the namespace, leaving those of the other namespaces
+func (n *namespacedStore) Flush(ctx context.Context) (bool, error) {
+	all, err := n.s.RetrieveAll(ctx)
+	if err != nil {
+		return false, err
+	}
+	return flush(ctx, n.s, all, func(k string) bool { return strings.HasPrefix(k, n.prefix) })
+}
+
+// Close doesn't close the shared store, which its owner closes
+func (n *namespacedStore) Close(ctx context.Context) error {
+	return nil
+}
+
+func (n *namespacedStore) Ping(ctx context.Context) (bool, error) {
+	return store.Ping(ctx, n.s)
+}
+
+// flush deletes the entries of all matching owned from s, in a single batch. Entries modified since they were read
+// fail the batch, rather than being deleted unseen.
+func flush(ctx context.Context, s store.Store, all map[string]string, owned func(string) bool) (bool, error) {
+	var ops []store.Op
+	for k, v := range all {
+		if owned(k) {
+			v := v
+			ops = append(ops, store.Op{Kind: store.OpDelete, ID: k, Expect: &v})
+		}
+	}
+	if len(ops) == 0 {
+		return true, nil
+	}
+	if err := s.Batch(ctx, ops); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package namespace
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type NamespaceTestSuite struct {
+	suite.Suite
+	base store.Store
+}
+
+func (suite *NamespaceTestSuite) SetupTest() {
+	suite.base = store.NewSyncMap(context.Background(), vlog.New(true))
+}
+
+// view returns the view of the namespace name of the base store
+func (suite *NamespaceTestSuite) view(name string) store.Store {
+	s, err := Store(suite.base, name)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	return s
+}
+
+func (suite *NamespaceTestSuite) TestValidate() {
+	for _, name := range []string{Root, "payments", "team-a", "a_1", "0"} {
+		suite.Require().NoErrorf(Validate(name), "expected %q to be valid", name)
+	}
+	for _, name := range []string{"Payments", "-a", "a/b", "a b", "_ns", "a.b"} {
+		suite.Require().ErrorIsf(Validate(name), ErrNameInvalid, "expected %q to be refused", name)
+	}
+	suite.Require().Equal("/x/.cipher", CipherLoc("/x/.cipher", Root))
+	suite.Require().Equal("/x/.cipher.payments", CipherLoc("/x/.cipher", "payments"))
+}
+
+func (suite *NamespaceTestSuite) TestIsolation() {
+	ctx := context.Background()
+	root, a, b := suite.view(Root), suite.view("a"), suite.view("b")
+
+	// the same key holds a value of its own in every namespace
+	suite.Require().NoError(root.Store(ctx, "app/db", "r"))
+	suite.Require().NoError(a.Store(ctx, "app/db", "a"))
+	suite.Require().NoError(b.Batch(ctx, []store.Op{{Kind: store.OpStore, ID: "app/db", Token: "b"}, {Kind: store.OpStore, ID: "app/cache", Token: "b2"}}))
+	for s, want := range map[store.Store]string{root: "r", a: "a", b: "b"} {
+		v, err := s.Retrieve(ctx, "app/db")
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equal(want, v)
+	}
+
+	all, err := root.RetrieveAll(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(map[string]string{"app/db": "r"}, all)
+	all, err = b.RetrieveAll(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(map[string]string{"app/db": "b", "app/cache": "b2"}, all)
+
+	page, err := b.Scan(ctx, store.ScanOptions{Prefix: "app/", Count: 1})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"app/cache"}, page.Keys)
+	suite.Require().Equal("b2", page.Values["app/cache"])
+	page, err = b.Scan(ctx, store.ScanOptions{Prefix: "app/", Cursor: page.Cursor})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"app/db"}, page.Keys)
+	page, err = root.Scan(ctx, store.ScanOptions{KeysOnly: true})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"app/db"}, page.Keys)
+
+	// the root namespace can't reach into the others
+	_, err = root.Retrieve(ctx, "_ns/a/app/db")
+	suite.Require().ErrorIs(err, ErrKeyReserved)
+	suite.Require().ErrorIs(root.Batch(ctx, []store.Op{{Kind: store.OpDelete, ID: "_ns/a/app/db"}}), ErrKeyReserved)
+
+	// flushing a namespace leaves the others
+	_, err = a.Flush(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	_, err = a.Retrieve(ctx, "app/db")
+	suite.Require().Error(err)
+	_, err = root.Flush(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	all, err = root.RetrieveAll(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Empty(all)
+	all, err = b.RetrieveAll(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(all, 2)
+}
+
+// TestNamespaceSuite tests the Namespace suite
+func TestNamespaceSuite(t *testing.T) {
+	suite.Run(t, new(NamespaceTestSuite))
+}
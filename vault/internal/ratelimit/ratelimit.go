@@ -0,0 +1,152 @@
+// Package ratelimit limits the rate of requests of every client with token buckets, one per client.
+package ratelimit
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLimitInvalid  = errors.New("invalid rate limit. expected <n>/<s|m|h>[:<burst>], e.g. 10/s or 600/m:20")
+	ErrRoutesInvalid = errors.New("invalid route rate limits. expected <route>=<limit>[,<route>=<limit>...]")
+)
+
+// sweepInterval is how often buckets that refilled are forgotten, so idle clients don't hold memory
+var sweepInterval = time.Minute
+
+// Limit is a rate of requests, and the number of requests that can be made at once above it
+type Limit struct {
+	// Rate is the number of requests allowed per second
+	Rate float64
+	// Burst is the number of requests allowed at once
+	Burst int
+}
+
+// Parse parses a limit from its text, e.g. 10/s, 600/m or 600/m:20. The burst defaults to a second's worth of
+// requests, and at least 1.
+func Parse(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	spec, burst, hasBurst := strings.Cut(s, ":")
+	count, unit, ok := strings.Cut(spec, "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if !ok || err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("%w: %q", ErrLimitInvalid, s)
+	}
+
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("%w: %q", ErrLimitInvalid, s)
+	}
+
+	l := Limit{Rate: n / per.Seconds()}
+	l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	if hasBurst {
+		if l.Burst, err = strconv.Atoi(burst); err != nil || l.Burst < 1 {
+			return Limit{}, fmt.Errorf("%w: %q", ErrLimitInvalid, s)
+		}
+	}
+	return l, nil
+}
+
+// ParseRoutes parses the limits of routes, e.g. /detokenize=10/s,/tokenize=100/s:200
+func ParseRoutes(s string) (map[string]Limit, error) {
+	routes := map[string]Limit{}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		route, limit, ok := strings.Cut(term, "=")
+		if !ok || !strings.HasPrefix(strings.TrimSpace(route), "/") {
+			return nil, fmt.Errorf("%w: %q", ErrRoutesInvalid, term)
+		}
+		l, err := Parse(limit)
+		if err != nil {
+			return nil, err
+		}
+		routes[strings.TrimSpace(route)] = l
+	}
+	return routes, nil
+}
+
+// bucket holds the tokens left to a client, as of last
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter limits the rate of requests of every client to its Limit
+type Limiter struct {
+	limit     Limit
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New creates a limiter of every client to l
+func New(l Limit) *Limiter {
+	return &Limiter{limit: l, buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// Limit returns the limit of the limiter
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token from the bucket of client. If there is none left, it returns false, and how long until there is.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// Cancel gives the token taken by the last request of client allowed back, e.g. because another limit refused it
+func (l *Limiter) Cancel(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[client]; ok {
+		b.tokens = math.Min(float64(l.limit.Burst), b.tokens+1)
+	}
+}
+
+// sweep forgets the buckets that refilled since they were last used, as they are as good as new
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type RateLimitTestSuite struct {
+	suite.Suite
+}
+
+var (
+	varTableParse = []struct {
+		spec  string
+		limit Limit
+	}{
+		{"10/s", Limit{Rate: 10, Burst: 10}},
+		{"600/m", Limit{Rate: 10, Burst: 10}},
+		{"600/m:20", Limit{Rate: 10, Burst: 20}},
+		{"1/m", Limit{Rate: 1.0 / 60, Burst: 1}},
+		{"36/h:3", Limit{Rate: 0.01, Burst: 3}},
+	}
+)
+
+func (suite *RateLimitTestSuite) TestParse() {
+	for _, tt := range varTableParse {
+		l, err := Parse(tt.spec)
+		suite.Require().NoErrorf(err, "expected no errors parsing %s, but got this %v\n", tt.spec, err)
+		suite.Require().InDeltaf(tt.limit.Rate, l.Rate, 1e-9, "unexpected rate of %s", tt.spec)
+		suite.Require().Equalf(tt.limit.Burst, l.Burst, "unexpected burst of %s", tt.spec)
+	}
+
+	for _, bad := range []string{"", "10", "10/d", "0/s", "-1/s", "x/s", "10/s:0", "10/s:x"} {
+		_, err := Parse(bad)
+		suite.Require().ErrorIsf(err, ErrLimitInvalid, "expected %q to be refused", bad)
+	}
+
+	routes, err := ParseRoutes("/detokenize=10/s, /tokenize=100/s:200")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(Limit{Rate: 100, Burst: 200}, routes["/tokenize"])
+	_, err = ParseRoutes("detokenize=10/s")
+	suite.Require().ErrorIs(err, ErrRoutesInvalid)
+}
+
+func (suite *RateLimitTestSuite) TestAllow() {
+	now := time.Unix(0, 0)
+	l := New(Limit{Rate: 2, Burst: 3})
+	l.now = func() time.Time { return now }
+
+	// a burst goes through, then the bucket is empty
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		suite.Require().Truef(ok, "expected request %d of the burst to be allowed", i)
+	}
+	ok, wait := l.Allow("a")
+	suite.Require().False(ok)
+	suite.Require().Equal(500*time.Millisecond, wait)
+
+	// clients have buckets of their own
+	ok, _ = l.Allow("b")
+	suite.Require().True(ok)
+
+	// the bucket refills at the rate
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	suite.Require().True(ok)
+	ok, _ = l.Allow("a")
+	suite.Require().False(ok)
+
+	// cancelled requests give their token back, up to the burst
+	l.Cancel("a")
+	ok, _ = l.Allow("a")
+	suite.Require().True(ok)
+	l.Cancel("b")
+	l.Cancel("b")
+	suite.Require().Equal(float64(3), l.buckets["b"].tokens)
+
+	// idle clients are forgotten once their bucket refilled
+	now = now.Add(2 * sweepInterval)
+	l.Allow("c")
+	suite.Require().Len(l.buckets, 1)
+}
+
+// TestRateLimitSuite tests the RateLimit suite
+func TestRateLimitSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
@@ -0,0 +1,208 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"time"
+)
+
+const (
+	// FuncName is the template function resolving vault references, e.g. {{ vault "db" "password" }}
+	FuncName = "vault"
+)
+
+var (
+	ErrRefInvalid    = errors.New("vault reference needs an id and at least one key, e.g. {{ vault \"db\" \"password\" }}")
+	ErrRefUnresolved = errors.New("vault reference could not be resolved")
+)
+
+// Ref is a single vault reference found in a template
+type Ref struct {
+	ID   string   `json:"id"`
+	Keys []string `json:"keys"`
+}
+
+// Key returns the store key the reference points to
+func (r *Ref) Key() string {
+	return tokenize.GetCombinedKey(append([]string{r.ID}, r.Keys...)...)
+}
+
+// RefStatus reports whether a reference resolves, without revealing its value
+type RefStatus struct {
+	Ref      *Ref   `json:"ref"`
+	Resolves bool   `json:"resolves"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Template is a parsed config template whose vault references are resolved through a tokenize.Manager
+type Template struct {
+	name string
+	src  string
+	m    *tokenize.Manager
+}
+
+// New parses src, ensuring it is a valid template
+func New(name, src string, m *tokenize.Manager) (*Template, error) {
+	t := &Template{name: name, src: src, m: m}
+	if _, err := t.parse(func(id string, keys ...string) (string, error) { return "", nil }); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Template) parse(fn func(id string, keys ...string) (string, error)) (*template.Template, error) {
+	return template.New(t.name).Option("missingkey=error").Funcs(template.FuncMap{FuncName: fn}).Parse(t.src)
+}
+
+// Refs returns every vault reference in the template, in order of first appearance
+func (t *Template) Refs() ([]*Ref, error) {
+	var refs []*Ref
+	seen := map[string]bool{}
+	tpl, err := t.parse(func(id string, keys ...string) (string, error) {
+		if len(id) == 0 || len(keys) == 0 {
+			return "", ErrRefInvalid
+		}
+		ref := &Ref{ID: id, Keys: keys}
+		if !seen[ref.Key()] {
+			seen[ref.Key()] = true
+			refs = append(refs, ref)
+		}
+		return "", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = tpl.Execute(&bytes.Buffer{}, nil); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// DryRun reports which references in the template would resolve, without decrypting anything
+func (t *Template) DryRun(ctx context.Context) ([]*RefStatus, error) {
+	refs, err := t.Refs()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*RefStatus, 0, len(refs))
+	for _, ref := range refs {
+		status := &RefStatus{Ref: ref, Resolves: true}
+		if _, err := t.m.Store().Retrieve(ctx, ref.Key()); err != nil {
+			status.Resolves = false
+			status.Error = fmt.Sprintf(tokenize.ErrKeyDoesNotExists, ref.Key())
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Render executes the template, detokenizing every reference
+func (t *Template) Render(ctx context.Context) ([]byte, error) {
+	tpl, err := t.parse(func(id string, keys ...string) (string, error) {
+		if len(id) == 0 || len(keys) == 0 {
+			return "", ErrRefInvalid
+		}
+		key := tokenize.GetCombinedKey(append([]string{id}, keys...)...)
+		token, _, err := t.m.GetToken(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrRefUnresolved, key)
+		}
+		found, plain, err := t.m.Detokenize(ctx, key, token)
+		if err != nil || !found {
+			return "", fmt.Errorf("%w: %s", ErrRefUnresolved, key)
+		}
+		return plain, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err = tpl.Execute(&out, nil); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Fingerprint digests the tokens currently stored for every reference. It changes whenever one of them is patched, created or deleted.
+func (t *Template) Fingerprint(ctx context.Context) (string, error) {
+	refs, err := t.Refs()
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		keys = append(keys, ref.Key())
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		token, err := t.m.Store().Retrieve(ctx, key)
+		if err != nil {
+			token = ""
+		}
+		fmt.Fprintf(h, "%d:%s%d:%s", len(key), key, len(token), token)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Watch polls the tokens of the template's references every interval, and calls fn whenever they change. It returns when ctx is done, or fn fails.
+func (t *Template) Watch(ctx context.Context, interval time.Duration, fn func() error) error {
+	last, err := t.Fingerprint(ctx)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			current, err := t.Fingerprint(ctx)
+			if err != nil {
+				return err
+			}
+			if current == last {
+				continue
+			}
+			last = current
+			if err = fn(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// WriteFile atomically writes content to loc with the given permissions, so readers never see a partially rendered file
+func WriteFile(loc string, content []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(loc), "."+filepath.Base(loc)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), loc)
+}
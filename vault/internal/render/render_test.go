@@ -0,0 +1,91 @@
+package render
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type RenderTestSuite struct {
+	suite.Suite
+	log *vlog.Logger
+	m   *tokenize.Manager
+}
+
+var (
+	varTemplate = `host = db.internal
+user = {{ vault "db" "user" }}
+password = {{ vault "db" "password" }}
+`
+)
+
+func (suite *RenderTestSuite) SetupTest() {
+	ctx := context.Background()
+	suite.log = vlog.New(true)
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	suite.m = tokenize.NewManager(ctx, suite.log, tokenize.WithStore(store.NewSyncMap(ctx, suite.log)), tokenize.WithCipherLoc(cipherLoc))
+	_, err := suite.m.Tokenize(ctx, tokenize.GetCombinedKey("db", "user"), "admin")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+}
+
+func (suite *RenderTestSuite) TestDryRunAndRender() {
+	ctx := context.Background()
+	t, err := New("app.tmpl", varTemplate, suite.m)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	statuses, err := t.DryRun(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(statuses, 2)
+	suite.Require().True(statuses[0].Resolves)
+	suite.Require().False(statuses[1].Resolves)
+
+	_, err = t.Render(ctx)
+	suite.Require().ErrorIs(err, ErrRefUnresolved)
+
+	_, err = suite.m.Tokenize(ctx, tokenize.GetCombinedKey("db", "password"), "hunter2")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	rendered, err := t.Render(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("host = db.internal\nuser = admin\npassword = hunter2\n", string(rendered))
+
+	out := filepath.Join(suite.T().TempDir(), "app.conf")
+	suite.Require().NoError(WriteFile(out, rendered, 0600))
+	info, err := os.Stat(out)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(os.FileMode(0600), info.Mode().Perm())
+}
+
+func (suite *RenderTestSuite) TestWatch() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	t, err := New("app.tmpl", `{{ vault "db" "user" }}`, suite.m)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	changed := make(chan struct{}, 1)
+	go t.Watch(ctx, 10*time.Millisecond, func() error {
+		changed <- struct{}{}
+		cancel()
+		return nil
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = suite.m.PatchTokenByID(ctx, tokenize.GetCombinedKey("db", "user"), "root")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	select {
+	case <-changed:
+	case <-ctx.Done():
+		suite.Fail("expected watch to notice the patched token")
+	}
+}
+
+// TestRenderSuite tests the Render suite
+func TestRenderSuite(t *testing.T) {
+	suite.Run(t, new(RenderTestSuite))
+}
@@ -0,0 +1,181 @@
+// Package secure keeps secrets, i.e. key material and decrypted values, in memory the Go runtime doesn't manage, so
+// they can be wiped once used rather than linger on the heap until it is reused.
+//
+// A Buffer is allocated outside the heap between two guard pages, which fault on any overflow, and locked in RAM
+// where the limits of the process allow it, so it is never swapped to disk. Destroying it zeroes it and releases it.
+// Buffers are created for every value decrypted, so the pages of destroyed buffers are kept, wiped, for the next ones
+// of their size, rather than mapped, guarded and locked again each time.
+// Copies made into Go strings, e.g. to encode a response, are out of its reach: they are kept as short-lived as the
+// callers can make them.
+package secure
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+var (
+	ErrSizeInvalid = errors.New("secure buffer size is invalid")
+)
+
+const (
+	// poolSize is the number of allocations of each size kept for reuse
+	poolSize = 64
+	// poolMaxBytes is the size above which allocations are released rather than kept
+	poolMaxBytes = 64 << 10
+)
+
+// allocation is the memory of a buffer: the whole of it, guard pages included, and the pages the data is in
+type allocation struct {
+	mem, inner []byte
+	locked     bool
+}
+
+// pool keeps wiped allocations by the size of their inner pages
+var pool = struct {
+	sync.Mutex
+	kept map[int][]allocation
+}{kept: map[int][]allocation{}}
+
+// get returns a kept allocation able to hold size bytes, or a new one
+func get(size int) (allocation, error) {
+	n := allocSize(size)
+	pool.Lock()
+	if kept := pool.kept[n]; len(kept) > 0 {
+		a := kept[len(kept)-1]
+		pool.kept[n] = kept[:len(kept)-1]
+		pool.Unlock()
+		return a, nil
+	}
+	pool.Unlock()
+	mem, inner, locked, err := alloc(size)
+	return allocation{mem: mem, inner: inner, locked: locked}, err
+}
+
+// put keeps the wiped allocation a for reuse, or releases it if enough of its size are kept already
+func put(a allocation) {
+	n := len(a.inner)
+	pool.Lock()
+	if n <= poolMaxBytes && len(pool.kept[n]) < poolSize {
+		pool.kept[n] = append(pool.kept[n], a)
+		pool.Unlock()
+		return
+	}
+	pool.Unlock()
+	free(a.mem, a.inner, a.locked)
+}
+
+// Buffer holds a secret outside the heap. Its zero value isn't usable; buffers are created with New.
+type Buffer struct {
+	mu sync.Mutex
+	// mem is the whole allocation, guard pages included, and inner the pages the data is in
+	mem, inner []byte
+	data       []byte
+	locked     bool
+}
+
+// New allocates a buffer of size zeroed bytes
+func New(size int) (*Buffer, error) {
+	if size < 0 {
+		return nil, ErrSizeInvalid
+	}
+	a, err := get(size)
+	if err != nil {
+		return nil, err
+	}
+	// the data ends where the trailing guard page starts, so writing past its end faults at once
+	return &Buffer{mem: a.mem, inner: a.inner, data: a.inner[len(a.inner)-size:], locked: a.locked}, nil
+}
+
+// From moves b into a new buffer: b is copied, then wiped
+func From(b []byte) (*Buffer, error) {
+	buf, err := New(len(b))
+	if err != nil {
+		return nil, err
+	}
+	copy(buf.data, b)
+	Wipe(b)
+	return buf, nil
+}
+
+// FromString copies s into a new buffer. The string itself can't be wiped.
+func FromString(s string) (*Buffer, error) {
+	buf, err := New(len(s))
+	if err != nil {
+		return nil, err
+	}
+	copy(buf.data, s)
+	return buf, nil
+}
+
+// Bytes returns the data of the buffer. It is only valid until the buffer is destroyed.
+func (b *Buffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+// Len returns the length of the data of the buffer
+func (b *Buffer) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.data)
+}
+
+// Locked reports whether the buffer is locked in RAM. Locking fails once the process exceeds its limit of locked
+// memory, e.g. RLIMIT_MEMLOCK, in which case the buffer is still wiped when destroyed.
+func (b *Buffer) Locked() bool {
+	return b != nil && b.locked
+}
+
+// Truncate wipes the data of the buffer past its first n bytes, and drops it
+func (b *Buffer) Truncate(n int) {
+	if n < 0 || n > len(b.data) {
+		return
+	}
+	Wipe(b.data[n:])
+	b.data = b.data[:n]
+}
+
+// Text returns a copy of the data of the buffer as a string. The copy is managed by the runtime, and can't be wiped.
+func (b *Buffer) Text() string {
+	return string(b.Bytes())
+}
+
+// UnsafeString returns the data of the buffer as a string, without copying it. The string must not be used, nor
+// retained, once the buffer is destroyed: its memory is wiped, then released.
+func (b *Buffer) UnsafeString() string {
+	if b.Len() == 0 {
+		return ""
+	}
+	return unsafe.String(&b.data[0], len(b.data))
+}
+
+// String keeps the data of the buffer out of logs and formatted output
+func (b *Buffer) String() string {
+	return "[secure buffer]"
+}
+
+// Destroy wipes the buffer, and releases its memory, or keeps it for the next buffer of its size. Destroying a buffer
+// more than once is a no-op.
+func (b *Buffer) Destroy() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mem == nil {
+		return
+	}
+	Wipe(b.inner)
+	put(allocation{mem: b.mem, inner: b.inner, locked: b.locked})
+	b.mem, b.inner, b.data = nil, nil, nil
+}
+
+// Wipe zeroes b
+func Wipe(b []byte) {
+	clear(b)
+}
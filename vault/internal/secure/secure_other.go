@@ -0,0 +1,27 @@
+//go:build !unix
+
+package secure
+
+import "errors"
+
+// ErrUnsupported is returned by what the platform can't do
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// allocSize returns the size alloc allocates for size bytes
+func allocSize(size int) int {
+	return size
+}
+
+// alloc allocates size bytes on the heap, where guard pages and locking aren't available
+func alloc(size int) (mem, inner []byte, locked bool, err error) {
+	mem = make([]byte, size)
+	return mem, mem, false, nil
+}
+
+// free leaves the memory of alloc, wiped, to the garbage collector
+func free(mem, inner []byte, locked bool) {}
+
+// DisableCoreDumps isn't supported on this platform
+func DisableCoreDumps() error {
+	return ErrUnsupported
+}
@@ -0,0 +1,82 @@
+package secure
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type SecureTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SecureTestSuite) TestBuffer() {
+	src := []byte("0123456789abcdef")
+	buf, err := From(src)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	// the source is wiped once moved
+	suite.Require().Equal(make([]byte, 16), src)
+	suite.Require().Equal(16, buf.Len())
+	suite.Require().Equal("0123456789abcdef", buf.UnsafeString())
+
+	// the padding dropped is wiped, and the data left is unchanged
+	data := buf.Bytes()
+	buf.Truncate(10)
+	suite.Require().Equal("0123456789", buf.Text())
+	suite.Require().Equal(make([]byte, 6), data[10:16])
+
+	// secrets stay out of formatted output
+	suite.Require().Equal("[secure buffer]", fmt.Sprintf("%v", buf))
+	suite.Require().Equal("[secure buffer]", fmt.Sprintf("%s", buf))
+
+	buf.Destroy()
+	buf.Destroy()
+	suite.Require().Nil(buf.Bytes())
+	suite.Require().Zero(buf.Len())
+	suite.Require().Empty(buf.UnsafeString())
+
+	var nilBuf *Buffer
+	nilBuf.Destroy()
+	suite.Require().False(nilBuf.Locked())
+}
+
+func (suite *SecureTestSuite) TestNew() {
+	buf, err := New(0)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Zero(buf.Len())
+	suite.Require().Empty(buf.UnsafeString())
+	buf.Destroy()
+
+	_, err = New(-1)
+	suite.Require().ErrorIs(err, ErrSizeInvalid)
+
+	// buffers spanning several pages are zeroed
+	buf, err = FromString("key")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer buf.Destroy()
+	suite.Require().Equal("key", buf.Text())
+	big, err := New(3 * 4096)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer big.Destroy()
+	suite.Require().Equal(make([]byte, 3*4096), big.Bytes())
+}
+
+func (suite *SecureTestSuite) TestPool() {
+	buf, err := FromString("hunter2")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	inner := &buf.inner[0]
+	buf.Destroy()
+
+	// the memory of a destroyed buffer is reused by the next of its size, wiped
+	again, err := New(5)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer again.Destroy()
+	suite.Require().Same(inner, &again.inner[0])
+	suite.Require().Equal(make([]byte, len(again.inner)), again.inner)
+	suite.Require().Equal(5, again.Len())
+}
+
+// TestSecureSuite tests the Secure suite
+func TestSecureSuite(t *testing.T) {
+	suite.Run(t, new(SecureTestSuite))
+}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package secure
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// allocSize returns the size of the inner pages alloc maps for size bytes: whole pages, at least one
+func allocSize(size int) int {
+	page := os.Getpagesize()
+	n := (size + page - 1) / page * page
+	if n == 0 {
+		n = page
+	}
+	return n
+}
+
+// alloc maps the pages holding size bytes between two guard pages, and locks them in RAM if the process may
+func alloc(size int) (mem, inner []byte, locked bool, err error) {
+	page := os.Getpagesize()
+	n := allocSize(size)
+	mem, err = unix.Mmap(-1, 0, n+2*page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err = unix.Mprotect(mem[:page], unix.PROT_NONE); err == nil {
+		err = unix.Mprotect(mem[page+n:], unix.PROT_NONE)
+	}
+	if err != nil {
+		unix.Munmap(mem)
+		return nil, nil, false, err
+	}
+	inner = mem[page : page+n]
+	return mem, inner, unix.Mlock(inner) == nil, nil
+}
+
+// free unlocks and unmaps the pages of alloc
+func free(mem, inner []byte, locked bool) {
+	if locked {
+		unix.Munlock(inner)
+	}
+	unix.Munmap(mem)
+}
+
+// DisableCoreDumps stops the process from dumping core, so a crash doesn't write its secrets to disk
+func DisableCoreDumps() error {
+	return unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{Cur: 0, Max: 0})
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package secure
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+)
+
+// envDisableCoreDumps is set in the process TestDisableCoreDumps disables core dumps of
+const envDisableCoreDumps = "SECURE_TEST_DISABLE_CORE_DUMPS"
+
+func (suite *SecureTestSuite) TestDisableCoreDumps() {
+	// the hard limit can't be raised back without CAP_SYS_RESOURCE, so core dumps are disabled in a child process,
+	// leaving the limits of the tests as they are
+	if os.Getenv(envDisableCoreDumps) == "" {
+		cmd := exec.Command(os.Args[0], "-test.v", "-test.run=^TestSecureSuite$", "-testify.m=^TestDisableCoreDumps$")
+		cmd.Env = append(os.Environ(), envDisableCoreDumps+"=1")
+		out, err := cmd.CombinedOutput()
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n%s", err, out)
+		suite.Require().Contains(string(out), "--- PASS: TestSecureSuite/TestDisableCoreDumps")
+		return
+	}
+
+	suite.Require().NoError(DisableCoreDumps())
+	var lim unix.Rlimit
+	suite.Require().NoError(unix.Getrlimit(unix.RLIMIT_CORE, &lim))
+	suite.Require().Zero(lim.Cur)
+	suite.Require().Zero(lim.Max)
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// OpKind is the kind of write performed by an Op
+type OpKind int
+
+const (
+	// OpStore creates a key. It fails if the key already exists.
+	OpStore OpKind = iota
+	// OpPatch replaces the token of a key. It fails if the key doesn't exist.
+	OpPatch
+	// OpDelete deletes a key. It fails if the key doesn't exist.
+	OpDelete
+)
+
+var (
+	ErrBatchKeyExists   = errors.New("batch aborted: key already exists")
+	ErrBatchKeyNotFound = errors.New("batch aborted: key does not exist")
+	ErrBatchConflict    = errors.New("batch aborted: keys were modified concurrently")
+	ErrBatchOpInvalid   = errors.New("batch aborted: invalid operation")
+)
+
+// Op is a single write in a batch
+type Op struct {
+	Kind  OpKind
+	ID    string
+	Token string
+	// Expect, when set, is the value the key must currently hold for the op to apply. Patches and deletes use it as a
+	// compare-and-swap, failing the batch with ErrBatchConflict if the key changed since it was read.
+	Expect *string
+}
+
+func (k OpKind) String() string {
+	switch k {
+	case OpStore:
+		return "store"
+	case OpPatch:
+		return "patch"
+	case OpDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("OpKind(%d)", int(k))
+	}
+}
+
+// stage checks every op against the current state of the store, as reported by lookup, and against the ops before it.
+// It returns the resulting writes keyed by id: the new token, or nil for a deletion. Nothing is returned if any op
+// doesn't hold, so the batch can be applied all or nothing.
+func stage(ops []Op, lookup func(id string) (string, bool, error)) (map[string]*string, error) {
+	writes := make(map[string]*string, len(ops))
+	for _, op := range ops {
+		var current string
+		var present bool
+		if w, ok := writes[op.ID]; ok {
+			if present = w != nil; present {
+				current = *w
+			}
+		} else {
+			var err error
+			if current, present, err = lookup(op.ID); err != nil {
+				return nil, err
+			}
+		}
+
+		if op.Expect != nil && (!present || current != *op.Expect) {
+			return nil, fmt.Errorf("%w: %s", ErrBatchConflict, op.ID)
+		}
+
+		switch op.Kind {
+		case OpStore:
+			if present {
+				return nil, fmt.Errorf("%w: %s", ErrBatchKeyExists, op.ID)
+			}
+		case OpPatch, OpDelete:
+			if !present {
+				return nil, fmt.Errorf("%w: %s", ErrBatchKeyNotFound, op.ID)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s on %s", ErrBatchOpInvalid, op.Kind, op.ID)
+		}
+
+		if op.Kind == OpDelete {
+			writes[op.ID] = nil
+			continue
+		}
+		token := op.Token
+		writes[op.ID] = &token
+	}
+	return writes, nil
+}
+
+// apply returns a copy of current with the staged writes applied
+func apply(current map[string]string, writes map[string]*string) map[string]string {
+	next := make(map[string]string, len(current)+len(writes))
+	for k, v := range current {
+		next[k] = v
+	}
+	for k, v := range writes {
+		if v == nil {
+			delete(next, k)
+			continue
+		}
+		next[k] = *v
+	}
+	return next
+}
+
+// writeFileAtomic writes a file at loc by way of a temporary file renamed over it, so loc holds either its previous
+// content or the new one in full, even if the process dies halfway
+func writeFileAtomic(loc string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(loc), "."+filepath.Base(loc)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), loc)
+}
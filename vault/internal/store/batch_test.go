@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type BatchTestSuite struct {
+	suite.Suite
+	log *vlog.Logger
+}
+
+func (suite *BatchTestSuite) SetupTest() {
+	suite.log = vlog.New(true)
+}
+
+// stores returns a fresh, connected instance of every store that doesn't need a server
+func (suite *BatchTestSuite) stores(ctx context.Context) map[string]Store {
+	dir := suite.T().TempDir()
+	file := NewFile(filepath.Join(dir, "batch.db"), suite.log)
+	gob, err := NewGob(ctx, filepath.Join(dir, "batch.gob"), suite.log, true)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	stores := map[string]Store{"map": NewSyncMap(ctx, suite.log), "file": file, "gob": gob}
+	for name, s := range stores {
+		b, err := s.Connect(ctx)
+		suite.Require().NoErrorf(err, "expected no errors connecting %s, but got this %v\n", name, err)
+		suite.Require().True(b, "expected true, but received false")
+	}
+	return stores
+}
+
+func (suite *BatchTestSuite) TestBatch() {
+	ctx := context.Background()
+	for name, s := range suite.stores(ctx) {
+		err := s.Store(ctx, "app/existing", "t0")
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+		err = s.Batch(ctx, []Op{
+			{Kind: OpStore, ID: "app/db", Token: "t1"},
+			{Kind: OpStore, ID: "app/api", Token: "t2"},
+			{Kind: OpPatch, ID: "app/existing", Token: "t3"},
+		})
+		suite.Require().NoErrorf(err, "expected no errors with %s, but got this %v\n", name, err)
+
+		all, err := s.RetrieveAll(ctx)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equalf(map[string]string{"app/db": "t1", "app/api": "t2", "app/existing": "t3"}, all, "unexpected state of %s", name)
+
+		// a single failing op leaves the store untouched
+		err = s.Batch(ctx, []Op{
+			{Kind: OpPatch, ID: "app/db", Token: "t4"},
+			{Kind: OpDelete, ID: "app/api"},
+			{Kind: OpStore, ID: "app/existing", Token: "t5"},
+		})
+		suite.Require().ErrorIsf(err, ErrBatchKeyExists, "expected the batch on %s to abort", name)
+		err = s.Batch(ctx, []Op{
+			{Kind: OpDelete, ID: "app/db"},
+			{Kind: OpPatch, ID: "app/db", Token: "t6"},
+		})
+		suite.Require().ErrorIsf(err, ErrBatchKeyNotFound, "expected the batch on %s to abort", name)
+
+		all, err = s.RetrieveAll(ctx)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equalf(map[string]string{"app/db": "t1", "app/api": "t2", "app/existing": "t3"}, all, "unexpected state of %s", name)
+
+		err = s.Batch(ctx, []Op{{Kind: OpDelete, ID: "app/db"}, {Kind: OpDelete, ID: "app/api"}})
+		suite.Require().NoErrorf(err, "expected no errors with %s, but got this %v\n", name, err)
+		_, err = s.Retrieve(ctx, "app/db")
+		suite.Require().Errorf(err, "expected app/db to be deleted from %s", name)
+		_ = s.Close(ctx)
+	}
+}
+
+func (suite *BatchTestSuite) TestBatchExpect() {
+	ctx := context.Background()
+	for name, s := range suite.stores(ctx) {
+		err := s.Store(ctx, "app/db", `{"token":"t1","rev":1}`)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+		stale, current := "t0", `{"token":"t1","rev":1}`
+		err = s.Batch(ctx, []Op{{Kind: OpPatch, ID: "app/db", Token: "t2", Expect: &stale}})
+		suite.Require().ErrorIsf(err, ErrBatchConflict, "expected the batch on %s to conflict", name)
+		err = s.Batch(ctx, []Op{{Kind: OpDelete, ID: "app/missing", Expect: &current}})
+		suite.Require().ErrorIsf(err, ErrBatchConflict, "expected the batch on %s to conflict", name)
+
+		err = s.Batch(ctx, []Op{{Kind: OpPatch, ID: "app/db", Token: `{"token":"t2","rev":2}`, Expect: &current}})
+		suite.Require().NoErrorf(err, "expected no errors with %s, but got this %v\n", name, err)
+		token, err := s.Retrieve(ctx, "app/db")
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equalf(`{"token":"t2","rev":2}`, token, "unexpected state of %s", name)
+		_ = s.Close(ctx)
+	}
+}
+
+// TestBatchSuite tests the Batch suite
+func (suite *BatchTestSuite) TestBatchWithSingleWrites() {
+	ctx := context.Background()
+	for name, s := range suite.stores(ctx) {
+		// single writes and batches interleave without losing each other's keys
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				suite.Assert().NoError(s.Store(ctx, fmt.Sprintf("single/%d", i), "t"))
+			}(i)
+			go func(i int) {
+				defer wg.Done()
+				suite.Assert().NoError(s.Batch(ctx, []Op{{Kind: OpStore, ID: fmt.Sprintf("batch/%d", i), Token: "t"}}))
+			}(i)
+		}
+		wg.Wait()
+
+		all, err := s.RetrieveAll(ctx)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Lenf(all, 40, "expected every write to %s to be kept", name)
+	}
+}
+
+func TestBatchSuite(t *testing.T) {
+	suite.Run(t, new(BatchTestSuite))
+}
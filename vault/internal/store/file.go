@@ -9,6 +9,8 @@ import (
 	"github.com/pkg/errors"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -61,9 +63,12 @@ func (f *File) Close(ctx context.Context) error {
 func (f *File) Store(ctx context.Context, id string, token any) error {
 	log := f.logger.Logger()
 	var err error
+	// hold the lock across the read-modify-write, as Batch does
+	f.Lock()
+	defer f.Unlock()
 
 	// read current contents of the file
-	content, err := f.read()
+	content, err := f.readLocked()
 	if err != nil {
 		log.Error().Msgf("error encountered while reading from file store: %s\n", err.Error())
 		return fmt.Errorf("error encountered while reading from file store: %s\n", err.Error())
@@ -73,7 +78,7 @@ func (f *File) Store(ctx context.Context, id string, token any) error {
 
 	// harvest currently stored values if file store is not empty
 	if len(content) > 0 {
-		storeMap, err = godotenv.UnmarshalBytes(content)
+		storeMap, err = unmarshalFile(content)
 	}
 
 	var tokenStr string
@@ -95,7 +100,7 @@ func (f *File) Store(ctx context.Context, id string, token any) error {
 	storeMap[id] = tokenStr
 
 	// write map to file store
-	err = f.Write(storeMap)
+	err = f.writeLocked(storeMap)
 	if err != nil {
 		log.Error().Msgf("error while writing map to file store")
 		return fmt.Errorf("error while writing map to file store")
@@ -106,10 +111,15 @@ func (f *File) Store(ctx context.Context, id string, token any) error {
 
 // read abstracts away the details of reading from a file
 func (f *File) read() ([]byte, error) {
-	var b bytes.Buffer
-	log := f.logger.Logger()
 	f.Lock()
 	defer f.Unlock()
+	return f.readLocked()
+}
+
+// readLocked reads the file, with the lock already held by the caller
+func (f *File) readLocked() ([]byte, error) {
+	var b bytes.Buffer
+	log := f.logger.Logger()
 	_, err := f.fd.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
@@ -143,7 +153,7 @@ func (f *File) Retrieve(ctx context.Context, id string) (string, error) {
 		return "", fmt.Errorf("token with id %s doesn't exist", id)
 	}
 
-	storeMap, err = godotenv.UnmarshalBytes(content)
+	storeMap, err = unmarshalFile(content)
 	// check err
 	if err != nil {
 		log.Debug().Msg("error while unmarshalling file store bytes")
@@ -180,7 +190,7 @@ func (f *File) RetrieveAll(ctx context.Context) (map[string]string, error) {
 		return nil, errors.New("file store empty")
 	}
 
-	storeMap, err = godotenv.UnmarshalBytes(content)
+	storeMap, err = unmarshalFile(content)
 	// check err
 	if err != nil {
 		log.Debug().Msg("error while unmarshalling file store bytes")
@@ -190,12 +200,32 @@ func (f *File) RetrieveAll(ctx context.Context) (map[string]string, error) {
 	return storeMap, nil
 }
 
+// Scan returns a page of entries, in key order
+func (f *File) Scan(ctx context.Context, opts ScanOptions) (*Page, error) {
+	content, err := f.read()
+	if err != nil {
+		return nil, fmt.Errorf("error encountered while reading from file store: %w", err)
+	}
+
+	var storeMap = map[string]string{}
+
+	// an empty file store scans as empty
+	if len(content) > 0 {
+		if storeMap, err = unmarshalFile(content); err != nil {
+			return nil, errors.New("error while unmarshalling file store bytes")
+		}
+	}
+	return scanSorted(storeMap, opts)
+}
+
 // Delete removes a token from the file store
 func (f *File) Delete(ctx context.Context, id string) (bool, error) {
 	log := f.logger.Logger()
+	f.Lock()
+	defer f.Unlock()
 
 	// read current contents of the file
-	content, err := f.read()
+	content, err := f.readLocked()
 	if err != nil {
 		log.Error().Msgf("error encountered while reading from file store: %s\n", err.Error())
 		return true, fmt.Errorf("error encountered while reading from file store: %s\n", err.Error())
@@ -209,7 +239,7 @@ func (f *File) Delete(ctx context.Context, id string) (bool, error) {
 		return true, errors.New("file store empty")
 	}
 
-	storeMap, err = godotenv.UnmarshalBytes(content)
+	storeMap, err = unmarshalFile(content)
 	// check err
 	if err != nil {
 		log.Debug().Msg("error while unmarshalling file store bytes")
@@ -221,7 +251,7 @@ func (f *File) Delete(ctx context.Context, id string) (bool, error) {
 
 	// write to file
 	// write map to file store
-	err = f.Write(storeMap)
+	err = f.writeLocked(storeMap)
 	if err != nil {
 		log.Error().Msgf("error while writing map to file store")
 		return false, fmt.Errorf("error while writing map to file store")
@@ -233,9 +263,11 @@ func (f *File) Delete(ctx context.Context, id string) (bool, error) {
 // Patch only updates a token in the file store, identified by id
 func (f *File) Patch(ctx context.Context, id string, token any) (bool, error) {
 	log := f.logger.Logger()
+	f.Lock()
+	defer f.Unlock()
 
 	// read current contents of the file
-	content, err := f.read()
+	content, err := f.readLocked()
 	if err != nil {
 		log.Error().Msgf("error encountered while reading from file store: %s\n", err.Error())
 		return true, fmt.Errorf("error encountered while reading from file store: %s\n", err.Error())
@@ -249,7 +281,7 @@ func (f *File) Patch(ctx context.Context, id string, token any) (bool, error) {
 		return true, errors.New("file store empty")
 	}
 
-	storeMap, err = godotenv.UnmarshalBytes(content)
+	storeMap, err = unmarshalFile(content)
 	// check err
 	if err != nil {
 		log.Debug().Msg("error while unmarshalling file store bytes")
@@ -275,7 +307,7 @@ func (f *File) Patch(ctx context.Context, id string, token any) (bool, error) {
 	storeMap[id] = tokenStr
 
 	// write map to file store
-	err = f.Write(storeMap)
+	err = f.writeLocked(storeMap)
 	if err != nil {
 		log.Error().Msgf("error while writing map to file store")
 		return false, fmt.Errorf("error while writing map to file store")
@@ -284,8 +316,68 @@ func (f *File) Patch(ctx context.Context, id string, token any) (bool, error) {
 	return true, nil
 }
 
+// Batch applies every op in a single write of the file store, or none of them if any doesn't hold
+func (f *File) Batch(ctx context.Context, ops []Op) error {
+	log := f.logger.Logger()
+	f.Lock()
+	defer f.Unlock()
+
+	// read current contents of the file
+	content, err := f.readLocked()
+	if err != nil {
+		log.Error().Msgf("error encountered while reading from file store: %s\n", err.Error())
+		return fmt.Errorf("error encountered while reading from file store: %s\n", err.Error())
+	}
+
+	var storeMap = map[string]string{}
+
+	// harvest currently stored values if file store is not empty
+	if len(content) > 0 {
+		storeMap, err = unmarshalFile(content)
+		if err != nil {
+			log.Debug().Msg("error while unmarshalling file store bytes")
+			return errors.New("error while unmarshalling file store bytes")
+		}
+	}
+
+	writes, err := stage(ops, func(id string) (string, bool, error) {
+		token, ok := storeMap[id]
+		return token, ok, nil
+	})
+	if err != nil {
+		log.Debug().Msgf("error staging batch: %s", err)
+		return err
+	}
+
+	env, err := godotenv.Marshal(encodeFileKeys(apply(storeMap, writes)))
+	if err != nil {
+		return err
+	}
+
+	// replace the file store in one go, and reopen it
+	err = writeFileAtomic(f.loc, func(w io.Writer) error {
+		_, err := io.WriteString(w, env+"\n")
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf("error while writing batch to file store: %s\n", err.Error())
+		return fmt.Errorf("error while writing batch to file store: %s\n", err.Error())
+	}
+	fd, err := os.OpenFile(f.loc, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	f.fd.Close()
+	f.fd = fd
+
+	log.Debug().Msgf("successfully applied batch of %d operations", len(ops))
+	return nil
+}
+
 // Flush cleans al the data from a file store
 func (f *File) Flush(ctx context.Context) (bool, error) {
+	f.Lock()
+	defer f.Unlock()
 	err := f.fd.Truncate(0)
 	if err != nil {
 		return false, err
@@ -300,7 +392,64 @@ func (f *File) Loop() {
 // Write persists the map to disk using godotenv.Write
 func (f *File) Write(m map[string]string) error {
 	f.Lock()
-	err := godotenv.Write(m, f.loc)
-	f.Unlock()
-	return err
+	defer f.Unlock()
+	return f.writeLocked(m)
+}
+
+// writeLocked persists the map to disk, with the lock already held by the caller
+func (f *File) writeLocked(m map[string]string) error {
+	return godotenv.Write(encodeFileKeys(m), f.loc)
+}
+
+// unmarshalFile parses the content of a file store, decoding its keys
+func unmarshalFile(content []byte) (map[string]string, error) {
+	env, err := godotenv.UnmarshalBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(env))
+	for k, v := range env {
+		m[decodeFileKey(k)] = v
+	}
+	return m, nil
+}
+
+// encodeFileKeys encodes every key of m with encodeFileKey
+func encodeFileKeys(m map[string]string) map[string]string {
+	env := make(map[string]string, len(m))
+	for k, v := range m {
+		env[encodeFileKey(k)] = v
+	}
+	return env
+}
+
+// encodeFileKey escapes the characters dotenv doesn't allow in variable names, i.e. anything but [A-Za-z0-9_], as '.'
+// followed by the hex value of each of their bytes. '.' itself is escaped too.
+func encodeFileKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, ".%02X", c)
+	}
+	return b.String()
+}
+
+// decodeFileKey reverses encodeFileKey
+func decodeFileKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		if key[i] == '.' && i+2 < len(key) {
+			if c, err := strconv.ParseUint(key[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(key[i])
+	}
+	return b.String()
 }
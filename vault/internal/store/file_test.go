@@ -208,6 +208,29 @@ func (suite *FileTestSuite) TestPatch() {
 	_ = file.Close(ctx)
 }
 
+func (suite *FileTestSuite) TestPing() {
+	ctx := context.Background()
+	loc := filepath.Join(suite.T().TempDir(), "ping.db")
+	file := NewFile(loc, suite.log)
+	_, err := file.Ping(ctx)
+	suite.Require().ErrorIs(err, ErrNotConnected)
+
+	_, err = file.Connect(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	ok, err := Ping(ctx, file)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().True(ok)
+
+	// the open file no longer backs the store once removed
+	suite.Require().NoError(os.Remove(loc))
+	_, err = Ping(ctx, file)
+	suite.Require().ErrorIs(err, ErrStoreFileGone)
+
+	suite.Require().NoError(file.Close(ctx))
+	_, err = file.Ping(ctx)
+	suite.Require().Error(err)
+}
+
 func (suite *FileTestSuite) TearDownTest() {
 	_ = context.Background()
 	log := suite.log.Logger()
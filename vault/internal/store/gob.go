@@ -20,6 +20,8 @@ type Gob struct {
 	fd     *os.File
 	logger *vlog.Logger
 	sync.RWMutex
+	// txn serializes writes, single ones and batches, from refreshing the in-memory map to persisting it
+	txn sync.Mutex
 }
 
 func NewGob(ctx context.Context, loc string, logger *vlog.Logger, trunc bool) (*Gob, error) {
@@ -48,7 +50,7 @@ func NewGob(ctx context.Context, loc string, logger *vlog.Logger, trunc bool) (*
 			return nil, err
 		}
 	}
-	return &Gob{loc, NewSyncMap(ctx, logger), fd, logger, sync.RWMutex{}}, nil
+	return &Gob{loc: loc, basin: NewSyncMap(ctx, logger), fd: fd, logger: logger}, nil
 }
 
 func (g *Gob) Connect(ctx context.Context) (bool, error) {
@@ -57,6 +59,8 @@ func (g *Gob) Connect(ctx context.Context) (bool, error) {
 
 func (g *Gob) Store(ctx context.Context, id string, token any) error {
 	log := g.logger.Logger()
+	g.txn.Lock()
+	defer g.txn.Unlock()
 
 	// TODO: Revisit this it's best to refresh before persisting new data; just in case there has been a latest update first refresh in-memory map, or the in-memory map has been cleared below
 	// see below
@@ -96,6 +100,8 @@ func (g *Gob) Store(ctx context.Context, id string, token any) error {
 
 func (g *Gob) Patch(ctx context.Context, id string, token any) (bool, error) {
 	log := g.logger.Logger()
+	g.txn.Lock()
+	defer g.txn.Unlock()
 
 	// first refresh in-memory map
 	err := g.MapRefresh(ctx)
@@ -165,8 +171,19 @@ func (g *Gob) RetrieveAll(ctx context.Context) (map[string]string, error) {
 	return m, err
 }
 
+// Scan returns a page of entries, in key order
+func (g *Gob) Scan(ctx context.Context, opts ScanOptions) (*Page, error) {
+	all, err := g.RetrieveAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return scanSorted(all, opts)
+}
+
 func (g *Gob) Delete(ctx context.Context, id string) (bool, error) {
 	log := g.logger.Logger()
+	g.txn.Lock()
+	defer g.txn.Unlock()
 
 	// first refresh in-memory map
 	err := g.MapRefresh(ctx)
@@ -196,6 +213,54 @@ func (g *Gob) Delete(ctx context.Context, id string) (bool, error) {
 	return true, nil
 }
 
+// Batch applies every op, or none of them if any doesn't hold. The new state is written to a temporary file renamed
+// over the persistent store, so the store is never left half written.
+func (g *Gob) Batch(ctx context.Context, ops []Op) error {
+	log := g.logger.Logger()
+	g.txn.Lock()
+	defer g.txn.Unlock()
+
+	// first refresh in-memory map
+	err := g.MapRefresh(ctx)
+	if err != nil {
+		log.Error().Msgf("error while refresh gob persistent storage: error: %s\n", err.Error())
+		return err
+	}
+
+	current, err := g.basin.RetrieveAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	writes, err := stage(ops, func(id string) (string, bool, error) {
+		token, ok := current[id]
+		return token, ok, nil
+	})
+	if err != nil {
+		log.Debug().Msgf("error staging batch: %s", err)
+		return err
+	}
+	next := apply(current, writes)
+
+	g.Lock()
+	err = writeFileAtomic(g.loc, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(next)
+	})
+	if err == nil {
+		// the persistent store was replaced, so reopen it
+		err = g.fileRefresh()
+	}
+	g.Unlock()
+	if err != nil {
+		log.Error().Msgf("error while persisting batch: %s\n", err.Error())
+		return err
+	}
+
+	// clear the in-memory map, so the next read refreshes it from the new persistent store
+	_, err = g.basin.Flush(ctx)
+	return err
+}
+
 func (g *Gob) trunc(i int64) error {
 	g.Lock()
 	defer g.Unlock()
@@ -344,6 +409,8 @@ func (g *Gob) fileRefresh() error {
 // TODO: Flush should rather persist the current state of the in-memory map into disk, and then empty the in-memory map. It isn't idiomatic for flush to clear the persistent store too.
 func (g *Gob) Flush(ctx context.Context) (bool, error) {
 	log := g.logger.Logger()
+	g.txn.Lock()
+	defer g.txn.Unlock()
 
 	// first empty sync map
 	b, err := g.basin.Flush(ctx)
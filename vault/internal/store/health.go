@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+)
+
+var (
+	ErrNotConnected  = errors.New("store is not connected")
+	ErrStoreFileGone = errors.New("store file was removed or replaced")
+)
+
+// Pinger is implemented by stores that can check they are still reachable, like Redis
+type Pinger interface {
+	Ping(ctx context.Context) (bool, error)
+}
+
+// Ping checks that s is reachable. Stores that don't implement Pinger, like the in-memory map, always are.
+func Ping(ctx context.Context, s Store) (bool, error) {
+	if p, ok := s.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return true, nil
+}
+
+// Ping checks that the file store is open, and still backed by the file at its location
+func (f *File) Ping(ctx context.Context) (bool, error) {
+	return pingFile(f.fd, f.loc)
+}
+
+// Ping checks that the gob store is open, and still backed by the file at its location
+func (g *Gob) Ping(ctx context.Context) (bool, error) {
+	return pingFile(g.fd, g.loc)
+}
+
+// pingFile checks that fd is open, and is the file at loc
+func pingFile(fd *os.File, loc string) (bool, error) {
+	if fd == nil {
+		return false, ErrNotConnected
+	}
+	open, err := fd.Stat()
+	if err != nil {
+		return false, err
+	}
+	onDisk, err := os.Stat(loc)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrStoreFileGone, err)
+	}
+	if !os.SameFile(open, onDisk) {
+		return false, fmt.Errorf("%w: %s", ErrStoreFileGone, loc)
+	}
+	return true, nil
+}
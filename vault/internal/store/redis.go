@@ -2,10 +2,17 @@ package store
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
 	"github.com/dark-enstein/vault/internal/vlog"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -13,7 +20,9 @@ import (
 const (
 	RedisStatusOkay              = "OK"
 	DefaultRedisConnectionString = "redis://localhost:6379"
-	DefaultTTL                   = 0
+	// DefaultRedisKeyPrefix prefixes the keys of the hashes the vault is stored in, if no key_prefix is configured
+	DefaultRedisKeyPrefix = "vault:"
+	DefaultTTL            = 0
 )
 
 var (
@@ -21,113 +30,248 @@ var (
 	OperationSuccessful = "operation successful"
 )
 
+const (
+	// namespacePrefix starts the keys of namespaces, as stored by the namespace package: _ns/<name>/<id>/<key>
+	namespacePrefix = "_ns" + keys.Delimiter
+)
+
 // Redis holds the config options and the state of the redis connection through the lifetime of the connection.
+//
+// Entries are stored in one hash per parent ID, the first segment of their key, under the field of the rest of their
+// key: app/db/password is the field db/password of the hash <prefix>app. The parent IDs of namespaces follow their
+// prefix, so _ns/payments/app/db is the field db of the hash <prefix>_ns/payments/app. Listing the children of an ID
+// reads its hash alone, and the vault never touches keys outside its prefix, so it can share a database with other
+// apps. Entries of namespaces stored in a single hash by a previous layout are moved on Connect; top-level keys of
+// previous layouts are only moved by MigrateKeys.
 type Redis struct {
-	connectionString, defaultDB string
-	conn                        *redis.Client
-	rOpts                       *redis.Options
-	logger                      *vlog.Logger
+	connectionString string
+	prefix           string
+	conn             redis.UniversalClient
+	cfg              *RedisConfig
+	logger           *vlog.Logger
 	sync.Mutex
 }
 
 // Options implements funcitonal options for passing in Redis configuration options
 type Options func(*Redis) error
 
-// NewRedis creates a new instance of Redis, for managing CRUD operations to the configured redis client
-func NewRedis(connectionString string, logger *vlog.Logger) (*Redis, error) {
+// NewRedis creates a new instance of Redis, for managing CRUD operations to the configured redis client. The
+// connection string selects the mode of the client, and configures it; see ParseRedisURL.
+func NewRedis(connectionString string, logger *vlog.Logger, opts ...Options) (*Redis, error) {
 	log := logger.Logger()
-	rOpts, err := redis.ParseURL(connectionString)
-	if err != nil {
-		log.Debug().Msgf("error parsing url string: %s: %s\n", connectionString, err.Error())
-		return nil, fmt.Errorf("error parsing url string: %s: %s\n", connectionString, err.Error())
+	r := &Redis{logger: logger}
+	if err := WithConnectionString(connectionString)(r); err != nil {
+		log.Debug().Msg(err.Error())
+		return nil, err
+	}
+	for i := 0; i < len(opts); i++ {
+		if err := opts[i](r); err != nil {
+			return nil, err
+		}
 	}
+	log.Debug().Msg("applied options successfully")
 
 	redis.SetLogger(logger)
 	log.Debug().Msgf("set up redis logger")
-	return &Redis{
-		connectionString: connectionString,
-		logger:           logger,
-		rOpts:            rOpts,
-	}, nil
+	return r, nil
 }
 
-// WithConnectionString switches the mode of connection to redis to using
+// WithConnectionString configures the connection with connection string s, in place of the one passed to NewRedis
 func WithConnectionString(s string) Options {
 	return func(r *Redis) error {
-		rOpts, err := redis.ParseURL(s)
+		cfg, err := ParseRedisURL(s)
 		if err != nil {
-			return fmt.Errorf("error parsing url string: %s: %s\n", s, err.Error())
+			return fmt.Errorf("error parsing url string: %s: %w", redactURL(s), err)
+		}
+		r.connectionString, r.cfg, r.prefix = s, cfg, cfg.Prefix
+		return nil
+	}
+}
+
+// WithKeyPrefix stores the vault under prefix, in place of the key_prefix of the connection string
+func WithKeyPrefix(prefix string) Options {
+	return func(r *Redis) error {
+		if prefix == "" {
+			return ErrRedisKeyPrefixEmpty
 		}
-		r.conn = redis.NewClient(rOpts)
+		r.prefix = prefix
+		return nil
+	}
+}
+
+// WithTLSConfig secures the connection with cfg, e.g. to trust a private CA, in place of the TLS config of the
+// rediss schemes
+func WithTLSConfig(cfg *tls.Config) Options {
+	return func(r *Redis) error {
+		switch r.cfg.Mode {
+		case RedisSentinel:
+			r.cfg.Failover.TLSConfig = cfg
+		case RedisCluster:
+			r.cfg.Cluster.TLSConfig = cfg
+		default:
+			r.cfg.Standalone.TLSConfig = cfg
+		}
+		return nil
+	}
+}
+
+// WithClient connects with client, rather than one created from the connection string
+func WithClient(client redis.UniversalClient) Options {
+	return func(r *Redis) error {
+		r.conn = client
 		return nil
 	}
 }
 
+// redactURL hides the password of a connection string, so it can be logged
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "<unparseable>"
+	}
+	return u.Redacted()
+}
+
+// Mode returns the mode of the connection, as selected by its connection string
+func (r *Redis) Mode() RedisMode {
+	return r.cfg.Mode
+}
+
 func (r *Redis) Connect(ctx context.Context) (bool, error) {
 	log := r.logger.Logger()
 
-	// apply options
-	//if len(opts) > 0 {
-	//	for i := 0; i < len(opts); i++ {
-	//		err := opts[i](r)
-	//		if err != nil {
-	//			return false, err
-	//		}
-	//	}
-	//	log.Debug().Msg("applied options successfully")
-	//} else {
-	//	log.Info().Msgf("no options provided moving ahead with default Redis connection config: %s", DefaultRedisConnectionString)
-	//}
-
-	// if connection isn't already created or I cannot ping the server successfully, then I'll create the connection using the default config.
+	// the connection is created once, and shared by every caller until closed
 	if r.conn == nil {
-		r.conn = redis.NewClient(&redis.Options{
-			Network:               "",
-			Addr:                  "localhost:6379",
-			ClientName:            "",
-			Dialer:                nil,
-			OnConnect:             nil,
-			Protocol:              0,
-			Username:              "",
-			Password:              "",
-			CredentialsProvider:   nil,
-			DB:                    0,
-			MaxRetries:            0,
-			MinRetryBackoff:       0,
-			MaxRetryBackoff:       0,
-			DialTimeout:           0,
-			ReadTimeout:           0,
-			WriteTimeout:          0,
-			ContextTimeoutEnabled: false,
-			PoolFIFO:              false,
-			PoolSize:              0,
-			PoolTimeout:           0,
-			MinIdleConns:          0,
-			MaxIdleConns:          0,
-			MaxActiveConns:        0,
-			ConnMaxIdleTime:       0,
-			ConnMaxLifetime:       0,
-			TLSConfig:             nil,
-			Limiter:               nil,
-			DisableIndentity:      false,
-			IdentitySuffix:        "",
-		})
+		r.conn = r.cfg.client()
+		log.Debug().Msgf("created the %s client of %s", r.cfg.Mode, strings.Join(r.cfg.Addrs(), ","))
 	}
-	log.Debug().Msgf("created the client: %#v\n", *r.conn)
 
 	b, err := r.Ping(ctx)
 	if err != nil || !b {
-		log.Debug().Msgf("encountered error pinging redis server %s: %v\n", r.connectionString, b)
+		log.Debug().Msgf("encountered error pinging redis server %s: %v\n", redactURL(r.connectionString), err)
 		return false, err
 	}
-	log.Debug().Msgf("successfully pinged redis server: %s\n", r.connectionString)
+	log.Debug().Msgf("successfully pinged redis server: %s\n", redactURL(r.connectionString))
 
+	n, err := r.migrateNamespaces(ctx)
+	if err != nil {
+		log.Error().Msgf("error migrating the namespaces of a previous layout: %s", err)
+		return false, err
+	}
+	if n > 0 {
+		log.Info().Msgf("migrated %d entries of namespaces of a previous layout", n)
+	}
 	return true, nil
 }
 
+// move moves value into the field of id, unless it is taken, and then calls remove to drop it from its previous
+// place. It reports whether value was moved.
+func (r *Redis) move(ctx context.Context, id, value string, remove func() error) (bool, error) {
+	hash, field := r.field(id)
+	set, err := r.Client().HSetNX(ctx, hash, field, value).Result()
+	if err != nil {
+		return false, err
+	}
+	if !set {
+		r.logger.Logger().Warn().Msgf("not migrating %s: it is already stored", id)
+		return false, nil
+	}
+	return true, remove()
+}
+
+// migrateNamespaces moves the entries of namespaces, once stored in the single hash <prefix>_ns, into hashes of
+// their own, and returns how many it moved. The hash is under the prefix, so this is safe to run on every Connect.
+func (r *Redis) migrateNamespaces(ctx context.Context) (int, error) {
+	var n int
+	namespaces := r.prefix + strings.TrimSuffix(namespacePrefix, keys.Delimiter)
+	fields, err := r.Client().HGetAll(ctx, namespaces).Result()
+	if err != nil {
+		return n, err
+	}
+	for f, value := range fields {
+		f := f
+		moved, err := r.move(ctx, namespacePrefix+f, value, func() error { return r.Client().HDel(ctx, namespaces, f).Err() })
+		if err != nil {
+			return n, err
+		}
+		if moved {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MigrateKeys moves the entries stored as top-level keys, before the vault had a prefix, into its hashes, and returns
+// how many it moved. Only the string keys matching match, outside the prefix, whose value owns reports as an entry of
+// the vault are moved; every other key of the database is left alone. A key is only deleted if it wasn't changed
+// after being copied, and keys whose field is already taken are left in place. It is never run on Connect: the
+// database may be shared with other apps, so moving keys out of it is left to the operator, e.g. with vault migrate.
+func (r *Redis) MigrateKeys(ctx context.Context, match string, owns func(key, value string) bool) (int, error) {
+	log := r.logger.Logger()
+
+	var n int
+	var mu sync.Mutex
+	err := r.forEachShard(ctx, func(ctx context.Context, c *redis.Client) error {
+		iter := c.ScanType(ctx, 0, match, int64(DefaultScanCount), "string").Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			if strings.HasPrefix(key, r.prefix) {
+				continue
+			}
+			value, err := c.Get(ctx, key).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !owns(key, value) {
+				log.Debug().Msgf("not migrating %s: it isn't an entry of the vault", key)
+				continue
+			}
+			mu.Lock()
+			moved, err := r.move(ctx, key, value, func() error { return deleteIfEqual(ctx, c, key, value) })
+			if moved {
+				n++
+			}
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	})
+	return n, err
+}
+
+// deleteIfEqual deletes key if it still holds value, so a key changed meanwhile isn't lost
+func deleteIfEqual(ctx context.Context, c *redis.Client, key, value string) error {
+	err := c.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if current != value {
+			return fmt.Errorf("%s was changed while being migrated, and was left in place", key)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return fmt.Errorf("%s was changed while being migrated, and was left in place", key)
+	}
+	return err
+}
+
 // Ping sends a ping message to the redis server to check the connection health
 func (r *Redis) Ping(ctx context.Context) (bool, error) {
 	log := r.logger.Logger()
+	if r.conn == nil {
+		return false, ErrNotConnected
+	}
 	if err := r.Client().Ping(ctx).Err(); err != nil {
 		log.Debug().Msgf("redis ping: unsuccessful")
 		return false, err
@@ -136,11 +280,52 @@ func (r *Redis) Ping(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
-// Client returns the Redis client to the caller
-func (r *Redis) Client() *redis.Client {
+// Client returns the Redis client to the caller: a *redis.Client for standalone and Sentinel connections, or a
+// *redis.ClusterClient for cluster ones
+func (r *Redis) Client() redis.UniversalClient {
 	return r.conn
 }
 
+// field returns the hash holding the entry of id, and its field in the hash
+func (r *Redis) field(id string) (string, string) {
+	parent, child, _ := splitParent(id)
+	return r.prefix + parent, child
+}
+
+// splitParent splits id into the parent ID its hash is named after, and the rest of it, reporting whether it has a
+// rest. The parent ID of a namespaced id includes the prefix of its namespace.
+func splitParent(id string) (string, string, bool) {
+	var skip int
+	if rest, ok := strings.CutPrefix(id, namespacePrefix); ok {
+		if _, after, ok := strings.Cut(rest, keys.Delimiter); ok {
+			skip = len(id) - len(after)
+		}
+	}
+	parent, child, ok := strings.Cut(id[skip:], keys.Delimiter)
+	return id[:skip] + parent, child, ok
+}
+
+// id returns the id of the entry at field of hash, reversing field
+func (r *Redis) id(hash, field string) string {
+	parent := strings.TrimPrefix(hash, r.prefix)
+	if field == "" {
+		return parent
+	}
+	return parent + keys.Delimiter + field
+}
+
+// forEachShard calls fn with the client of every master holding keys: the one server, or every master of a cluster
+func (r *Redis) forEachShard(ctx context.Context, fn func(ctx context.Context, c *redis.Client) error) error {
+	switch c := r.conn.(type) {
+	case *redis.ClusterClient:
+		return c.ForEachMaster(ctx, fn)
+	case *redis.Client:
+		return fn(ctx, c)
+	default:
+		return fmt.Errorf("unsupported redis client %T", c)
+	}
+}
+
 // Store stores a key/value pair in the database.
 func (r *Redis) Store(ctx context.Context, id string, token any) (err error) {
 	log := r.logger.Logger()
@@ -159,16 +344,11 @@ func (r *Redis) Store(ctx context.Context, id string, token any) (err error) {
 		return err
 	}
 
-	// check if key already exists
-	status, err := r.Client().Set(ctx, id, tokenStr, DefaultTTL).Result()
-	if err != nil {
+	hash, field := r.field(id)
+	if err = r.Client().HSet(ctx, hash, field, tokenStr).Err(); err != nil {
 		log.Error().Msgf(ErrWithOperation, err.Error())
 		return err
 	}
-	if status != RedisStatusOkay {
-		log.Debug().Msgf("did not receive an \"OK\" response from redis, received %s", RedisStatusOkay)
-		return fmt.Errorf("did not receive an \"OK\" response from redis, received %s", RedisStatusOkay)
-	}
 	log.Debug().Msg(OperationSuccessful)
 	return nil
 }
@@ -184,7 +364,8 @@ func (r *Redis) IsExists(ctx context.Context, key string) bool {
 
 // Retrieve retrieves a key/value pair from the database.
 func (r *Redis) Retrieve(ctx context.Context, id string) (string, error) {
-	val, err := r.Client().Get(ctx, id).Result()
+	hash, field := r.field(id)
+	val, err := r.Client().HGet(ctx, hash, field).Result()
 	if err != nil {
 		log.Error().Msgf(ErrWithOperation, err.Error())
 		return val, err
@@ -196,27 +377,229 @@ func (r *Redis) Retrieve(ctx context.Context, id string) (string, error) {
 // RetrieveAll retrieves all the key/value pairs currently stored in the database.
 func (r *Redis) RetrieveAll(ctx context.Context) (map[string]string, error) {
 	log := r.logger.Logger()
-	keys := r.conn.Keys(ctx, "*")
-	l := len(keys.Val())
-	// allocate map of size l
-	kv := make(map[string]string, l)
-	if l < 1 {
-		log.Error().Msgf("length of retrieved keys is less than zero. it is: %d", l)
-		return kv, fmt.Errorf("length of retrieved keys is less than zero. it is: %d", l)
+	page, err := r.Scan(ctx, ScanOptions{})
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("parsed %d database entries into map", len(page.Keys))
+
+	return page.Values, nil
+}
+
+// Scan returns a page of entries, a hash at a time, so the keyspace is never walked in one go. A prefix within a
+// parent ID scans its hash alone, with HGETALL, or HSCAN if the page has a count, whose cursor is the one of the scan.
+// Any other prefix scans the hashes of the parent IDs starting with it with SCAN, and the cursor is the one of SCAN.
+// Whole hashes are returned, so pages may hold more entries than their count. As with SCAN, a key may be returned in
+// more than one page if the keyspace changes during the scan.
+//
+// The cursors of SCAN are those of a single node, so on a cluster every master is scanned whole, and the page is the
+// one following the last hash of the previous page, in key order.
+func (r *Redis) Scan(ctx context.Context, opts ScanOptions) (*Page, error) {
+	log := r.logger.Logger()
+
+	var entries map[string]string
+	var cursor string
+	var err error
+	if parent, prefix, ok := splitParent(opts.Prefix); ok {
+		entries, cursor, err = r.scanHash(ctx, r.prefix+parent, prefix, opts)
+	} else {
+		entries, cursor, err = r.scanHashes(ctx, opts)
+	}
+	if err != nil {
+		log.Error().Msgf(ErrWithOperation, err.Error())
+		return nil, err
+	}
+
+	page := &Page{Keys: make([]string, 0, len(entries)), Cursor: cursor}
+	for k := range entries {
+		page.Keys = append(page.Keys, k)
+	}
+	sort.Strings(page.Keys)
+	if !opts.KeysOnly {
+		page.Values = entries
+	}
+	log.Debug().Msg(OperationSuccessful)
+	return page, nil
+}
+
+// scanHash returns the entries of hash whose fields start with prefix, continuing the HSCAN cursor of opts
+func (r *Redis) scanHash(ctx context.Context, hash, prefix string, opts ScanOptions) (map[string]string, string, error) {
+	entries := map[string]string{}
+	if opts.Count <= 0 && opts.Cursor == "" {
+		fields, err := r.Client().HGetAll(ctx, hash).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for f, v := range fields {
+			if strings.HasPrefix(f, prefix) {
+				entries[r.id(hash, f)] = v
+			}
+		}
+		return entries, "", nil
+	}
+
+	cursor, count, err := scanCursor(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		fields, next, err := r.Client().HScan(ctx, hash, cursor, scanPattern(prefix), count).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			entries[r.id(hash, fields[i])] = fields[i+1]
+		}
+		cursor = next
+
+		// without a count, the page holds every remaining field
+		if cursor == 0 || opts.Count > 0 && len(entries) >= opts.Count {
+			break
+		}
+	}
+	return entries, formatCursor(cursor), nil
+}
+
+// scanHashes returns the entries of a page of the hashes of the parent IDs starting with the prefix of opts
+func (r *Redis) scanHashes(ctx context.Context, opts ScanOptions) (map[string]string, string, error) {
+	var hashes []string
+	var cursor string
+	var err error
+	if _, ok := r.conn.(*redis.ClusterClient); ok {
+		hashes, cursor, err = r.clusterHashes(ctx, opts)
+	} else {
+		hashes, cursor, err = r.cursorHashes(ctx, opts)
+	}
+	if err != nil || len(hashes) == 0 {
+		return map[string]string{}, cursor, err
 	}
 
-	for i := 0; i < l; i++ {
-		kv[keys.Val()[i]] = r.conn.Get(ctx, keys.Val()[i]).Val()
+	// the hashes are read in a single round trip
+	all := make([]*redis.MapStringStringCmd, len(hashes))
+	fields := make([]*redis.StringSliceCmd, len(hashes))
+	_, err = r.Client().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, h := range hashes {
+			if opts.KeysOnly {
+				fields[i] = pipe.HKeys(ctx, h)
+			} else {
+				all[i] = pipe.HGetAll(ctx, h)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	entries := map[string]string{}
+	for i, h := range hashes {
+		if opts.KeysOnly {
+			for _, f := range fields[i].Val() {
+				entries[r.id(h, f)] = ""
+			}
+			continue
+		}
+		for f, v := range all[i].Val() {
+			entries[r.id(h, f)] = v
+		}
+	}
+	return entries, cursor, nil
+}
+
+// cursorHashes returns a page of the hashes of a single server, continuing the SCAN cursor of opts
+func (r *Redis) cursorHashes(ctx context.Context, opts ScanOptions) ([]string, string, error) {
+	cursor, count, err := scanCursor(opts)
+	if err != nil {
+		return nil, "", err
 	}
-	log.Debug().Msgf("parsed database contents into map")
 
-	return kv, nil
+	var hashes []string
+	seen := map[string]bool{}
+	for {
+		keys, next, err := r.Client().Scan(ctx, cursor, scanPattern(r.prefix+opts.Prefix), count).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				hashes = append(hashes, k)
+			}
+		}
+		cursor = next
+
+		// without a count, the page holds every remaining hash
+		if cursor == 0 || opts.Count > 0 && len(hashes) >= opts.Count {
+			break
+		}
+	}
+	return hashes, formatCursor(cursor), nil
+}
+
+// clusterHashes returns a page of the hashes of every master of a cluster, in key order
+func (r *Redis) clusterHashes(ctx context.Context, opts ScanOptions) ([]string, string, error) {
+	var mu sync.Mutex
+	hashes := map[string]string{}
+	err := r.forEachShard(ctx, func(ctx context.Context, c *redis.Client) error {
+		iter := c.Scan(ctx, 0, scanPattern(r.prefix+opts.Prefix), int64(DefaultScanCount)).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			hashes[iter.Val()] = ""
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	page, err := scanSorted(hashes, ScanOptions{Cursor: opts.Cursor, Count: opts.Count, KeysOnly: true})
+	if err != nil {
+		return nil, "", err
+	}
+	return page.Keys, page.Cursor, nil
+}
+
+// scanCursor returns the SCAN cursor of opts, and the count to scan with
+func scanCursor(opts ScanOptions) (uint64, int64, error) {
+	var cursor uint64
+	if opts.Cursor != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(opts.Cursor, 10, 64); err != nil || cursor == 0 {
+			return 0, 0, ErrScanCursorInvalid
+		}
+	}
+	count := opts.Count
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	return cursor, int64(count), nil
+}
+
+// formatCursor returns the cursor of a page ending at SCAN cursor c, empty once the scan is complete
+func formatCursor(c uint64) string {
+	if c == 0 {
+		return ""
+	}
+	return strconv.FormatUint(c, 10)
+}
+
+// scanPattern returns the SCAN MATCH pattern of keys starting with prefix
+func scanPattern(prefix string) string {
+	var b strings.Builder
+	for _, c := range prefix {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('*')
+	return b.String()
 }
 
 // Delete deletes a key/value pair identified by key
 func (r *Redis) Delete(ctx context.Context, id string) (bool, error) {
 	log := r.logger.Logger()
-	n, err := r.conn.Del(ctx, id).Result()
+	hash, field := r.field(id)
+	n, err := r.conn.HDel(ctx, hash, field).Result()
 	log.Info().Msgf("deleted %d number of keys", n)
 	if err != nil {
 		log.Error().Msgf("error occurred while deleting key %s: %s\n", id, err.Error())
@@ -242,25 +625,93 @@ func (r *Redis) Patch(ctx context.Context, id string, token any) (bool, error) {
 		return false, fmt.Errorf(ErrTokenTypeNotString)
 	}
 
-	status, err := r.Client().Set(ctx, id, value, DefaultTTL).Result()
-	if err != nil {
+	hash, field := r.field(id)
+	if err := r.Client().HSet(ctx, hash, field, value).Err(); err != nil {
 		log.Error().Msgf(ErrWithOperation, err.Error())
 		return false, err
 	}
-	if status != RedisStatusOkay {
-		log.Debug().Msgf("did not receive an \"OK\" response from redis, received %s", RedisStatusOkay)
-		return false, fmt.Errorf("did not receive an \"OK\" response from redis, received %s", RedisStatusOkay)
-	}
 	log.Debug().Msg(OperationSuccessful)
 	return true, nil
 }
 
-// Flush clears the content of the current redis DB
+// Batch applies every op in a single MULTI/EXEC transaction, or none of them if any doesn't hold. The hashes of the
+// keys are WATCHed while they are checked, so a concurrent write to any of them aborts the transaction with
+// ErrBatchConflict. On a cluster, transactions can't span slots, so the keys of a batch must all belong to the same
+// parent ID, or to parent IDs hashing to the same slot.
+func (r *Redis) Batch(ctx context.Context, ops []Op) error {
+	log := r.logger.Logger()
+
+	var hashes []string
+	seen := map[string]bool{}
+	for _, op := range ops {
+		if hash, _ := r.field(op.ID); !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+
+	err := r.Client().Watch(ctx, func(tx *redis.Tx) error {
+		writes, err := stage(ops, func(id string) (string, bool, error) {
+			hash, field := r.field(id)
+			token, err := tx.HGet(ctx, hash, field).Result()
+			if errors.Is(err, redis.Nil) {
+				return "", false, nil
+			}
+			return token, err == nil, err
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for id, token := range writes {
+				hash, field := r.field(id)
+				if token == nil {
+					pipe.HDel(ctx, hash, field)
+					continue
+				}
+				pipe.HSet(ctx, hash, field, *token)
+			}
+			return nil
+		})
+		return err
+	}, hashes...)
+	if errors.Is(err, redis.TxFailedErr) {
+		log.Debug().Msgf("batch transaction failed: %s", err)
+		return ErrBatchConflict
+	}
+	if err != nil {
+		log.Error().Msgf(ErrWithOperation, err.Error())
+		return err
+	}
+	log.Debug().Msg(OperationSuccessful)
+	return nil
+}
+
+// Flush deletes the hashes of the vault, i.e. the keys under its prefix, on the server or every master of a cluster.
+// Other keys of the database are left alone.
 func (r *Redis) Flush(ctx context.Context) (bool, error) {
-	err := r.Client().FlushDB(ctx)
-	if err.Err() != nil {
-		log.Error().Msgf(ErrWithOperation, err.Err())
-		return false, err.Err()
+	err := r.forEachShard(ctx, func(ctx context.Context, c *redis.Client) error {
+		var hashes []string
+		iter := c.Scan(ctx, 0, scanPattern(r.prefix), int64(DefaultScanCount)).Iterator()
+		for iter.Next(ctx) {
+			hashes = append(hashes, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		// keys are deleted one by one, as a key deleting several must not span slots on a cluster
+		_, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, h := range hashes {
+				pipe.Del(ctx, h)
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf(ErrWithOperation, err)
+		return false, err
 	}
 	log.Debug().Msg(OperationSuccessful)
 	return true, nil
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/dark-enstein/vault/internal/vlog"
 	"os/exec"
 	"testing"
@@ -218,6 +219,140 @@ func TestRedisSuite(t *testing.T) {
 	suite.Run(t, new(RedisTestSuite))
 }
 
+type RedisLayoutTestSuite struct {
+	suite.Suite
+	mr    *miniredis.Miniredis
+	redis *Redis
+}
+
+// SetupTest connects to an in-process Redis, shared with the key of another app
+func (suite *RedisLayoutTestSuite) SetupTest() {
+	suite.mr = miniredis.RunT(suite.T())
+	suite.Require().NoError(suite.mr.Set("other", "app"))
+	var err error
+	suite.redis, err = NewRedis("redis://"+suite.mr.Addr()+"?key_prefix=team:vault:", vlog.New(true))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	_, err = suite.redis.Connect(context.Background())
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+}
+
+func (suite *RedisLayoutTestSuite) TearDownTest() {
+	suite.redis.Close(context.Background())
+}
+
+func (suite *RedisLayoutTestSuite) TestLayout() {
+	ctx := context.Background()
+	for k, v := range map[string]string{"app/db/password": "t1", "app/api": "t2", "db/x": "t3"} {
+		suite.Require().NoError(suite.redis.Store(ctx, k, v))
+	}
+	// every parent ID is a hash under the prefix
+	suite.Require().Equal([]string{"other", "team:vault:app", "team:vault:db"}, suite.mr.Keys())
+	suite.Require().Equal("t1", suite.mr.HGet("team:vault:app", "db/password"))
+
+	// the children of an ID are read with a single command
+	commands := suite.mr.CommandCount()
+	page, err := suite.redis.Scan(ctx, ScanOptions{Prefix: "app/"})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(commands+1, suite.mr.CommandCount())
+	suite.Require().Equal([]string{"app/api", "app/db/password"}, page.Keys)
+	suite.Require().Equal("t1", page.Values["app/db/password"])
+
+	// the whole vault is scanned a hash at a time
+	var keys []string
+	opts := ScanOptions{Count: 1, KeysOnly: true}
+	for {
+		page, err := suite.redis.Scan(ctx, opts)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		keys = append(keys, page.Keys...)
+		if opts.Cursor = page.Cursor; opts.Cursor == "" {
+			break
+		}
+	}
+	suite.Require().ElementsMatch([]string{"app/api", "app/db/password", "db/x"}, keys)
+
+	// every namespace has hashes of its own
+	suite.Require().NoError(suite.redis.Store(ctx, "_ns/payments/app/db", "t4"))
+	suite.Require().Equal("t4", suite.mr.HGet("team:vault:_ns/payments/app", "db"))
+	for _, prefix := range []string{"_ns/payments/", "_ns/payments/app/"} {
+		page, err = suite.redis.Scan(ctx, ScanOptions{Prefix: prefix})
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equal([]string{"_ns/payments/app/db"}, page.Keys)
+	}
+
+	_, err = suite.redis.Delete(ctx, "app/api")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	_, err = suite.redis.Retrieve(ctx, "app/api")
+	suite.Require().Error(err)
+
+	// flushing leaves the keys of other apps alone
+	ok, err := suite.redis.Flush(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().True(ok)
+	suite.Require().Equal([]string{"other"}, suite.mr.Keys())
+	all, err := suite.redis.RetrieveAll(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Empty(all)
+	ok, err = suite.redis.Flush(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().True(ok)
+
+	_, err = NewRedis("redis://"+suite.mr.Addr(), vlog.New(true), WithKeyPrefix(""))
+	suite.Require().ErrorIs(err, ErrRedisKeyPrefixEmpty)
+}
+
+func (suite *RedisLayoutTestSuite) TestMigrate() {
+	ctx := context.Background()
+	connect := func() {
+		r, err := NewRedis("redis://"+suite.mr.Addr()+"?key_prefix=team:vault:", vlog.New(true))
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		_, err = r.Connect(ctx)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		r.Close(ctx)
+	}
+
+	// entries of the first releases, and of releases storing records, are top-level keys, next to the keys of others
+	suite.Require().NoError(suite.mr.Set("app__db", "t1"))
+	suite.Require().NoError(suite.mr.Set("web/api", `{"token":"t2","rev":1}`))
+	suite.Require().NoError(suite.mr.Set("session__abc", "user-42"))
+	connect()
+	suite.Require().Equal([]string{"app__db", "other", "session__abc", "web/api"}, suite.mr.Keys())
+
+	// they are only moved on demand, if the vault owns them
+	owns := func(key, value string) bool { return value != "app" && value != "user-42" }
+	n, err := suite.redis.MigrateKeys(ctx, "*", owns)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(2, n)
+	suite.Require().Equal([]string{"other", "session__abc", "team:vault:app__db", "team:vault:web"}, suite.mr.Keys())
+	v, err := suite.redis.Retrieve(ctx, "app__db")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("t1", v)
+	v, err = suite.redis.Retrieve(ctx, "web/api")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(`{"token":"t2","rev":1}`, v)
+
+	// keys whose field is taken, or outside match, are left in place
+	suite.Require().NoError(suite.mr.Set("app__db", "t3"))
+	suite.Require().NoError(suite.mr.Set("late__db", "t4"))
+	n, err = suite.redis.MigrateKeys(ctx, "app*", owns)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Zero(n)
+	suite.Require().True(suite.mr.Exists("app__db"))
+	suite.Require().True(suite.mr.Exists("late__db"))
+
+	// namespaces were once stored in a single hash under the prefix, and are moved on Connect
+	suite.mr.HSet("team:vault:_ns", "payments/app/db", "t5")
+	connect()
+	suite.Require().False(suite.mr.Exists("team:vault:_ns"))
+	v, err = suite.redis.Retrieve(ctx, "_ns/payments/app/db")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("t5", v)
+}
+
+// TestRedisLayoutSuite tests the RedisLayout suite
+func TestRedisLayoutSuite(t *testing.T) {
+	suite.Run(t, new(RedisLayoutTestSuite))
+}
+
 // SetUpEnv spawns up a redis instance in a docker container
 func SetUpEnv(port string) error {
 	cmd := exec.Command("docker", fmt.Sprintf("run -d --name redis-stack -p %s:6379 -p 8001:8001 redis/redis-stack:latest", port))
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// DefaultScanCount is the number of keys requested per round trip when scanning a remote store
+var DefaultScanCount = 100
+
+var ErrScanCursorInvalid = errors.New("scan cursor is invalid")
+
+// ScanOptions selects a page of entries
+type ScanOptions struct {
+	// Cursor continues a previous scan from where its page ended. Empty starts from the beginning.
+	Cursor string
+	// Prefix restricts the scan to keys starting with it
+	Prefix string
+	// Count is the number of keys wanted in the page, or every remaining key if not positive. Remote stores may return
+	// slightly more or fewer.
+	Count int
+	// KeysOnly skips retrieving values
+	KeysOnly bool
+}
+
+// Page is a page of scanned entries
+type Page struct {
+	// Keys are the keys of the page, sorted for stores with an order
+	Keys []string
+	// Values holds the value of every key, unless the scan was KeysOnly
+	Values map[string]string
+	// Cursor continues the scan after this page. It is empty once the scan is complete.
+	Cursor string
+}
+
+// scanSorted pages through entries in key order. Its cursors are the last key of the page, encoded to keep them opaque.
+func scanSorted(entries map[string]string, opts ScanOptions) (*Page, error) {
+	var after string
+	if opts.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, ErrScanCursorInvalid
+		}
+		after = string(b)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		if strings.HasPrefix(k, opts.Prefix) && (opts.Cursor == "" || k > after) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	page := &Page{Keys: keys}
+	if opts.Count > 0 && len(keys) > opts.Count {
+		page.Keys = keys[:opts.Count]
+		page.Cursor = base64.RawURLEncoding.EncodeToString([]byte(page.Keys[len(page.Keys)-1]))
+	}
+	if !opts.KeysOnly {
+		page.Values = make(map[string]string, len(page.Keys))
+		for _, k := range page.Keys {
+			page.Values[k] = entries[k]
+		}
+	}
+	return page, nil
+}
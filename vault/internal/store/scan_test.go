@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// TestScan pages through every store that doesn't need a server, as part of the Batch suite which sets them up
+func (suite *BatchTestSuite) TestScan() {
+	ctx := context.Background()
+	for name, s := range suite.stores(ctx) {
+		page, err := s.Scan(ctx, ScanOptions{})
+		suite.Require().NoErrorf(err, "expected no errors scanning an empty %s, but got this %v\n", name, err)
+		suite.Require().Empty(page.Keys)
+
+		ops := []Op{{Kind: OpStore, ID: "other/x", Token: "t"}}
+		for i := 0; i < 5; i++ {
+			ops = append(ops, Op{Kind: OpStore, ID: fmt.Sprintf("app/k%d", i), Token: fmt.Sprint(i)})
+		}
+		suite.Require().NoError(s.Batch(ctx, ops))
+
+		var keys []string
+		var cursor string
+		for pages := 0; ; pages++ {
+			suite.Require().Lessf(pages, 3, "expected %s to be scanned in 3 pages", name)
+			page, err = s.Scan(ctx, ScanOptions{Cursor: cursor, Prefix: "app/", Count: 2})
+			suite.Require().NoErrorf(err, "expected no errors with %s, but got this %v\n", name, err)
+			for _, k := range page.Keys {
+				suite.Require().Equal(k[len("app/k"):], page.Values[k])
+			}
+			keys = append(keys, page.Keys...)
+			if cursor = page.Cursor; cursor == "" {
+				break
+			}
+		}
+		suite.Require().Equalf([]string{"app/k0", "app/k1", "app/k2", "app/k3", "app/k4"}, keys, "unexpected scan of %s", name)
+
+		page, err = s.Scan(ctx, ScanOptions{KeysOnly: true})
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Len(page.Keys, 6)
+		suite.Require().Nil(page.Values)
+
+		_, err = s.Scan(ctx, ScanOptions{Cursor: "%"})
+		suite.Require().ErrorIsf(err, ErrScanCursorInvalid, "expected %s to refuse the cursor", name)
+		_ = s.Close(ctx)
+	}
+}
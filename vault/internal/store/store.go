@@ -8,8 +8,12 @@ type Store interface {
 	Store(ctx context.Context, id string, token any) error
 	Retrieve(ctx context.Context, id string) (string, error)
 	RetrieveAll(ctx context.Context) (map[string]string, error)
+	// Scan returns a page of entries, without loading the whole store at once where the store allows it
+	Scan(ctx context.Context, opts ScanOptions) (*Page, error)
 	Delete(ctx context.Context, id string) (bool, error)
 	Patch(ctx context.Context, id string, token any) (bool, error)
+	// Batch applies every op atomically: either all of them are persisted, or none is, and an error is returned.
+	Batch(ctx context.Context, ops []Op) error
 	Flush(ctx context.Context) (bool, error)
 	Close(ctx context.Context) error
 }
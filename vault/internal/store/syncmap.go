@@ -11,6 +11,8 @@ import (
 type Map struct {
 	scaffold *sync.Map
 	logger   *vlog.Logger
+	// mu serializes writes, so a Batch is never interleaved with other writes
+	mu sync.Mutex
 }
 
 //func NewSyncMap() *sync.Map {
@@ -19,8 +21,8 @@ type Map struct {
 
 func NewSyncMap(ctx context.Context, logger *vlog.Logger) *Map {
 	return &Map{
-		&sync.Map{},
-		logger,
+		scaffold: &sync.Map{},
+		logger:   logger,
 	}
 }
 
@@ -30,6 +32,8 @@ func (m *Map) Connect(ctx context.Context) (bool, error) {
 
 func (m *Map) Store(ctx context.Context, id string, token any) error {
 	log := m.logger.Logger()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	// check if key exists
 	if m.IsExist(id) {
@@ -92,8 +96,19 @@ func (m *Map) RetrieveAll(ctx context.Context) (map[string]string, error) {
 	return allTokenMap, nil
 }
 
+// Scan returns a page of entries, in key order
+func (m *Map) Scan(ctx context.Context, opts ScanOptions) (*Page, error) {
+	all, err := m.RetrieveAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return scanSorted(all, opts)
+}
+
 func (m *Map) Delete(ctx context.Context, id string) (bool, error) {
 	log := m.logger.Logger()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	// delete key from map
 	m.scaffold.Delete(id)
@@ -111,6 +126,8 @@ func (m *Map) Delete(ctx context.Context, id string) (bool, error) {
 
 func (m *Map) Patch(ctx context.Context, id string, token any) (bool, error) {
 	log := m.logger.Logger()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	// check if key exists
 	if m.IsExist(id) {
@@ -132,6 +149,36 @@ func (m *Map) Patch(ctx context.Context, id string, token any) (bool, error) {
 	return true, nil
 }
 
+// Batch applies every op, or none of them if any doesn't hold
+func (m *Map) Batch(ctx context.Context, ops []Op) error {
+	log := m.logger.Logger()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writes, err := stage(ops, func(id string) (string, bool, error) {
+		v, ok := m.scaffold.Load(id)
+		if !ok {
+			return "", false, nil
+		}
+		_, token := InterfaceIsString(v)
+		return token, true, nil
+	})
+	if err != nil {
+		log.Debug().Msgf("error staging batch: %s", err)
+		return err
+	}
+
+	for id, token := range writes {
+		if token == nil {
+			m.scaffold.Delete(id)
+			continue
+		}
+		m.scaffold.Store(id, *token)
+	}
+	log.Debug().Msgf("successfully applied batch of %d operations", len(ops))
+	return nil
+}
+
 func (m *Map) Flush(ctx context.Context) (bool, error) {
 
 	// simulate flushing by assigning a new instance of sync.Map to scaffold
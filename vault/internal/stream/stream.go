@@ -0,0 +1,236 @@
+// Package stream detokenizes newline-delimited JSON streams, so callers can detokenize millions of rows without either
+// side holding them all in memory.
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"io"
+)
+
+const (
+	// DefaultConcurrency is the number of lines detokenized at once, if none is configured
+	DefaultConcurrency = 8
+	// MaxConcurrency caps the number of lines detokenized at once
+	MaxConcurrency = 64
+	// DefaultMaxLineSize is the size above which a line is rejected, if none is configured
+	DefaultMaxLineSize = 1 << 20
+)
+
+var (
+	ErrLineTooLong      = errors.New("line exceeds the maximum line size")
+	ErrTokenRequired    = errors.New("token is required")
+	ErrConcurrencyRange = errors.New("concurrency must be between 1 and 64")
+)
+
+// Detokenizer detokenizes a token stored under key. tokenize.Manager implements it.
+type Detokenizer interface {
+	Detokenize(ctx context.Context, key, token string) (bool, string, error)
+}
+
+// Options configures a stream
+type Options struct {
+	// Concurrency is the number of lines detokenized at once. It defaults to DefaultConcurrency.
+	Concurrency int
+	// MaxLineSize is the size above which a line is rejected. It defaults to DefaultMaxLineSize.
+	MaxLineSize int
+	// Flush, if set, is called whenever the written results are pushed out to the consumer
+	Flush func()
+}
+
+// job is a line being detokenized. Its result is delivered on out.
+type job struct {
+	line int
+	raw  []byte
+	err  error
+	out  chan *model.StreamResult
+}
+
+// Detokenize reads a model.StreamLine per line of r, and writes a model.StreamResult per line to w, in the order of the
+// lines. A line that fails reports its error in its result, and the stream carries on; blank lines are skipped.
+//
+// At most Concurrency lines are detokenized at once, and at most twice as many wait to be written. Once that window is
+// full, r isn't read any further until w catches up, so a slow consumer slows the producer down rather than growing
+// the memory held by the stream.
+//
+// It returns the number of results written. If reading r fails, the error is also written as a result with a Line of 0.
+func Detokenize(ctx context.Context, d Detokenizer, r io.Reader, w io.Writer, opts Options) (int, error) {
+	if opts.Concurrency == 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Concurrency < 0 || opts.Concurrency > MaxConcurrency {
+		return 0, ErrConcurrencyRange
+	}
+	if opts.MaxLineSize <= 0 {
+		opts.MaxLineSize = DefaultMaxLineSize
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan *job)
+	pending := make(chan *job, 2*opts.Concurrency)
+	var readErr error
+
+	// read lines, and queue them both for the workers, and in order for the writer
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		br := bufio.NewReader(r)
+		for n := 1; ; n++ {
+			raw, err := readLine(br, opts.MaxLineSize)
+			if err == io.EOF {
+				return
+			}
+			if err != nil && !errors.Is(err, ErrLineTooLong) {
+				readErr = err
+				return
+			}
+			if err == nil && len(bytes.TrimSpace(raw)) == 0 {
+				continue
+			}
+
+			j := &job{line: n, raw: raw, err: err, out: make(chan *model.StreamResult, 1)}
+			select {
+			case pending <- j:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < opts.Concurrency; i++ {
+		go func() {
+			for j := range jobs {
+				j.out <- detokenizeLine(ctx, d, j)
+			}
+		}()
+	}
+
+	bw := bufio.NewWriter(w)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if opts.Flush != nil {
+			opts.Flush()
+		}
+		return nil
+	}
+
+	enc := json.NewEncoder(bw)
+	var written int
+	for j := range pending {
+		var res *model.StreamResult
+		select {
+		case res = <-j.out:
+		default:
+			// nothing is ready, so push out what was written before waiting
+			if err := flush(); err != nil {
+				return written, err
+			}
+			select {
+			case res = <-j.out:
+			case <-ctx.Done():
+				return written, ctx.Err()
+			}
+		}
+		if err := enc.Encode(res); err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	if readErr != nil {
+		enc.Encode(&model.StreamResult{Error: readErr.Error()})
+		flush()
+		return written, readErr
+	}
+	return written, flush()
+}
+
+// detokenizeLine detokenizes the token of a line
+func detokenizeLine(ctx context.Context, d Detokenizer, j *job) *model.StreamResult {
+	res := &model.StreamResult{Line: j.line}
+	if j.err != nil {
+		res.Error = j.err.Error()
+		return res
+	}
+
+	var line model.StreamLine
+	dec := json.NewDecoder(bytes.NewReader(j.raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&line); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.ID, res.Key = line.ID, line.Key
+
+	var key string
+	var err error
+	if line.ID != "" {
+		key, err = tokenize.ChildKey(line.ID, line.Key)
+	} else {
+		key, err = keys.Normalize(line.Key)
+	}
+	if err == nil && line.Token == "" {
+		err = ErrTokenRequired
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Found, res.Datum, err = d.Detokenize(ctx, key, line.Token)
+	if err != nil {
+		res.Found, res.Datum, res.Error = false, "", err.Error()
+	}
+	return res
+}
+
+// readLine reads a line, without its line ending. A line longer than max is read up to its end and dropped, and
+// reported with ErrLineTooLong. io.EOF is only returned once there is nothing left to read.
+func readLine(br *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	var tooLong, read bool
+	for {
+		chunk, err := br.ReadSlice('\n')
+		read = read || len(chunk) > 0
+		if !tooLong {
+			if len(line)+len(chunk) > max+2 {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && read {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tooLong {
+			return nil, ErrLineTooLong
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if len(line) > max {
+			return nil, ErrLineTooLong
+		}
+		return line, nil
+	}
+}
@@ -0,0 +1,112 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type StreamTestSuite struct {
+	suite.Suite
+	m *tokenize.Manager
+}
+
+func (suite *StreamTestSuite) SetupTest() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	suite.m = tokenize.NewManager(ctx, log, tokenize.WithStore(store.NewSyncMap(ctx, log)), tokenize.WithCipherLoc(cipherLoc))
+}
+
+// counter counts the lines detokenized
+type counter struct {
+	calls atomic.Int64
+}
+
+func (c *counter) Detokenize(ctx context.Context, key, token string) (bool, string, error) {
+	c.calls.Add(1)
+	return true, token, nil
+}
+
+func (suite *StreamTestSuite) TestDetokenize() {
+	ctx := context.Background()
+	var in strings.Builder
+	for i := 0; i < 100; i++ {
+		token, err := suite.m.Tokenize(ctx, fmt.Sprintf("app/k%d", i), fmt.Sprint(i))
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		fmt.Fprintf(&in, `{"id":"app","key":"k%d","token":%q}`+"\n", i, token)
+	}
+	// a blank line, a malformed line, a wrong token and an oversized line fail on their own
+	in.WriteString("\n{\"key\":\n")
+	in.WriteString(`{"key":"app/k0","token":"wrong"}` + "\r\n")
+	in.WriteString(`{"key":"app/k0","token":"` + strings.Repeat("x", 200) + `"}`)
+
+	var out strings.Builder
+	n, err := Detokenize(ctx, suite.m, strings.NewReader(in.String()), &out, Options{Concurrency: 4, MaxLineSize: 128})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(103, n)
+
+	var results []model.StreamResult
+	sc := bufio.NewScanner(strings.NewReader(out.String()))
+	for sc.Scan() {
+		var res model.StreamResult
+		suite.Require().NoError(json.Unmarshal(sc.Bytes(), &res))
+		results = append(results, res)
+	}
+	suite.Require().Len(results, 103)
+	for i := 0; i < 100; i++ {
+		suite.Require().Equal(i+1, results[i].Line)
+		suite.Require().True(results[i].Found)
+		suite.Require().Equal(fmt.Sprint(i), results[i].Datum)
+	}
+	suite.Require().Equal(102, results[100].Line)
+	suite.Require().NotEmpty(results[100].Error)
+	suite.Require().Equal(103, results[101].Line)
+	suite.Require().Contains(results[101].Error, "does not match")
+	suite.Require().Equal(104, results[102].Line)
+	suite.Require().Equal(ErrLineTooLong.Error(), results[102].Error)
+}
+
+func (suite *StreamTestSuite) TestBackpressure() {
+	var in strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&in, `{"key":"app/k%d","token":"t"}`+"\n", i)
+	}
+
+	// nobody reads the output, so the stream must stop reading its input
+	d := &counter{}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := Detokenize(context.Background(), d, strings.NewReader(in.String()), pw, Options{Concurrency: 2})
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	suite.Require().Less(d.calls.Load(), int64(500))
+
+	// the stream gives up once its consumer is gone
+	pr.Close()
+	select {
+	case err := <-done:
+		suite.Require().ErrorIs(err, io.ErrClosedPipe)
+	case <-time.After(5 * time.Second):
+		suite.Fail("expected the stream to stop")
+	}
+}
+
+// TestStreamSuite tests the Stream suite
+func TestStreamSuite(t *testing.T) {
+	suite.Run(t, new(StreamTestSuite))
+}
@@ -2,15 +2,22 @@ package tokenize
 
 import (
 	"context"
+	"crypto/aes"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/events"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/labels"
 	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/secure"
 	"github.com/dark-enstein/vault/internal/store"
 	"github.com/dark-enstein/vault/internal/vlog"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 	"math/rand"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -19,20 +26,43 @@ var (
 	ErrKeyAlreadyExists = errors.New("key already exists. not overriding")
 	ErrKeyDoesNotExists = "key %s does not exist"
 	ErrDuplicateKeys    = errors.New("key already exists in request. accepted only the first one")
+	ErrKeyUnderValue    = errors.New("key is nested under a key that already holds a value")
+	ErrKeyOverValue     = errors.New("key already has keys holding values nested under it")
+	ErrRevisionMismatch = errors.New("key is not at the expected revision")
+	ErrCipherInvalid    = errors.New("cipher is invalid")
 )
 
 var (
 	DefaultCipherLoc           = "./.cipher"
 	EnvKeyAESCipher            = "CIPHER"
 	EnvKeyInitializationVector = "IV"
-	KeyDelimiter               = "__"
+	KeyDelimiter               = keys.Delimiter
 )
 
+const (
+	// OpTokenize is the operation reported to an Observer for tokenizations, patches included
+	OpTokenize = "tokenize"
+	// OpDetokenize is the operation reported to an Observer for detokenizations
+	OpDetokenize = "detokenize"
+)
+
+// Observer is notified of the outcome of n values going through op, e.g. to count them
+type Observer func(op string, n int, err error)
+
 type Manager struct {
-	store     store.Store
-	cipher    map[string]string
+	store store.Store
+	// view is the reservedStore store is wrapped in, which the tombstones store hides are read through
+	view *reservedStore
+	// cipher holds the key material in secure buffers. It is guarded by mu, as SetCipher destroys the buffers it
+	// replaces.
+	cipher    map[string]*secure.Buffer
+	mu        sync.RWMutex
 	cipherLoc string
 	log       *vlog.Logger
+	observer  Observer
+	// events announces the keys created, patched, deleted and expired, as those of namespace
+	events    *events.Bus
+	namespace string
 }
 
 // NewManager creates a new instance of Manager. It manages token operations (retrieval, storage, servicing) throughout the lifetime of the server.
@@ -41,16 +71,26 @@ func NewManager(ctx context.Context, logger *vlog.Logger, opts ...Options) *Mana
 	var manager = &Manager{}
 	manager.log = logger
 	manager.cipherLoc = DefaultCipherLoc
-	manager.cipher = map[string]string{}
-	if len(opts) == 0 {
-		manager.store = store.NewSyncMap(ctx, manager.log)
-	} else {
+	manager.cipher = map[string]*secure.Buffer{}
+	if len(opts) > 0 {
 		log.Debug().Msg("a separate store option was passed in")
 		for i := 0; i < len(opts); i++ {
 			opts[i](manager)
 		}
 	}
 
+	if manager.events == nil {
+		manager.events = events.NewBus(events.DefaultHistory)
+	}
+
+	// fall back to the in-memory store if no store was configured
+	if manager.store == nil {
+		manager.store = store.NewSyncMap(ctx, manager.log)
+	}
+	// the keys the vault keeps for itself are out of reach of ordinary tokens
+	manager.view = &reservedStore{s: manager.store}
+	manager.store = manager.view
+
 	b, err := manager.store.Connect(ctx)
 	if err != nil || !b {
 		log.Debug().Msgf("connection failed to store: %s\n", err.Error())
@@ -67,7 +107,10 @@ func NewManager(ctx context.Context, logger *vlog.Logger, opts ...Options) *Mana
 
 	// if cipher file already exists, emvMap is empty, so read from file
 	if len(manager.cipher) == 0 {
-		manager.cipher, err = godotenv.Read(manager.cipherLoc)
+		c, err := godotenv.Read(manager.cipherLoc)
+		if err == nil {
+			manager.cipher, err = lockCipher(c)
+		}
 		if err != nil {
 			manager.log.Logger().Error().Msgf("error encountered while reading cipher from file %s: %s\n", manager.cipherLoc, err.Error())
 		}
@@ -76,43 +119,367 @@ func NewManager(ctx context.Context, logger *vlog.Logger, opts ...Options) *Mana
 	return manager
 }
 
+// MigrateLegacyKeys moves the keys stored as id__key, before paths were introduced, to their path id/key, so they are
+// read and written like any other key. It scans the whole store, so it is only run on demand, by the migrate command.
+// Every key is first copied to its path, and then deleted only if it wasn't changed meanwhile; the two steps are
+// batches of their own, as the old key and its path may not share a slot of a Redis cluster. Keys whose path already
+// holds another value are left in place, and reported. It returns the number of keys moved.
+func (m *Manager) MigrateLegacyKeys(ctx context.Context) (int, error) {
+	var legacy []string
+	opts := store.ScanOptions{Count: store.DefaultScanCount, KeysOnly: true}
+	for {
+		page, err := m.store.Scan(ctx, opts)
+		if err != nil {
+			return 0, err
+		}
+		for _, k := range page.Keys {
+			if keys.IsLegacy(k) {
+				legacy = append(legacy, k)
+			}
+		}
+		if opts.Cursor = page.Cursor; opts.Cursor == "" {
+			break
+		}
+	}
+
+	var n int
+	for _, old := range legacy {
+		moved, err := m.migrateLegacyKey(ctx, old)
+		if err != nil {
+			return n, err
+		}
+		if moved {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// migrateLegacyKey moves the key old, stored as id__key, to its path. It reports whether old was moved.
+func (m *Manager) migrateLegacyKey(ctx context.Context, old string) (bool, error) {
+	log := m.log.Logger()
+	segments, err := keys.SplitStored(old)
+	if err != nil {
+		return false, err
+	}
+	path := keys.Join(segments...)
+	raw, err := m.store.Retrieve(ctx, old)
+	if err != nil {
+		// deleted since it was scanned
+		return false, nil
+	}
+
+	err = m.store.Batch(ctx, []store.Op{{Kind: store.OpStore, ID: path, Token: raw}})
+	if errors.Is(err, store.ErrBatchKeyExists) {
+		// a previous run may have stopped between copying the key and deleting it
+		if current, err := m.store.Retrieve(ctx, path); err != nil || current != raw {
+			log.Warn().Msgf("not migrating key %s: %s already exists", old, path)
+			return false, nil
+		}
+	} else if err != nil {
+		return false, fmt.Errorf("error migrating key %s to %s: %w", old, path, err)
+	}
+
+	changed := func(err error) bool {
+		return errors.Is(err, store.ErrBatchConflict) || errors.Is(err, store.ErrBatchKeyNotFound)
+	}
+	err = m.store.Batch(ctx, []store.Op{{Kind: store.OpDelete, ID: old, Expect: &raw}})
+	if changed(err) {
+		// old was changed meanwhile, so its copy is stale and dropped, unless the copy was changed too
+		if err := m.store.Batch(ctx, []store.Op{{Kind: store.OpDelete, ID: path, Expect: &raw}}); err != nil && !changed(err) {
+			return false, fmt.Errorf("error undoing the migration of key %s to %s: %w", old, path, err)
+		}
+		log.Warn().Msgf("not migrating key %s: it changed while being migrated", old)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error deleting key %s migrated to %s: %w", old, path, err)
+	}
+	return true, nil
+}
+
+// Owns reports whether raw is a value the manager stores: a record, or a bare token of the first releases, whose token
+// its cipher decrypts. It tells the entries of the vault from the keys of other apps, e.g. when migrating them.
+func (m *Manager) Owns(raw string) bool {
+	rec, err := decodeRecord(raw)
+	if err != nil {
+		return false
+	}
+	m.mu.RLock()
+	plain, err := detokenize(rec.Token, m.cipher)
+	m.mu.RUnlock()
+	if err != nil {
+		return false
+	}
+	plain.Destroy()
+	return true
+}
+
 // GenerateCipher generates a new AES cipher and Initialization Vector pais, and persists it to disk
 func (m *Manager) GenerateCipher() error {
-	// generate 32 digit key
-	m.cipher[EnvKeyAESCipher] = genAlphaNumericString(32)
-	// generate 16 digit initialization vector
-	m.cipher[EnvKeyInitializationVector] = genAlphaNumericString(16)
+	c := map[string]string{
+		// generate 32 digit key
+		EnvKeyAESCipher: genAlphaNumericString(32),
+		// generate 16 digit initialization vector
+		EnvKeyInitializationVector: genAlphaNumericString(16),
+	}
+	cipher, err := lockCipher(c)
+	if err != nil {
+		return err
+	}
+	m.replaceCipher(cipher)
 	// write to file
-	return godotenv.Write(m.cipher, m.cipherLoc)
+	return godotenv.Write(c, m.cipherLoc)
+}
+
+// lockCipher moves the values of a cipher map into secure buffers
+func lockCipher(c map[string]string) (map[string]*secure.Buffer, error) {
+	locked := make(map[string]*secure.Buffer, len(c))
+	for k, v := range c {
+		buf, err := secure.FromString(v)
+		if err != nil {
+			destroyCipher(locked)
+			return nil, err
+		}
+		locked[k] = buf
+	}
+	return locked, nil
+}
+
+// replaceCipher loads cipher in place of the current one, which is destroyed once no tokenization uses it anymore
+func (m *Manager) replaceCipher(cipher map[string]*secure.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	destroyCipher(m.cipher)
+	m.cipher = cipher
+}
+
+// destroyCipher wipes the secure buffers of a cipher
+func destroyCipher(c map[string]*secure.Buffer) {
+	for _, buf := range c {
+		buf.Destroy()
+	}
+}
+
+// Events returns the bus the changes of keys are published on
+func (m *Manager) Events() *events.Bus {
+	return m.events
+}
+
+// publish announces that key changed to rev, or was deleted from it. The changes of reserved keys aren't announced.
+func (m *Manager) publish(t events.Type, key string, rev int64) {
+	if IsReserved(key) {
+		return
+	}
+	m.events.Publish(events.Event{Type: t, Namespace: m.namespace, Key: key, Revision: rev})
+}
+
+// Store returns the underlying store backing the manager, as seen by tokens: reserved keys are only reachable with a
+// System context
+func (m *Manager) Store() store.Store {
+	return m.store
+}
+
+// CipherLoc returns the location of the cipher file of the manager
+func (m *Manager) CipherLoc() string {
+	return m.cipherLoc
+}
+
+// Cipher returns a copy of the cipher map currently loaded by the manager. Its values are copied out of the secure
+// buffers holding them, so it should only be held as long as needed.
+func (m *Manager) Cipher() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c := make(map[string]string, len(m.cipher))
+	for k, v := range m.cipher {
+		c[k] = v.Text()
+	}
+	return c
+}
+
+// CheckCipher checks that the loaded cipher holds a valid AES key and initialization vector
+func (m *Manager) CheckCipher() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.cipher[EnvKeyAESCipher]
+	if !ok {
+		return ErrCipherToken404AES
+	}
+	iv, ok := m.cipher[EnvKeyInitializationVector]
+	if !ok {
+		return ErrCipherToken404IV
+	}
+	return checkCipher(key.Bytes(), iv.Bytes())
+}
+
+// ValidateCipher checks that the cipher map c holds a valid AES key and initialization vector, before it is loaded
+func ValidateCipher(c map[string]string) error {
+	key, ok := c[EnvKeyAESCipher]
+	if !ok {
+		return ErrCipherToken404AES
+	}
+	iv, ok := c[EnvKeyInitializationVector]
+	if !ok {
+		return ErrCipherToken404IV
+	}
+	return checkCipher([]byte(key), []byte(iv))
+}
+
+// checkCipher checks that key is a valid AES key, and iv an initialization vector of its block size
+func checkCipher(key, iv []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("%w: %s", ErrCipherInvalid, err)
+	}
+	if len(iv) != aes.BlockSize {
+		return fmt.Errorf("%w: initialization vector must be %d bytes long", ErrCipherInvalid, aes.BlockSize)
+	}
+	return nil
+}
+
+// Ping checks that the store backing the manager is reachable
+func (m *Manager) Ping(ctx context.Context) (bool, error) {
+	return store.Ping(ctx, m.store)
+}
+
+// SetCipher replaces the cipher loaded by the manager, and persists it to the cipher location
+func (m *Manager) SetCipher(c map[string]string) error {
+	if _, ok := c[EnvKeyAESCipher]; !ok {
+		return ErrCipherToken404AES
+	}
+	if _, ok := c[EnvKeyInitializationVector]; !ok {
+		return ErrCipherToken404IV
+	}
+
+	if err := godotenv.Write(c, m.cipherLoc); err != nil {
+		m.log.Logger().Error().Msgf("error encountered while writing cipher to file %s: %s\n", m.cipherLoc, err.Error())
+		return err
+	}
+	cipher, err := lockCipher(c)
+	if err != nil {
+		return err
+	}
+	m.replaceCipher(cipher)
+	return nil
 }
 
 // GetTokenByID returns the token owned by a specific ID/Key
 func (m *Manager) GetTokenByID(ctx context.Context, id string) (*model.Tokenize, error) {
 	log := m.log.Logger()
-	var tokenStr string
 
-	if val, err := m.store.Retrieve(ctx, id); err != nil {
-		return nil, fmt.Errorf(ErrKeyDoesNotExists, id)
-	} else {
-		tokenStr = fmt.Sprint(val)
+	rec, _, err := m.record(ctx, id)
+	if err != nil {
+		return nil, err
 	}
 	log.Debug().Msg("successfully ranged over store data")
 
-	ss := strings.Split(id, KeyDelimiter)
+	parent, child, err := splitStoredKey(id)
+	if err != nil {
+		return nil, err
+	}
 
 	log.Debug().Msg("found token in store")
 	return &model.Tokenize{
-		ID: ss[0],
+		ID: parent,
 		Data: []model.Child{
 			{
-				Key:   ss[1],
-				Value: tokenStr,
+				Key:      child,
+				Value:    rec.Token,
+				Revision: rec.Rev,
+				Labels:   rec.Labels,
 			},
 		},
 	}, nil
 }
 
-// GetAllTokens returns all tokens currently in the store
+// GetChildrenByID returns every child token stored under the parent ID, keyed by their path relative to the parent.
+// Only the keys under the ID are scanned, not the whole store.
+func (m *Manager) GetChildrenByID(ctx context.Context, id string) (*model.Tokenize, error) {
+	log := m.log.Logger()
+
+	page, err := m.store.Scan(ctx, store.ScanOptions{Prefix: id + keys.Delimiter})
+	if err != nil {
+		log.Error().Msgf("error while scanning keys under %s: %s\n", id, err.Error())
+		return nil, fmt.Errorf(ErrKeyDoesNotExists, id)
+	}
+
+	token := &model.Tokenize{ID: id}
+	for k, v := range page.Values {
+		rel, ok := keys.Rel(id, k)
+		if !ok {
+			continue
+		}
+		rec, err := decodeRecord(v)
+		if err != nil {
+			return nil, fmt.Errorf("error with key %s: %w", k, err)
+		}
+		token.Data = append(token.Data, model.Child{
+			Key:      rel,
+			Value:    rec.Token,
+			Revision: rec.Rev,
+			Labels:   rec.Labels,
+		})
+	}
+
+	if len(token.Data) == 0 {
+		return nil, fmt.Errorf(ErrKeyDoesNotExists, id)
+	}
+
+	// keep the order stable for callers rendering the children
+	sort.Slice(token.Data, func(i, j int) bool {
+		return token.Data[i].Key < token.Data[j].Key
+	})
+
+	log.Debug().Msgf("found %d children under id %s", len(token.Data), id)
+	return token, nil
+}
+
+// GetDocument returns the token stored at path if it holds a value, otherwise the tokens nested under it, as a
+// document mirroring their hierarchy
+func (m *Manager) GetDocument(ctx context.Context, path string) (any, error) {
+	path, err := keys.Normalize(path)
+	if err != nil {
+		return nil, err
+	}
+	if rec, _, err := m.record(ctx, path); err == nil {
+		return rec.Token, nil
+	}
+
+	token, err := m.GetChildrenByID(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return keys.Nest(childMap(token.Data))
+}
+
+// DeleteByPath deletes the token stored at path, or every token nested under it. It returns the deleted keys.
+func (m *Manager) DeleteByPath(ctx context.Context, path string) ([]string, error) {
+	path, err := keys.Normalize(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.store.Retrieve(ctx, path); err == nil {
+		if _, err := m.DeleteTokenByID(ctx, path); err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	}
+
+	token, err := m.GetChildrenByID(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]string, 0, len(token.Data))
+	for _, child := range token.Data {
+		key := path + keys.Delimiter + child.Key
+		if _, err := m.DeleteTokenByID(ctx, key); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, key)
+	}
+	return deleted, nil
+}
+
+// GetAllTokens returns all tokens currently in the store, grouped by parent ID
 func (m *Manager) GetAllTokens(ctx context.Context) ([]*model.Tokenize, error) {
 	log := m.log.Logger()
 	allTokens := map[string]*model.Tokenize{}
@@ -125,36 +492,269 @@ func (m *Manager) GetAllTokens(ctx context.Context) ([]*model.Tokenize, error) {
 
 	// parse all tokens into a slice of model.Tokenize
 	for k, v := range allTokenMap {
-		ss := strings.Split(k, KeyDelimiter)
-		if val, ok := allTokens[k]; ok {
-			if len(val.ID) == 0 {
-				log.Debug().Msgf("token with id %s is already stored. continuing.", val.ID)
-			}
-			val.ID = ss[0]
-			val.Data = append(val.Data, model.Child{
-				Key:   ss[1],
-				Value: v,
-			})
+		parent, child, err := splitStoredKey(k)
+		if err != nil {
+			log.Debug().Msgf("skipping invalid key %s: %s", k, err)
 			continue
 		}
-		allTokens[k] = &model.Tokenize{
-			ID: ss[0],
+		rec, err := decodeRecord(v)
+		if err != nil {
+			log.Debug().Msgf("skipping invalid record under %s: %s", k, err)
+			continue
+		}
+		if _, ok := allTokens[parent]; !ok {
+			allTokens[parent] = &model.Tokenize{ID: parent}
 		}
-		allTokens[k].Data = append(allTokens[k].Data, model.Child{
-			Key:   ss[1],
-			Value: v,
+		allTokens[parent].Data = append(allTokens[parent].Data, model.Child{
+			Key:      child,
+			Value:    rec.Token,
+			Revision: rec.Rev,
+			Labels:   rec.Labels,
 		})
 	}
 
 	respTokens := []*model.Tokenize{}
 	for _, v := range allTokens {
+		sort.Slice(v.Data, func(i, j int) bool {
+			return v.Data[i].Key < v.Data[j].Key
+		})
 		respTokens = append(respTokens, v)
 	}
+	sort.Slice(respTokens, func(i, j int) bool {
+		return respTokens[i].ID < respTokens[j].ID
+	})
 
 	log.Debug().Msg("successfully parsed all tokens into a token array")
 	return respTokens, nil
 }
 
+// ListOptions selects a page of tokens
+type ListOptions struct {
+	// Prefix restricts the listing to keys starting with it, e.g. an ID
+	Prefix string
+	// Cursor continues a previous listing from the end of its page
+	Cursor string
+	// Limit is the maximum number of tokens in the page, or every token if not positive
+	Limit int
+	// Selector restricts the listing to tokens whose labels match it
+	Selector labels.Selector
+	// KeysOnly lists the keys of the tokens, without their tokens
+	KeysOnly bool
+}
+
+// ListTokens returns a page of the tokens in the store, grouped by parent ID, and the cursor of the next page. Only the
+// page is loaded from the store, not its whole content.
+func (m *Manager) ListTokens(ctx context.Context, opts ListOptions) (*model.All, error) {
+	log := m.log.Logger()
+
+	// keys are enough, unless the labels have to be matched
+	keysOnly := opts.KeysOnly && len(opts.Selector) == 0
+	all := &model.All{Tokens: []*model.Tokenize{}}
+	groups := map[string]*model.Tokenize{}
+	var listed int
+	cursor := opts.Cursor
+	for {
+		scanOpts := store.ScanOptions{Cursor: cursor, Prefix: opts.Prefix, KeysOnly: keysOnly}
+		if opts.Limit > 0 {
+			scanOpts.Count = opts.Limit - listed
+		}
+		page, err := m.store.Scan(ctx, scanOpts)
+		if err != nil {
+			log.Error().Msgf("error while scanning keys: %s\n", err.Error())
+			return nil, err
+		}
+
+		for _, k := range page.Keys {
+			if keysOnly {
+				all.Keys = append(all.Keys, k)
+				listed++
+				continue
+			}
+			rec, err := decodeRecord(page.Values[k])
+			if err != nil {
+				log.Debug().Msgf("skipping invalid record under %s: %s", k, err)
+				continue
+			}
+			if !opts.Selector.Matches(rec.Labels) {
+				continue
+			}
+			if opts.KeysOnly {
+				all.Keys = append(all.Keys, k)
+				listed++
+				continue
+			}
+			parent, child, err := splitStoredKey(k)
+			if err != nil {
+				log.Debug().Msgf("skipping invalid key %s: %s", k, err)
+				continue
+			}
+			if _, ok := groups[parent]; !ok {
+				groups[parent] = &model.Tokenize{ID: parent}
+				all.Tokens = append(all.Tokens, groups[parent])
+			}
+			groups[parent].Data = append(groups[parent].Data, model.Child{
+				Key:      child,
+				Value:    rec.Token,
+				Revision: rec.Rev,
+				Labels:   rec.Labels,
+			})
+			listed++
+		}
+
+		// keep scanning until the page is full, as label selectors may filter out whole scanned pages
+		cursor = page.Cursor
+		if cursor == "" || opts.Limit <= 0 || listed >= opts.Limit {
+			break
+		}
+	}
+	all.Next = cursor
+
+	log.Debug().Msgf("listed %d tokens", listed)
+	return all, nil
+}
+
+// CountTokens returns the number of tokens in the store. It scans the keys of the whole store, a page at a time.
+func (m *Manager) CountTokens(ctx context.Context) (int, error) {
+	var n int
+	opts := store.ScanOptions{Count: store.DefaultScanCount, KeysOnly: true}
+	for {
+		page, err := m.store.Scan(ctx, opts)
+		if err != nil {
+			return n, err
+		}
+		n += len(page.Keys)
+		if opts.Cursor = page.Cursor; opts.Cursor == "" {
+			return n, nil
+		}
+	}
+}
+
+// Put stores token under key as it is, without tokenizing anything, along with labels. It fails with
+// store.ErrBatchKeyExists if key already exists.
+func (m *Manager) Put(ctx context.Context, key, token string, labels map[string]string) error {
+	rec := newRecord(token)
+	rec.Labels = labels
+	if err := m.store.Batch(ctx, []store.Op{m.creation(ctx, key, rec)}); err != nil {
+		return err
+	}
+	m.publish(events.Created, key, rec.Rev)
+	return nil
+}
+
+// Take removes the token stored under key, and returns it along with its labels. Of concurrent takes of the same key,
+// only one succeeds; the others fail with store.ErrBatchConflict or store.ErrBatchKeyNotFound.
+func (m *Manager) Take(ctx context.Context, key string) (string, map[string]string, error) {
+	return m.take(ctx, key, events.Deleted)
+}
+
+// Expire removes the token stored under key as it expired. Like Take, only one of concurrent expiries succeeds.
+func (m *Manager) Expire(ctx context.Context, key string) error {
+	_, _, err := m.take(ctx, key, events.Expired)
+	return err
+}
+
+// take removes the token stored under key, and announces it as t
+func (m *Manager) take(ctx context.Context, key string, t events.Type) (string, map[string]string, error) {
+	rec, raw, err := m.record(ctx, key)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s", store.ErrBatchKeyNotFound, key)
+	}
+	if err = m.store.Batch(ctx, []store.Op{deletion(key, rec, raw)}); err != nil {
+		return "", nil, err
+	}
+	m.publish(t, key, rec.Rev)
+	return rec.Token, rec.Labels, nil
+}
+
+// GetToken returns the token stored under key, and its revision
+func (m *Manager) GetToken(ctx context.Context, key string) (string, int64, error) {
+	rec, _, err := m.record(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	return rec.Token, rec.Rev, nil
+}
+
+// record retrieves and decodes the record stored under key. The raw stored value is returned too, for writes to
+// compare against.
+func (m *Manager) record(ctx context.Context, key string) (*record, string, error) {
+	raw, err := m.store.Retrieve(ctx, key)
+	if err != nil {
+		return nil, "", fmt.Errorf(ErrKeyDoesNotExists, key)
+	}
+	rec, err := decodeRecord(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("error with key %s: %w", key, err)
+	}
+	return rec, raw, nil
+}
+
+// checkRevision ensures rec is at the expected revision, unless any revision is expected
+func checkRevision(key string, rec *record, expected int64) error {
+	if expected != AnyRevision && rec.Rev != expected {
+		return fmt.Errorf("%w: %s is at revision %d, not %d", ErrRevisionMismatch, key, rec.Rev, expected)
+	}
+	return nil
+}
+
+// creation returns the op storing rec under key. A key deleted before holds a tombstone, which rec replaces at the
+// revision following it.
+func (m *Manager) creation(ctx context.Context, key string, rec *record) store.Op {
+	if tomb, raw, ok := m.view.tombstone(ctx, key); ok {
+		rec.Rev = tomb.Rev + 1
+		return store.Op{Kind: store.OpPatch, ID: key, Token: rec.encode(), Expect: &raw}
+	}
+	return store.Op{Kind: store.OpStore, ID: key, Token: rec.encode()}
+}
+
+// deletion returns the op deleting key, read as raw holding rec. Its tombstone is left in its place, so it is created
+// again at a later revision, but for reserved keys, which are never stored twice under the same key.
+func deletion(key string, rec *record, raw string) store.Op {
+	if IsReserved(key) {
+		return store.Op{Kind: store.OpDelete, ID: key, Expect: &raw}
+	}
+	return store.Op{Kind: store.OpPatch, ID: key, Token: tombstone(rec.Rev).encode(), Expect: &raw}
+}
+
+// splitStoredKey splits a stored key into its parent ID, and the path of the child relative to it
+func splitStoredKey(key string) (string, string, error) {
+	segments, err := keys.SplitStored(key)
+	if err != nil {
+		return "", "", err
+	}
+	return keys.Join(segments[0]), keys.Join(segments[1:]...), nil
+}
+
+// childMap indexes children by key
+func childMap(children []model.Child) map[string]string {
+	cm := make(map[string]string, len(children))
+	for _, c := range children {
+		cm[c.Key] = c.Value
+	}
+	return cm
+}
+
+// DocumentChildren flattens a nested document into children, keyed by their path relative to the document root
+func DocumentChildren(doc map[string]any) ([]model.Child, error) {
+	flat, err := keys.Flatten(doc)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]model.Child, 0, len(flat))
+	for p, v := range flat {
+		children = append(children, model.Child{Key: p, Value: v})
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Key < children[j].Key
+	})
+	return children, nil
+}
+
+// NestChildren builds a document mirroring the hierarchy of the children, keyed by path relative to their ID
+func NestChildren(children []model.Child) (map[string]any, error) {
+	return keys.Nest(childMap(children))
+}
+
 // ValidateResponse holds the error response from validation and the associated key.
 type ValidateResponse struct {
 	Key string
@@ -181,12 +781,16 @@ func (m *Manager) ValidateKeys(ctx context.Context, token *model.Tokenize) ([]*V
 	parentKey := token.ID
 	for i := 0; i < len(token.Data); i++ {
 		childKey := token.Data[i].Key
-		combinedKeyName := GetCombinedKey(parentKey, childKey)
+		combinedKeyName, err := ChildKey(parentKey, childKey)
+		if err != nil {
+			verdict = false
+			valResp = append(valResp, &ValidateResponse{parentKey + KeyDelimiter + childKey, fmt.Errorf("error validating keys: %w", err)})
+			continue
+		}
 		// check that key doesn't already exist
-		var err error
 		if err = keysIsPresent(ctx, combinedKeyName, tempMap, m.store); err != nil {
 			verdict = false
-			valResp = append(valResp, &ValidateResponse{combinedKeyName, fmt.Errorf("error validating keys: %s\n", err.Error())})
+			valResp = append(valResp, &ValidateResponse{combinedKeyName, fmt.Errorf("error validating keys: %w", err)})
 		}
 	}
 	return valResp, verdict
@@ -199,54 +803,223 @@ func keysIsPresent(ctx context.Context, key string, tempStore map[string]bool, s
 	if _, err := store.Retrieve(ctx, key); err == nil {
 		return ErrKeyAlreadyExists
 	}
+	if err := nested(ctx, key, tempStore, store); err != nil {
+		return err
+	}
 
 	tempStore[key] = true
 	return nil
 }
 
+// nested returns ErrKeyUnderValue if an ancestor of key holds a value, or ErrKeyOverValue if a key nested under it
+// does, in store or among the pending keys: a value can't be nested under another value
+func nested(ctx context.Context, key string, pending map[string]bool, s store.Store) error {
+	for ancestor, ok := keys.Parent(key); ok; ancestor, ok = keys.Parent(ancestor) {
+		if _, err := s.Retrieve(ctx, ancestor); err == nil || pending[ancestor] {
+			return fmt.Errorf("%w: %s", ErrKeyUnderValue, ancestor)
+		}
+	}
+	prefix := key + keys.Delimiter
+	for k := range pending {
+		if strings.HasPrefix(k, prefix) {
+			return fmt.Errorf("%w: %s", ErrKeyOverValue, k)
+		}
+	}
+	// pages may come back empty, with the tombstones of deleted keys left out
+	opts := store.ScanOptions{Prefix: prefix, Count: store.DefaultScanCount, KeysOnly: true}
+	for {
+		page, err := s.Scan(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if len(page.Keys) > 0 {
+			return fmt.Errorf("%w: %s", ErrKeyOverValue, page.Keys[0])
+		}
+		if opts.Cursor = page.Cursor; opts.Cursor == "" {
+			return nil
+		}
+	}
+}
+
+// observe notifies the observer, if any, of the outcome of an operation
+func (m *Manager) observe(op string, n int, err error) {
+	if m.observer != nil {
+		m.observer(op, n, err)
+	}
+}
+
 // Tokenize manages the tokenization, and stores generated tokens in an internal store, for easy retrieval
-func (m *Manager) Tokenize(ctx context.Context, key, val string) (string, error) {
+func (m *Manager) Tokenize(ctx context.Context, key, val string) (token string, err error) {
+	defer func() { m.observe(OpTokenize, 1, err) }()
+	key, err = keys.Normalize(key)
+	if err != nil {
+		return "", err
+	}
+	if err = nested(ctx, key, nil, m.store); err != nil {
+		return "", err
+	}
 
 	// tokenize
-	token, err := tokenize(val, m.cipher)
+	m.mu.RLock()
+	t, err := tokenize(val, m.cipher)
+	m.mu.RUnlock()
 	if err != nil {
 		m.log.Logger().Error().Msgf("error occurred while generating token: %s\n", err.Error())
 		return "", err
 	}
 
 	// proceed to store generated token
-	err = m.store.Store(ctx, key, token.token)
+	rec := newRecord(t.token)
+	err = m.store.Batch(ctx, []store.Op{m.creation(ctx, key, rec)})
 	if err != nil {
 		m.log.Logger().Error().Msgf("error occurred while storing token: %s\n", err.Error())
 		return "", err
 	}
-	return token.token, nil
+	m.publish(events.Created, key, rec.Rev)
+	return t.token, nil
+}
+
+// Entry is a value to be tokenized under a store key
+type Entry struct {
+	Key   string
+	Value string
+	// Revision is the revision the key must be at for a patch to apply, or AnyRevision
+	Revision int64
+	// Labels are stored with the token. Patches keep the current labels if nil.
+	Labels map[string]string
+}
+
+// Receipt is the outcome of a write: the token stored under a key, and the revision it was stored at
+type Receipt struct {
+	Token    string
+	Revision int64
+}
+
+// TokenizeBatch tokenizes every entry, and commits them to the store atomically: either all of them are stored, or none
+// is. With patch, every key must already exist, at the entry's revision if one is given, and its token is replaced;
+// otherwise no key may exist yet, nor be nested under a value, stored or in the batch. Keys written concurrently fail
+// the batch with store.ErrBatchConflict. The receipts are returned in the order of the entries.
+func (m *Manager) TokenizeBatch(ctx context.Context, entries []Entry, patch bool) (receipts []Receipt, err error) {
+	defer func() { m.observe(OpTokenize, len(entries), err) }()
+	kind, change := store.OpStore, events.Created
+	if patch {
+		kind, change = store.OpPatch, events.Patched
+	}
+
+	ops := make([]store.Op, 0, len(entries))
+	receipts = make([]Receipt, 0, len(entries))
+	normalized := make([]string, len(entries))
+	stored := make(map[string]bool, len(entries))
+	for i, e := range entries {
+		key, err := keys.Normalize(e.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error with key %s: %w", e.Key, err)
+		}
+		normalized[i], stored[key] = key, true
+	}
+	// values can't be nested under values, stored or in the batch. The deepest keys are checked first, so keys of the
+	// batch nested under one another are reported as the deeper one being under a value.
+	if !patch {
+		deepest := append([]string(nil), normalized...)
+		sort.SliceStable(deepest, func(i, j int) bool {
+			return strings.Count(deepest[i], keys.Delimiter) > strings.Count(deepest[j], keys.Delimiter)
+		})
+		for _, key := range deepest {
+			if err := nested(ctx, key, stored, m.store); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, e := range entries {
+		key := normalized[i]
+		m.mu.RLock()
+		token, err := tokenize(e.Value, m.cipher)
+		m.mu.RUnlock()
+		if err != nil {
+			m.log.Logger().Error().Msgf("error occurred while generating token: %s\n", err.Error())
+			return nil, fmt.Errorf("error with key %s: %w", e.Key, err)
+		}
+
+		if err = labels.Validate(e.Labels); err != nil {
+			return nil, fmt.Errorf("error with key %s: %w", e.Key, err)
+		}
+
+		rec := newRecord(token.token)
+		rec.Labels = e.Labels
+		op := store.Op{Kind: kind, ID: key}
+		if !patch {
+			op = m.creation(ctx, key, rec)
+		} else {
+			current, raw, err := m.record(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", store.ErrBatchKeyNotFound, key)
+			}
+			if err = checkRevision(key, current, e.Revision); err != nil {
+				return nil, err
+			}
+			// the key must still hold what was read, or the patch would clobber a concurrent write
+			rec, op.Expect = current.next(token.token, e.Labels), &raw
+			op.Token = rec.encode()
+		}
+		ops = append(ops, op)
+		receipts = append(receipts, Receipt{Token: rec.Token, Revision: rec.Rev})
+	}
+
+	if err := m.store.Batch(ctx, ops); err != nil {
+		m.log.Logger().Error().Msgf("error occurred while committing batch of %d tokens: %s\n", len(ops), err.Error())
+		return nil, err
+	}
+	for i, op := range ops {
+		m.publish(change, op.ID, receipts[i].Revision)
+	}
+	return receipts, nil
 }
 
 // Detokenize retrieves the value represented by a particular token, identified by the particular key
 func (m *Manager) Detokenize(ctx context.Context, key, token string) (bool, string, error) {
+	found, plain, err := m.DetokenizeBuffer(ctx, key, token)
+	if err != nil {
+		return found, "", err
+	}
+	defer plain.Destroy()
+	return found, plain.Text(), nil
+}
+
+// DetokenizeBuffer retrieves the value represented by a particular token, identified by the particular key, in a
+// secure buffer the caller must destroy once done with it
+func (m *Manager) DetokenizeBuffer(ctx context.Context, key, token string) (found bool, plain *secure.Buffer, err error) {
+	found, plain, _, err = m.DetokenizeRevision(ctx, key, token)
+	return found, plain, err
+}
+
+// DetokenizeRevision is DetokenizeBuffer, also returning the revision of key the token was found at, for a later
+// conditional patch or delete
+func (m *Manager) DetokenizeRevision(ctx context.Context, key, token string) (found bool, plain *secure.Buffer, rev int64, err error) {
+	defer func() { m.observe(OpDetokenize, 1, err) }()
 
 	// ensure that token matches what is in store
-	storedToken, err := m.store.Retrieve(ctx, key)
+	rec, _, err := m.record(ctx, key)
 	if err != nil {
 		m.log.Logger().Error().Msgf("error while confirming token key: %s\n", err.Error())
-		return false, "", err
+		return false, nil, 0, err
 	}
 
 	// check if the stored token match the provided token. abort if no match
-	if storedToken != token {
+	if rec.Token != token {
 		m.log.Logger().Error().Msgf("provided token does not match stored token. provided token: %s\n", store.Redact(token))
-		return false, "", fmt.Errorf("provided token does not match stored token. provided token: %s\n", store.Redact(token))
+		return false, nil, 0, fmt.Errorf("provided token does not match stored token. provided token: %s\n", store.Redact(token))
 	}
 
 	// detokenize
-	decryptedStr, err := detokenize(token, m.cipher)
+	m.mu.RLock()
+	plain, err = detokenize(token, m.cipher)
+	m.mu.RUnlock()
 	if err != nil {
 		m.log.Logger().Error().Msgf("error occurred while decrypting token: %s\n", err.Error())
-		return false, "", err
+		return false, nil, 0, err
 	}
 
-	return true, decryptedStr, nil
+	return true, plain, rec.Rev, nil
 }
 
 // gotten from https://stackoverflow.com/questions/22892120/how-to-generate-a-random-string-of-a-fixed-length-in-go#:~:text=%22Mimicing%22%20strings.Builder%20with%20package%20unsafe
@@ -280,36 +1053,59 @@ func genAlphaNumericString(n int) string {
 
 // DeleteTokenByID deletes the token from the store identified by ID
 func (m *Manager) DeleteTokenByID(ctx context.Context, id string) (bool, error) {
+	return m.DeleteTokenIf(ctx, id, AnyRevision)
+}
+
+// DeleteTokenIf deletes the token from the store identified by ID, provided it is at the expected revision. With
+// AnyRevision, it is deleted regardless.
+func (m *Manager) DeleteTokenIf(ctx context.Context, id string, expected int64) (bool, error) {
 	log := m.log.Logger()
 
-	if b, err := m.store.Delete(ctx, id); err != nil || !b {
-		return false, fmt.Errorf(ErrKeyDoesNotExists, id)
+	// the record is only deleted as it was read, so the revision announced is the one removed
+	for {
+		rec, raw, err := m.record(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if err = checkRevision(id, rec, expected); err != nil {
+			return false, err
+		}
+		err = m.store.Batch(ctx, []store.Op{deletion(id, rec, raw)})
+		if errors.Is(err, store.ErrBatchConflict) {
+			if expected == AnyRevision {
+				// written concurrently: delete what was written instead
+				continue
+			}
+			return false, fmt.Errorf("%w: %s was modified concurrently", ErrRevisionMismatch, id)
+		}
+		if err != nil {
+			return false, err
+		}
+		m.publish(events.Deleted, id, rec.Rev)
+		log.Debug().Msgf("successfully deleted ID from store at revision %d", rec.Rev)
+		return true, nil
 	}
-
-	log.Debug().Msg("successfully deleted ID from store")
-
-	log.Debug().Msg("found token in store")
-	return true, nil
 }
 
 // PatchTokenByID updates a token in the store identified by ID
 func (m *Manager) PatchTokenByID(ctx context.Context, key, val string) (string, error) {
-	log := m.log.Logger()
-
-	// tokenize
-	token, err := tokenize(val, m.cipher)
+	receipt, err := m.PatchTokenIf(ctx, key, val, AnyRevision)
 	if err != nil {
-		m.log.Logger().Error().Msgf("error occurred while generating token: %s\n", err.Error())
 		return "", err
 	}
+	return receipt.Token, nil
+}
 
-	// patch token entry
-	if b, err := m.store.Patch(ctx, key, token.token); err != nil || !b {
-		return "", fmt.Errorf("error patching token: %s\n", err.Error())
+// PatchTokenIf updates a token in the store identified by ID, provided it is at the expected revision. With
+// AnyRevision, it is updated regardless of its revision, though a concurrent write still fails it.
+func (m *Manager) PatchTokenIf(ctx context.Context, key, val string, expected int64) (*Receipt, error) {
+	receipts, err := m.TokenizeBatch(ctx, []Entry{{Key: key, Value: val, Revision: expected}}, true)
+	if err != nil {
+		return nil, fmt.Errorf("error patching token: %w", err)
 	}
 
-	log.Debug().Msg("successfully patched ID from store")
-	return token.String(), nil
+	m.log.Logger().Debug().Msgf("successfully patched ID in store to revision %d", receipts[0].Revision)
+	return &receipts[0], nil
 }
 
 // IsErrKeyAlreadyExist enables easy checking of error
@@ -320,14 +1116,12 @@ func IsErrKeyAlreadyExist(err error) bool {
 	return false
 }
 
-// GetCombinedKey creates a key string unique to every value in the request object. This key string is a concatenation of all the parent keys that constitute the request data
+// GetCombinedKey creates a key string unique to every value in the request object. This key string is the path of all the parent keys that constitute the request data, each escaped so it may contain the delimiter.
 func GetCombinedKey(s ...string) (cs string) {
-	for i := 0; i < len(s); i++ {
-		delimiter := ""
-		if i > 0 {
-			delimiter = KeyDelimiter
-		}
-		cs += delimiter + s[i]
-	}
-	return
+	return keys.Join(s...)
+}
+
+// ChildKey returns the store key of a child, given the paths of its parent ID and of the child relative to it
+func ChildKey(id, key string) (string, error) {
+	return keys.Concat(id, key)
 }
@@ -1,6 +1,9 @@
 package tokenize
 
-import "github.com/dark-enstein/vault/internal/store"
+import (
+	"github.com/dark-enstein/vault/internal/events"
+	"github.com/dark-enstein/vault/internal/store"
+)
 
 type Options func(*Manager)
 
@@ -9,3 +12,24 @@ func WithStore(store store.Store) func(*Manager) {
 		manager.store = store
 	}
 }
+
+func WithCipherLoc(loc string) func(*Manager) {
+	return func(manager *Manager) {
+		manager.cipherLoc = loc
+	}
+}
+
+// WithObserver notifies o of the outcome of every tokenization and detokenization
+func WithObserver(o Observer) func(*Manager) {
+	return func(manager *Manager) {
+		manager.observer = o
+	}
+}
+
+// WithEvents publishes the changes of keys on bus, as those of the namespace name. Managers have a bus of their own
+// otherwise, as the root namespace.
+func WithEvents(bus *events.Bus, name string) func(*Manager) {
+	return func(manager *Manager) {
+		manager.events, manager.namespace = bus, name
+	}
+}
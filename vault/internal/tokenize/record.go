@@ -0,0 +1,77 @@
+package tokenize
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// AnyRevision disables the revision check of writes
+	AnyRevision int64 = 0
+	// FirstRevision is the revision of newly stored keys, and of keys stored before revisions were introduced
+	FirstRevision int64 = 1
+)
+
+// record is the value persisted in the store for every key: its token, and metadata about it
+type record struct {
+	Token  string            `json:"token"`
+	Rev    int64             `json:"rev"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Deleted marks the tombstone of a deleted key
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// newRecord creates the record of a newly stored token
+func newRecord(token string) *record {
+	return &record{Token: token, Rev: FirstRevision}
+}
+
+// tombstone returns the record left in place of a key deleted at revision rev. Reads don't see it, but a key created
+// again over it continues from rev, so a revision never names two different values of a key.
+func tombstone(rev int64) *record {
+	return &record{Rev: rev, Deleted: true}
+}
+
+// isTombstone returns the tombstone stored as raw, reporting whether raw is one
+func isTombstone(raw string) (*record, bool) {
+	// most values aren't, and are told apart without decoding them
+	if !strings.Contains(raw, `"deleted":true`) {
+		return nil, false
+	}
+	rec, err := decodeRecord(raw)
+	if err != nil || !rec.Deleted {
+		return nil, false
+	}
+	return rec, true
+}
+
+// decodeRecord decodes a stored value. Stores written before records were introduced hold bare tokens, which are
+// base64 and so never start with '{'.
+func decodeRecord(raw string) (*record, error) {
+	if !strings.HasPrefix(raw, "{") {
+		return &record{Token: raw, Rev: FirstRevision}, nil
+	}
+	var r record
+	if err := json.Unmarshal([]byte(raw), &r); err != nil {
+		return nil, fmt.Errorf("error decoding stored record: %w", err)
+	}
+	return &r, nil
+}
+
+// next returns the record replacing r when its token is patched. Labels are kept unless new ones are given.
+func (r *record) next(token string, labels map[string]string) *record {
+	n := *r
+	n.Token = token
+	n.Rev++
+	if labels != nil {
+		n.Labels = labels
+	}
+	return &n
+}
+
+// encode returns the value persisted in the store
+func (r *record) encode() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
@@ -0,0 +1,238 @@
+package tokenize
+
+import (
+	"context"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	// WrapPrefix is the path values wrapped by the wrap package are stored under
+	WrapPrefix = "_wrap"
+	// TransitPrefix is the path the keyring of the transit package is stored under
+	TransitPrefix = "_transit"
+)
+
+// ErrKeyReserved is returned when the keys the vault keeps for itself are addressed as ordinary tokens
+var ErrKeyReserved = errors.New("key is reserved for the vault itself")
+
+// reservedPrefixes are the paths the vault keeps entries of its own under. They are only seen, and only written, with a
+// System context: listings hide them, and every other access fails with ErrKeyReserved.
+var reservedPrefixes = []string{WrapPrefix, TransitPrefix}
+
+// IsReserved reports whether key is kept by the vault for itself
+func IsReserved(key string) bool {
+	for _, prefix := range reservedPrefixes {
+		if keys.IsUnder(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type systemKey struct{}
+
+// System returns a context the reserved keys are accessible with, for the packages of the vault that keep entries of
+// their own through a Manager. Requests never carry it.
+func System(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemKey{}, true)
+}
+
+// isSystem reports whether ctx was returned by System
+func isSystem(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	system, _ := ctx.Value(systemKey{}).(bool)
+	return system
+}
+
+// refused reports whether id can't be accessed with ctx
+func refused(ctx context.Context, id string) bool {
+	return IsReserved(id) && !isSystem(ctx)
+}
+
+// reservedStore is the view of a store every Manager works through. It hides the reserved keys, and refuses to touch
+// them, unless asked with a System context.
+//
+// It hides the tombstones of deleted keys too, whatever the context. Storing a key over its tombstone replaces it, at a
+// revision continuing from the one the key was deleted at, and patching or deleting it fails as it doesn't exist.
+// Tombstones stay in the store until it is flushed, and are the only trace left of deleted keys.
+type reservedStore struct {
+	s store.Store
+}
+
+// tombstone returns the tombstone stored under id, and its raw value, reporting whether id holds one
+func (r *reservedStore) tombstone(ctx context.Context, id string) (*record, string, bool) {
+	// the reserved keys are deleted for good
+	if IsReserved(id) {
+		return nil, "", false
+	}
+	raw, err := r.s.Retrieve(ctx, id)
+	if err != nil {
+		return nil, "", false
+	}
+	rec, ok := isTombstone(raw)
+	return rec, raw, ok
+}
+
+// overTombstone returns op as it applies to id if it holds a tombstone: stores patch it, continuing its revisions, and
+// the patches and deletes that don't expect it fail
+func (r *reservedStore) overTombstone(ctx context.Context, op store.Op) (store.Op, error) {
+	if op.Kind != store.OpStore && op.Expect != nil {
+		return op, nil
+	}
+	tomb, raw, ok := r.tombstone(ctx, op.ID)
+	if !ok {
+		return op, nil
+	}
+	if op.Kind != store.OpStore {
+		return op, fmt.Errorf("%w: %s", store.ErrBatchKeyNotFound, op.ID)
+	}
+	rec, err := decodeRecord(op.Token)
+	if err != nil {
+		return op, err
+	}
+	rec.Rev += tomb.Rev
+	return store.Op{Kind: store.OpPatch, ID: op.ID, Token: rec.encode(), Expect: &raw}, nil
+}
+
+func (r *reservedStore) Connect(ctx context.Context) (bool, error) {
+	return r.s.Connect(ctx)
+}
+
+func (r *reservedStore) Store(ctx context.Context, id string, token any) error {
+	if refused(ctx, id) {
+		return ErrKeyReserved
+	}
+	if s, ok := token.(string); ok {
+		if _, _, ok = r.tombstone(ctx, id); ok {
+			return r.Batch(ctx, []store.Op{{Kind: store.OpStore, ID: id, Token: s}})
+		}
+	}
+	return r.s.Store(ctx, id, token)
+}
+
+func (r *reservedStore) Retrieve(ctx context.Context, id string) (string, error) {
+	if refused(ctx, id) {
+		return "", ErrKeyReserved
+	}
+	raw, err := r.s.Retrieve(ctx, id)
+	if err != nil {
+		return raw, err
+	}
+	if _, ok := isTombstone(raw); ok {
+		return "", fmt.Errorf(ErrKeyDoesNotExists, id)
+	}
+	return raw, nil
+}
+
+func (r *reservedStore) RetrieveAll(ctx context.Context) (map[string]string, error) {
+	all, err := r.s.RetrieveAll(ctx)
+	if err != nil {
+		return all, err
+	}
+	system := isSystem(ctx)
+	for k, v := range all {
+		if _, ok := isTombstone(v); ok || (IsReserved(k) && !system) {
+			delete(all, k)
+		}
+	}
+	return all, nil
+}
+
+func (r *reservedStore) Scan(ctx context.Context, opts store.ScanOptions) (*store.Page, error) {
+	// tombstones are told by their values
+	inner := opts
+	inner.KeysOnly = false
+	page, err := r.s.Scan(ctx, inner)
+	if err != nil {
+		return page, err
+	}
+	system := isSystem(ctx)
+	visible := page.Keys[:0]
+	for _, k := range page.Keys {
+		if _, ok := isTombstone(page.Values[k]); ok || (IsReserved(k) && !system) {
+			delete(page.Values, k)
+			continue
+		}
+		visible = append(visible, k)
+	}
+	page.Keys = visible
+	if opts.KeysOnly {
+		page.Values = nil
+	}
+	return page, nil
+}
+
+func (r *reservedStore) Delete(ctx context.Context, id string) (bool, error) {
+	if refused(ctx, id) {
+		return false, ErrKeyReserved
+	}
+	if _, _, ok := r.tombstone(ctx, id); ok {
+		return false, nil
+	}
+	return r.s.Delete(ctx, id)
+}
+
+func (r *reservedStore) Patch(ctx context.Context, id string, token any) (bool, error) {
+	if refused(ctx, id) {
+		return false, ErrKeyReserved
+	}
+	if _, _, ok := r.tombstone(ctx, id); ok {
+		return false, fmt.Errorf(ErrKeyDoesNotExists, id)
+	}
+	return r.s.Patch(ctx, id, token)
+}
+
+func (r *reservedStore) Batch(ctx context.Context, ops []store.Op) error {
+	applied := make([]store.Op, len(ops))
+	for i, op := range ops {
+		if refused(ctx, op.ID) {
+			return ErrKeyReserved
+		}
+		var err error
+		if applied[i], err = r.overTombstone(ctx, op); err != nil {
+			return err
+		}
+	}
+	return r.s.Batch(ctx, applied)
+}
+
+// Flush deletes every key but the reserved ones, unless asked with a System context
+func (r *reservedStore) Flush(ctx context.Context) (bool, error) {
+	if isSystem(ctx) {
+		return r.s.Flush(ctx)
+	}
+	all, err := r.s.RetrieveAll(ctx)
+	if err != nil {
+		return false, err
+	}
+	var ops []store.Op
+	for k, v := range all {
+		if !IsReserved(k) {
+			v := v
+			ops = append(ops, store.Op{Kind: store.OpDelete, ID: k, Expect: &v})
+		}
+	}
+	if len(ops) == len(all) {
+		return r.s.Flush(ctx)
+	}
+	if len(ops) == 0 {
+		return true, nil
+	}
+	if err = r.s.Batch(ctx, ops); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *reservedStore) Close(ctx context.Context) error {
+	return r.s.Close(ctx)
+}
+
+func (r *reservedStore) Ping(ctx context.Context) (bool, error) {
+	return store.Ping(ctx, r.s)
+}
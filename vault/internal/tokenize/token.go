@@ -1,10 +1,10 @@
 package tokenize
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/base64"
+	"github.com/dark-enstein/vault/internal/secure"
 	"github.com/pkg/errors"
 )
 
@@ -24,9 +24,10 @@ func (t *Token) String() string {
 	return t.token
 }
 
-func tokenize(s string, cypher map[string]string) (*Token, error) {
+// tokenize encrypts s with the cipher. The padded plaintext is kept in a secure buffer, wiped once encrypted.
+func tokenize(s string, cypher map[string]*secure.Buffer) (*Token, error) {
 	// resolve aes cipher and initialization vector
-	var aesKey, iv string
+	var aesKey, iv *secure.Buffer
 	var ok bool
 	if aesKey, ok = cypher[EnvKeyAESCipher]; !ok {
 		return nil, ErrCipherToken404AES
@@ -37,95 +38,106 @@ func tokenize(s string, cypher map[string]string) (*Token, error) {
 	}
 
 	// get request string padded bytes. Padding is done following PKCS #7: https://en.wikipedia.org/wiki/PKCS_7
-	padded := getPaddedBlock(s)
+	padded, err := getPaddedBlock(s)
+	if err != nil {
+		return nil, err
+	}
+	defer padded.Destroy()
 
-	block, err := aes.NewCipher([]byte(aesKey))
+	block, err := aes.NewCipher(aesKey.Bytes())
 	if err != nil {
 		return nil, err
 	}
 
 	// generate cipher mode using cipher block
-	mode := cipher.NewCBCEncrypter(block, []byte(iv))
+	mode := cipher.NewCBCEncrypter(block, iv.Bytes())
 
 	// create a byte block to hold the encrypted bytes. It will be the length of the padded request string
-	encryptedBytes := make([]byte, len(padded))
-	mode.CryptBlocks(encryptedBytes, padded)
+	encryptedBytes := make([]byte, padded.Len())
+	mode.CryptBlocks(encryptedBytes, padded.Bytes())
 
 	// encode encryptedBytes using base64 encoding
 	token := base64.StdEncoding.EncodeToString(encryptedBytes)
 	return &Token{token: token}, nil
 }
 
-func detokenize(token string, cypher map[string]string) (string, error) {
+// detokenize decrypts token with the cipher, into a secure buffer the caller must destroy
+func detokenize(token string, cypher map[string]*secure.Buffer) (*secure.Buffer, error) {
 	// resolve aes cipher and initialization vector
-	var aesKey, iv string
+	var aesKey, iv *secure.Buffer
 	var ok bool
 	if aesKey, ok = cypher[EnvKeyAESCipher]; !ok {
-		return "", ErrCipherToken404AES
+		return nil, ErrCipherToken404AES
 	}
 
 	if iv, ok = cypher[EnvKeyInitializationVector]; !ok {
-		return "", ErrCipherToken404IV
+		return nil, ErrCipherToken404IV
 	}
 
 	// base64 decode token string
 	encryptedBytes, err := base64.StdEncoding.DecodeString(token)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// begin decryption process
-	block, err := aes.NewCipher([]byte(aesKey))
+	block, err := aes.NewCipher(aesKey.Bytes())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// check if the ciphertext length is a multiple of the block size
-	if len(encryptedBytes)%aes.BlockSize != 0 {
-		return "", ErrTokenInvalidBlockSize
+	if len(encryptedBytes)%aes.BlockSize != 0 || len(encryptedBytes) == 0 {
+		return nil, ErrTokenInvalidBlockSize
 	}
 
 	// decryption core
-	decrypted := make([]byte, len(encryptedBytes))
-	mode := cipher.NewCBCDecrypter(block, []byte(iv))
-	mode.CryptBlocks(decrypted, encryptedBytes)
+	decrypted, err := secure.New(len(encryptedBytes))
+	if err != nil {
+		return nil, err
+	}
+	mode := cipher.NewCBCDecrypter(block, iv.Bytes())
+	mode.CryptBlocks(decrypted.Bytes(), encryptedBytes)
+	plain := decrypted.Bytes()
 
 	// extract padding metadata
-	padding := int(decrypted[len(decrypted)-1])
+	padding := int(plain[len(plain)-1])
 
 	// test decrypted padding byte integrity
-	if padding > aes.BlockSize || decrypted[len(decrypted)-1] == 0 {
-		return "", ErrTokenInvalidPadding
+	if padding > aes.BlockSize || padding == 0 {
+		decrypted.Destroy()
+		return nil, ErrTokenInvalidPadding
 	}
 
 	// check that all the padded strings are the same
-	for _, v := range decrypted[len(decrypted)-padding:] {
+	for _, v := range plain[len(plain)-padding:] {
 		if padding != int(v) {
-			return "", ErrTokenInvalidPaddingNotHomogeneous
+			decrypted.Destroy()
+			return nil, ErrTokenInvalidPaddingNotHomogeneous
 		}
 	}
 
 	// return decrypted without padding
-	return string(decrypted[:len(decrypted)-padding]), nil
+	decrypted.Truncate(len(plain) - padding)
+	return decrypted, nil
 }
 
-// getPaddedBlock returns a properly padded bytes block such that it is works with AES encrypting requirements. The padding is done following PKCS #7: https://en.wikipedia.org/wiki/PKCS_7
-func getPaddedBlock(s string) []byte {
-	// get bytes representation and length of string. this is needed for block checking and AES encryption.
-	sBytes := []byte(s)
-	length := len(sBytes)
-
+// getPaddedBlock returns a properly padded bytes block such that it is works with AES encrypting requirements, in a secure buffer the caller must destroy. The padding is done following PKCS #7: https://en.wikipedia.org/wiki/PKCS_7
+func getPaddedBlock(s string) (*secure.Buffer, error) {
 	// calculate the mod 16 of the bytes length, to determing how much is required to make the block a multiple of 16. AES standard. https://en.wikipedia.org/wiki/Advanced_Encryption_Standard
+	length := len(s)
 	paddingRequired := 16 - length%16
 
+	// create a mod16 block, and first copy the source bytes into it
+	padded, err := secure.New(length + paddingRequired)
+	if err != nil {
+		return nil, err
+	}
+	copy(padded.Bytes(), s)
+
 	// following PKCS #7, the padding to be added (if needed) will be a repetition of the byte representation of the reminder
-	// create a mod16 block
-	sPaddedBlock := make([]byte, length)
-	// first copy the source bytes into the new mod16 block
-	copy(sPaddedBlock, sBytes)
-
-	padding := bytes.Repeat([]byte{byte(paddingRequired)}, paddingRequired)
-	// then add the padded bytes to the same mod16 block
-	sPaddedBlock = append(sPaddedBlock, padding...)
-	return sPaddedBlock
+	for i := length; i < padded.Len(); i++ {
+		padded.Bytes()[i] = byte(paddingRequired)
+	}
+	return padded, nil
 }
@@ -0,0 +1,223 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrPathSyntax = errors.New("invalid JSONPath")
+	ErrPathRoot   = errors.New("JSONPath must select fields below the root, e.g. $.customer.email")
+)
+
+// stepKind is the kind of a JSONPath step
+type stepKind int
+
+const (
+	// stepField selects a member of an object, e.g. .email or ['e-mail']
+	stepField stepKind = iota
+	// stepIndex selects an element of an array, e.g. [0] or [-1]
+	stepIndex
+	// stepWildcard selects every member or element, e.g. .* or [*]
+	stepWildcard
+)
+
+// step is a single selection of a JSONPath. A recursive step applies to the node and all of its descendants, e.g.
+// ..email.
+type step struct {
+	kind      stepKind
+	name      string
+	index     int
+	recursive bool
+}
+
+// Path is a parsed JSONPath. The supported subset is the root $, members .name and ['name'], indexes [n], wildcards .*
+// and [*], and recursive descent ..name and ..*; filters and slices are not.
+type Path struct {
+	raw   string
+	steps []step
+}
+
+func (p Path) String() string {
+	return p.raw
+}
+
+// ParsePath parses a JSONPath
+func ParsePath(raw string) (Path, error) {
+	p := Path{raw: raw}
+	s := strings.TrimSpace(raw)
+	if !strings.HasPrefix(s, "$") {
+		return p, fmt.Errorf("%w %q: must start with $", ErrPathSyntax, raw)
+	}
+	s = s[1:]
+
+	for len(s) > 0 {
+		var st step
+		switch {
+		case strings.HasPrefix(s, ".."):
+			st.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(s, "."):
+			s = strings.TrimPrefix(s, ".")
+			if strings.HasPrefix(s, "*") {
+				st.kind = stepWildcard
+				s = s[1:]
+				p.steps = append(p.steps, st)
+				continue
+			}
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return p, fmt.Errorf("%w %q: empty member name", ErrPathSyntax, raw)
+			}
+			if strings.ContainsAny(s[:end], " \t\r\n'\"]*$") {
+				return p, fmt.Errorf("%w %q: member %q must use bracket notation", ErrPathSyntax, raw, s[:end])
+			}
+			st.kind, st.name = stepField, s[:end]
+			s = s[end:]
+			p.steps = append(p.steps, st)
+			continue
+		case strings.HasPrefix(s, "["):
+		default:
+			return p, fmt.Errorf("%w %q: unexpected %q", ErrPathSyntax, raw, s)
+		}
+
+		// bracket notation
+		end := closingBracket(s)
+		if end < 0 {
+			return p, fmt.Errorf("%w %q: unterminated [", ErrPathSyntax, raw)
+		}
+		inner := strings.TrimSpace(s[1:end])
+		s = s[end+1:]
+		switch {
+		case inner == "*":
+			st.kind = stepWildcard
+		case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+			st.kind, st.name = stepField, strings.ReplaceAll(inner[1:len(inner)-1], `\`+inner[:1], inner[:1])
+		default:
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return p, fmt.Errorf("%w %q: unsupported selector [%s]", ErrPathSyntax, raw, inner)
+			}
+			st.kind, st.index = stepIndex, i
+		}
+		p.steps = append(p.steps, st)
+	}
+
+	if len(p.steps) == 0 {
+		return p, ErrPathRoot
+	}
+	return p, nil
+}
+
+// closingBracket returns the index of the ] closing the [ s starts with, skipping quoted names
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote == 0 && c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// match is a value selected by a path: the segments leading to it from the root, and how to replace it
+type match struct {
+	segments []string
+	value    any
+	set      func(v any)
+}
+
+// selectAll returns every value of doc selected by the path
+func (p Path) selectAll(doc any) []match {
+	var matches []match
+	walk(doc, nil, nil, p.steps, func(m match) {
+		matches = append(matches, m)
+	})
+	return matches
+}
+
+// walk applies steps to node, reached by segments and replaced with set, and calls fn with every node they select
+func walk(node any, segments []string, set func(any), steps []step, fn func(match)) {
+	if len(steps) == 0 {
+		fn(match{segments: segments, value: node, set: set})
+		return
+	}
+
+	st := steps[0]
+	if st.recursive {
+		// apply the step to the node itself, then to every descendant
+		flat := st
+		flat.recursive = false
+		walk(node, segments, set, append([]step{flat}, steps[1:]...), fn)
+		children(node, segments, func(child any, segs []string, set func(any)) {
+			walk(child, segs, set, steps, fn)
+		})
+		return
+	}
+
+	switch st.kind {
+	case stepField:
+		if obj, ok := node.(map[string]any); ok {
+			if child, ok := obj[st.name]; ok {
+				walk(child, appendSegment(segments, st.name), objectSetter(obj, st.name), steps[1:], fn)
+			}
+		}
+	case stepIndex:
+		if arr, ok := node.([]any); ok {
+			i := st.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if i >= 0 && i < len(arr) {
+				walk(arr[i], appendSegment(segments, strconv.Itoa(i)), arraySetter(arr, i), steps[1:], fn)
+			}
+		}
+	case stepWildcard:
+		children(node, segments, func(child any, segs []string, set func(any)) {
+			walk(child, segs, set, steps[1:], fn)
+		})
+	}
+}
+
+// children calls fn with every member of an object, in key order, or every element of an array
+func children(node any, segments []string, fn func(child any, segments []string, set func(any))) {
+	switch n := node.(type) {
+	case map[string]any:
+		for _, k := range sortedKeys(n) {
+			fn(n[k], appendSegment(segments, k), objectSetter(n, k))
+		}
+	case []any:
+		for i := range n {
+			fn(n[i], appendSegment(segments, strconv.Itoa(i)), arraySetter(n, i))
+		}
+	}
+}
+
+func objectSetter(obj map[string]any, k string) func(any) {
+	return func(v any) { obj[k] = v }
+}
+
+func arraySetter(arr []any, i int) func(any) {
+	return func(v any) { arr[i] = v }
+}
+
+// appendSegment appends seg to a copy of segments, so sibling matches don't share their backing array
+func appendSegment(segments []string, seg string) []string {
+	return append(append(make([]string, 0, len(segments)+1), segments...), seg)
+}
@@ -0,0 +1,211 @@
+// Package transform tokenizes and detokenizes the fields of arbitrary JSON documents selected by JSONPath, leaving the
+// rest of the document untouched.
+package transform
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrIDRequired      = errors.New("id is required")
+	ErrPathsRequired   = errors.New("at least one JSONPath is required")
+	ErrFieldNotToken   = errors.New("field does not hold a token")
+	ErrFieldUnresolved = errors.New("field could not be detokenized")
+	ErrFieldInvalid    = errors.New("field can't be transformed")
+)
+
+// field is a scalar value selected for transformation, and the key its token is stored under
+type field struct {
+	key   string
+	rel   string
+	value string
+	set   func(v any)
+}
+
+// ParsePaths parses every JSONPath
+func ParsePaths(raw []string) ([]Path, error) {
+	if len(raw) == 0 {
+		return nil, ErrPathsRequired
+	}
+	paths := make([]Path, 0, len(raw))
+	for _, r := range raw {
+		p, err := ParsePath(r)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// Decode decodes a JSON document, keeping numbers as they are written
+func Decode(r io.Reader) (any, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Tokenize replaces every value of doc selected by paths with its token, in place. Each token is stored under id and
+// the location of its value, e.g. order-17/customer/email. Selected objects and arrays are tokenized leaf by leaf, and
+// nulls are left alone. Numbers and booleans are tokenized as their JSON text, and so come back as strings. Every token
+// is committed at once, or none is. The keys of the tokenized fields are returned relative to id.
+func Tokenize(ctx context.Context, m *tokenize.Manager, id string, doc any, paths []Path) ([]string, error) {
+	fields, err := selectFields(doc, id, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]tokenize.Entry, 0, len(fields))
+	for _, f := range fields {
+		entries = append(entries, tokenize.Entry{Key: f.key, Value: f.value})
+	}
+	receipts, err := m.TokenizeBatch(ctx, entries, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rels := make([]string, 0, len(fields))
+	for i, f := range fields {
+		f.set(receipts[i].Token)
+		rels = append(rels, f.rel)
+	}
+	return rels, nil
+}
+
+// Detokenizer detokenizes a token stored under key. tokenize.Manager implements it.
+type Detokenizer interface {
+	Detokenize(ctx context.Context, key, token string) (bool, string, error)
+}
+
+// Detokenize reverses Tokenize: it replaces every token of doc selected by paths with its value, in place. It fails
+// without touching doc if any selected field can't be detokenized. The keys of the detokenized fields are returned
+// relative to id.
+func Detokenize(ctx context.Context, m Detokenizer, id string, doc any, paths []Path) ([]string, error) {
+	fields, err := selectFields(doc, id, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]string, 0, len(fields))
+	for _, f := range fields {
+		found, value, err := m.Detokenize(ctx, f.key, f.value)
+		if err != nil || !found {
+			return nil, fmt.Errorf("%w: %s", ErrFieldUnresolved, f.rel)
+		}
+		plain = append(plain, value)
+	}
+
+	rels := make([]string, 0, len(fields))
+	for i, f := range fields {
+		f.set(plain[i])
+		rels = append(rels, f.rel)
+	}
+	return rels, nil
+}
+
+// selectFields returns the scalar fields of doc selected by any of paths, once each, in the order of their keys
+func selectFields(doc any, id string, paths []Path) ([]field, error) {
+	if len(id) == 0 {
+		return nil, ErrIDRequired
+	}
+	if len(paths) == 0 {
+		return nil, ErrPathsRequired
+	}
+
+	selected := map[string]field{}
+	var add func(m match) error
+	add = func(m match) error {
+		switch v := m.value.(type) {
+		case nil:
+			return nil
+		case map[string]any, []any:
+			var err error
+			children(v, m.segments, func(child any, segs []string, set func(any)) {
+				if err == nil {
+					err = add(match{segments: segs, value: child, set: set})
+				}
+			})
+			return err
+		}
+
+		rel := keys.Join(m.segments...)
+		key, err := tokenize.ChildKey(id, rel)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrFieldInvalid, rel, err)
+		}
+		value, err := scalar(m.value)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrFieldInvalid, rel, err)
+		}
+		selected[key] = field{key: key, rel: rel, value: value, set: m.set}
+		return nil
+	}
+
+	for _, p := range paths {
+		for _, m := range p.selectAll(doc) {
+			if err := add(m); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fields := make([]field, 0, len(selected))
+	for _, f := range selected {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].key < fields[j].key
+	})
+	return fields, nil
+}
+
+// scalar returns the text of a scalar JSON value
+func scalar(v any) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case json.Number:
+		return s.String(), nil
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+// Encode writes doc as indented JSON
+func Encode(w io.Writer, doc any) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// sortedKeys returns the keys of obj in order
+func sortedKeys(obj map[string]any) []string {
+	ks := make([]string, 0, len(obj))
+	for k := range obj {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
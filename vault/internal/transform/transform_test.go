@@ -0,0 +1,110 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type TransformTestSuite struct {
+	suite.Suite
+	m *tokenize.Manager
+}
+
+var (
+	varTableRecord = `{
+  "customer": {"name": "Ada", "email": "ada@example.com", "a/b": "slash"},
+  "items": [{"sku": "A1", "card": 4111111111111111}, {"sku": "B2", "card": 5500000000000004}],
+  "notes": null
+}`
+
+	varTableSelect = []struct {
+		path   string
+		fields []string
+	}{
+		{"$.customer.email", []string{"customer/email"}},
+		{"$['customer']['a/b']", []string{"customer/a%2Fb"}},
+		{"$.items[*].card", []string{"items/0/card", "items/1/card"}},
+		{"$.items[-1].sku", []string{"items/1/sku"}},
+		{"$..sku", []string{"items/0/sku", "items/1/sku"}},
+		{"$.customer", []string{"customer/a%2Fb", "customer/email", "customer/name"}},
+		{"$.notes", []string{}},
+		{"$.missing", []string{}},
+	}
+)
+
+func (suite *TransformTestSuite) SetupTest() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	suite.m = tokenize.NewManager(ctx, log, tokenize.WithStore(store.NewSyncMap(ctx, log)), tokenize.WithCipherLoc(cipherLoc))
+}
+
+func (suite *TransformTestSuite) TestSelect() {
+	for _, tt := range varTableSelect {
+		doc, err := Decode(strings.NewReader(varTableRecord))
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		p, err := ParsePath(tt.path)
+		suite.Require().NoErrorf(err, "expected no errors parsing %s, but got this %v\n", tt.path, err)
+		fields, err := selectFields(doc, "order", []Path{p})
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+		rels := []string{}
+		for _, f := range fields {
+			rels = append(rels, f.rel)
+		}
+		suite.Require().Equalf(tt.fields, rels, "unexpected fields selected by %s", tt.path)
+	}
+
+	for _, bad := range []string{"customer", "$", "$.", "$[x]", "$['a'", "$.a b"} {
+		_, err := ParsePath(bad)
+		suite.Require().Errorf(err, "expected %q to be refused", bad)
+	}
+}
+
+func (suite *TransformTestSuite) TestRoundTrip() {
+	ctx := context.Background()
+	doc, err := Decode(strings.NewReader(varTableRecord))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	paths, err := ParsePaths([]string{"$.customer.email", "$.items[*].card", "$..card"})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	fields, err := Tokenize(ctx, suite.m, "order-17", doc, paths)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal([]string{"customer/email", "items/0/card", "items/1/card"}, fields)
+
+	var out bytes.Buffer
+	suite.Require().NoError(Encode(&out, doc))
+	suite.Require().NotContains(out.String(), "ada@example.com")
+	suite.Require().NotContains(out.String(), "4111111111111111")
+	suite.Require().Contains(out.String(), `"sku": "A1"`)
+
+	// tokenizing the same record again would override its tokens
+	again, _ := Decode(strings.NewReader(varTableRecord))
+	_, err = Tokenize(ctx, suite.m, "order-17", again, paths)
+	suite.Require().ErrorIs(err, store.ErrBatchKeyExists)
+
+	doc, err = Decode(bytes.NewReader(out.Bytes()))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	_, err = Detokenize(ctx, suite.m, "order-17", doc, paths)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	customer := doc.(map[string]any)["customer"].(map[string]any)
+	suite.Require().Equal("ada@example.com", customer["email"])
+	suite.Require().Equal("4111111111111111", doc.(map[string]any)["items"].([]any)[0].(map[string]any)["card"])
+
+	// a field that isn't a token of the record fails the whole document
+	_, err = Detokenize(ctx, suite.m, "order-17", doc, paths)
+	suite.Require().ErrorIs(err, ErrFieldUnresolved)
+	suite.Require().Equal("ada@example.com", customer["email"])
+}
+
+// TestTransformSuite tests the Transform suite
+func TestTransformSuite(t *testing.T) {
+	suite.Run(t, new(TransformTestSuite))
+}
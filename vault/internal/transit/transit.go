@@ -0,0 +1,390 @@
+// Package transit encrypts, decrypts, signs and verifies data for callers, with the named keys of the keyring of the
+// vault, without storing the data. Services encrypt large payloads they keep themselves, and never hold the keys.
+//
+// Every key has versions. Rotating a key adds a version, which encrypts and signs from then on; the previous ones still
+// decrypt and verify what they produced, until it is rewrapped under the latest. Ciphertexts and signatures carry the
+// version that made them, as vault:v<version>:<base64>.
+//
+// Keys are kept in the store under Prefix, tokenized like any other value, so they are encrypted under the data key of
+// the manager at rest, and decrypted into secure buffers when used. The prefix is reserved: token requests can neither
+// read nor change the keyring, only the operations of this package use it. Data is encrypted with AES-256-GCM under a random
+// nonce; aes256-gcm keys sign with HMAC-SHA256, and ed25519 keys with Ed25519.
+package transit
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/secure"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyType is the algorithm of a key
+type KeyType string
+
+const (
+	// AES256GCM keys encrypt, decrypt and rewrap with AES-256-GCM, and sign with HMAC-SHA256
+	AES256GCM KeyType = "aes256-gcm"
+	// Ed25519 keys sign with Ed25519. Their public keys are published.
+	Ed25519 KeyType = "ed25519"
+)
+
+const (
+	// Prefix is the path the keys of the keyring are stored under
+	Prefix = tokenize.TransitPrefix
+	// versionPrefix starts ciphertexts and signatures, followed by the version of the key that made them
+	versionPrefix = "vault:v"
+)
+
+var (
+	ErrKeyNameInvalid    = errors.New("invalid transit key name. expected 1 to 128 letters, digits, '-', '_' or '.', starting with a letter or digit")
+	ErrKeyTypeInvalid    = errors.New("invalid transit key type. expected aes256-gcm or ed25519")
+	ErrKeyNotFound       = errors.New("transit key not found")
+	ErrKeyExists         = errors.New("transit key already exists")
+	ErrUnsupported       = errors.New("operation not supported by the type of the transit key")
+	ErrCiphertextInvalid = errors.New("invalid ciphertext. expected vault:v<version>:<base64> made by the key")
+	ErrSignatureInvalid  = errors.New("invalid signature. expected vault:v<version>:<base64> made by the key")
+)
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,127}$`)
+
+// version is a version of a key, and its material: an AES-256 key, or an Ed25519 seed
+type version struct {
+	Version  int       `json:"version"`
+	Material []byte    `json:"material"`
+	Created  time.Time `json:"created"`
+}
+
+// key is a key of the keyring, as stored
+type key struct {
+	Type     KeyType   `json:"type"`
+	Versions []version `json:"versions"`
+	// rev is the revision of the stored key, so concurrent rotations don't lose a version
+	rev int64
+}
+
+// Info describes a key, without its material
+type Info struct {
+	Name          string
+	Type          KeyType
+	LatestVersion int
+	Versions      []VersionInfo
+}
+
+// VersionInfo describes a version of a key. PublicKey is the PEM encoded public key of Ed25519 keys.
+type VersionInfo struct {
+	Version   int
+	Created   time.Time
+	PublicKey string
+}
+
+// Create creates the key name of type t, or AES256GCM if empty, at version 1
+func Create(ctx context.Context, m *tokenize.Manager, name string, t KeyType) (*Info, error) {
+	if !nameRe.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNameInvalid, name)
+	}
+	if t == "" {
+		t = AES256GCM
+	}
+	if t != AES256GCM && t != Ed25519 {
+		return nil, fmt.Errorf("%w: %q", ErrKeyTypeInvalid, t)
+	}
+	k := &key{Type: t}
+	defer k.destroy()
+	if err := k.rotate(); err != nil {
+		return nil, err
+	}
+	if err := save(ctx, m, name, k, false); err != nil {
+		if errors.Is(err, store.ErrBatchKeyExists) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyExists, name)
+		}
+		return nil, err
+	}
+	return k.info(name), nil
+}
+
+// Rotate adds a version to the key name, which encrypts and signs from then on
+func Rotate(ctx context.Context, m *tokenize.Manager, name string) (*Info, error) {
+	k, err := load(ctx, m, name)
+	if err != nil {
+		return nil, err
+	}
+	defer k.destroy()
+	if err = k.rotate(); err != nil {
+		return nil, err
+	}
+	if err = save(ctx, m, name, k, true); err != nil {
+		return nil, err
+	}
+	return k.info(name), nil
+}
+
+// Describe describes the key name
+func Describe(ctx context.Context, m *tokenize.Manager, name string) (*Info, error) {
+	k, err := load(ctx, m, name)
+	if err != nil {
+		return nil, err
+	}
+	defer k.destroy()
+	return k.info(name), nil
+}
+
+// Encrypt encrypts plaintext with the latest version of the key name. It returns the ciphertext, and the version.
+func Encrypt(ctx context.Context, m *tokenize.Manager, name string, plaintext []byte) (string, int, error) {
+	k, err := load(ctx, m, name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer k.destroy()
+	return k.encrypt(plaintext)
+}
+
+// Decrypt decrypts ciphertext with the version of the key name that encrypted it, into a secure buffer the caller must
+// destroy
+func Decrypt(ctx context.Context, m *tokenize.Manager, name, ciphertext string) (*secure.Buffer, error) {
+	k, err := load(ctx, m, name)
+	if err != nil {
+		return nil, err
+	}
+	defer k.destroy()
+	return k.decrypt(ciphertext)
+}
+
+// Rewrap decrypts ciphertext, and encrypts it again with the latest version of the key name, without the plaintext
+// leaving the vault. It returns the new ciphertext, and the version.
+func Rewrap(ctx context.Context, m *tokenize.Manager, name, ciphertext string) (string, int, error) {
+	k, err := load(ctx, m, name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer k.destroy()
+	plain, err := k.decrypt(ciphertext)
+	if err != nil {
+		return "", 0, err
+	}
+	defer plain.Destroy()
+	return k.encrypt(plain.Bytes())
+}
+
+// Sign signs input with the latest version of the key name. It returns the signature, and the version.
+func Sign(ctx context.Context, m *tokenize.Manager, name string, input []byte) (string, int, error) {
+	k, err := load(ctx, m, name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer k.destroy()
+	v := k.latest()
+	var sig []byte
+	switch k.Type {
+	case AES256GCM:
+		sig = mac(v.Material, input)
+	case Ed25519:
+		sig = ed25519.Sign(ed25519.NewKeyFromSeed(v.Material), input)
+	}
+	return encode(v.Version, sig), v.Version, nil
+}
+
+// Verify reports whether signature is a signature of input by a version of the key name
+func Verify(ctx context.Context, m *tokenize.Manager, name string, input []byte, signature string) (bool, error) {
+	k, err := load(ctx, m, name)
+	if err != nil {
+		return false, err
+	}
+	defer k.destroy()
+	v, sig, err := k.decode(signature)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	switch k.Type {
+	case AES256GCM:
+		return hmac.Equal(mac(v.Material, input), sig), nil
+	default:
+		return ed25519.Verify(ed25519.NewKeyFromSeed(v.Material).Public().(ed25519.PublicKey), input, sig), nil
+	}
+}
+
+// storeKey is the key the transit key name is stored at
+func storeKey(name string) string {
+	return keys.Join(Prefix, name)
+}
+
+// load retrieves and decrypts the key name. The caller must destroy it.
+func load(ctx context.Context, m *tokenize.Manager, name string) (*key, error) {
+	if !nameRe.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNameInvalid, name)
+	}
+	ctx = tokenize.System(ctx)
+	token, rev, err := m.GetToken(ctx, storeKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	_, plain, err := m.DetokenizeBuffer(ctx, storeKey(name), token)
+	if err != nil {
+		return nil, err
+	}
+	defer plain.Destroy()
+
+	k := &key{rev: rev}
+	if err = json.Unmarshal(plain.Bytes(), k); err != nil {
+		k.destroy()
+		return nil, fmt.Errorf("error decoding transit key %s: %w", name, err)
+	}
+	return k, nil
+}
+
+// save tokenizes k, and stores it as the key name. With patch, the stored key must still be at the revision k was
+// loaded at.
+func save(ctx context.Context, m *tokenize.Manager, name string, k *key, patch bool) error {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	defer secure.Wipe(b)
+	revision := tokenize.AnyRevision
+	if patch {
+		revision = k.rev
+	}
+	_, err = m.TokenizeBatch(tokenize.System(ctx), []tokenize.Entry{{Key: storeKey(name), Value: string(b), Revision: revision}}, patch)
+	return err
+}
+
+// rotate adds a version to k, of new material
+func (k *key) rotate() error {
+	material := make([]byte, 32)
+	if _, err := rand.Read(material); err != nil {
+		return err
+	}
+	k.Versions = append(k.Versions, version{Version: len(k.Versions) + 1, Material: material, Created: time.Now().UTC().Truncate(time.Second)})
+	return nil
+}
+
+// latest returns the latest version of k
+func (k *key) latest() version {
+	return k.Versions[len(k.Versions)-1]
+}
+
+// destroy wipes the material of every version of k
+func (k *key) destroy() {
+	for _, v := range k.Versions {
+		secure.Wipe(v.Material)
+	}
+}
+
+// info describes k as the key name
+func (k *key) info(name string) *Info {
+	info := &Info{Name: name, Type: k.Type, LatestVersion: k.latest().Version}
+	for _, v := range k.Versions {
+		vi := VersionInfo{Version: v.Version, Created: v.Created}
+		if k.Type == Ed25519 {
+			der, err := x509.MarshalPKIXPublicKey(ed25519.NewKeyFromSeed(v.Material).Public())
+			if err == nil {
+				vi.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+			}
+		}
+		info.Versions = append(info.Versions, vi)
+	}
+	return info
+}
+
+// aead returns the cipher of the version v
+func aead(v version) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(v.Material)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals plaintext with the latest version of k, under a random nonce
+func (k *key) encrypt(plaintext []byte) (string, int, error) {
+	if k.Type != AES256GCM {
+		return "", 0, fmt.Errorf("%w: %s keys don't encrypt", ErrUnsupported, k.Type)
+	}
+	v := k.latest()
+	gcm, err := aead(v)
+	if err != nil {
+		return "", 0, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", 0, err
+	}
+	return encode(v.Version, gcm.Seal(nonce, nonce, plaintext, nil)), v.Version, nil
+}
+
+// decrypt opens ciphertext with the version of k that sealed it, into a secure buffer
+func (k *key) decrypt(ciphertext string) (*secure.Buffer, error) {
+	if k.Type != AES256GCM {
+		return nil, fmt.Errorf("%w: %s keys don't decrypt", ErrUnsupported, k.Type)
+	}
+	v, sealed, err := k.decode(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCiphertextInvalid, err)
+	}
+	gcm, err := aead(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("%w: too short", ErrCiphertextInvalid)
+	}
+	plain, err := secure.New(len(sealed) - gcm.NonceSize() - gcm.Overhead())
+	if err != nil {
+		return nil, err
+	}
+	// the plaintext is opened in place, into the buffer
+	if _, err = gcm.Open(plain.Bytes()[:0], sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil); err != nil {
+		plain.Destroy()
+		return nil, fmt.Errorf("%w: %w", ErrCiphertextInvalid, err)
+	}
+	return plain, nil
+}
+
+// encode formats what version made as a ciphertext or signature
+func encode(version int, b []byte) string {
+	return versionPrefix + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(b)
+}
+
+// decode parses a ciphertext or signature into the version of k that made it, and what it made
+func (k *key) decode(s string) (version, []byte, error) {
+	rest, ok := strings.CutPrefix(s, versionPrefix)
+	n, encoded, found := strings.Cut(rest, ":")
+	if !ok || !found {
+		return version{}, nil, errors.New("missing version")
+	}
+	i, err := strconv.Atoi(n)
+	if err != nil || i < 1 || i > len(k.Versions) {
+		return version{}, nil, fmt.Errorf("unknown version %s", n)
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return version{}, nil, err
+	}
+	return k.Versions[i-1], b, nil
+}
+
+// mac returns the HMAC-SHA256 of input, under a key derived from the material of a version, so the material isn't
+// used both to encrypt and to sign
+func mac(material, input []byte) []byte {
+	derived := hmac.New(sha256.New, material)
+	derived.Write([]byte("vault-transit-hmac"))
+	h := hmac.New(sha256.New, derived.Sum(nil))
+	h.Write(input)
+	return h.Sum(nil)
+}
@@ -0,0 +1,128 @@
+package transit
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type TransitTestSuite struct {
+	suite.Suite
+	ctx context.Context
+	m   *tokenize.Manager
+}
+
+func (suite *TransitTestSuite) SetupTest() {
+	suite.ctx = context.Background()
+	log := vlog.New(true)
+	suite.m = tokenize.NewManager(suite.ctx, log, tokenize.WithStore(store.NewSyncMap(suite.ctx, log)), tokenize.WithCipherLoc(filepath.Join(suite.T().TempDir(), ".cipher")))
+}
+
+func (suite *TransitTestSuite) TestEncrypt() {
+	info, err := Create(suite.ctx, suite.m, "payments", "")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(AES256GCM, info.Type)
+	suite.Require().Equal(1, info.LatestVersion)
+	_, err = Create(suite.ctx, suite.m, "payments", AES256GCM)
+	suite.Require().ErrorIs(err, ErrKeyExists)
+
+	ciphertext, version, err := Encrypt(suite.ctx, suite.m, "payments", []byte("card 4242"))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(1, version)
+	suite.Require().True(strings.HasPrefix(ciphertext, "vault:v1:"))
+	// nonces are random, so the same plaintext never gives the same ciphertext
+	again, _, err := Encrypt(suite.ctx, suite.m, "payments", []byte("card 4242"))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().NotEqual(ciphertext, again)
+
+	plain, err := Decrypt(suite.ctx, suite.m, "payments", ciphertext)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("card 4242", plain.Text())
+	plain.Destroy()
+
+	// the key is stored encrypted, not in the clear, and out of reach of ordinary tokens
+	_, _, err = suite.m.GetToken(suite.ctx, storeKey("payments"))
+	suite.Require().Error(err, "expected the keyring to be reserved")
+	token, _, err := suite.m.GetToken(tokenize.System(suite.ctx), storeKey("payments"))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().NotContains(token, "versions")
+
+	for _, tampered := range []string{ciphertext[:len(ciphertext)-4] + "AAA=", "vault:v2" + ciphertext[8:], "v1:" + ciphertext[9:], "vault:v1:!"} {
+		_, err = Decrypt(suite.ctx, suite.m, "payments", tampered)
+		suite.Require().ErrorIsf(err, ErrCiphertextInvalid, "expected %q to be refused", tampered)
+	}
+	_, _, err = Encrypt(suite.ctx, suite.m, "missing", []byte("x"))
+	suite.Require().ErrorIs(err, ErrKeyNotFound)
+	_, err = Create(suite.ctx, suite.m, "../payments", "")
+	suite.Require().ErrorIs(err, ErrKeyNameInvalid)
+	_, err = Create(suite.ctx, suite.m, "legacy", "des")
+	suite.Require().ErrorIs(err, ErrKeyTypeInvalid)
+}
+
+func (suite *TransitTestSuite) TestRotate() {
+	_, err := Create(suite.ctx, suite.m, "payments", AES256GCM)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	old, _, err := Encrypt(suite.ctx, suite.m, "payments", []byte("card 4242"))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	info, err := Rotate(suite.ctx, suite.m, "payments")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(2, info.LatestVersion)
+	suite.Require().Len(info.Versions, 2)
+
+	// old versions still decrypt, and rewrap moves ciphertexts to the latest
+	plain, err := Decrypt(suite.ctx, suite.m, "payments", old)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("card 4242", plain.Text())
+	plain.Destroy()
+	rewrapped, version, err := Rewrap(suite.ctx, suite.m, "payments", old)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(2, version)
+	suite.Require().True(strings.HasPrefix(rewrapped, "vault:v2:"))
+	plain, err = Decrypt(suite.ctx, suite.m, "payments", rewrapped)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal("card 4242", plain.Text())
+	plain.Destroy()
+
+	described, err := Describe(suite.ctx, suite.m, "payments")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(info, described)
+}
+
+func (suite *TransitTestSuite) TestSign() {
+	for _, t := range []KeyType{AES256GCM, Ed25519} {
+		name := "release-" + string(t)
+		info, err := Create(suite.ctx, suite.m, name, t)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		if t == Ed25519 {
+			suite.Require().Contains(info.Versions[0].PublicKey, "PUBLIC KEY")
+			_, _, err = Encrypt(suite.ctx, suite.m, name, []byte("x"))
+			suite.Require().ErrorIs(err, ErrUnsupported)
+		}
+
+		signature, version, err := Sign(suite.ctx, suite.m, name, []byte("v1.2.3"))
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Equal(1, version)
+		_, err = Rotate(suite.ctx, suite.m, name)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+		valid, err := Verify(suite.ctx, suite.m, name, []byte("v1.2.3"), signature)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Truef(valid, "expected the signature of %s to verify", t)
+		valid, err = Verify(suite.ctx, suite.m, name, []byte("v1.2.4"), signature)
+		suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+		suite.Require().Falsef(valid, "expected the signature of %s not to verify other input", t)
+		_, err = Verify(suite.ctx, suite.m, name, []byte("v1.2.3"), "vault:v3:"+signature[9:])
+		suite.Require().ErrorIs(err, ErrSignatureInvalid)
+	}
+}
+
+// TestTransitSuite tests the Transit suite
+func TestTransitSuite(t *testing.T) {
+	suite.Run(t, new(TransitTestSuite))
+}
@@ -16,7 +16,7 @@ type Logger struct {
 func New(debug bool) *Logger {
 	level := resolveLogger(debug)
 	zerolog.SetGlobalLevel(level)
-	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
+	output := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
 	output.FormatLevel = func(i interface{}) string {
 		return strings.ToUpper(fmt.Sprintf("| %-6s|", i))
 	}
@@ -46,6 +46,6 @@ func (l *Logger) Logger() *zerolog.Logger {
 
 // Printf implements the redis.internal.Logger interface so it can be used
 func (l *Logger) Printf(ctx context.Context, format string, v ...interface{}) {
-	l.Logger().Printf(format, v)
+	l.Logger().Printf(format, v...)
 	return
 }
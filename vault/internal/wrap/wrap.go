@@ -0,0 +1,180 @@
+// Package wrap hands secrets off once: a value is sealed under a random wrapping token, which can be unwrapped a single
+// time, before it expires.
+//
+// Wrapped values are kept in the store under Prefix, keyed by a hash of their wrapping token, and sealed with a key
+// derived from it. The store, and whoever can list it, never holds what it takes to unseal them. The prefix is
+// reserved, so token requests can neither list nor delete wrapped values, and backups leave them out.
+package wrap
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// Prefix is the path wrapped values are stored under
+	Prefix = tokenize.WrapPrefix
+	// DefaultTTL is how long a wrapping token is valid, if no TTL is given
+	DefaultTTL = 5 * time.Minute
+	// MaxTTL caps how long a wrapping token is valid
+	MaxTTL = 24 * time.Hour
+	// LabelExpires holds the unix time a wrapped value expires at, so expired ones can be swept without their token
+	LabelExpires = "wrap.expires"
+)
+
+var (
+	ErrTTLRange = errors.New("wrap ttl must be between 1s and 24h")
+	// ErrNotFound doesn't tell invalid, expired and used tokens apart, so probing tokens reveals nothing
+	ErrNotFound = errors.New("wrapping token is invalid, expired or already unwrapped")
+)
+
+// Wrapped is a value handed off, and where it was read from
+type Wrapped struct {
+	ID      string    `json:"id"`
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// Wrap seals w under a new wrapping token valid for ttl, or DefaultTTL if 0, and stores it. It returns the wrapping
+// token, and w with its expiry set. Expired values left behind are swept along the way.
+func Wrap(ctx context.Context, m *tokenize.Manager, w Wrapped, ttl time.Duration) (string, *Wrapped, error) {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < time.Second || ttl > MaxTTL {
+		return "", nil, ErrTTLRange
+	}
+	ctx = tokenize.System(ctx)
+	Sweep(ctx, m)
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	w.Expires = time.Now().Add(ttl).UTC().Truncate(time.Second)
+	plain, err := json.Marshal(w)
+	if err != nil {
+		return "", nil, err
+	}
+	sealed, err := seal(token, plain)
+	if err != nil {
+		return "", nil, err
+	}
+
+	labels := map[string]string{LabelExpires: strconv.FormatInt(w.Expires.Unix(), 10)}
+	if err = m.Put(ctx, storeKey(token), sealed, labels); err != nil {
+		return "", nil, err
+	}
+	return token, &w, nil
+}
+
+// Unwrap returns the value wrapped under token, and deletes it, so it can only be unwrapped once
+func Unwrap(ctx context.Context, m *tokenize.Manager, token string) (*Wrapped, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	ctx = tokenize.System(ctx)
+	// whoever takes the value first is the one to unwrap it
+	sealed, _, err := m.Take(ctx, storeKey(token))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	plain, err := open(token, sealed)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var w Wrapped
+	if err = json.Unmarshal(plain, &w); err != nil {
+		return nil, err
+	}
+	if time.Now().After(w.Expires) {
+		return nil, ErrNotFound
+	}
+	return &w, nil
+}
+
+// Sweep deletes the wrapped values that expired, and returns how many it deleted. Values it fails to delete are left
+// for the next sweep.
+func Sweep(ctx context.Context, m *tokenize.Manager) int {
+	ctx = tokenize.System(ctx)
+	var n int
+	opts := tokenize.ListOptions{Prefix: Prefix + keys.Delimiter}
+	all, err := m.ListTokens(ctx, opts)
+	if err != nil {
+		return 0
+	}
+	now := time.Now().Unix()
+	for _, t := range all.Tokens {
+		for _, c := range t.Data {
+			expires, err := strconv.ParseInt(c.Labels[LabelExpires], 10, 64)
+			if err != nil || expires > now {
+				continue
+			}
+			if err = m.Expire(ctx, keys.Join(Prefix, c.Key)); err == nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// storeKey is the key a value wrapped under token is stored at. It is a hash of the token, which can't be reversed.
+func storeKey(token string) string {
+	sum := sha256.Sum256([]byte("vault-wrap-id:" + token))
+	return keys.Join(Prefix, hex.EncodeToString(sum[:]))
+}
+
+// aead returns the cipher sealing values wrapped under token
+func aead(token string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("vault-wrap-key:" + token))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plain with the key of token
+func seal(token string, plain []byte) (string, error) {
+	gcm, err := aead(token)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// open reverses seal
+func open(token, sealed string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := aead(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed value is too short")
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+}
@@ -0,0 +1,101 @@
+package wrap
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type WrapTestSuite struct {
+	suite.Suite
+	m *tokenize.Manager
+}
+
+func (suite *WrapTestSuite) SetupTest() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	suite.m = tokenize.NewManager(ctx, log, tokenize.WithStore(store.NewSyncMap(ctx, log)), tokenize.WithCipherLoc(cipherLoc))
+}
+
+func (suite *WrapTestSuite) TestUnwrapOnce() {
+	ctx := context.Background()
+	token, w, err := Wrap(ctx, suite.m, Wrapped{ID: "app", Key: "db", Value: "hunter2"}, time.Minute)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().WithinDuration(time.Now().Add(time.Minute), w.Expires, 2*time.Second)
+
+	// the store holds neither the token nor the value, and only shows what it holds to the vault itself
+	all, err := suite.m.Store().RetrieveAll(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Empty(all)
+	all, err = suite.m.Store().RetrieveAll(tokenize.System(ctx))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(all, 1)
+	for k, v := range all {
+		suite.Require().NotContains(k, token)
+		suite.Require().NotContains(v, "hunter2")
+	}
+
+	// of concurrent unwraps, exactly one gets the value
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var unwrapped []*Wrapped
+	var errs []error
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, err := Unwrap(ctx, suite.m, token)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			unwrapped = append(unwrapped, w)
+		}()
+	}
+	wg.Wait()
+	suite.Require().Len(unwrapped, 1)
+	for _, err := range errs {
+		suite.Require().ErrorIs(err, ErrNotFound)
+	}
+	suite.Require().Equal("hunter2", unwrapped[0].Value)
+	suite.Require().Equal("db", unwrapped[0].Key)
+
+	_, err = Unwrap(ctx, suite.m, "not-a-token")
+	suite.Require().ErrorIs(err, ErrNotFound)
+	_, _, err = Wrap(ctx, suite.m, Wrapped{Value: "x"}, 48*time.Hour)
+	suite.Require().ErrorIs(err, ErrTTLRange)
+}
+
+func (suite *WrapTestSuite) TestExpiry() {
+	ctx := context.Background()
+	token, _, err := Wrap(ctx, suite.m, Wrapped{Value: "old"}, time.Second)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+
+	// backdate the expiry of what's stored, as the clock can't be moved
+	key := storeKey(token)
+	_, _, err = suite.m.Take(ctx, key)
+	suite.Require().Error(err, "expected wrapped values to be reserved")
+	sealed, labels, err := suite.m.Take(tokenize.System(ctx), key)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	labels[LabelExpires] = strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	suite.Require().NoError(suite.m.Put(tokenize.System(ctx), key, sealed, labels))
+
+	suite.Require().Equal(1, Sweep(ctx, suite.m))
+	_, err = Unwrap(ctx, suite.m, token)
+	suite.Require().ErrorIs(err, ErrNotFound)
+}
+
+// TestWrapSuite tests the Wrap suite
+func TestWrapSuite(t *testing.T) {
+	suite.Run(t, new(WrapTestSuite))
+}
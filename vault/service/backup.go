@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/backup"
+	"github.com/dark-enstein/vault/internal/model"
+	"net/http"
+	"time"
+)
+
+const (
+	// HeaderBackupToken carries the operator credential backups are served to, as set by WithBackupToken
+	HeaderBackupToken = "X-Vault-Backup-Token"
+)
+
+var (
+	ErrBackupPassphraseEmpty = "passphrase must be set to encrypt the backup archive"
+	ErrBackupToken           = "backup token is missing or wrong"
+)
+
+// BackupHandlerFunc streams an encrypted, compressed archive of the whole vault back to callers holding the backup
+// token
+func BackupHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", SysBackup))
+		ctx := context.Background()
+		var resp model.Response
+		var req model.Backup
+		var err error
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			resp.Error = append(resp.Error, ErrMethodNotAllowed+": "+r.Method)
+			log.Logger().Error().Msg(ErrMethodNotAllowed)
+			resp.Code = CodeMethodNotAllowed
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		// the route is only served with a token, but an empty one must never let anyone in
+		if srv.backupToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(HeaderBackupToken)), []byte(srv.backupToken)) != 1 {
+			resp.Error = append(resp.Error, ErrBackupToken)
+			log.Logger().Error().Msg(ErrBackupToken)
+			resp.Code = CodeInvalidRequest
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		defer r.Body.Close()
+
+		// Check that json is a valid model.Backup structure
+		if err = jsonDecoder.Decode(&req); err != nil || len(req.Passphrase) == 0 {
+			if err == nil {
+				resp.Error = append(resp.Error, ErrBackupPassphraseEmpty)
+			} else {
+				resp.Error = append(resp.Error, err.Error())
+			}
+			log.Logger().Error().Msg(resp.Error[0])
+			resp.Code = CodeInvalidRequest
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		archive, err := backup.New(ctx, srv.managerOf(r))
+		if err != nil {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = CodeInternalServerError
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		sealed, err := archive.Seal([]byte(req.Passphrase))
+		if err != nil {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = CodeInternalServerError
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		// set header and return the archive as an attachment
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"vault-%d.bak\"", time.Now().Unix()))
+		w.WriteHeader(http.StatusOK)
+		w.Write(sealed)
+		log.Logger().Info().Msgf("backed up %d entries", len(archive.Entries))
+	}
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/backup"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *TokensTestSuite) TestBackup() {
+	ctx := context.Background()
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"hunter2"}`, nil))
+
+	// backups hold every token and the data key, so they aren't served without a backup token
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodPost, SysBackup, `{"passphrase":"p"}`, nil))
+
+	suite.srv = &Service{log: suite.srv.log, mux: http.NewServeMux(), manager: suite.srv.manager, backupToken: "op3rator"}
+	suite.srv.LoadHandlers(ctx)
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, SysBackup, strings.NewReader(`{"passphrase":"p"}`))
+		if token != "" {
+			req.Header.Set(HeaderBackupToken, token)
+		}
+		rec := httptest.NewRecorder()
+		suite.srv.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	suite.Require().Equal(http.StatusForbidden, send("").Code)
+	suite.Require().Equal(http.StatusForbidden, send("wrong").Code)
+	rec := send("op3rator")
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	archive, err := backup.Open(rec.Body.Bytes(), []byte("p"))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Contains(archive.Entries, "app/db")
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/cluster"
+	"github.com/dark-enstein/vault/internal/model"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// RaftStatus reports the view of the cluster from the node
+	RaftStatus = "/v1/sys/raft/status"
+	// RaftJoin adds a node to the cluster
+	RaftJoin = cluster.JoinPath
+	// RaftApply commits the writes followers forward to the leader
+	RaftApply = cluster.ApplyPath
+)
+
+var (
+	ErrClusterSecret = "cluster secret is missing or wrong"
+)
+
+// clusterHandlerFunc serves a route of the cluster on method, to callers holding the cluster secret
+func clusterHandlerFunc(srv *Service, route, method string, fn func(r *http.Request, body []byte) (any, error)) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Debug().Msg(fmt.Sprintf("received a request on %s", route))
+		var resp model.Response
+		fail := func(status, code int, errs ...string) {
+			resp.Error = append(resp.Error, errs...)
+			log.Logger().Error().Msg(strings.Join(errs, "; "))
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != method {
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, ErrMethodNotAllowed+": "+r.Method)
+			return
+		}
+		// a node can't start without a secret, but an empty one must never let anyone in
+		secret := srv.cluster.Secret()
+		if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(cluster.HeaderSecret)), []byte(secret)) != 1 {
+			fail(http.StatusForbidden, CodeInvalidRequest, ErrClusterSecret)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		out, err := fn(r, body)
+		if err != nil {
+			fail(http.StatusInternalServerError, CodeInternalServerError, err.Error())
+			return
+		}
+		resp.Resp = out
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// RaftStatusHandlerFunc returns the cluster.Status of the node
+func RaftStatusHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return clusterHandlerFunc(srv, RaftStatus, http.MethodGet, func(r *http.Request, body []byte) (any, error) {
+		return srv.cluster.Status()
+	})
+}
+
+// RaftJoinHandlerFunc adds the cluster.Peer requesting it to the cluster, forwarding to the leader if need be
+func RaftJoinHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return clusterHandlerFunc(srv, RaftJoin, http.MethodPost, func(r *http.Request, body []byte) (any, error) {
+		var p cluster.Peer
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		if err := srv.cluster.Join(r.Context(), p); err != nil {
+			return nil, err
+		}
+		return &cluster.Reply{OK: true}, nil
+	})
+}
+
+// RaftApplyHandlerFunc commits a write forwarded by a follower, and returns its cluster.Reply. Failures of the write
+// itself are part of the reply, so the follower can return them as its own.
+func RaftApplyHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return clusterHandlerFunc(srv, RaftApply, http.MethodPost, func(r *http.Request, body []byte) (any, error) {
+		return srv.cluster.ApplyForwarded(body), nil
+	})
+}
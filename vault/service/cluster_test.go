@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/cluster"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/hashicorp/raft"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (suite *TokensTestSuite) TestCluster() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	_, transport := raft.NewInmemTransport("n1")
+	logs := raft.NewInmemStore()
+	node, err := cluster.New(ctx, cluster.Config{NodeID: "n1", RaftAddr: "n1", APIAddr: "http://n1", Bootstrap: true, Secret: "s3cr3t"}, log,
+		cluster.WithTransport(transport), cluster.WithRaftStores(logs, logs, raft.NewInmemSnapshotStore()))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer node.Close(ctx)
+	suite.Require().Eventually(node.IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	suite.srv = &Service{log: log, mux: http.NewServeMux(), cluster: node}
+	suite.srv.manager = tokenize.NewManager(ctx, log, tokenize.WithStore(node), tokenize.WithCipherLoc(filepath.Join(suite.T().TempDir(), ".cipher")))
+	suite.srv.LoadHandlers(ctx)
+
+	// the cluster routes are only served to nodes holding the secret
+	suite.Require().Equal(http.StatusForbidden, suite.do(http.MethodGet, RaftStatus, "", nil))
+	suite.Require().Equal(http.StatusForbidden, suite.do(http.MethodPost, RaftApply, `{"kind":"store","id":"app/x","token":"t"}`, nil))
+	suite.Require().Equal(http.StatusForbidden, suite.do(http.MethodPost, RaftJoin, `{"id":"n2","raft_addr":"n2","api_addr":"http://n2"}`, nil))
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(cluster.HeaderSecret, "s3cr3t")
+		rec := httptest.NewRecorder()
+		suite.srv.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	var status struct {
+		Resp cluster.Status `json:"resp"`
+	}
+	rec := send(http.MethodGet, RaftStatus, "")
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), &status))
+	suite.Require().Equal("n1", status.Resp.Leader)
+
+	// tokens go through the replicated store, and writes forwarded to it are committed
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"hunter2"}`, nil))
+	var reply struct {
+		Resp cluster.Reply `json:"resp"`
+	}
+	rec = send(http.MethodPost, RaftApply, `{"kind":"store","id":"app/cache","token":"t"}`)
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), &reply))
+	suite.Require().True(reply.Resp.OK)
+	all, err := node.RetrieveAll(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(all, 2)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/events"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/namespace"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// Events streams the changes of keys as Server-Sent Events
+	Events = "/v1/events"
+	// ParamTypes selects the types of the events streamed, e.g. patched,deleted
+	ParamTypes = "types"
+	// HeaderLastEventID holds the ID of the last event a client received, which the stream resumes from
+	HeaderLastEventID = "Last-Event-ID"
+	// ContentTypeEventStream is the content type of Server-Sent Events
+	ContentTypeEventStream = "text/event-stream"
+	// EventReset tells clients resuming the stream that events were missed, so they should reload what they watch
+	EventReset = "reset"
+	// ErrLastEventIDInvalid is returned for a Last-Event-ID header that isn't an event ID
+	ErrLastEventIDInvalid = "Last-Event-ID must be the ID of an event"
+)
+
+// keepAliveInterval is how often an idle stream is written a comment, so proxies don't time it out
+var keepAliveInterval = 15 * time.Second
+
+// EventsHandlerFunc streams the changes of the keys of the namespace of the request starting with the prefix
+// parameter, of the types of the types parameter or all of them, as Server-Sent Events. Each event has its ID, and
+// its type as event name. Clients reconnecting with the Last-Event-ID header resume after that event. Those that
+// missed events past the history of the service are sent a reset event first. Only the changes served by this
+// instance are streamed: instances sharing a store each stream their own.
+func EventsHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", Events))
+		var resp model.Response
+
+		fail := func(status, code int, err string) {
+			resp.Error = append(resp.Error, err)
+			log.Logger().Error().Msg(err)
+			resp.Code = code
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if r.Method != http.MethodGet {
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, ErrMethodNotAllowed+": "+r.Method)
+			return
+		}
+
+		filter := events.Filter{Namespace: namespace.Root, Prefix: r.URL.Query().Get(ParamPrefix)}
+		if t := tenantOf(r); t != nil {
+			filter.Namespace = t.name
+		}
+		types, err := events.ParseTypes(r.URL.Query().Get(ParamTypes))
+		if err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		filter.Types = types
+		var after uint64
+		if id := r.Header.Get(HeaderLastEventID); id != "" {
+			if after, err = strconv.ParseUint(id, 10, 64); err != nil {
+				fail(http.StatusBadRequest, CodeInvalidRequest, ErrLastEventIDInvalid)
+				return
+			}
+		}
+
+		// the stream outlives the write timeout of the server
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Logger().Debug().Msgf("write deadline not supported: %s", err)
+		}
+
+		sub := srv.managerOf(r).Events().Subscribe(filter, after, events.DefaultBuffer)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", ContentTypeEventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if sub.Missed {
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", EventReset)
+		}
+		rc.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case ev, ok := <-sub.C:
+				if !ok {
+					// the client reconnects, and resumes from the last event it received
+					log.Logger().Debug().Msgf("event stream closed: %s", sub.Err())
+					return
+				}
+				data, err := json.Marshal(ev)
+				if err != nil {
+					log.Logger().Error().Msgf("error encoding event %d: %s", ev.ID, err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/events"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+)
+
+func (suite *TokensTestSuite) TestEvents() {
+	srv := httptest.NewServer(suite.srv.mux)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + Events + "?prefix=app/&types=patched,deleted")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer res.Body.Close()
+	suite.Require().Equal(http.StatusOK, res.StatusCode)
+	suite.Require().Equal(ContentTypeEventStream, res.Header.Get("Content-Type"))
+
+	// only the patches and deletions of keys under the prefix are streamed
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"one"}`, nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/web/db", `{"value":"one"}`, nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPatch, "/v1/tokens/web/db", `{"value":"two"}`, nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPatch, "/v1/tokens/app/db", `{"value":"two"}`, nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodDelete, "/v1/tokens/app/db", "", nil))
+
+	next := func(r *bufio.Reader) (string, events.Event) {
+		var name string
+		var ev events.Event
+		for {
+			line, err := r.ReadString('\n')
+			suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+			switch {
+			case line == "\n":
+				return name, ev
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+			case strings.HasPrefix(line, "data: "):
+				suite.Require().NoError(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+			}
+		}
+	}
+	stream := bufio.NewReader(res.Body)
+	name, patched := next(stream)
+	suite.Require().Equal("patched", name)
+	suite.Require().Equal(events.Event{ID: patched.ID, Type: events.Patched, Key: "app/db", Revision: 2, Time: patched.Time}, patched)
+	name, deleted := next(stream)
+	suite.Require().Equal("deleted", name)
+	suite.Require().Equal("app/db", deleted.Key)
+	// deletions announce the revision removed
+	suite.Require().Equal(int64(2), deleted.Revision)
+
+	// reconnecting resumes after the last event received
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+Events, nil)
+	req.Header.Set(HeaderLastEventID, strconv.FormatUint(patched.ID-1, 10))
+	resumed, err := http.DefaultClient.Do(req)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer resumed.Body.Close()
+	stream = bufio.NewReader(resumed.Body)
+	_, ev := next(stream)
+	suite.Require().Equal(patched.ID, ev.ID)
+	_, ev = next(stream)
+	suite.Require().Equal(deleted.ID, ev.ID)
+
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodGet, Events+"?types=renamed", "", nil))
+	suite.Require().Equal(http.StatusMethodNotAllowed, suite.do(http.MethodPost, Events, "", nil))
+}
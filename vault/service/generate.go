@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/generate"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+var (
+	// Generate mints a secret, and tokenizes it
+	Generate = "/v1/generate"
+)
+
+// GenerateHandlerFunc generates the secret of a model.Generate, and tokenizes it under its key, which must not exist
+// yet. Only the token is returned, along with the public key of key pairs, unless the value is asked to be revealed,
+// in which case it is masked as the caller's policy requires.
+func GenerateHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", Generate))
+		ctx := context.Background()
+		var resp model.Response
+		var req model.Generate
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		fail := func(status, code int, err error) {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if r.Method != http.MethodPost {
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, errors.New(ErrMethodNotAllowed+": "+r.Method))
+			return
+		}
+
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		defer r.Body.Close()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+
+		policy, err := srv.maskPolicy(r)
+		if err != nil {
+			status, code := maskErrStatus(err)
+			fail(status, code, err)
+			return
+		}
+		t, err := generate.ParseType(req.Type)
+		if err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+		// a secret stored by ID alone has no key
+		key, err := keys.Normalize(req.ID)
+		if req.Key != "" {
+			key, err = tokenize.ChildKey(req.ID, req.Key)
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+
+		p := generate.Policy{Length: req.Length, Charset: generate.ParseCharset(req.Charset), Symbols: req.Symbols, Bits: req.Bits}
+		minted, err := generate.Mint(ctx, srv.managerOf(r), key, t, p)
+		if errors.Is(err, generate.ErrPolicyInvalid) {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+		if err != nil {
+			status, code := batchErrStatus(err)
+			fail(status, code, fmt.Errorf("error with key %s: %w", key, err))
+			return
+		}
+
+		gen := &model.GenerateResponse{ID: req.ID, Key: req.Key, Type: string(t), Token: minted.Token, Revision: minted.Revision, Public: minted.Public}
+		if req.Reveal {
+			gen.Datum = policy.Apply(minted.Value)
+		}
+		resp.Resp = gen
+		resp.Code = CodeSuccess
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"github.com/dark-enstein/vault/internal/model"
+	"net/http"
+)
+
+func (suite *TokensTestSuite) TestGenerate() {
+	var resp struct {
+		Resp model.GenerateResponse `json:"resp"`
+	}
+	code := suite.do(http.MethodPost, Generate, `{"id":"app","key":"db/password","type":"password","length":24,"symbols":true}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().NotEmpty(resp.Resp.Token)
+	suite.Require().Equal(int64(1), resp.Resp.Revision)
+	// the value is only returned when asked for
+	suite.Require().Empty(resp.Resp.Datum)
+
+	var detoken struct {
+		Resp model.DetokenizeResponse `json:"resp"`
+	}
+	body := `{"id":"app","data":[{"key":"db/password","value":"` + resp.Resp.Token + `"}]}`
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, Detokenize, body, &detoken))
+	suite.Require().Len(detoken.Resp.Data[0].Value.Datum, 24)
+
+	code = suite.do(http.MethodPost, Generate, `{"id":"app","key":"signing","type":"ed25519","reveal":true}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Contains(resp.Resp.Public, "PUBLIC KEY")
+	suite.Require().Contains(resp.Resp.Datum, "PRIVATE KEY")
+
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, Generate, `{"id":"app","key":"db/password","type":"password"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, Generate, `{"id":"app","key":"db/password/old","type":"password"}`, nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, Generate, `{"id":"session-key","type":"hex"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, Generate, `{"id":"app","key":"x","type":"dsa"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, Generate, `{"id":"app","key":"x","type":"uuid","length":8}`, nil))
+	suite.Require().Equal(http.StatusMethodNotAllowed, suite.do(http.MethodGet, Generate, "", nil))
+}
@@ -4,9 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/labels"
 	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/namespace"
+	"github.com/dark-enstein/vault/internal/secure"
+	"github.com/dark-enstein/vault/internal/store"
 	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -16,11 +23,18 @@ const (
 	CodeInvalidRequest
 	CodeMethodNotAllowed
 	CodeRequestTimeout
+	CodeServiceUnavailable
+	CodeTooManyRequests
 )
 
 var (
-	KeyDelimiter = tokenize.KeyDelimiter
-	ParamVarID   = "id"
+	KeyDelimiter  = tokenize.KeyDelimiter
+	ParamVarID    = "id"
+	ParamPrefix   = "prefix"
+	ParamCursor   = "cursor"
+	ParamLimit    = "limit"
+	ParamSelector = "selector"
+	ParamKeysOnly = "keys_only"
 )
 
 var (
@@ -32,12 +46,19 @@ var (
 	GetTokensByID = "/id"
 	DeleteToken   = "/delete"
 	PatchToken    = "/patch"
+	SysBackup     = "/v1/sys/backup"
+	// TokensPath implements path based access to tokens, e.g. /v1/tokens/app/db/password
+	TokensPath = "/v1/tokens/"
+	// Metrics serves the service metrics to Prometheus
+	Metrics = "/metrics"
 )
 
 var (
 	ErrMethodNotAllowed                = "method not allowed"
 	Err404                             = "404 not found"
 	ErrParameterizedVariableNotPassedF = "parameterized variable %s empty"
+	ErrLimitInvalid                    = "limit must be a positive integer"
+	ErrKeysOnlyInvalid                 = "keys_only must be true or false"
 )
 
 type VaultHandler map[string]func(w http.ResponseWriter, r *http.Request)
@@ -51,6 +72,33 @@ func NewVaultHandler(ctx context.Context, srv *Service) *VaultHandler {
 	vh[GetTokensByID] = GetTokenByIDParamHandler(srv)
 	vh[DeleteToken] = DeleteTokenByIDParamHandler(srv)
 	vh[PatchToken] = PatchTokenByIDParamHandler(srv)
+	if srv.backupToken != "" {
+		vh[SysBackup] = BackupHandlerFunc(srv)
+	}
+	vh[TokensPath] = TokensPathHandlerFunc(srv)
+	vh[DetokenizeStream] = DetokenizeStreamHandlerFunc(srv)
+	vh[TransformTokenize] = TransformTokenizeHandlerFunc(srv)
+	vh[TransformDetokenize] = TransformDetokenizeHandlerFunc(srv)
+	vh[Wrap] = WrapHandlerFunc(srv)
+	vh[Unwrap] = UnwrapHandlerFunc(srv)
+	vh[Generate] = GenerateHandlerFunc(srv)
+	vh[TransitKeys] = TransitKeysHandlerFunc(srv)
+	vh[TransitEncrypt] = TransitEncryptHandlerFunc(srv)
+	vh[TransitDecrypt] = TransitDecryptHandlerFunc(srv)
+	vh[TransitRewrap] = TransitRewrapHandlerFunc(srv)
+	vh[TransitSign] = TransitSignHandlerFunc(srv)
+	vh[TransitVerify] = TransitVerifyHandlerFunc(srv)
+	vh[Events] = EventsHandlerFunc(srv)
+	vh[Healthz] = HealthzHandlerFunc(srv)
+	vh[Readyz] = ReadyzHandlerFunc(srv)
+	if srv.metrics != nil {
+		vh[Metrics] = srv.metrics.Handler().ServeHTTP
+	}
+	if srv.cluster != nil {
+		vh[RaftStatus] = RaftStatusHandlerFunc(srv)
+		vh[RaftJoin] = RaftJoinHandlerFunc(srv)
+		vh[RaftApply] = RaftApplyHandlerFunc(srv)
+	}
 	//vh[Introduction] = newVaultHandleFunc
 	return &vh
 }
@@ -88,7 +136,7 @@ func GetTokenByIDParamHandler(srv *Service) func(w http.ResponseWriter, r *http.
 		w.Header().Set("Content-Type", "application/json")
 		query := r.URL.Query().Get(IDQueryKey)
 
-		token, err := srv.manager.GetTokenByID(ctx, query)
+		token, err := srv.managerOf(r).GetTokenByID(ctx, query)
 		if err != nil {
 			resp.Error = append(resp.Error, err.Error())
 			log.Logger().Error().Msg(err.Error())
@@ -137,13 +185,30 @@ func DeleteTokenByIDParamHandler(srv *Service) func(w http.ResponseWriter, r *ht
 		w.Header().Set("Content-Type", "application/json")
 		query := r.URL.Query().Get(IDQueryKey)
 
-		b, err := srv.manager.DeleteTokenByID(ctx, query)
+		// only delete the key at the expected revision, if any is given
+		expected, err := queryRevision(r)
+		if err == nil {
+			expected, err = expectedRevision(r, expected)
+		}
+		if err != nil {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = CodeInvalidRequest
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		b, err := srv.managerOf(r).DeleteTokenIf(ctx, query, expected)
 		if err != nil || !b {
+			status, code := http.StatusInternalServerError, CodeInternalServerError
+			if errors.Is(err, tokenize.ErrRevisionMismatch) {
+				status, code = http.StatusConflict, CodeInvalidRequest
+			}
 			resp.Error = append(resp.Error, err.Error())
 			log.Logger().Error().Msg(err.Error())
-			resp.Code = CodeInternalServerError
-			// return 400 status codes
-			w.WriteHeader(http.StatusInternalServerError)
+			resp.Code = code
+			w.WriteHeader(status)
 			json.NewEncoder(w).Encode(resp)
 			return
 		}
@@ -193,11 +258,26 @@ func PatchTokenByIDParamHandler(srv *Service) func(w http.ResponseWriter, r *htt
 			return
 		}
 
-		var tokenStr string
+		// flatten a nested document into children
+		isDocument := token.Document != nil
+		if isDocument {
+			children, err := tokenize.DocumentChildren(token.Document)
+			if err != nil {
+				resp.Error = append(resp.Error, err.Error())
+				log.Logger().Error().Msg(err.Error())
+				resp.Code = CodeInvalidRequest
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			token.Data = append(token.Data, children...)
+			token.Document = nil
+		}
+
 		var children []model.Child
 
 		// tokenize logic
-		manager := srv.manager
+		manager := srv.managerOf(r)
 
 		// ensure user request parameter is correct and valid
 		validationResp, ok := manager.Validate(ctx, &token, true)
@@ -216,23 +296,54 @@ func PatchTokenByIDParamHandler(srv *Service) func(w http.ResponseWriter, r *htt
 
 		w.Header().Set("Content-Type", "application/json")
 
+		// If-Match applies to every child not expecting a revision of its own
+		ifMatch, err := expectedRevision(r, tokenize.AnyRevision)
+		if err != nil {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = CodeInvalidRequest
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		// user request valid, not proceed to process
 		parentKey := token.ID
+		entries := make([]tokenize.Entry, 0, len(token.Data))
 		for i := 0; i < len(token.Data); i++ {
 			childKey := token.Data[i].Key
-			combinedKeyName := tokenize.GetCombinedKey(parentKey, childKey)
-			tokenStr, err = manager.PatchTokenByID(ctx, combinedKeyName, token.Data[i].Value)
+			combinedKeyName, err := tokenize.ChildKey(parentKey, childKey)
 			if err != nil {
 				resp.Error = append(resp.Error, fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
 				log.Logger().Error().Msg(fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
-				resp.Code = CodeInternalServerError
-				w.WriteHeader(http.StatusInternalServerError)
+				resp.Code = CodeInvalidRequest
+				w.WriteHeader(http.StatusBadRequest)
 				json.NewEncoder(w).Encode(resp)
 				return
 			}
+			expected := token.Data[i].ExpectedRevision
+			if expected == tokenize.AnyRevision {
+				expected = ifMatch
+			}
+			entries = append(entries, tokenize.Entry{Key: combinedKeyName, Value: token.Data[i].Value, Revision: expected, Labels: token.Data[i].Labels})
+		}
+
+		// commit all children, or none of them
+		receipts, err := manager.TokenizeBatch(ctx, entries, true)
+		if err != nil {
+			status, code := batchErrStatus(err)
+			resp.Error = append(resp.Error, fmt.Sprintf("error with id %s: %s", parentKey, err.Error()))
+			log.Logger().Error().Msg(fmt.Sprintf("error with id %s: %s", parentKey, err.Error()))
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		for i := 0; i < len(token.Data); i++ {
 			children = append(children, model.Child{
-				Key:   childKey,
-				Value: tokenStr,
+				Key:      token.Data[i].Key,
+				Value:    receipts[i].Token,
+				Revision: receipts[i].Revision,
 			})
 		}
 
@@ -241,6 +352,17 @@ func PatchTokenByIDParamHandler(srv *Service) func(w http.ResponseWriter, r *htt
 			ID:   token.ID,
 			Data: children,
 		}
+		if isDocument {
+			if tokenStruct.Document, err = tokenize.NestChildren(children); err != nil {
+				resp.Error = append(resp.Error, err.Error())
+				log.Logger().Error().Msg(err.Error())
+				resp.Code = CodeInternalServerError
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			tokenStruct.Data = nil
+		}
 		resp.Resp = tokenStruct
 		resp.Code = CodeSuccess
 
@@ -250,6 +372,20 @@ func PatchTokenByIDParamHandler(srv *Service) func(w http.ResponseWriter, r *htt
 	}
 }
 
+// batchErrStatus maps an error committing a batch to a http status and response code. Batches abort on keys that
+// exist, or don't, when they shouldn't, which is a client error.
+func batchErrStatus(err error) (int, int) {
+	switch {
+	case errors.Is(err, store.ErrBatchKeyExists), errors.Is(err, store.ErrBatchKeyNotFound), errors.Is(err, labels.ErrKeyInvalid), errors.Is(err, namespace.ErrKeyReserved), errors.Is(err, tokenize.ErrKeyReserved),
+		errors.Is(err, tokenize.ErrKeyUnderValue), errors.Is(err, tokenize.ErrKeyOverValue):
+		return http.StatusBadRequest, CodeInvalidRequest
+	case errors.Is(err, store.ErrBatchConflict), errors.Is(err, tokenize.ErrRevisionMismatch):
+		return http.StatusConflict, CodeInvalidRequest
+	default:
+		return http.StatusInternalServerError, CodeInternalServerError
+	}
+}
+
 func GetTokensByIDHandler(srv *Service) func(w http.ResponseWriter, r *http.Request) {
 	log := srv.log
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -289,7 +425,7 @@ func GetTokensByIDHandler(srv *Service) func(w http.ResponseWriter, r *http.Requ
 		var err error
 
 		// tokenize logic
-		manager := srv.manager
+		manager := srv.managerOf(r)
 
 		token, err := manager.GetTokenByID(ctx, id)
 		if err != nil {
@@ -354,7 +490,7 @@ func DeleteTokenByIDHandler(srv *Service) func(w http.ResponseWriter, r *http.Re
 		log.Logger().Debug().Msg(fmt.Sprintf("id after after path: %s", id))
 		var err error
 
-		b, err := srv.manager.DeleteTokenByID(ctx, id)
+		b, err := srv.managerOf(r).DeleteTokenByID(ctx, id)
 		if err != nil || !b {
 			resp.Error = append(resp.Error, err.Error())
 			log.Logger().Error().Msg(err.Error())
@@ -376,6 +512,33 @@ func DeleteTokenByIDHandler(srv *Service) func(w http.ResponseWriter, r *http.Re
 	}
 }
 
+// listOptions parses the listing options of a request from its query parameters
+func listOptions(r *http.Request) (tokenize.ListOptions, error) {
+	query := r.URL.Query()
+	opts := tokenize.ListOptions{
+		Prefix: query.Get(ParamPrefix),
+		Cursor: query.Get(ParamCursor),
+	}
+
+	var err error
+	if limit := query.Get(ParamLimit); limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit <= 0 {
+			return opts, errors.New(ErrLimitInvalid)
+		}
+	}
+	if keysOnly := query.Get(ParamKeysOnly); keysOnly != "" {
+		if opts.KeysOnly, err = strconv.ParseBool(keysOnly); err != nil {
+			return opts, errors.New(ErrKeysOnlyInvalid)
+		}
+	}
+	if opts.Selector, err = labels.Parse(query.Get(ParamSelector)); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// GetTokensHandler lists the tokens in the store, a page at a time if a limit is given. The listing is filtered by the
+// prefix, selector and keys_only query parameters, and continued from the next cursor of the previous page with cursor.
 func GetTokensHandler(srv *Service) func(w http.ResponseWriter, r *http.Request) {
 	log := srv.log
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -396,24 +559,34 @@ func GetTokensHandler(srv *Service) func(w http.ResponseWriter, r *http.Request)
 		//reqCtx := context.Background()
 
 		// tokenize logic
-		manager := srv.manager
+		manager := srv.managerOf(r)
+
+		opts, err := listOptions(r)
+		if err != nil {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = CodeInvalidRequest
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
 
 		// user request valid, not proceed to process
-		tokens, err := manager.GetAllTokens(ctx)
+		tokenStruct, err := manager.ListTokens(ctx, opts)
 		if err != nil {
+			status, code := http.StatusInternalServerError, CodeInternalServerError
+			if errors.Is(err, store.ErrScanCursorInvalid) {
+				status, code = http.StatusBadRequest, CodeInvalidRequest
+			}
 			resp.Error = append(resp.Error, err.Error())
 			log.Logger().Error().Msg(err.Error())
-			resp.Code = CodeInternalServerError
-			// return 400 status codes
-			w.WriteHeader(http.StatusInternalServerError)
+			resp.Code = code
+			w.WriteHeader(status)
 			json.NewEncoder(w).Encode(resp)
 			return
 		}
 
 		// generate response
-		tokenStruct := &model.All{
-			tokens,
-		}
 		resp.Resp = tokenStruct
 		resp.Code = CodeSuccess
 
@@ -445,6 +618,19 @@ func DetokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Req
 		//reqCtx := context.Background()
 
 		w.Header().Set("Content-Type", "application/json")
+
+		// mask the values as the caller's role, or the caller, requires
+		policy, err := srv.maskPolicy(r)
+		if err != nil {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			status, code := maskErrStatus(err)
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		jsonDecoder := json.NewDecoder(r.Body)
 		jsonDecoder.DisallowUnknownFields()
 		defer r.Body.Close()
@@ -460,19 +646,52 @@ func DetokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Req
 			return
 		}
 
-		var decryptedStr string
+		// flatten a nested document of tokens into children
+		isDocument := detoken.Document != nil
+		if isDocument {
+			children, err := tokenize.DocumentChildren(detoken.Document)
+			if err != nil {
+				resp.Error = append(resp.Error, err.Error())
+				log.Logger().Error().Msg(err.Error())
+				resp.Code = CodeInvalidRequest
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			detoken.Data = append(detoken.Data, children...)
+			detoken.Document = nil
+		}
+
 		var children []*model.ChildReceipt
 
+		// the decrypted values stay in secure buffers, wiped once the response is written
+		var plains []*secure.Buffer
+		defer func() {
+			for _, p := range plains {
+				p.Destroy()
+			}
+		}()
+
 		// tokenize logic
-		manager := srv.manager
+		manager := srv.managerOf(r)
 
 		// user request valid, not proceed to process
 		parentKey := detoken.ID
 		for i := 0; i < len(detoken.Data); i++ {
 			var found bool
 			childKey := detoken.Data[i].Key
-			combinedKeyName := tokenize.GetCombinedKey(parentKey, childKey)
-			found, decryptedStr, err = manager.Detokenize(ctx, combinedKeyName, detoken.Data[i].Value)
+			combinedKeyName, err := tokenize.ChildKey(parentKey, childKey)
+			if err != nil {
+				resp.Error = append(resp.Error, fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
+				log.Logger().Error().Msg(fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
+				resp.Code = CodeInvalidRequest
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			var plain *secure.Buffer
+			var rev int64
+			found, plain, rev, err = manager.DetokenizeRevision(ctx, combinedKeyName, detoken.Data[i].Value)
 			if err != nil || !found {
 				resp.Error = append(resp.Error, fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
 				log.Logger().Error().Msg(fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
@@ -484,10 +703,12 @@ func DetokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Req
 			children = append(children, &model.ChildReceipt{
 				Key: childKey,
 				Value: &model.ChildResp{
-					Found: found,
-					Datum: decryptedStr,
+					Found:    found,
+					Datum:    policy.Apply(plain.UnsafeString()),
+					Revision: rev,
 				},
 			})
+			plains = append(plains, plain)
 		}
 
 		// generate response
@@ -495,6 +716,21 @@ func DetokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Req
 			ID:   detoken.ID,
 			Data: children,
 		}
+		if isDocument {
+			receipts := make(map[string]*model.ChildResp, len(children))
+			for _, c := range children {
+				receipts[c.Key] = c.Value
+			}
+			if tokenStruct.Document, err = keys.Nest(receipts); err != nil {
+				resp.Error = append(resp.Error, err.Error())
+				log.Logger().Error().Msg(err.Error())
+				resp.Code = CodeInternalServerError
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			tokenStruct.Data = nil
+		}
 		resp.Resp = tokenStruct
 		resp.Code = CodeSuccess
 
@@ -541,11 +777,26 @@ func TokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Reque
 			return
 		}
 
-		var tokenStr string
+		// flatten a nested document into children
+		isDocument := token.Document != nil
+		if isDocument {
+			children, err := tokenize.DocumentChildren(token.Document)
+			if err != nil {
+				resp.Error = append(resp.Error, err.Error())
+				log.Logger().Error().Msg(err.Error())
+				resp.Code = CodeInvalidRequest
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			token.Data = append(token.Data, children...)
+			token.Document = nil
+		}
+
 		var children []model.Child
 
 		// tokenize logic
-		manager := srv.manager
+		manager := srv.managerOf(r)
 
 		// ensure user request parameter is correct and valid
 		validationResp, ok := manager.Validate(ctx, &token, false)
@@ -562,21 +813,37 @@ func TokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Reque
 
 		// user request valid, not proceed to process
 		parentKey := token.ID
+		entries := make([]tokenize.Entry, 0, len(token.Data))
 		for i := 0; i < len(token.Data); i++ {
 			childKey := token.Data[i].Key
-			combinedKeyName := tokenize.GetCombinedKey(parentKey, childKey)
-			tokenStr, err = manager.Tokenize(ctx, combinedKeyName, token.Data[i].Value)
+			combinedKeyName, err := tokenize.ChildKey(parentKey, childKey)
 			if err != nil {
 				resp.Error = append(resp.Error, fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
 				log.Logger().Error().Msg(fmt.Sprintf("error with key %s.%s: %s", parentKey, childKey, err.Error()))
-				resp.Code = CodeInternalServerError
-				w.WriteHeader(http.StatusInternalServerError)
+				resp.Code = CodeInvalidRequest
+				w.WriteHeader(http.StatusBadRequest)
 				json.NewEncoder(w).Encode(resp)
 				return
 			}
+			entries = append(entries, tokenize.Entry{Key: combinedKeyName, Value: token.Data[i].Value, Labels: token.Data[i].Labels})
+		}
+
+		// commit all children, or none of them
+		receipts, err := manager.TokenizeBatch(ctx, entries, false)
+		if err != nil {
+			status, code := batchErrStatus(err)
+			resp.Error = append(resp.Error, fmt.Sprintf("error with id %s: %s", parentKey, err.Error()))
+			log.Logger().Error().Msg(fmt.Sprintf("error with id %s: %s", parentKey, err.Error()))
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		for i := 0; i < len(token.Data); i++ {
 			children = append(children, model.Child{
-				Key:   childKey,
-				Value: tokenStr,
+				Key:      token.Data[i].Key,
+				Value:    receipts[i].Token,
+				Revision: receipts[i].Revision,
 			})
 		}
 
@@ -585,6 +852,17 @@ func TokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Reque
 			ID:   token.ID,
 			Data: children,
 		}
+		if isDocument {
+			if tokenStruct.Document, err = tokenize.NestChildren(children); err != nil {
+				resp.Error = append(resp.Error, err.Error())
+				log.Logger().Error().Msg(err.Error())
+				resp.Code = CodeInternalServerError
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			tokenStruct.Data = nil
+		}
 		resp.Resp = tokenStruct
 		resp.Code = CodeSuccess
 
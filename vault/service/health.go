@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/model"
+	"net/http"
+	"time"
+)
+
+var (
+	// Healthz reports whether the service is alive. It only fails if the cipher isn't usable, which restarting the
+	// service may fix; a store outage is reported, but doesn't fail it.
+	Healthz = "/healthz"
+	// Readyz reports whether the service can serve requests: the cipher is usable, and the store is reachable
+	Readyz = "/readyz"
+	// HealthTimeout bounds the time spent on the checks of a single probe
+	HealthTimeout = 2 * time.Second
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	CheckCipher       = "cipher"
+	CheckStore        = "store"
+)
+
+// checkHealth runs every health check of the service
+func (s *Service) checkHealth(ctx context.Context) *model.Health {
+	ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	defer cancel()
+
+	health := &model.Health{Status: StatusOK, Checks: map[string]*model.Check{}}
+	run := func(name string, fn func() error) {
+		start := time.Now()
+		c := &model.Check{Status: StatusOK}
+		if err := fn(); err != nil {
+			c.Status, c.Error = StatusUnavailable, err.Error()
+			health.Status = StatusUnavailable
+		}
+		c.Latency = time.Since(start).String()
+		health.Checks[name] = c
+	}
+
+	run(CheckCipher, s.manager.CheckCipher)
+	run(CheckStore, func() error {
+		ok, err := s.manager.Ping(ctx)
+		if err == nil && !ok {
+			err = fmt.Errorf("store did not answer")
+		}
+		return err
+	})
+	return health
+}
+
+// HealthzHandlerFunc serves the liveness probe of the service
+func HealthzHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return healthHandlerFunc(srv, Healthz, func(h *model.Health) bool {
+		return h.Checks[CheckCipher].Status == StatusOK
+	})
+}
+
+// ReadyzHandlerFunc serves the readiness probe of the service
+func ReadyzHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return healthHandlerFunc(srv, Readyz, func(h *model.Health) bool {
+		return h.Status == StatusOK
+	})
+}
+
+// healthHandlerFunc reports the health checks on route, with 503 Service Unavailable unless pass accepts them
+func healthHandlerFunc(srv *Service, route string, pass func(*model.Health) bool) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Debug().Msg(fmt.Sprintf("received a request on %s", route))
+		var resp model.Response
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			resp.Error = append(resp.Error, ErrMethodNotAllowed+": "+r.Method)
+			resp.Code = CodeMethodNotAllowed
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		// probes must never be served from a cache
+		w.Header().Set("Cache-Control", "no-store")
+
+		health := srv.checkHealth(r.Context())
+		resp.Resp = health
+		resp.Code = CodeSuccess
+		status := http.StatusOK
+		if !pass(health) {
+			for _, name := range []string{CheckCipher, CheckStore} {
+				if c := health.Checks[name]; c.Status != StatusOK {
+					resp.Error = append(resp.Error, name+": "+c.Error)
+					log.Logger().Error().Msgf("%s check failed on %s: %s", name, route, c.Error)
+				}
+			}
+			resp.Code = CodeServiceUnavailable
+			status = http.StatusServiceUnavailable
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
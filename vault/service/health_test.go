@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+func (suite *TokensTestSuite) TestHealth() {
+	probe := func(route string) (int, *model.Health) {
+		var resp struct {
+			Resp model.Health `json:"resp"`
+		}
+		code := suite.do(http.MethodGet, route, "", &resp)
+		return code, &resp.Resp
+	}
+
+	code, health := probe(Readyz)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal(StatusOK, health.Status)
+	suite.Require().Equal(StatusOK, health.Checks[CheckStore].Status)
+	suite.Require().Equal(http.StatusMethodNotAllowed, suite.do(http.MethodPost, Healthz, "", nil))
+
+	// a store that went away makes the service unready, but not dead
+	ctx := context.Background()
+	loc := filepath.Join(suite.T().TempDir(), ".store")
+	file := store.NewFile(loc, suite.srv.log)
+	suite.srv.manager = tokenize.NewManager(ctx, suite.srv.log, tokenize.WithStore(file), tokenize.WithCipherLoc(filepath.Join(suite.T().TempDir(), ".cipher")))
+	suite.Require().NoError(os.Remove(loc))
+	code, health = probe(Readyz)
+	suite.Require().Equal(http.StatusServiceUnavailable, code)
+	suite.Require().Equal(StatusUnavailable, health.Checks[CheckStore].Status)
+	code, health = probe(Healthz)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal(StatusUnavailable, health.Status)
+
+	// without a usable cipher, nothing can be served
+	suite.Require().NoError(suite.srv.manager.SetCipher(map[string]string{tokenize.EnvKeyAESCipher: "short", tokenize.EnvKeyInitializationVector: "iv"}))
+	code, health = probe(Healthz)
+	suite.Require().Equal(http.StatusServiceUnavailable, code)
+	suite.Require().Equal(StatusUnavailable, health.Checks[CheckCipher].Status)
+}
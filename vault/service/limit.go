@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// DefaultMaxBodyBytes is the size above which request bodies are refused, if none is configured
+	DefaultMaxBodyBytes int64 = 4 << 20
+	ErrRateLimited            = "rate limit exceeded"
+	ErrBodyTooLarge           = "request body too large"
+)
+
+// unlimited routes are never rate limited, so probes, scrapes and replication keep working while clients are throttled
+var unlimited = map[string]bool{Healthz: true, Readyz: true, Metrics: true, RaftJoin: true, RaftApply: true}
+
+// limitConfig bounds what clients can send
+type limitConfig struct {
+	// global limits the requests of every client across all routes
+	global *ratelimit.Limiter
+	// routes limits the requests of every client on a route
+	routes map[string]*ratelimit.Limiter
+	// clientHeader names the header identifying clients, if set by a trusted proxy. Clients are otherwise identified by
+	// their IP address.
+	clientHeader string
+	// maxBodyBytes is the size above which request bodies are refused, if positive
+	maxBodyBytes int64
+}
+
+// clientID identifies the client of a request
+func (s *Service) clientID(r *http.Request) string {
+	if s.limits.clientHeader != "" {
+		if id := r.Header.Get(s.limits.clientHeader); id != "" {
+			return id
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limit refuses the requests of clients over their rate limits on route with 429 Too Many Requests, and bounds the
+// size of their bodies. Streams are only bounded line by line.
+func (s *Service) limit(route string, h func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	log := s.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resp model.Response
+		fail := func(status, code int, msg string) {
+			resp.Error = append(resp.Error, msg)
+			log.Logger().Error().Msgf("%s on %s: %s", msg, route, s.clientID(r))
+			resp.Code = code
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if !unlimited[route] {
+			// a request refused by either limit isn't charged against the other: the tokens taken before are given back
+			client := s.clientID(r)
+			var taken []*ratelimit.Limiter
+			for _, l := range []*ratelimit.Limiter{s.limits.routes[route], s.limits.global} {
+				if l == nil {
+					continue
+				}
+				if ok, wait := l.Allow(client); !ok {
+					for _, t := range taken {
+						t.Cancel(client)
+					}
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					fail(http.StatusTooManyRequests, CodeTooManyRequests, ErrRateLimited)
+					return
+				}
+				taken = append(taken, l)
+			}
+		}
+
+		if s.limits.maxBodyBytes > 0 && route != DetokenizeStream {
+			// refuse what is known to be too large upfront, and cut off the rest once it gets there
+			if r.ContentLength > s.limits.maxBodyBytes {
+				fail(http.StatusRequestEntityTooLarge, CodeInvalidRequest, fmt.Sprintf("%s: limit is %d bytes", ErrBodyTooLarge, s.limits.maxBodyBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, s.limits.maxBodyBytes)
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"github.com/dark-enstein/vault/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *TokensTestSuite) TestLimits() {
+	suite.srv.limits = limitConfig{
+		global:       ratelimit.New(ratelimit.Limit{Rate: 1.0 / 60, Burst: 2}),
+		routes:       map[string]*ratelimit.Limiter{Detokenize: ratelimit.New(ratelimit.Limit{Rate: 1.0 / 60, Burst: 1})},
+		clientHeader: "X-Client",
+		maxBodyBytes: 64,
+	}
+	send := func(client, route, body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, route, strings.NewReader(body))
+		req.Header.Set("X-Client", client)
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		suite.srv.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the route limit applies before the global one
+	suite.Require().NotEqual(http.StatusTooManyRequests, send("a", Detokenize, "{}", false).Code)
+	rec := send("a", Detokenize, "{}", false)
+	suite.Require().Equal(http.StatusTooManyRequests, rec.Code)
+	suite.Require().Equal("60", rec.Header().Get("Retry-After"))
+	suite.Require().NotEqual(http.StatusTooManyRequests, send("a", Tokenize, "{}", false).Code)
+	suite.Require().Equal(http.StatusTooManyRequests, send("a", Tokenize, "{}", false).Code)
+
+	// a request refused by the global limit isn't charged against its route
+	suite.Require().NotEqual(http.StatusTooManyRequests, send("e", Tokenize, "{}", false).Code)
+	suite.Require().NotEqual(http.StatusTooManyRequests, send("e", Tokenize, "{}", false).Code)
+	suite.Require().Equal(http.StatusTooManyRequests, send("e", Detokenize, "{}", false).Code)
+	suite.srv.limits.global = ratelimit.New(ratelimit.Limit{Rate: 1.0 / 60, Burst: 2})
+	suite.Require().NotEqual(http.StatusTooManyRequests, send("e", Detokenize, "{}", false).Code)
+
+	// other clients, and probes, are unaffected
+	suite.Require().NotEqual(http.StatusTooManyRequests, send("b", Tokenize, "{}", false).Code)
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, Readyz, "", nil))
+
+	// bodies are bounded whether their length is announced or not
+	large := `{"id":"app","data":[{"key":"k","value":"` + strings.Repeat("x", 64) + `"}]}`
+	suite.Require().Equal(http.StatusRequestEntityTooLarge, send("c", Tokenize, large, false).Code)
+	rec = send("d", Tokenize, large, true)
+	suite.Require().Equal(http.StatusBadRequest, rec.Code)
+	suite.Require().Contains(rec.Body.String(), "request body too large")
+}
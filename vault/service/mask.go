@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/mask"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+var (
+	// HeaderRole is the role of the caller, which selects its masking policy. The service doesn't authenticate
+	// callers, so it is meant to be set by the proxy in front of it, which does.
+	HeaderRole = "X-Vault-Role"
+	// ParamMask is the masking policy requested by the caller, e.g. last:4. It can only be stricter than the policy of
+	// the caller's role.
+	ParamMask = "mask"
+)
+
+// maskPolicy returns the masking policy applying to the request, in its namespace
+func (s *Service) maskPolicy(r *http.Request) (mask.Policy, error) {
+	if t := tenantOf(r); t != nil {
+		return t.masks.Resolve(r.Header.Get(HeaderRole), r.URL.Query().Get(ParamMask))
+	}
+	return s.masks.Resolve(r.Header.Get(HeaderRole), r.URL.Query().Get(ParamMask))
+}
+
+// maskErrStatus maps the error resolving a masking policy to its HTTP status and response code
+func maskErrStatus(err error) (int, int) {
+	if errors.Is(err, mask.ErrPolicyNotAllowed) {
+		return http.StatusForbidden, CodeInvalidRequest
+	}
+	return http.StatusBadRequest, CodeInvalidRequest
+}
+
+// maskedDetokenizer masks the values detokenized by a manager
+type maskedDetokenizer struct {
+	m      *tokenize.Manager
+	policy mask.Policy
+}
+
+func (d maskedDetokenizer) Detokenize(ctx context.Context, key, token string) (bool, string, error) {
+	found, value, err := d.m.Detokenize(ctx, key, token)
+	if err != nil || !found {
+		return found, value, err
+	}
+	return found, d.policy.Apply(value), nil
+}
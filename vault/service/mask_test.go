@@ -0,0 +1,77 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/mask"
+	"github.com/dark-enstein/vault/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *TokensTestSuite) TestMasking() {
+	suite.srv.masks = &mask.Policies{
+		Default: mask.PolicyRedact,
+		Roles:   map[string]mask.Policy{"support": {Kind: mask.Last, N: 4}, "admin": mask.PolicyFull},
+	}
+	var token struct {
+		Resp model.PathResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/app/card", `{"value":"4111111111111234"}`, &token))
+
+	detokenize := func(role, policy string) (int, string) {
+		var resp struct {
+			Resp model.DetokenizeResponse `json:"resp"`
+		}
+		target := Detokenize
+		if policy != "" {
+			target += "?" + ParamMask + "=" + policy
+		}
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"id":"app","data":[{"key":"card","value":"`+token.Resp.Token+`"}]}`))
+		req.Header.Set(HeaderRole, role)
+		rec := httptest.NewRecorder()
+		suite.srv.mux.ServeHTTP(rec, req)
+		suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), &resp))
+		if len(resp.Resp.Data) == 0 {
+			return rec.Code, ""
+		}
+		return rec.Code, resp.Resp.Data[0].Value.Datum
+	}
+
+	for _, tt := range []struct {
+		role, policy string
+		code         int
+		datum        string
+	}{
+		{"admin", "", http.StatusOK, "4111111111111234"},
+		{"support", "", http.StatusOK, "************1234"},
+		{"support", "last:2", http.StatusOK, "**************34"},
+		{"", "", http.StatusOK, "********"},
+		{"support", "full", http.StatusForbidden, ""},
+		{"admin", "half", http.StatusBadRequest, ""},
+	} {
+		code, datum := detokenize(tt.role, tt.policy)
+		suite.Require().Equalf(tt.code, code, "unexpected status for role %q and policy %q", tt.role, tt.policy)
+		suite.Require().Equalf(tt.datum, datum, "unexpected datum for role %q and policy %q", tt.role, tt.policy)
+	}
+
+	// streams and documents are masked alike
+	req := httptest.NewRequest(http.MethodPost, DetokenizeStream, strings.NewReader(`{"key":"app/card","token":"`+token.Resp.Token+`"}`+"\n"))
+	req.Header.Set(HeaderRole, "support")
+	rec := httptest.NewRecorder()
+	suite.srv.mux.ServeHTTP(rec, req)
+	var line model.StreamResult
+	suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), &line))
+	suite.Require().Equal("************1234", line.Datum)
+
+	var doc struct {
+		Resp model.TransformResponse `json:"resp"`
+	}
+	body, _ := json.Marshal(model.Transform{ID: "app", Document: map[string]any{"card": token.Resp.Token}, Paths: []string{"$.card"}})
+	req = httptest.NewRequest(http.MethodPost, TransformDetokenize+"?"+ParamMask+"=first:4", strings.NewReader(string(body)))
+	req.Header.Set(HeaderRole, "admin")
+	rec = httptest.NewRecorder()
+	suite.srv.mux.ServeHTTP(rec, req)
+	suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), &doc))
+	suite.Require().Equal("4111************", doc.Resp.Document.(map[string]any)["card"])
+}
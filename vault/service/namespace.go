@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/mask"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/namespace"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"net/http"
+)
+
+var (
+	// HeaderNamespace selects the namespace a request operates in. Requests without it operate in the root namespace.
+	HeaderNamespace      = "X-Vault-Namespace"
+	ErrNamespaceNotFound = "namespace not found"
+)
+
+// namespaceKey is the context key of the namespace of a request
+type namespaceKey struct{}
+
+// tenant is a namespace served, with its own manager and masking policies
+type tenant struct {
+	name    string
+	manager *tokenize.Manager
+	masks   *mask.Policies
+}
+
+// namespaceConfig holds the namespaces served besides the root one
+type namespaceConfig struct {
+	// masks holds the role masking policies of each namespace, which override those of the root namespace
+	masks map[string]map[string]mask.Policy
+	// tenants holds every namespace served, once the service is created
+	tenants map[string]*tenant
+}
+
+// loadNamespaces creates the manager of every namespace served. Each sees its own view of base, the store shared by
+// every namespace, and has its own data key, next to the root one.
+func (s *Service) loadNamespaces(ctx context.Context, base store.Store) error {
+	s.namespaces.tenants = make(map[string]*tenant, len(s.namespaces.masks))
+	for name := range s.namespaces.masks {
+		if name == namespace.Root {
+			return fmt.Errorf("%w: the root namespace is always served", ErrInvalidRequestParameter)
+		}
+		view, err := namespace.Store(base, name)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequestParameter, err)
+		}
+
+		masks := &mask.Policies{Default: mask.PolicyFull, Roles: make(map[string]mask.Policy)}
+		if s.masks != nil {
+			masks.Default = s.masks.Default
+			for role, p := range s.masks.Roles {
+				masks.Roles[role] = p
+			}
+		}
+		for role, p := range s.namespaces.masks[name] {
+			masks.Roles[role] = p
+		}
+
+		s.namespaces.tenants[name] = &tenant{
+			name:    name,
+			manager: tokenize.NewManager(ctx, s.log, tokenize.WithStore(view), tokenize.WithCipherLoc(namespace.CipherLoc(s.manager.CipherLoc(), name)), tokenize.WithObserver(s.metrics.ObserveTokens), tokenize.WithEvents(s.events, name)),
+			masks:   masks,
+		}
+	}
+	return nil
+}
+
+// namespaced resolves the namespace of requests to h from their HeaderNamespace header. Requests to namespaces that
+// aren't served fail with 404 Not Found, rather than having one created on the fly.
+func (s *Service) namespaced(h func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	log := s.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(HeaderNamespace)
+		if name == namespace.Root {
+			h(w, r)
+			return
+		}
+
+		t, ok := s.namespaces.tenants[name]
+		if !ok {
+			var resp model.Response
+			resp.Error = append(resp.Error, fmt.Sprintf("%s: %q", ErrNamespaceNotFound, name))
+			log.Logger().Error().Msg(resp.Error[0])
+			resp.Code = CodeInvalidRequest
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), namespaceKey{}, t)))
+	}
+}
+
+// tenantOf returns the namespace of the request, or nil for the root namespace
+func tenantOf(r *http.Request) *tenant {
+	t, _ := r.Context().Value(namespaceKey{}).(*tenant)
+	return t
+}
+
+// managerOf returns the manager of the namespace of the request
+func (s *Service) managerOf(r *http.Request) *tokenize.Manager {
+	if t := tenantOf(r); t != nil {
+		return t.manager
+	}
+	return s.manager
+}
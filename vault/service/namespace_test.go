@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/mask"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/namespace"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+)
+
+func (suite *TokensTestSuite) TestNamespaces() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	base := store.NewSyncMap(ctx, log)
+	root, err := namespace.Store(base, namespace.Root)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.srv = &Service{log: log, mux: http.NewServeMux(), masks: &mask.Policies{Default: mask.PolicyFull, Roles: map[string]mask.Policy{"support": {Kind: mask.Last, N: 4}}}}
+	suite.srv.manager = tokenize.NewManager(ctx, log, tokenize.WithStore(root), tokenize.WithCipherLoc(cipherLoc))
+	WithNamespaces([]string{"search"})(suite.srv)
+	WithNamespaceMasks("payments", map[string]mask.Policy{"support": mask.PolicyRedact})(suite.srv)
+	suite.Require().NoError(suite.srv.loadNamespaces(ctx, base))
+	suite.srv.LoadHandlers(ctx)
+
+	in := func(ns, role, method, target, body string, resp any) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(HeaderNamespace, ns)
+		req.Header.Set(HeaderRole, role)
+		rec := httptest.NewRecorder()
+		suite.srv.mux.ServeHTTP(rec, req)
+		if resp != nil {
+			suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), resp))
+		}
+		return rec.Code
+	}
+
+	// the same path holds a value of its own in every namespace
+	tokens := map[string]string{}
+	for _, ns := range []string{"", "payments", "search"} {
+		var resp struct {
+			Resp model.PathResponse `json:"resp"`
+		}
+		suite.Require().Equal(http.StatusOK, in(ns, "", http.MethodPost, "/v1/tokens/app/card", `{"value":"4111-`+ns+`"}`, &resp))
+		tokens[ns] = resp.Resp.Token
+	}
+	for _, ns := range []string{"", "payments", "search"} {
+		var resp struct {
+			Resp model.PathResponse `json:"resp"`
+		}
+		suite.Require().Equal(http.StatusOK, in(ns, "", http.MethodGet, "/v1/tokens/app/card", "", &resp))
+		suite.Require().Equal(tokens[ns], resp.Resp.Token)
+	}
+
+	// tokens of a namespace don't detokenize in another, as its data key differs
+	var detoken struct {
+		Resp model.DetokenizeResponse `json:"resp"`
+	}
+	body := `{"id":"app","data":[{"key":"card","value":"` + tokens["payments"] + `"}]}`
+	suite.Require().Equal(http.StatusOK, in("payments", "", http.MethodPost, Detokenize, body, &detoken))
+	suite.Require().Equal("4111-payments", detoken.Resp.Data[0].Value.Datum)
+	suite.Require().NotEqual(http.StatusOK, in("search", "", http.MethodPost, Detokenize, body, nil))
+
+	// roles are masked by the policies of the namespace, falling back to those of the root namespace
+	suite.Require().Equal(http.StatusOK, in("payments", "support", http.MethodPost, Detokenize, body, &detoken))
+	suite.Require().Equal(mask.PolicyRedact.Apply("4111-payments"), detoken.Resp.Data[0].Value.Datum)
+	body = `{"id":"app","data":[{"key":"card","value":"` + tokens["search"] + `"}]}`
+	suite.Require().Equal(http.StatusOK, in("search", "support", http.MethodPost, Detokenize, body, &detoken))
+	suite.Require().Equal("*******arch", detoken.Resp.Data[0].Value.Datum)
+
+	// the root namespace neither lists nor touches the keys of other namespaces
+	var all struct {
+		Resp model.All `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, in("", "", http.MethodGet, GetTokens, "", &all))
+	suite.Require().Len(all.Resp.Tokens, 1)
+	suite.Require().Len(all.Resp.Tokens[0].Data, 1)
+	suite.Require().Equal(http.StatusBadRequest, in("", "", http.MethodPost, "/v1/tokens/_ns/payments/app/card", `{"value":"x"}`, nil))
+
+	suite.Require().Equal(http.StatusNotFound, in("unknown", "", http.MethodGet, "/v1/tokens/app/card", "", nil))
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	// HeaderIfMatch carries the revision a write expects the key to be at, as an entity tag, e.g. "3"
+	HeaderIfMatch = "If-Match"
+	// HeaderETag carries the revision of the key read, as an entity tag
+	HeaderETag = "ETag"
+	// ParamExpectedRevision is the query parameter alternative to If-Match
+	ParamExpectedRevision = "expected_revision"
+)
+
+var (
+	ErrIfMatchInvalid          = "If-Match must hold a single revision, e.g. \"3\""
+	ErrExpectedRevisionInvalid = "expected_revision must be a positive integer"
+	ErrRevisionsDisagree       = "If-Match and expected_revision disagree"
+)
+
+// expectedRevision returns the revision a write expects, from the If-Match header or from the request body. It returns
+// tokenize.AnyRevision if neither is set, and fails if both are set and differ.
+func expectedRevision(r *http.Request, body int64) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get(HeaderIfMatch))
+	if header == "" || header == "*" {
+		return body, nil
+	}
+
+	// strong and weak entity tags compare the same, as revisions identify the whole record
+	rev, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil || rev <= 0 {
+		return 0, errors.New(ErrIfMatchInvalid)
+	}
+	if body != tokenize.AnyRevision && body != rev {
+		return 0, errors.New(ErrRevisionsDisagree)
+	}
+	return rev, nil
+}
+
+// queryRevision returns the revision expected by the expected_revision query parameter, or tokenize.AnyRevision
+func queryRevision(r *http.Request) (int64, error) {
+	param := r.URL.Query().Get(ParamExpectedRevision)
+	if param == "" {
+		return tokenize.AnyRevision, nil
+	}
+	rev, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || rev <= 0 {
+		return 0, errors.New(ErrExpectedRevisionInvalid)
+	}
+	return rev, nil
+}
+
+// etag formats a revision as an entity tag
+func etag(rev int64) string {
+	return strconv.Quote(strconv.FormatInt(rev, 10))
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"github.com/dark-enstein/vault/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *TokensTestSuite) TestRevisions() {
+	var resp struct {
+		Resp model.PathResponse `json:"resp"`
+	}
+	code := suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"one"}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal(int64(1), resp.Resp.Revision)
+
+	// two writers read revision 1; the second one to write conflicts instead of clobbering the first
+	code = suite.do(http.MethodPatch, "/v1/tokens/app/db", `{"value":"two","expected_revision":1}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal(int64(2), resp.Resp.Revision)
+	suite.Require().Equal(http.StatusConflict, suite.do(http.MethodPatch, "/v1/tokens/app/db", `{"value":"three","expected_revision":1}`, nil))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, PatchToken, strings.NewReader(`{"id":"app","data":[{"key":"db","value":"three"}]}`))
+	req.Header.Set(HeaderIfMatch, `"1"`)
+	suite.srv.mux.ServeHTTP(rec, req)
+	suite.Require().Equal(http.StatusConflict, rec.Code)
+
+	rec = httptest.NewRecorder()
+	suite.srv.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tokens/app/db", nil))
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	suite.Require().Equal(`"2"`, rec.Header().Get(HeaderETag))
+
+	var all struct {
+		Resp model.All `json:"resp"`
+	}
+	suite.do(http.MethodGet, GetTokens, "", &all)
+	suite.Require().Equal(int64(2), all.Resp.Tokens[0].Data[0].Revision)
+
+	// detokenizing returns the revision to patch or delete at
+	var detoken struct {
+		Resp model.DetokenizeResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, Detokenize, `{"id":"app","data":[{"key":"db","value":"`+all.Resp.Tokens[0].Data[0].Value+`"}]}`, &detoken))
+	suite.Require().Equal(int64(2), detoken.Resp.Data[0].Value.Revision)
+
+	suite.Require().Equal(http.StatusConflict, suite.do(http.MethodDelete, DeleteToken+"?id=app/db&expected_revision=1", "", nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodDelete, "/v1/tokens/app/db?expected_revision=x", "", nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodDelete, "/v1/tokens/app/db?expected_revision=2", "", nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodGet, "/v1/tokens/app/db", "", nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodDelete, "/v1/tokens/app/db", "", nil))
+	all.Resp.Tokens = nil
+	suite.do(http.MethodGet, GetTokens, "", &all)
+	suite.Require().Empty(all.Resp.Tokens)
+
+	// a key created again continues from the revision it was deleted at, so writes expecting an earlier one fail
+	code = suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"four"}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal(int64(3), resp.Resp.Revision)
+	suite.Require().Equal(http.StatusConflict, suite.do(http.MethodPatch, "/v1/tokens/app/db", `{"value":"five","expected_revision":1}`, nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPatch, "/v1/tokens/app/db", `{"value":"five","expected_revision":3}`, &resp))
+	suite.Require().Equal(int64(4), resp.Resp.Revision)
+}
@@ -3,6 +3,14 @@ package service
 import (
 	"context"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/cache"
+	"github.com/dark-enstein/vault/internal/cluster"
+	"github.com/dark-enstein/vault/internal/events"
+	"github.com/dark-enstein/vault/internal/mask"
+	"github.com/dark-enstein/vault/internal/metrics"
+	"github.com/dark-enstein/vault/internal/namespace"
+	"github.com/dark-enstein/vault/internal/ratelimit"
+	"github.com/dark-enstein/vault/internal/secure"
 	"github.com/dark-enstein/vault/internal/store"
 	"github.com/dark-enstein/vault/internal/tokenize"
 	"github.com/dark-enstein/vault/internal/vlog"
@@ -17,10 +25,14 @@ const (
 	STORE_GOB   = "gob"
 	STORE_FILE  = "file"
 	STORE_MAP   = "map"
+	STORE_RAFT  = "raft"
 )
 
 var (
 	ErrInvalidRequestParameter = errors.New("invalid request parameter")
+	// ReadTimeout and WriteTimeout bound the time taken to read a request, and to write its response
+	ReadTimeout  = 10 * time.Second
+	WriteTimeout = 10 * time.Second
 )
 
 type Service struct {
@@ -30,6 +42,19 @@ type Service struct {
 	mux        *http.ServeMux
 	log        *vlog.Logger
 	storeStr   string
+	masks      *mask.Policies
+	metrics    *metrics.Metrics
+	limits     limitConfig
+	namespaces namespaceConfig
+	cluster    *cluster.Node
+	// events is the bus the managers of every namespace publish the changes of their keys on
+	events *events.Bus
+	// backupToken is the operator credential backups are served to. The backup route is off without it.
+	backupToken string
+	webhooks    struct {
+		hooks []events.Webhook
+		cfg   events.Config
+	}
 	fileConfig struct {
 		loc string
 	}
@@ -40,11 +65,21 @@ type Service struct {
 	redisConfig struct {
 		connectionString string
 	}
+	raftConfig struct {
+		cluster.Config
+		join string
+	}
+	cacheConfig struct {
+		cache.Config
+		enabled bool
+		channel string
+	}
 	syncMapConfig struct{}
 }
 
 func New(ctx context.Context, log *vlog.Logger, opts ...Options) (*Service, error) {
-	srv := &Service{sc: &StartConfig{port: port}, mux: http.NewServeMux(), log: log}
+	srv := &Service{sc: &StartConfig{port: port}, mux: http.NewServeMux(), log: log, masks: &mask.Policies{Default: mask.PolicyFull}, metrics: metrics.New(), events: events.NewBus(events.DefaultHistory)}
+	srv.limits.maxBodyBytes = DefaultMaxBodyBytes
 
 	// fill in the gaps in the struct
 	for i := 0; i < len(opts); i++ {
@@ -56,24 +91,60 @@ func New(ctx context.Context, log *vlog.Logger, opts ...Options) (*Service, erro
 	}
 
 	// resolve store type
-	store, err := srv.isInvalidStore(ctx)
+	backend, err := srv.isInvalidStore(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	srv.manager = tokenize.NewManager(ctx, srv.log, tokenize.WithStore(store))
+	// observe the store, and what goes through the manager
+	if backend == nil {
+		backend, srv.storeStr = store.NewSyncMap(ctx, srv.log), STORE_MAP
+	}
+	base := srv.metrics.Store(backend, srv.storeStr)
+	if srv.cacheConfig.enabled {
+		base = srv.cached(base, backend)
+	}
+	root, err := namespace.Store(base, namespace.Root)
+	if err != nil {
+		return nil, err
+	}
+	srv.manager = tokenize.NewManager(ctx, srv.log, tokenize.WithStore(root), tokenize.WithObserver(srv.metrics.ObserveTokens), tokenize.WithEvents(srv.events, namespace.Root))
+	srv.metrics.CountTokens(srv.manager.CountTokens)
+	if err = srv.loadNamespaces(ctx, base); err != nil {
+		return nil, err
+	}
+
+	// webhooks of namespaces that aren't served would silently never be called
+	for _, hook := range srv.webhooks.hooks {
+		if _, ok := srv.namespaces.tenants[hook.Filter.Namespace]; !ok && hook.Filter.Namespace != namespace.Root {
+			return nil, fmt.Errorf("%w: webhook %s is of namespace %q, which isn't served", ErrInvalidRequestParameter, hook.URL, hook.Filter.Namespace)
+		}
+	}
+
+	// limits of routes that aren't served would silently never apply
+	routes := NewVaultHandler(ctx, srv)
+	for route := range srv.limits.routes {
+		if _, ok := (*routes)[route]; !ok {
+			return nil, fmt.Errorf("%w: no route %s to rate limit", ErrInvalidRequestParameter, route)
+		}
+	}
 
 	log.Logger().Debug().Msg("generating service config")
-	readTimeout := 10 * time.Second
-	writeTimeout := 10 * time.Second
-	srv.srv = &http.Server{
-		Addr:                         ":" + port,
+	srv.srv = newHTTPServer(":" + srv.sc.port)
+	log.Logger().Debug().Msgf("initialized service with settings:\n\taddress: %v\n\tread timeout: %v\n\twrite timeout: %v\n", ":"+srv.sc.port, ReadTimeout, WriteTimeout)
+	return srv, nil
+}
+
+// newHTTPServer configures the HTTP server of the service, listening on addr. Streaming handlers clear its timeouts.
+func newHTTPServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:                         addr,
 		Handler:                      nil,
 		DisableGeneralOptionsHandler: false,
 		TLSConfig:                    nil,
-		ReadTimeout:                  readTimeout,
+		ReadTimeout:                  ReadTimeout,
 		ReadHeaderTimeout:            0,
-		WriteTimeout:                 writeTimeout,
+		WriteTimeout:                 WriteTimeout,
 		IdleTimeout:                  0,
 		MaxHeaderBytes:               1 << 20,
 		TLSNextProto:                 nil,
@@ -82,9 +153,6 @@ func New(ctx context.Context, log *vlog.Logger, opts ...Options) (*Service, erro
 		BaseContext:                  nil,
 		ConnContext:                  nil,
 	}
-
-	log.Logger().Debug().Msgf("initialized service with settings:\n\taddress: %v\n\tread timeout: %v\n\twrite timeout: %v\n", ":"+port, readTimeout, writeTimeout)
-	return srv, nil
 }
 
 type StartConfig struct {
@@ -97,15 +165,32 @@ func (s *Service) Port() string {
 
 func (s *Service) LoadHandlers(ctx context.Context) {
 	for k, v := range *NewVaultHandler(ctx, s) {
-		s.mux.HandleFunc(k, v)
+		s.mux.HandleFunc(k, s.metrics.Instrument(k, s.limit(k, s.namespaced(v))))
 	}
 }
 
 func (s *Service) Run(ctx context.Context) error {
+	// a crash mustn't write the keys and values in memory to disk
+	if err := secure.DisableCoreDumps(); err != nil {
+		s.log.Logger().Warn().Msgf("could not disable core dumps: %s", err)
+	}
 	// load handlers into mux
 	s.LoadHandlers(ctx)
 	// set mux into server
 	s.srv.Handler = s.mux
+	// deliver the changes of keys to webhooks for as long as the service runs
+	if len(s.webhooks.hooks) > 0 {
+		stop := events.Dispatch(ctx, s.events, s.webhooks.hooks, s.webhooks.cfg, s.log)
+		defer stop()
+	}
+	// join the cluster once the service is up, as the leader may need to reach it
+	if s.cluster != nil && s.raftConfig.join != "" {
+		go func() {
+			if err := s.cluster.JoinCluster(ctx, s.raftConfig.join); err != nil {
+				s.log.Logger().Error().Msgf("error joining cluster: %s", err)
+			}
+		}()
+	}
 	// start server
 	return s.srv.ListenAndServe()
 }
@@ -128,6 +213,9 @@ func (s *Service) isInvalidStore(ctx context.Context) (store.Store, error) {
 		val, err = store.NewGob(ctx, s.gobConfig.loc, s.log, s.gobConfig.trunc)
 	case STORE_MAP:
 		val = store.NewSyncMap(ctx, s.log)
+	case STORE_RAFT:
+		s.cluster, err = cluster.New(ctx, s.raftConfig.Config, s.log)
+		val = s.cluster
 	default:
 		return nil, fmt.Errorf("%w: input store invalid", ErrInvalidRequestParameter)
 	}
@@ -142,6 +230,20 @@ func (s *Service) SetDefaults() {
 	}
 }
 
+// cached wraps st, the observed store of backend, with a cache. Caches in front of Redis are kept coherent with those
+// of the other instances sharing it over pub/sub.
+func (s *Service) cached(st store.Store, backend store.Store) store.Store {
+	cfg := s.cacheConfig.Config
+	if r, ok := backend.(*store.Redis); ok {
+		cfg.Invalidator = cache.NewRedisInvalidator(r.Client, s.cacheConfig.channel, s.log)
+	}
+	c := cache.New(st, cfg)
+	if s.metrics != nil {
+		s.metrics.CacheStats(c.Stats)
+	}
+	return c
+}
+
 type Options func(*Service)
 
 func WithFileLoc(loc string) Options {
@@ -168,8 +270,129 @@ func WithRedisConnString(connStr string) Options {
 	}
 }
 
+// WithPort serves the service on port
+func WithPort(port string) Options {
+	return func(s *Service) {
+		s.sc.port = port
+	}
+}
+
+// WithCache caches up to size values read from the store, for up to ttl. Defaults apply to values that aren't positive.
+func WithCache(size int, ttl time.Duration) Options {
+	return func(s *Service) {
+		s.cacheConfig.enabled = true
+		s.cacheConfig.Size, s.cacheConfig.TTL = size, ttl
+	}
+}
+
+// WithCacheChannel publishes the cache invalidations of a Redis store on channel, rather than cache.DefaultChannel
+func WithCacheChannel(channel string) Options {
+	return func(s *Service) {
+		s.cacheConfig.channel = channel
+	}
+}
+
+// WithRaft replicates the store across the nodes of a cluster, as the node configured by cfg
+func WithRaft(cfg cluster.Config) Options {
+	return func(s *Service) {
+		s.raftConfig.Config = cfg
+	}
+}
+
+// WithRaftJoin joins the cluster through the vault service at addr, once the service runs
+func WithRaftJoin(addr string) Options {
+	return func(s *Service) {
+		s.raftConfig.join = addr
+	}
+}
+
 func WithStoreStr(storeStr string) Options {
 	return func(s *Service) {
 		s.storeStr = storeStr
 	}
 }
+
+// WithMaskDefault sets the masking policy of callers without a known role. Values are revealed whole by default.
+func WithMaskDefault(p mask.Policy) Options {
+	return func(s *Service) {
+		s.masks.Default = p
+	}
+}
+
+// WithMaskRoles sets the masking policy of each caller role, named by the HeaderRole header
+func WithMaskRoles(roles map[string]mask.Policy) Options {
+	return func(s *Service) {
+		s.masks.Roles = roles
+	}
+}
+
+// WithNamespaces serves the namespaces names, besides the root one, with the masking policies of the root namespace
+func WithNamespaces(names []string) Options {
+	return func(s *Service) {
+		if s.namespaces.masks == nil {
+			s.namespaces.masks = make(map[string]map[string]mask.Policy)
+		}
+		for _, name := range names {
+			if _, ok := s.namespaces.masks[name]; !ok {
+				s.namespaces.masks[name] = nil
+			}
+		}
+	}
+}
+
+// WithNamespaceMasks serves the namespace name, with the masking policy of each role in roles overriding that of the
+// root namespace
+func WithNamespaceMasks(name string, roles map[string]mask.Policy) Options {
+	return func(s *Service) {
+		if s.namespaces.masks == nil {
+			s.namespaces.masks = make(map[string]map[string]mask.Policy)
+		}
+		s.namespaces.masks[name] = roles
+	}
+}
+
+// WithWebhooks posts the changes of keys matching each of hooks to it, delivered as configured by cfg
+func WithWebhooks(hooks []events.Webhook, cfg events.Config) Options {
+	return func(s *Service) {
+		s.webhooks.hooks, s.webhooks.cfg = hooks, cfg
+	}
+}
+
+// WithBackupToken serves backups of the vault to callers presenting token in the HeaderBackupToken header. Backups
+// hold every token and the data key, so the backup route is off unless a token is set.
+func WithBackupToken(token string) Options {
+	return func(s *Service) {
+		s.backupToken = token
+	}
+}
+
+// WithRateLimit limits the requests of every client across all routes
+func WithRateLimit(l ratelimit.Limit) Options {
+	return func(s *Service) {
+		s.limits.global = ratelimit.New(l)
+	}
+}
+
+// WithRouteLimits limits the requests of every client on each route, on top of WithRateLimit
+func WithRouteLimits(routes map[string]ratelimit.Limit) Options {
+	return func(s *Service) {
+		s.limits.routes = make(map[string]*ratelimit.Limiter, len(routes))
+		for route, l := range routes {
+			s.limits.routes[route] = ratelimit.New(l)
+		}
+	}
+}
+
+// WithClientHeader identifies clients by the value of header, as set by a trusted proxy, rather than by their IP address
+func WithClientHeader(header string) Options {
+	return func(s *Service) {
+		s.limits.clientHeader = header
+	}
+}
+
+// WithMaxBodyBytes refuses request bodies larger than n bytes, or none if n isn't positive
+func WithMaxBodyBytes(n int64) Options {
+	return func(s *Service) {
+		s.limits.maxBodyBytes = n
+	}
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+func (suite *TokensTestSuite) TestMetrics() {
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, Tokenize, `{"id":"app","data":[{"key":"a","value":"1"},{"key":"b","value":"2"}]}`, nil))
+	suite.Require().Equal(http.StatusInternalServerError, suite.do(http.MethodPost, Detokenize, `{"id":"app","data":[{"key":"a","value":"wrong"}]}`, nil))
+
+	rec := httptest.NewRecorder()
+	suite.srv.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Metrics, nil))
+	suite.Require().Equal(http.StatusOK, rec.Code)
+	out := rec.Body.String()
+	suite.Require().Contains(out, `vault_http_requests_total{code="200",handler="/tokenize",method="POST"} 1`)
+	suite.Require().Contains(out, `vault_http_requests_total{code="500",handler="/detokenize",method="POST"} 1`)
+	suite.Require().Contains(out, `vault_token_operations_total{op="tokenize",result="success"} 2`)
+	suite.Require().Contains(out, `vault_token_operations_total{op="detokenize",result="failure"} 1`)
+	suite.Require().Contains(out, `vault_store_operation_duration_seconds_count{backend="map",op="batch"} 1`)
+	suite.Require().Contains(out, "vault_tokens 2")
+}
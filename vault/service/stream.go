@@ -0,0 +1,93 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/stream"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// DetokenizeStream detokenizes newline-delimited JSON, a line at a time
+	DetokenizeStream = "/v1/detokenize/stream"
+	// ParamConcurrency is the number of lines detokenized at once
+	ParamConcurrency = "concurrency"
+	// ContentTypeNDJSON is the content type of newline-delimited JSON
+	ContentTypeNDJSON = "application/x-ndjson"
+)
+
+// DetokenizeStreamHandlerFunc detokenizes a stream of model.StreamLine, and streams a model.StreamResult back per line,
+// in the same order. Lines fail on their own without aborting the stream. The request is only read as fast as the
+// caller reads the response.
+func DetokenizeStreamHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", DetokenizeStream))
+		var resp model.Response
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			resp.Error = append(resp.Error, ErrMethodNotAllowed+": "+r.Method)
+			log.Logger().Error().Msg(ErrMethodNotAllowed)
+			resp.Code = CodeMethodNotAllowed
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		opts := stream.Options{}
+		if c := r.URL.Query().Get(ParamConcurrency); c != "" {
+			var err error
+			if opts.Concurrency, err = strconv.Atoi(c); err != nil || opts.Concurrency < 1 || opts.Concurrency > stream.MaxConcurrency {
+				resp.Error = append(resp.Error, stream.ErrConcurrencyRange.Error())
+				log.Logger().Error().Msg(stream.ErrConcurrencyRange.Error())
+				resp.Code = CodeInvalidRequest
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+		}
+		policy, err := srv.maskPolicy(r)
+		if err != nil {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			status, code := maskErrStatus(err)
+			resp.Code = code
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		defer r.Body.Close()
+
+		// results are written while the request is still being read, for as long as the stream lasts: it outlives the
+		// read and write timeouts of the server
+		rc := http.NewResponseController(w)
+		if err := rc.EnableFullDuplex(); err != nil {
+			log.Logger().Debug().Msgf("full duplex not supported: %s", err)
+		}
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			log.Logger().Debug().Msgf("read deadline not supported: %s", err)
+		}
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Logger().Debug().Msgf("write deadline not supported: %s", err)
+		}
+		opts.Flush = func() {
+			rc.Flush()
+		}
+
+		w.Header().Set("Content-Type", ContentTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+
+		// cancel the stream if the caller goes away
+		n, err := stream.Detokenize(r.Context(), maskedDetokenizer{srv.managerOf(r), policy}, r.Body, w, opts)
+		if err != nil {
+			log.Logger().Error().Msgf("detokenize stream stopped after %d lines: %s", n, err)
+			return
+		}
+		log.Logger().Debug().Msgf("detokenized stream of %d lines", n)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func (suite *TokensTestSuite) TestDetokenizeStream() {
+	var resp struct {
+		Resp model.PathResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"hunter2"}`, &resp))
+
+	srv := httptest.NewServer(suite.srv.mux)
+	defer srv.Close()
+	body := `{"key":"app/db","token":"` + resp.Resp.Token + `"}` + "\n" + `{"key":"app/missing","token":"x"}` + "\n"
+	res, err := http.Post(srv.URL+DetokenizeStream+"?concurrency=2", ContentTypeNDJSON, strings.NewReader(body))
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer res.Body.Close()
+	suite.Require().Equal(http.StatusOK, res.StatusCode)
+	suite.Require().Equal(ContentTypeNDJSON, res.Header.Get("Content-Type"))
+
+	dec := json.NewDecoder(res.Body)
+	var first, second model.StreamResult
+	suite.Require().NoError(dec.Decode(&first))
+	suite.Require().NoError(dec.Decode(&second))
+	suite.Require().Equal("hunter2", first.Datum)
+	suite.Require().Equal(2, second.Line)
+	suite.Require().NotEmpty(second.Error)
+
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, DetokenizeStream+"?concurrency=0", "", nil))
+}
+
+func (suite *TokensTestSuite) TestDetokenizeStreamTimeouts() {
+	var resp struct {
+		Resp model.PathResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"hunter2"}`, &resp))
+
+	// the server of the service, with its timeouts shortened, which the stream outlasts
+	defer func(read, write time.Duration) { ReadTimeout, WriteTimeout = read, write }(ReadTimeout, WriteTimeout)
+	ReadTimeout, WriteTimeout = 200*time.Millisecond, 200*time.Millisecond
+	srv := httptest.NewUnstartedServer(suite.srv.mux)
+	srv.Config = newHTTPServer("")
+	srv.Config.Handler = suite.srv.mux
+	srv.Start()
+	defer srv.Close()
+
+	// the lines are sent over longer than the timeouts
+	body, lines := io.Pipe()
+	go func() {
+		defer lines.Close()
+		for i := 0; i < 3; i++ {
+			time.Sleep(150 * time.Millisecond)
+			if _, err := io.WriteString(lines, `{"key":"app/db","token":"`+resp.Resp.Token+`"}`+"\n"); err != nil {
+				return
+			}
+		}
+	}()
+
+	res, err := http.Post(srv.URL+DetokenizeStream, ContentTypeNDJSON, body)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	defer res.Body.Close()
+	dec := json.NewDecoder(res.Body)
+	for i := 1; i <= 3; i++ {
+		var result model.StreamResult
+		suite.Require().NoErrorf(dec.Decode(&result), "expected line %d to be streamed", i)
+		suite.Require().Equal("hunter2", result.Datum)
+	}
+}
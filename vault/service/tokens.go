@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrPathValueOrDocument     = "exactly one of value or document must be set"
+	ErrPathTooShort            = "path must hold an id and at least one child key, e.g. /v1/tokens/app/db/password"
+	ErrPathRevisionUnsupported = "an expected revision is only supported when patching or deleting a single value"
+)
+
+// TokensPathHandlerFunc serves tokens by path. The path below TokensPath is the encoded key path: segments containing
+// '/' must escape it as %2F.
+//
+//	GET    returns the token stored at the path and its revision as ETag, or the document of tokens nested under it
+//	POST   tokenizes a value, or a document nested under the path. Existing keys are not overridden
+//	PATCH  re-tokenizes a value, or a document nested under the path
+//	DELETE deletes the token stored at the path, or every token nested under it
+//
+// PATCH and DELETE of a value accept the revision it must be at, as If-Match or expected_revision, and fail with 409
+// Conflict if it moved on.
+func TokensPathHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", TokensPath))
+		ctx := context.Background()
+		var resp model.Response
+
+		w.Header().Set("Content-Type", "application/json")
+		fail := func(status, code int, errs ...string) {
+			resp.Error = append(resp.Error, errs...)
+			log.Logger().Error().Msg(strings.Join(errs, "; "))
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		path, err := keys.Normalize(strings.TrimPrefix(r.URL.EscapedPath(), TokensPath))
+		if err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+
+		manager := srv.managerOf(r)
+		switch r.Method {
+		case http.MethodGet:
+			if token, rev, err := manager.GetToken(ctx, path); err == nil {
+				w.Header().Set(HeaderETag, etag(rev))
+				resp.Resp = &model.PathResponse{Path: path, Token: token, Revision: rev}
+				break
+			}
+			doc, err := manager.GetDocument(ctx, path)
+			if err != nil {
+				fail(http.StatusNotFound, CodeInvalidRequest, err.Error())
+				return
+			}
+			pathResp := &model.PathResponse{Path: path}
+			if token, ok := doc.(string); ok {
+				pathResp.Token = token
+			} else {
+				pathResp.Document = doc
+			}
+			resp.Resp = pathResp
+
+		case http.MethodDelete:
+			expected, err := queryRevision(r)
+			if err == nil {
+				expected, err = expectedRevision(r, expected)
+			}
+			if err != nil {
+				fail(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+				return
+			}
+
+			// a revision only identifies a single value, not a document
+			var deleted []string
+			if expected != tokenize.AnyRevision {
+				if _, err = manager.DeleteTokenIf(ctx, path, expected); err == nil {
+					deleted = []string{path}
+				}
+			} else {
+				deleted, err = manager.DeleteByPath(ctx, path)
+			}
+			if errors.Is(err, tokenize.ErrRevisionMismatch) {
+				fail(http.StatusConflict, CodeInvalidRequest, err.Error())
+				return
+			}
+			if err != nil {
+				fail(http.StatusNotFound, CodeInvalidRequest, err.Error())
+				return
+			}
+			resp.Resp = &model.TokenizeResponse{ID: path, Data: relChildren(path, deleted)}
+
+		case http.MethodPost, http.MethodPatch:
+			var req model.Path
+			jsonDecoder := json.NewDecoder(r.Body)
+			jsonDecoder.DisallowUnknownFields()
+			defer r.Body.Close()
+			if err = jsonDecoder.Decode(&req); err != nil {
+				fail(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+				return
+			}
+
+			token, err := pathRequest(path, &req)
+			if err != nil {
+				fail(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+				return
+			}
+
+			// only creation refuses existing keys, and only patches of a value can expect a revision
+			patch := r.Method == http.MethodPatch
+			expected, err := expectedRevision(r, req.ExpectedRevision)
+			if err == nil && expected != tokenize.AnyRevision && (!patch || req.Value == nil) {
+				err = errors.New(ErrPathRevisionUnsupported)
+			}
+			if err != nil {
+				fail(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+				return
+			}
+
+			if validationResp, ok := manager.Validate(ctx, token, patch); !ok {
+				var errs []string
+				for i := 0; i < len(validationResp); i++ {
+					errs = append(errs, fmt.Sprintf("error with key %s: %s", validationResp[i].Key, validationResp[i].Err))
+				}
+				fail(http.StatusBadRequest, CodeInvalidRequest, errs...)
+				return
+			}
+
+			entries := make([]tokenize.Entry, 0, len(token.Data))
+			for i := 0; i < len(token.Data); i++ {
+				combinedKeyName, err := tokenize.ChildKey(token.ID, token.Data[i].Key)
+				if err != nil {
+					fail(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("error with key %s: %s", token.Data[i].Key, err))
+					return
+				}
+				entries = append(entries, tokenize.Entry{Key: combinedKeyName, Value: token.Data[i].Value, Revision: expected, Labels: req.Labels})
+			}
+
+			// commit the whole document, or none of it
+			receipts, err := manager.TokenizeBatch(ctx, entries, patch)
+			if err != nil {
+				status, code := batchErrStatus(err)
+				fail(status, code, fmt.Sprintf("error with path %s: %s", path, err))
+				return
+			}
+			var children []model.Child
+			for i := 0; i < len(token.Data); i++ {
+				children = append(children, model.Child{Key: token.Data[i].Key, Value: receipts[i].Token, Revision: receipts[i].Revision})
+			}
+
+			pathResp := &model.PathResponse{Path: path}
+			if req.Value != nil {
+				pathResp.Token, pathResp.Revision = children[0].Value, children[0].Revision
+				w.Header().Set(HeaderETag, etag(pathResp.Revision))
+			} else if pathResp.Document, err = tokenize.NestChildren(children); err != nil {
+				fail(http.StatusInternalServerError, CodeInternalServerError, err.Error())
+				return
+			}
+			resp.Resp = pathResp
+
+		default:
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, ErrMethodNotAllowed+": "+r.Method)
+			return
+		}
+
+		resp.Code = CodeSuccess
+
+		// set header and return
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// pathRequest maps a write to path onto the model.Tokenize it is equivalent to
+func pathRequest(path string, req *model.Path) (*model.Tokenize, error) {
+	if (req.Value == nil) == (req.Document == nil) {
+		return nil, errors.New(ErrPathValueOrDocument)
+	}
+
+	// a document is nested under the path
+	if req.Document != nil {
+		children, err := tokenize.DocumentChildren(req.Document)
+		if err != nil {
+			return nil, err
+		}
+		return &model.Tokenize{ID: path, Data: children}, nil
+	}
+
+	// a value is stored at the path itself, as the last child of its parent
+	parent, ok := keys.Parent(path)
+	if !ok {
+		return nil, errors.New(ErrPathTooShort)
+	}
+	return &model.Tokenize{ID: parent, Data: []model.Child{{Key: strings.TrimPrefix(path, parent+keys.Delimiter), Value: *req.Value}}}, nil
+}
+
+// relChildren lists deleted keys relative to path
+func relChildren(path string, deleted []string) []model.Child {
+	children := make([]model.Child, 0, len(deleted))
+	for _, key := range deleted {
+		rel, ok := keys.Rel(path, key)
+		if !ok {
+			rel = key
+		}
+		children = append(children, model.Child{Key: rel})
+	}
+	return children
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/metrics"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type TokensTestSuite struct {
+	suite.Suite
+	srv *Service
+}
+
+func (suite *TokensTestSuite) SetupTest() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	cipherLoc := filepath.Join(suite.T().TempDir(), ".cipher")
+	suite.srv = &Service{log: log, mux: http.NewServeMux(), metrics: metrics.New()}
+	s := suite.srv.metrics.Store(store.NewSyncMap(ctx, log), STORE_MAP)
+	suite.srv.manager = tokenize.NewManager(ctx, log, tokenize.WithStore(s), tokenize.WithCipherLoc(cipherLoc), tokenize.WithObserver(suite.srv.metrics.ObserveTokens))
+	suite.srv.metrics.CountTokens(suite.srv.manager.CountTokens)
+	suite.srv.LoadHandlers(ctx)
+}
+
+// do sends a request to the service, and decodes the response into resp
+func (suite *TokensTestSuite) do(method, target, body string, resp any) int {
+	rec := httptest.NewRecorder()
+	suite.srv.mux.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	if resp != nil {
+		suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), resp))
+	}
+	return rec.Code
+}
+
+func (suite *TokensTestSuite) TestTokenizeDocument() {
+	var resp struct {
+		Resp model.TokenizeResponse `json:"resp"`
+	}
+	code := suite.do(http.MethodPost, Tokenize, `{"id":"app","document":{"db":{"password":"hunter2","user":"admin"},"a/b":"escaped"}}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	db, ok := resp.Resp.Document["db"].(map[string]any)
+	suite.Require().True(ok)
+	suite.Require().NotEmpty(db["password"])
+
+	var detoken struct {
+		Resp model.DetokenizeResponse `json:"resp"`
+	}
+	body, _ := json.Marshal(model.Detokenize{ID: "app", Document: map[string]any{"db": map[string]any{"password": db["password"]}}})
+	code = suite.do(http.MethodPost, Detokenize, string(body), &detoken)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal("hunter2", detoken.Resp.Document["db"].(map[string]any)["password"].(map[string]any)["datum"])
+
+	// keys containing the old delimiter no longer corrupt the hierarchy
+	code = suite.do(http.MethodPost, Tokenize, `{"id":"env","data":[{"key":"DB__PASSWORD","value":"x"}]}`, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	var all struct {
+		Resp model.All `json:"resp"`
+	}
+	suite.do(http.MethodGet, GetTokens, "", &all)
+	suite.Require().Len(all.Resp.Tokens, 2)
+	suite.Require().Equal("env", all.Resp.Tokens[1].ID)
+	suite.Require().Equal("DB__PASSWORD", all.Resp.Tokens[1].Data[0].Key)
+}
+
+func (suite *TokensTestSuite) TestPathAccess() {
+	var resp struct {
+		Resp model.PathResponse `json:"resp"`
+	}
+	code := suite.do(http.MethodPost, "/v1/tokens/app/db/password", `{"value":"hunter2"}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	token := resp.Resp.Token
+	suite.Require().NotEmpty(token)
+
+	// existing keys, and values nested under values, are refused on creation
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, "/v1/tokens/app/db/password", `{"value":"again"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, "/v1/tokens/app/db/password/x", `{"value":"nested"}`, nil))
+	// nor can values be stored over keys nested under them
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"over"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, "/v1/tokens/app", `{"value":"over"}`, nil))
+
+	code = suite.do(http.MethodPost, "/v1/tokens/app/a%2Fb", `{"document":{"k":"v"}}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+
+	code = suite.do(http.MethodGet, "/v1/tokens/app/db/password", "", &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal(token, resp.Resp.Token)
+
+	code = suite.do(http.MethodGet, "/v1/tokens/app", "", &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	doc := resp.Resp.Document.(map[string]any)
+	suite.Require().Equal(token, doc["db"].(map[string]any)["password"])
+	suite.Require().Contains(doc, "a/b")
+
+	code = suite.do(http.MethodPatch, "/v1/tokens/app/db/password", `{"value":"hunter3"}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().NotEqual(token, resp.Resp.Token)
+
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodDelete, "/v1/tokens/app/db", "", nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodGet, "/v1/tokens/app/db/password", "", nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, "/v1/tokens/app", `{"value":"too short"}`, nil))
+}
+
+func (suite *TokensTestSuite) TestLegacyKeys() {
+	ctx := context.Background()
+	log := vlog.New(true)
+	var tokens struct {
+		Resp model.TokenizeResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, Tokenize, `{"id":"seed","data":[{"key":"pw","value":"hunter2"}]}`, &tokens))
+	token := tokens.Resp.Data[0].Value
+
+	// a store written before paths were introduced holds bare tokens under id__key
+	s := store.NewSyncMap(ctx, log)
+	suite.Require().NoError(s.Store(ctx, "legacy__pw", token))
+	suite.Require().NoError(s.Store(ctx, "legacy__db__user", token))
+	suite.srv.manager = tokenize.NewManager(ctx, log, tokenize.WithStore(s), tokenize.WithCipherLoc(suite.srv.manager.CipherLoc()))
+	suite.srv.mux = http.NewServeMux()
+	suite.srv.LoadHandlers(ctx)
+
+	// keys are only migrated on demand, never by creating a manager
+	_, err := s.Retrieve(ctx, "legacy__pw")
+	suite.Require().NoError(err)
+	n, err := suite.srv.manager.MigrateLegacyKeys(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(2, n)
+	_, err = s.Retrieve(ctx, "legacy__pw")
+	suite.Require().Error(err, "expected legacy keys to be migrated")
+	var detoken struct {
+		Resp model.DetokenizeResponse `json:"resp"`
+	}
+	body := `{"id":"legacy","data":[{"key":"pw","value":"` + token + `"},{"key":"db/user","value":"` + token + `"}]}`
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, Detokenize, body, &detoken))
+	suite.Require().Equal("hunter2", detoken.Resp.Data[0].Value.Datum)
+	suite.Require().Equal("hunter2", detoken.Resp.Data[1].Value.Datum)
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPatch, "/v1/tokens/legacy/pw", `{"value":"hunter3"}`, nil))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodDelete, "/v1/tokens/legacy/db", "", nil))
+
+	// keys whose path is taken are left alone
+	suite.Require().NoError(s.Store(ctx, "legacy__pw", token))
+	n, err = suite.srv.manager.MigrateLegacyKeys(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Zero(n)
+	_, err = s.Retrieve(ctx, "legacy__pw")
+	suite.Require().NoError(err)
+
+	// a key copied to its path by a run that stopped before deleting it is still moved
+	suite.Require().NoError(s.Store(ctx, "legacy__api", token))
+	suite.Require().NoError(s.Store(ctx, "legacy/api", token))
+	n, err = suite.srv.manager.MigrateLegacyKeys(ctx)
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(1, n)
+	_, err = s.Retrieve(ctx, "legacy__api")
+	suite.Require().Error(err)
+}
+
+func (suite *TokensTestSuite) TestPatchIsAtomic() {
+	var resp struct {
+		Resp model.TokenizeResponse `json:"resp"`
+	}
+	code := suite.do(http.MethodPost, Tokenize, `{"id":"app","data":[{"key":"db","value":"one"}]}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	token := resp.Resp.Data[0].Value
+
+	// the missing key aborts the whole patch
+	code = suite.do(http.MethodPatch, PatchToken, `{"id":"app","data":[{"key":"db","value":"two"},{"key":"missing","value":"x"}]}`, nil)
+	suite.Require().Equal(http.StatusBadRequest, code)
+	stored, _, err := suite.srv.manager.GetToken(context.Background(), "app/db")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(token, stored)
+}
+
+func (suite *TokensTestSuite) TestListTokens() {
+	code := suite.do(http.MethodPost, Tokenize, `{"id":"app","data":[{"key":"a","value":"1","labels":{"env":"prod"}},{"key":"b","value":"2","labels":{"env":"dev"}},{"key":"c","value":"3"}]}`, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	code = suite.do(http.MethodPost, Tokenize, `{"id":"web","data":[{"key":"a","value":"4","labels":{"env":"prod"}}]}`, nil)
+	suite.Require().Equal(http.StatusOK, code)
+
+	var all struct {
+		Resp model.All `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, GetTokens+"?limit=2", "", &all))
+	suite.Require().Len(all.Resp.Tokens, 1)
+	suite.Require().Len(all.Resp.Tokens[0].Data, 2)
+	suite.Require().Equal(map[string]string{"env": "prod"}, all.Resp.Tokens[0].Data[0].Labels)
+	suite.Require().NotEmpty(all.Resp.Next)
+
+	next := all.Resp.Next
+	all.Resp = model.All{}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, GetTokens+"?limit=2&cursor="+next, "", &all))
+	suite.Require().Equal("app", all.Resp.Tokens[0].ID)
+	suite.Require().Equal("c", all.Resp.Tokens[0].Data[0].Key)
+	suite.Require().Equal("web", all.Resp.Tokens[1].ID)
+	suite.Require().Empty(all.Resp.Next)
+
+	// labels are kept through patches that don't set them
+	code = suite.do(http.MethodPatch, PatchToken, `{"id":"app","data":[{"key":"a","value":"5"}]}`, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	all.Resp = model.All{}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, GetTokens+"?selector=env%3Dprod&keys_only=true", "", &all))
+	suite.Require().Equal([]string{"app/a", "web/a"}, all.Resp.Keys)
+	suite.Require().Empty(all.Resp.Tokens)
+
+	all.Resp = model.All{}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, GetTokens+"?prefix=web/&keys_only=true", "", &all))
+	suite.Require().Equal([]string{"web/a"}, all.Resp.Keys)
+
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodGet, GetTokens+"?limit=0", "", nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodGet, GetTokens+"?selector=a%20b", "", nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodGet, GetTokens+"?cursor=%25", "", nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, Tokenize, `{"id":"x","data":[{"key":"a","value":"1","labels":{"":"x"}}]}`, nil))
+}
+
+// TestTokensSuite tests the Tokens suite
+func TestTokensSuite(t *testing.T) {
+	suite.Run(t, new(TokensTestSuite))
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/mask"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/transform"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+var (
+	// TransformTokenize tokenizes the fields of a JSON document selected by JSONPath
+	TransformTokenize = "/v1/transform/tokenize"
+	// TransformDetokenize reverses TransformTokenize
+	TransformDetokenize = "/v1/transform/detokenize"
+)
+
+// TransformTokenizeHandlerFunc returns a model.Transform document with the fields selected by its paths tokenized, and
+// the rest of its structure preserved
+func TransformTokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return transformHandlerFunc(srv, TransformTokenize, func(ctx context.Context, r *http.Request, id string, doc any, paths []transform.Path) ([]string, error) {
+		return transform.Tokenize(ctx, srv.managerOf(r), id, doc, paths)
+	})
+}
+
+// TransformDetokenizeHandlerFunc returns a model.Transform document with the tokens selected by its paths detokenized,
+// and masked as the caller's masking policy requires
+func TransformDetokenizeHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return transformHandlerFunc(srv, TransformDetokenize, func(ctx context.Context, r *http.Request, id string, doc any, paths []transform.Path) ([]string, error) {
+		policy, err := srv.maskPolicy(r)
+		if err != nil {
+			return nil, err
+		}
+		return transform.Detokenize(ctx, maskedDetokenizer{srv.managerOf(r), policy}, id, doc, paths)
+	})
+}
+
+// transformHandlerFunc serves a transformation of documents on route
+func transformHandlerFunc(srv *Service, route string, fn func(context.Context, *http.Request, string, any, []transform.Path) ([]string, error)) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", route))
+		ctx := context.Background()
+		var resp model.Response
+		var req model.Transform
+
+		w.Header().Set("Content-Type", "application/json")
+		fail := func(status, code int, err error) {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if r.Method != http.MethodPost {
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, errors.New(ErrMethodNotAllowed+": "+r.Method))
+			return
+		}
+
+		// keep numbers as they were sent
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		jsonDecoder.UseNumber()
+		defer r.Body.Close()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+
+		paths, err := transform.ParsePaths(req.Paths)
+		if err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+
+		fields, err := fn(ctx, r, req.ID, req.Document, paths)
+		if err != nil {
+			fail(transformErrStatus(err))
+			return
+		}
+
+		resp.Resp = &model.TransformResponse{ID: req.ID, Document: req.Document, Fields: fields}
+		resp.Code = CodeSuccess
+
+		// set header and return
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// transformErrStatus maps the error of a transformation to its HTTP status and response code, and returns it along
+func transformErrStatus(err error) (int, int, error) {
+	switch {
+	case errors.Is(err, transform.ErrIDRequired), errors.Is(err, transform.ErrPathsRequired),
+		errors.Is(err, transform.ErrFieldInvalid), errors.Is(err, transform.ErrFieldUnresolved):
+		return http.StatusBadRequest, CodeInvalidRequest, err
+	case errors.Is(err, mask.ErrPolicyInvalid), errors.Is(err, mask.ErrPolicyNotAllowed):
+		status, code := maskErrStatus(err)
+		return status, code, err
+	default:
+		status, code := batchErrStatus(err)
+		return status, code, err
+	}
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/dark-enstein/vault/internal/model"
+	"net/http"
+)
+
+func (suite *TokensTestSuite) TestTransform() {
+	var resp struct {
+		Resp model.TransformResponse `json:"resp"`
+	}
+	code := suite.do(http.MethodPost, TransformTokenize, `{"id":"order-1","document":{"email":"ada@example.com","total":12.50,"items":[{"card":4111}]},"paths":["$.email","$.items[*].card"]}`, &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Equal([]string{"email", "items/0/card"}, resp.Resp.Fields)
+	doc := resp.Resp.Document.(map[string]any)
+	suite.Require().NotEqual("ada@example.com", doc["email"])
+	suite.Require().Equal(12.5, doc["total"])
+
+	body, _ := json.Marshal(model.Transform{ID: "order-1", Document: doc, Paths: []string{"$.email", "$.items[*].card"}})
+	code = suite.do(http.MethodPost, TransformDetokenize, string(body), &resp)
+	suite.Require().Equal(http.StatusOK, code)
+	doc = resp.Resp.Document.(map[string]any)
+	suite.Require().Equal("ada@example.com", doc["email"])
+	suite.Require().Equal("4111", doc["items"].([]any)[0].(map[string]any)["card"])
+
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TransformTokenize, `{"id":"order-2","document":{},"paths":["email"]}`, nil))
+	// fields can't be nested under values already stored
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TransformTokenize, `{"id":"order-1","document":{"email":{"work":"ada@example.com"}},"paths":["$.email.work"]}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TransformTokenize, `{"document":{"a":"b"},"paths":["$.a"]}`, nil))
+	// detokenizing the plain document fails, as it holds no tokens
+	body, _ = json.Marshal(model.Transform{ID: "order-1", Document: doc, Paths: []string{"$.email"}})
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TransformDetokenize, string(body), nil))
+}
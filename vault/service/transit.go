@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/transit"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// TransitKeys creates, rotates and describes the transit keys of the keyring, by name
+	TransitKeys = "/v1/transit/keys/"
+	// TransitEncrypt encrypts data with a transit key, without storing it
+	TransitEncrypt = "/v1/transit/encrypt"
+	// TransitDecrypt decrypts data encrypted with a transit key
+	TransitDecrypt = "/v1/transit/decrypt"
+	// TransitRewrap encrypts data again with the latest version of its transit key
+	TransitRewrap = "/v1/transit/rewrap"
+	// TransitSign signs data with a transit key
+	TransitSign = "/v1/transit/sign"
+	// TransitVerify verifies a signature made with a transit key
+	TransitVerify = "/v1/transit/verify"
+)
+
+// TransitKeysHandlerFunc serves the transit keys of the keyring. The path below TransitKeys is the name of the key.
+//
+//	GET  <name>         describes the key, and its versions
+//	POST <name>         creates the key, of the type of a model.TransitKey. Existing keys get 409 Conflict
+//	POST <name>/rotate  adds a version to the key, which encrypts and signs from then on
+func TransitKeysHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", TransitKeys))
+		ctx := context.Background()
+		var resp model.Response
+
+		w.Header().Set("Content-Type", "application/json")
+		fail := func(status, code int, err error) {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		name, rotate := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, TransitKeys), "/rotate")
+		manager := srv.managerOf(r)
+		var info *transit.Info
+		var err error
+		switch {
+		case r.Method == http.MethodGet && !rotate:
+			info, err = transit.Describe(ctx, manager, name)
+		case r.Method == http.MethodPost && rotate:
+			info, err = transit.Rotate(ctx, manager, name)
+		case r.Method == http.MethodPost:
+			var req model.TransitKey
+			jsonDecoder := json.NewDecoder(r.Body)
+			jsonDecoder.DisallowUnknownFields()
+			defer r.Body.Close()
+			// the type is optional, so is the body
+			if err = jsonDecoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				fail(http.StatusBadRequest, CodeInvalidRequest, err)
+				return
+			}
+			info, err = transit.Create(ctx, manager, name, transit.KeyType(strings.ToLower(req.Type)))
+		default:
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, errors.New(ErrMethodNotAllowed+": "+r.Method))
+			return
+		}
+		if err != nil {
+			status, code := transitErrStatus(err)
+			fail(status, code, err)
+			return
+		}
+
+		keyResp := &model.TransitKeyResponse{Name: info.Name, Type: string(info.Type), LatestVersion: info.LatestVersion}
+		for _, v := range info.Versions {
+			keyResp.Versions = append(keyResp.Versions, model.TransitKeyVersion{Version: v.Version, Created: v.Created, PublicKey: v.PublicKey})
+		}
+		resp.Resp = keyResp
+		resp.Code = CodeSuccess
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// TransitEncryptHandlerFunc encrypts the plaintext of a model.TransitEncrypt with the latest version of its key. The
+// plaintext isn't stored: only the caller keeps the ciphertext.
+func TransitEncryptHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return transitHandlerFunc(srv, TransitEncrypt, func(ctx context.Context, m *tokenize.Manager, r *http.Request) (model.Resp, error) {
+		var req model.TransitEncrypt
+		if err := decodeTransit(r, &req); err != nil {
+			return nil, err
+		}
+		plaintext, err := decodeBase64("plaintext", req.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, version, err := transit.Encrypt(ctx, m, req.Key, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		return &model.TransitResponse{Ciphertext: ciphertext, KeyVersion: version}, nil
+	})
+}
+
+// TransitDecryptHandlerFunc decrypts the ciphertext of a model.TransitDecrypt with the version of its key that encrypted
+// it, and returns the plaintext base64 encoded
+func TransitDecryptHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return transitHandlerFunc(srv, TransitDecrypt, func(ctx context.Context, m *tokenize.Manager, r *http.Request) (model.Resp, error) {
+		var req model.TransitDecrypt
+		if err := decodeTransit(r, &req); err != nil {
+			return nil, err
+		}
+		plain, err := transit.Decrypt(ctx, m, req.Key, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		defer plain.Destroy()
+		return &model.TransitResponse{Plaintext: base64.StdEncoding.EncodeToString(plain.Bytes())}, nil
+	})
+}
+
+// TransitRewrapHandlerFunc encrypts the ciphertext of a model.TransitDecrypt again with the latest version of its key,
+// without returning the plaintext, so ciphertexts move off old versions after a rotation
+func TransitRewrapHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return transitHandlerFunc(srv, TransitRewrap, func(ctx context.Context, m *tokenize.Manager, r *http.Request) (model.Resp, error) {
+		var req model.TransitDecrypt
+		if err := decodeTransit(r, &req); err != nil {
+			return nil, err
+		}
+		ciphertext, version, err := transit.Rewrap(ctx, m, req.Key, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return &model.TransitResponse{Ciphertext: ciphertext, KeyVersion: version}, nil
+	})
+}
+
+// TransitSignHandlerFunc signs the input of a model.TransitSign with the latest version of its key
+func TransitSignHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return transitHandlerFunc(srv, TransitSign, func(ctx context.Context, m *tokenize.Manager, r *http.Request) (model.Resp, error) {
+		var req model.TransitSign
+		if err := decodeTransit(r, &req); err != nil {
+			return nil, err
+		}
+		input, err := decodeBase64("input", req.Input)
+		if err != nil {
+			return nil, err
+		}
+		signature, version, err := transit.Sign(ctx, m, req.Key, input)
+		if err != nil {
+			return nil, err
+		}
+		return &model.TransitResponse{Signature: signature, KeyVersion: version}, nil
+	})
+}
+
+// TransitVerifyHandlerFunc verifies the signature of a model.TransitVerify. A signature that doesn't match is valid
+// false, not an error.
+func TransitVerifyHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	return transitHandlerFunc(srv, TransitVerify, func(ctx context.Context, m *tokenize.Manager, r *http.Request) (model.Resp, error) {
+		var req model.TransitVerify
+		if err := decodeTransit(r, &req); err != nil {
+			return nil, err
+		}
+		input, err := decodeBase64("input", req.Input)
+		if err != nil {
+			return nil, err
+		}
+		valid, err := transit.Verify(ctx, m, req.Key, input, req.Signature)
+		if err != nil {
+			return nil, err
+		}
+		return &model.TransitVerifyResponse{Valid: valid}, nil
+	})
+}
+
+// errTransitRequest marks the errors of malformed transit requests
+var errTransitRequest = errors.New("invalid transit request")
+
+// transitHandlerFunc serves the POST only transit operation at route, done by do with the manager of the namespace of
+// the request
+func transitHandlerFunc(srv *Service, route string, do func(ctx context.Context, m *tokenize.Manager, r *http.Request) (model.Resp, error)) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", route))
+		ctx := context.Background()
+		var resp model.Response
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		fail := func(status, code int, err error) {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if r.Method != http.MethodPost {
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, errors.New(ErrMethodNotAllowed+": "+r.Method))
+			return
+		}
+
+		result, err := do(ctx, srv.managerOf(r), r)
+		if err != nil {
+			status, code := transitErrStatus(err)
+			fail(status, code, err)
+			return
+		}
+
+		resp.Resp = result
+		resp.Code = CodeSuccess
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// decodeTransit decodes the body of the transit request r into req
+func decodeTransit(r *http.Request, req any) error {
+	jsonDecoder := json.NewDecoder(r.Body)
+	jsonDecoder.DisallowUnknownFields()
+	defer r.Body.Close()
+	if err := jsonDecoder.Decode(req); err != nil {
+		return fmt.Errorf("%w: %w", errTransitRequest, err)
+	}
+	return nil
+}
+
+// decodeBase64 decodes the base64 encoded field of a transit request
+func decodeBase64(field, s string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be base64 encoded: %w", errTransitRequest, field, err)
+	}
+	return b, nil
+}
+
+// transitErrStatus maps the errors of transit operations to their HTTP status and response code
+func transitErrStatus(err error) (int, int) {
+	switch {
+	case errors.Is(err, transit.ErrKeyNotFound):
+		return http.StatusNotFound, CodeInvalidRequest
+	case errors.Is(err, transit.ErrKeyExists):
+		return http.StatusConflict, CodeInvalidRequest
+	case errors.Is(err, errTransitRequest), errors.Is(err, transit.ErrKeyNameInvalid), errors.Is(err, transit.ErrKeyTypeInvalid),
+		errors.Is(err, transit.ErrUnsupported), errors.Is(err, transit.ErrCiphertextInvalid), errors.Is(err, transit.ErrSignatureInvalid):
+		return http.StatusBadRequest, CodeInvalidRequest
+	default:
+		return batchErrStatus(err)
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"net/http"
+)
+
+func (suite *TokensTestSuite) TestTransit() {
+	var key struct {
+		Resp model.TransitKeyResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitKeys+"payments", "", &key))
+	suite.Require().Equal("aes256-gcm", key.Resp.Type)
+	suite.Require().Equal(http.StatusConflict, suite.do(http.MethodPost, TransitKeys+"payments", "", nil))
+
+	// the keyring is out of reach of the token routes
+	stored, _, err := suite.srv.manager.GetToken(tokenize.System(context.Background()), tokenize.TransitPrefix+"/payments")
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodGet, TokensPath+tokenize.TransitPrefix+"/payments", "", nil))
+	suite.Require().NotEqual(http.StatusOK, suite.do(http.MethodPost, Detokenize, `{"id":"`+tokenize.TransitPrefix+`","data":[{"key":"payments","value":"`+stored+`"}]}`, nil))
+	suite.Require().NotEqual(http.StatusOK, suite.do(http.MethodPatch, TokensPath+tokenize.TransitPrefix+"/payments", `{"value":"x"}`, nil))
+	suite.Require().NotEqual(http.StatusOK, suite.do(http.MethodPatch, PatchToken, `{"id":"`+tokenize.TransitPrefix+`","data":[{"key":"payments","value":"x"}]}`, nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodDelete, TokensPath+tokenize.TransitPrefix, "", nil))
+	suite.Require().NotEqual(http.StatusOK, suite.do(http.MethodDelete, DeleteToken+"?id="+tokenize.TransitPrefix+"/payments", "", nil))
+	var all struct {
+		Resp model.All `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, GetTokens+"?keys_only=true", "", &all))
+	suite.Require().Empty(all.Resp.Keys)
+
+	var resp struct {
+		Resp model.TransitResponse `json:"resp"`
+	}
+	plaintext := base64.StdEncoding.EncodeToString([]byte("card 4242"))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitEncrypt, `{"key":"payments","plaintext":"`+plaintext+`"}`, &resp))
+	ciphertext := resp.Resp.Ciphertext
+	suite.Require().Equal(1, resp.Resp.KeyVersion)
+
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitKeys+"payments/rotate", "", &key))
+	suite.Require().Equal(2, key.Resp.LatestVersion)
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitRewrap, `{"key":"payments","ciphertext":"`+ciphertext+`"}`, &resp))
+	suite.Require().Equal(2, resp.Resp.KeyVersion)
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitDecrypt, `{"key":"payments","ciphertext":"`+resp.Resp.Ciphertext+`"}`, &resp))
+	suite.Require().Equal(plaintext, resp.Resp.Plaintext)
+
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitKeys+"releases", `{"type":"ed25519"}`, &key))
+	suite.Require().Contains(key.Resp.Versions[0].PublicKey, "PUBLIC KEY")
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, TransitKeys+"releases", "", &key))
+	suite.Require().Equal("ed25519", key.Resp.Type)
+	input := base64.StdEncoding.EncodeToString([]byte("v1.2.3"))
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitSign, `{"key":"releases","input":"`+input+`"}`, &resp))
+	var verify struct {
+		Resp model.TransitVerifyResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, TransitVerify, `{"key":"releases","input":"`+input+`","signature":"`+resp.Resp.Signature+`"}`, &verify))
+	suite.Require().True(verify.Resp.Valid)
+
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TransitEncrypt, `{"key":"releases","plaintext":"`+plaintext+`"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TransitEncrypt, `{"key":"payments","plaintext":"not base64"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TransitDecrypt, `{"key":"payments","ciphertext":"vault:v9:AAAA"}`, nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodPost, TransitSign, `{"key":"missing","input":"`+input+`"}`, nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodGet, TransitKeys+"missing", "", nil))
+	suite.Require().Equal(http.StatusMethodNotAllowed, suite.do(http.MethodGet, TransitEncrypt, "", nil))
+}
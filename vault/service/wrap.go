@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/wrap"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// Wrap detokenizes a value, and hands it off under a single-use wrapping token
+	Wrap = "/v1/wrap"
+	// Unwrap returns a wrapped value, once
+	Unwrap = "/v1/unwrap"
+)
+
+// WrapHandlerFunc detokenizes the value of a model.Wrap, masked as the caller's policy requires, and wraps it under a
+// wrapping token valid for its TTL
+func WrapHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", Wrap))
+		ctx := context.Background()
+		var resp model.Response
+		var req model.Wrap
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		fail := func(status, code int, err error) {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if r.Method != http.MethodPost {
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, errors.New(ErrMethodNotAllowed+": "+r.Method))
+			return
+		}
+
+		policy, err := srv.maskPolicy(r)
+		if err != nil {
+			status, code := maskErrStatus(err)
+			fail(status, code, err)
+			return
+		}
+
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		defer r.Body.Close()
+		if err = jsonDecoder.Decode(&req); err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+
+		var ttl time.Duration
+		if req.TTL != "" {
+			if ttl, err = time.ParseDuration(req.TTL); err != nil {
+				fail(http.StatusBadRequest, CodeInvalidRequest, fmt.Errorf("%w: %s", wrap.ErrTTLRange, err))
+				return
+			}
+		}
+
+		key, err := tokenize.ChildKey(req.ID, req.Key)
+		if err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+		found, value, err := srv.managerOf(r).Detokenize(ctx, key, req.Token)
+		if err != nil || !found {
+			fail(http.StatusBadRequest, CodeInvalidRequest, fmt.Errorf("error with key %s.%s: %v", req.ID, req.Key, err))
+			return
+		}
+
+		token, wrapped, err := wrap.Wrap(ctx, srv.managerOf(r), wrap.Wrapped{ID: req.ID, Key: req.Key, Value: policy.Apply(value)}, ttl)
+		if errors.Is(err, wrap.ErrTTLRange) {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+		if err != nil {
+			fail(http.StatusInternalServerError, CodeInternalServerError, err)
+			return
+		}
+
+		resp.Resp = &model.WrapResponse{WrapToken: token, Expires: wrapped.Expires}
+		resp.Code = CodeSuccess
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// UnwrapHandlerFunc returns the value wrapped under the token of a model.Unwrap, and deletes it. Invalid, expired and
+// already unwrapped tokens all get 404 Not Found.
+func UnwrapHandlerFunc(srv *Service) func(w http.ResponseWriter, r *http.Request) {
+	log := srv.log
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Logger().Info().Msg(fmt.Sprintf("received a request on %s", Unwrap))
+		ctx := context.Background()
+		var resp model.Response
+		var req model.Unwrap
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		fail := func(status, code int, err error) {
+			resp.Error = append(resp.Error, err.Error())
+			log.Logger().Error().Msg(err.Error())
+			resp.Code = code
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if r.Method != http.MethodPost {
+			fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, errors.New(ErrMethodNotAllowed+": "+r.Method))
+			return
+		}
+
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		defer r.Body.Close()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			fail(http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+
+		wrapped, err := wrap.Unwrap(ctx, srv.managerOf(r), req.WrapToken)
+		if errors.Is(err, wrap.ErrNotFound) {
+			fail(http.StatusNotFound, CodeInvalidRequest, err)
+			return
+		}
+		if err != nil {
+			fail(http.StatusInternalServerError, CodeInternalServerError, err)
+			return
+		}
+
+		resp.Resp = &model.UnwrapResponse{ID: wrapped.ID, Key: wrapped.Key, Datum: wrapped.Value}
+		resp.Code = CodeSuccess
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"net/http"
+	"time"
+)
+
+func (suite *TokensTestSuite) TestWrap() {
+	var token struct {
+		Resp model.PathResponse `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, "/v1/tokens/app/db", `{"value":"hunter2"}`, &token))
+
+	var wrapped struct {
+		Resp model.WrapResponse `json:"resp"`
+	}
+	code := suite.do(http.MethodPost, Wrap, `{"id":"app","key":"db","token":"`+token.Resp.Token+`","ttl":"2m"}`, &wrapped)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().NotEmpty(wrapped.Resp.WrapToken)
+	suite.Require().WithinDuration(time.Now().Add(2*time.Minute), wrapped.Resp.Expires, 2*time.Second)
+
+	// wrapped values are out of reach of the token routes
+	var all struct {
+		Resp model.All `json:"resp"`
+	}
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodGet, GetTokens+"?keys_only=true", "", &all))
+	suite.Require().Equal([]string{"app/db"}, all.Resp.Keys)
+	reserved, err := suite.srv.manager.ListTokens(tokenize.System(context.Background()), tokenize.ListOptions{Prefix: tokenize.WrapPrefix + "/", KeysOnly: true})
+	suite.Require().NoErrorf(err, "expected no errors, but got this %v\n", err)
+	suite.Require().Len(reserved.Keys, 1)
+	wrapKey := reserved.Keys[0]
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodGet, TokensPath+wrapKey, "", nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodDelete, TokensPath+wrapKey, "", nil))
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodDelete, TokensPath+tokenize.WrapPrefix, "", nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, TokensPath+wrapKey, `{"value":"x"}`, nil))
+	suite.Require().NotEqual(http.StatusOK, suite.do(http.MethodDelete, DeleteToken+"?id="+tokenize.WrapPrefix, "", nil))
+
+	var unwrapped struct {
+		Resp model.UnwrapResponse `json:"resp"`
+	}
+	body := `{"wrap_token":"` + wrapped.Resp.WrapToken + `"}`
+	suite.Require().Equal(http.StatusOK, suite.do(http.MethodPost, Unwrap, body, &unwrapped))
+	suite.Require().Equal("hunter2", unwrapped.Resp.Datum)
+	suite.Require().Equal("db", unwrapped.Resp.Key)
+	suite.Require().Equal(http.StatusNotFound, suite.do(http.MethodPost, Unwrap, body, nil))
+
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, Wrap, `{"id":"app","key":"db","token":"`+token.Resp.Token+`","ttl":"48h"}`, nil))
+	suite.Require().Equal(http.StatusBadRequest, suite.do(http.MethodPost, Wrap, `{"id":"app","key":"db","token":"wrong"}`, nil))
+}
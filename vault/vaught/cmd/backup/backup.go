@@ -0,0 +1,139 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/backup"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+const (
+	FlagOut        = "out"
+	FlagPassphrase = "passphrase"
+	// EnvPassphrase is read when the passphrase flag isn't set, so the passphrase doesn't end up in shell history
+	EnvPassphrase = "VAULT_BACKUP_PASSPHRASE"
+)
+
+var (
+	ErrPassphraseNotSet = errors.New("backup passphrase not set. pass --passphrase or set " + EnvPassphrase)
+)
+
+type BackupOptions struct {
+	out        string
+	passphrase string
+	debug      bool
+}
+
+// NewBackupCmd represents the cli command for backing up the vault
+func NewBackupCmd() *cobra.Command {
+
+	bop := &BackupOptions{}
+
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Creates an encrypted backup archive of the entire vault",
+		Long: `The 'backup' command snapshots every token in the configured store together with the cipher needed to decrypt them,
+and writes them to a single compressed archive encrypted with a passphrase of your choosing.
+
+Usage:
+
+  vault backup --out <path to archive> [ --passphrase <passphrase> ]
+
+If '--passphrase' isn't set, the passphrase is read from the VAULT_BACKUP_PASSPHRASE environment variable. Keep the passphrase safe: the archive cannot be restored without it.
+
+Examples:
+Back up the vault:
+  VAULT_BACKUP_PASSPHRASE=<passphrase> vault backup --out ./vault.bak
+
+Restore it later with 'vault restore --in ./vault.bak'.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			bop.debug = debug
+
+			n, err := bop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+
+			fmt.Printf("Backed up %d entries to %s\n", n, bop.out)
+		},
+	}
+
+	backupCmd.Flags().StringVarP(&bop.out, FlagOut, "o", fmt.Sprintf("vault-%d.bak", time.Now().Unix()), "specify the file the backup archive is written to")
+	backupCmd.Flags().StringVarP(&bop.passphrase, FlagPassphrase, "p", "", "specify the passphrase used to encrypt the archive")
+	return backupCmd
+}
+
+// Passphrase resolves the backup passphrase from the flag, falling back to the environment
+func Passphrase(flag string) (string, error) {
+	if len(flag) > 0 {
+		return flag, nil
+	}
+	if env := os.Getenv(EnvPassphrase); len(env) > 0 {
+		return env, nil
+	}
+	return "", ErrPassphraseNotSet
+}
+
+func (bop *BackupOptions) Run(ctx context.Context, logger *vlog.Logger) (int, error) {
+	fmt.Println("Backing up vault")
+	var err error
+
+	passphrase, err := Passphrase(bop.passphrase)
+	if err != nil {
+		return 0, err
+	}
+
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return 0, err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return 0, err
+	}
+
+	archive, err := backup.New(ctx, manager)
+	if err != nil {
+		logger.Logger().Error().Msgf("error creating backup archive: %s", err)
+		return 0, err
+	}
+
+	sealed, err := archive.Seal([]byte(passphrase))
+	if err != nil {
+		logger.Logger().Error().Msgf("error sealing backup archive: %s", err)
+		return 0, err
+	}
+
+	// the archive holds the key material, so keep it private to the current user
+	err = os.WriteFile(bop.out, sealed, 0600)
+	if err != nil {
+		logger.Logger().Error().Msgf("error writing backup archive to %s: %s", bop.out, err)
+		return 0, err
+	}
+
+	return len(archive.Entries), nil
+}
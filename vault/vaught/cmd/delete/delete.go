@@ -6,6 +6,7 @@ package del
 import (
 	"context"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/tokenize"
 	"github.com/dark-enstein/vault/internal/vlog"
 	"github.com/dark-enstein/vault/vaught/cmd/helper"
 	"github.com/pkg/errors"
@@ -15,7 +16,8 @@ import (
 )
 
 type DeleteOptions struct {
-	id string
+	id       string
+	revision int64
 }
 
 // NewDeleteCmd represents the cli command
@@ -36,6 +38,9 @@ Examples:
 Delete a token by its ID:
   vault delete --id <token-id>
 
+Delete a token only if it hasn't been patched since revision 3 was read:
+  vault delete --id <token-id> --revision 3
+
 Ensure the correct token ID is specified to prevent unintended data loss. This command is designed for precise operation, allowing for the secure management and cleanup of stored tokens.
 
 Each deletion operation requires the token ID to be specified, ensuring targeted and secure removal of sensitive information.`,
@@ -43,7 +48,7 @@ Each deletion operation requires the token ID to be specified, ensuring targeted
 			fmt.Println("Deleting record with id:", do.id)
 			debug, err := cmd.Flags().GetBool("debug")
 			if err != nil {
-				log.Error().Msgf("error retrieving persistent flag: %s: %w", "debug", err)
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
 			}
 
 			ctx := context.Background()
@@ -55,12 +60,17 @@ Each deletion operation requires the token ID to be specified, ensuring targeted
 					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
 					os.Exit(1)
 				}
+				if errors.Is(err, tokenize.ErrRevisionMismatch) {
+					fmt.Println(err)
+					os.Exit(1)
+				}
 			}
 			fmt.Println("Deleted successfully")
 		},
 	}
 
 	deleteCmd.Flags().StringVarP(&do.id, "id", "i", "", "specify token ID to be deleted.")
+	deleteCmd.Flags().Int64VarP(&do.revision, "revision", "r", tokenize.AnyRevision, "only delete the token if it is still at this revision.")
 	return deleteCmd
 }
 
@@ -80,7 +90,10 @@ func (do *DeleteOptions) Run(ctx context.Context, logger *vlog.Logger) error {
 		return err
 	}
 
-	_, err = manager.DeleteTokenByID(ctx, do.id)
-	// ignore error, since delete op
+	_, err = manager.DeleteTokenIf(ctx, do.id, do.revision)
+	// ignore error, since delete op, unless the token moved on from the expected revision
+	if errors.Is(err, tokenize.ErrRevisionMismatch) {
+		return err
+	}
 	return nil
 }
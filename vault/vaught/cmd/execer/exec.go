@@ -0,0 +1,160 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package execer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/export"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"os/signal"
+)
+
+const (
+	FlagID     = "id"
+	FlagPrefix = "prefix"
+)
+
+var (
+	ErrCommandEmpty = errors.New("no command to run. pass it after '--', e.g. vault exec --id myapp -- ./server")
+)
+
+type ExecOptions struct {
+	id     string
+	prefix string
+	args   []string
+	debug  bool
+}
+
+// NewExecCmd represents the cli command for running a child process with secrets injected into its environment
+func NewExecCmd() *cobra.Command {
+
+	eop := &ExecOptions{}
+
+	execCmd := &cobra.Command{
+		Use:   "exec --id <id> -- <command> [args...]",
+		Short: "Runs a command with the secrets of an ID injected as environment variables",
+		Long: `The 'exec' command detokenizes every child key stored under an ID, and runs a command with them set as environment variables.
+Secrets only ever live in the memory of vault and of the child process: nothing is written to disk or exported into the parent shell.
+
+Child keys are upper cased and non alphanumeric characters replaced with '_' to form environment variable names, optionally prefixed with --prefix.
+Secrets override inherited variables of the same name. Signals received by vault are forwarded to the child, and vault exits with the child's exit code.
+
+Usage:
+
+  vault exec --id <id> [ --prefix <prefix> ] -- <command> [args...]
+
+Examples:
+Run a server with its secrets:
+  vault exec --id myapp -- ./server --port 8080`,
+		Args: cobra.ArbitraryArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			eop.debug = debug
+			eop.args = args
+
+			code, err := eop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Fprintln(os.Stderr, "config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+			os.Exit(code)
+		},
+	}
+
+	execCmd.Flags().StringVarP(&eop.id, FlagID, "i", "", "specify the ID whose secrets are injected")
+	execCmd.Flags().StringVarP(&eop.prefix, FlagPrefix, "p", "", "specify a prefix added to every environment variable name")
+	execCmd.MarkFlagRequired(FlagID)
+	return execCmd
+}
+
+// Run starts the child process with the secrets of the ID in its environment, and waits for it to exit. It returns the child's exit code.
+func (eop *ExecOptions) Run(ctx context.Context, logger *vlog.Logger) (int, error) {
+	var err error
+
+	if len(eop.args) == 0 {
+		return 0, ErrCommandEmpty
+	}
+
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return 0, err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return 0, err
+	}
+
+	secrets, err := export.Reveal(ctx, manager, eop.id)
+	if err != nil {
+		logger.Logger().Error().Msgf("error revealing secrets of id %s: %s", eop.id, err)
+		return 0, err
+	}
+
+	env, err := export.Environ(os.Environ(), eop.prefix, secrets)
+	if err != nil {
+		return 0, err
+	}
+
+	child := exec.Command(eop.args[0], eop.args[1:]...)
+	child.Env = env
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	// start listening before the child starts, so no signal is missed
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	if err = child.Start(); err != nil {
+		logger.Logger().Error().Msgf("error starting command %s: %s", eop.args[0], err)
+		return 0, err
+	}
+	logger.Logger().Debug().Msgf("started command %s with pid %d", eop.args[0], child.Process.Pid)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				logger.Logger().Debug().Msgf("forwarding signal %s to pid %d", sig, child.Process.Pid)
+				_ = child.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = child.Wait()
+	close(done)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitCode(exitErr), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package execer
+
+import (
+	"os"
+	"os/exec"
+)
+
+// forwardedSignals are relayed from vault to the child process
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+}
+
+func exitCode(err *exec.ExitError) int {
+	return err.ExitCode()
+}
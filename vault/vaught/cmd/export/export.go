@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/export"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	FlagID     = "id"
+	FlagFormat = "format"
+	FlagOut    = "out"
+	FlagReveal = "reveal"
+)
+
+var (
+	ErrRevealRequired = errors.New("refusing to write plaintext secrets to stdout without --reveal. pass --reveal, or write to a file with --out")
+)
+
+type ExportOptions struct {
+	id     string
+	format string
+	out    string
+	reveal bool
+	debug  bool
+}
+
+// NewExportCmd represents the cli command for exporting the secrets of an ID in deployable formats
+func NewExportCmd() *cobra.Command {
+
+	eop := &ExportOptions{}
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Exports the decrypted secrets of an ID as env, dotenv, JSON or a Kubernetes Secret",
+		Long: `The 'export' command detokenizes every child key stored under an ID and renders them in a format ready to be deployed.
+
+Supported formats:
+- env: 'export KEY=value' lines, safe to eval in a shell.
+- dotenv: a dotenv file.
+- json: a JSON object of the child keys and their values.
+- k8s-secret: a Kubernetes Secret manifest, with base64 encoded data.
+
+Child keys are upper cased and non alphanumeric characters replaced with '_' to form environment variable names.
+Writing plaintext to stdout requires the explicit --reveal flag. Files written with --out are only readable by the current user.
+
+Usage:
+
+  vault export --id <id> --format env|dotenv|json|k8s-secret [ --out <path> | --reveal ]
+
+Examples:
+Write a Kubernetes Secret manifest to a file:
+  vault export --id myapp --format k8s-secret --out secret.yaml
+
+Load secrets into the current shell:
+  eval "$(vault export --id myapp --format env --reveal)"`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			eop.debug = debug
+
+			rendered, err := eop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Fprintln(os.Stderr, "config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+
+			if len(eop.out) > 0 {
+				fmt.Fprintf(os.Stderr, "Exported secrets of id %s to %s\n", eop.id, eop.out)
+				return
+			}
+			os.Stdout.Write(rendered)
+		},
+	}
+
+	exportCmd.Flags().StringVarP(&eop.id, FlagID, "i", "", "specify the ID whose secrets are exported")
+	exportCmd.Flags().StringVar(&eop.format, FlagFormat, export.FormatEnv, "specify the export format. Options: env, dotenv, json, k8s-secret")
+	exportCmd.Flags().StringVarP(&eop.out, FlagOut, "o", "", "specify a file to write the exported secrets to")
+	exportCmd.Flags().BoolVar(&eop.reveal, FlagReveal, false, "allow plaintext secrets to be written to stdout")
+	exportCmd.MarkFlagRequired(FlagID)
+	return exportCmd
+}
+
+func (eop *ExportOptions) Run(ctx context.Context, logger *vlog.Logger) ([]byte, error) {
+	var err error
+
+	// check guards before any secret is decrypted
+	if err = export.ValidateFormat(eop.format); err != nil {
+		return nil, err
+	}
+	if len(eop.out) == 0 && !eop.reveal {
+		return nil, ErrRevealRequired
+	}
+
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return nil, err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return nil, err
+	}
+
+	secrets, err := export.Reveal(ctx, manager, eop.id)
+	if err != nil {
+		logger.Logger().Error().Msgf("error revealing secrets of id %s: %s", eop.id, err)
+		return nil, err
+	}
+
+	var rendered bytes.Buffer
+	if err = export.Render(&rendered, eop.format, eop.id, secrets); err != nil {
+		return nil, err
+	}
+
+	if len(eop.out) > 0 {
+		if err = os.WriteFile(eop.out, rendered.Bytes(), 0600); err != nil {
+			logger.Logger().Error().Msgf("error writing exported secrets to %s: %s", eop.out, err)
+			return nil, err
+		}
+	}
+
+	return rendered.Bytes(), nil
+}
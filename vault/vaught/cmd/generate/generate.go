@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/generate"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	FlagID      = "id"
+	FlagKey     = "key"
+	FlagType    = "type"
+	FlagLength  = "length"
+	FlagCharset = "charset"
+	FlagSymbols = "symbols"
+	FlagBits    = "bits"
+	FlagReveal  = "reveal"
+)
+
+type GenerateOptions struct {
+	id      string
+	key     string
+	typ     string
+	length  int
+	charset string
+	symbols bool
+	bits    int
+	reveal  bool
+	debug   bool
+}
+
+// NewGenerateCmd represents the cli command for minting a secret in the vault
+func NewGenerateCmd() *cobra.Command {
+
+	gop := &GenerateOptions{}
+
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Mints a secret, and stores its token in the vault",
+		Long: `The 'generate' command creates a secret from a cryptographically secure random source, tokenizes it at once under a key of an ID, and prints its token. The value itself is only printed with --reveal, so it never has to pass through a terminal or a shell history: apps read it with 'vault peel', 'vault export' or 'vault exec' when they need it.
+
+Secret types:
+- password: --length characters (32 by default) of --charset, with --symbols if asked, holding at least one character of every class of the charset
+- hex, base64: --length random bytes (32 by default), encoded
+- uuid: a random, version 4, UUID
+- ed25519, rsa: a private key, PEM encoded as PKCS #8, of --bits for RSA (2048 by default). The public key is printed too.
+
+Usage:
+
+  vault generate --id <id> [--key <key>] --type password|hex|base64|uuid|ed25519|rsa [--length <n>] [--charset <charset>] [--symbols] [--bits <n>] [--reveal]
+
+Examples:
+Generate a 40 character database password, with symbols:
+  vault generate --id myapp --key db/password --type password --length 40 --symbols
+
+Generate a PIN of 8 digits, and print it:
+  vault generate --id myapp --key pin --type password --length 8 --charset digits --reveal
+
+Generate a signing key pair, and save the public key:
+  vault generate --id myapp --key signing/key --type ed25519 2> signing.pub`,
+		Run: func(cmd *cobra.Command, args []string) {
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			gop.debug = debug
+
+			err = gop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Fprintln(os.Stderr, "config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+		},
+	}
+
+	generateCmd.Flags().StringVarP(&gop.id, FlagID, "i", "", "specify the ID to store the secret under")
+	generateCmd.Flags().StringVarP(&gop.key, FlagKey, "k", "", "specify the key under the ID to store the secret at, if not the ID alone")
+	generateCmd.Flags().StringVarP(&gop.typ, FlagType, "t", string(generate.Password), "specify the type of the secret. Options: password, hex, base64, uuid, ed25519, rsa")
+	generateCmd.Flags().IntVarP(&gop.length, FlagLength, "l", 0, "specify the number of characters of a password, or of random bytes of a hex or base64 secret. Defaults to 32")
+	generateCmd.Flags().StringVar(&gop.charset, FlagCharset, "", "specify the characters of a password: alphanumeric (the default), letters, lowercase, uppercase, digits, or the characters themselves")
+	generateCmd.Flags().BoolVar(&gop.symbols, FlagSymbols, false, "include symbols in a password")
+	generateCmd.Flags().IntVar(&gop.bits, FlagBits, 0, "specify the size of an RSA key: 2048, 3072 or 4096. Defaults to 2048")
+	generateCmd.Flags().BoolVar(&gop.reveal, FlagReveal, false, "print the value of the secret on stderr, besides its token")
+	generateCmd.MarkFlagRequired(FlagID)
+	return generateCmd
+}
+
+func (gop *GenerateOptions) Run(ctx context.Context, logger *vlog.Logger) error {
+	t, err := generate.ParseType(gop.typ)
+	if err != nil {
+		return err
+	}
+	// a secret stored by ID alone, as with 'vault store', has no key
+	key, err := keys.Normalize(gop.id)
+	if gop.key != "" {
+		key, err = tokenize.ChildKey(gop.id, gop.key)
+	}
+	if err != nil {
+		return err
+	}
+
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return err
+	}
+
+	p := generate.Policy{Length: gop.length, Charset: generate.ParseCharset(gop.charset), Symbols: gop.symbols, Bits: gop.bits}
+	minted, err := generate.Mint(ctx, manager, key, t, p)
+	if err != nil {
+		return fmt.Errorf("error generating %s secret at %s: %w", t, key, err)
+	}
+	logger.Logger().Debug().Msgf("generated %s secret at %s, revision %d", t, key, minted.Revision)
+
+	// the token goes to stdout, to be piped; everything else to stderr
+	if minted.Public != "" {
+		fmt.Fprint(os.Stderr, minted.Public)
+	}
+	if gop.reveal {
+		fmt.Fprintln(os.Stderr, minted.Value)
+	}
+	fmt.Println(minted.Token)
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/namespace"
 	"github.com/dark-enstein/vault/internal/store"
 	"github.com/dark-enstein/vault/internal/tokenize"
 	"github.com/dark-enstein/vault/internal/vlog"
@@ -29,6 +30,9 @@ var (
 
 var logger = vlog.New(true)
 
+// Namespace is the namespace commands operate in, set by the --namespace flag. Empty is the root namespace.
+var Namespace = namespace.Root
+
 type InstanceConfig struct {
 	ID          string `json:"id"`
 	CipherLoc   string `json:"cipher_loc"`
@@ -43,35 +47,67 @@ func NewInstanceConfig() *InstanceConfig {
 	return &InstanceConfig{}
 }
 
+// Manager creates the manager of the store configured, in the namespace selected by Namespace
 func (ic *InstanceConfig) Manager(ctx context.Context) (*tokenize.Manager, error) {
-	if len(ic.StoreLoc) == 0 {
+	s, err := ic.Store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ic.ManagerOf(ctx, s, Namespace)
+}
+
+// Store creates the store configured, unconnected
+func (ic *InstanceConfig) Store(ctx context.Context) (store.Store, error) {
+	if len(ic.StoreType) == 0 {
 		return nil, ErrStoreTypeEmpty
 	}
 	log := logger.Logger()
-	switch ic.StoreLoc {
+	var s store.Store
+	switch ic.StoreType {
 	case service.STORE_FILE:
 		log.Info().Msg("Using File storage")
-		return tokenize.NewManager(ctx, logger, tokenize.WithStore(store.NewFile(ic.StoreLoc, logger))), nil
+		s = store.NewFile(ic.StoreLoc, logger)
 	case service.STORE_GOB:
 		log.Info().Msg("Using Gob storage")
 		gob, err := store.NewGob(ctx, ic.StoreLoc, logger, false)
 		if err != nil {
 			log.Fatal().Msgf("error while creating storage backend: %s", err)
 		}
-		return tokenize.NewManager(ctx, logger, tokenize.WithStore(gob)), nil
+		s = gob
 	case service.STORE_REDIS:
 		log.Info().Msg("Using Redis storage")
 		r, err := store.NewRedis(ic.RedisString, logger)
 		if err != nil {
 			log.Fatal().Msgf("error while creating storage backend: %s", err)
 		}
-		return tokenize.NewManager(ctx, logger, tokenize.WithStore(r)), nil
+		s = r
 	case service.STORE_MAP:
 		log.Info().Msg("Using In-memory map storage")
-		return tokenize.NewManager(ctx, logger, tokenize.WithStore(store.NewSyncMap(ctx, logger))), nil
+		s = store.NewSyncMap(ctx, logger)
 	default:
 		return nil, ErrStoreTypeInvalid
 	}
+	return s, nil
+}
+
+// ManagerOf creates the manager of the namespace ns of s, a store created by Store
+func (ic *InstanceConfig) ManagerOf(ctx context.Context, s store.Store, ns string) (*tokenize.Manager, error) {
+	view, err := namespace.Store(s, ns)
+	if err != nil {
+		return nil, err
+	}
+	// views of namespaces other than the root one share the store, so it is connected here rather than by the manager
+	if ns != namespace.Root {
+		if _, err = s.Connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	cipherLoc := tokenize.DefaultCipherLoc
+	if len(ic.CipherLoc) > 0 {
+		cipherLoc = ic.CipherLoc
+	}
+	return tokenize.NewManager(ctx, logger, tokenize.WithStore(view), tokenize.WithCipherLoc(namespace.CipherLoc(cipherLoc, ns))), nil
 }
 
 func (ic *InstanceConfig) JsonEncode(path string) error {
@@ -126,9 +162,9 @@ func (ic *InstanceConfig) jsonDecode(path string) error {
 	}
 
 	// reset instance config
-	ic = &InstanceConfig{}
+	*ic = InstanceConfig{}
 
-	err = json.Unmarshal(fileBytes, &ic)
+	err = json.Unmarshal(fileBytes, ic)
 	if err != nil {
 		log.Error().Msgf("json config invalid: %s", err)
 		return err
@@ -0,0 +1,141 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/importer"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	FlagID     = "id"
+	FlagFile   = "file"
+	FlagFormat = "format"
+	FlagAtomic = "atomic"
+)
+
+type ImportOptions struct {
+	id     string
+	file   string
+	format string
+	atomic bool
+	debug  bool
+}
+
+// NewImportCmd represents the cli command for importing secrets in bulk
+func NewImportCmd() *cobra.Command {
+
+	iop := &ImportOptions{}
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Imports secrets in bulk from dotenv, JSON or CSV files",
+		Long: `The 'import' command tokenizes every secret in a dotenv, JSON or CSV file and stores it in the vault, so onboarding a service doesn't take one 'vault store' per secret.
+
+Supported formats:
+- dotenv: every variable becomes a child key of --id. --id is required.
+- json: nested objects and arrays are flattened into child keys. Without --id, every top level key is a parent ID of its own.
+- csv: an "id,key,value" header, or a "key,value" header when --id is set.
+
+The format is inferred from the file extension when --format isn't set. Every row is validated before anything is written:
+duplicate keys and keys already in the vault are skipped and reported. With --atomic, a single invalid row aborts the import, and nothing is written.
+
+Usage:
+
+  vault import --file <path> [ --id <parent id> ] [ --format dotenv|json|csv ] [ --atomic ]
+
+Examples:
+Import a service's dotenv file:
+  vault import --id myapp --file .env
+
+Import a json document, all or nothing:
+  vault import --id myapp --file secrets.json --atomic`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			iop.debug = debug
+
+			reportBytes, err := iop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				if len(reportBytes) > 0 {
+					fmt.Println(string(reportBytes))
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+
+			fmt.Println("Import report:")
+			fmt.Println(string(reportBytes))
+		},
+	}
+
+	importCmd.Flags().StringVarP(&iop.id, FlagID, "i", "", "specify the parent ID secrets are imported under")
+	importCmd.Flags().StringVarP(&iop.file, FlagFile, "f", "", "specify the file to import secrets from")
+	importCmd.Flags().StringVar(&iop.format, FlagFormat, "", "specify the format of the file. Options: dotenv, json, csv")
+	importCmd.Flags().BoolVar(&iop.atomic, FlagAtomic, false, "import all secrets or none of them")
+	importCmd.MarkFlagRequired(FlagFile)
+	return importCmd
+}
+
+func (iop *ImportOptions) Run(ctx context.Context, logger *vlog.Logger) ([]byte, error) {
+	fmt.Println("Importing secrets from", iop.file)
+	var err error
+
+	format, err := importer.ResolveFormat(iop.format, iop.file)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(iop.file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	rows, err := importer.Parse(fd, format, iop.id)
+	if err != nil {
+		logger.Logger().Error().Msgf("error parsing %s as %s: %s", iop.file, format, err)
+		return nil, err
+	}
+
+	// check if config exists, override
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return nil, err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return nil, err
+	}
+
+	report, importErr := importer.Import(ctx, manager, rows, importer.Options{Atomic: iop.atomic})
+
+	jsonByte, err := json.Marshal(report)
+	if err != nil {
+		logger.Logger().Error().Msgf("error marshalling import report into json: %s", err)
+		return nil, err
+	}
+
+	return jsonByte, importErr
+}
@@ -8,6 +8,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/labels"
+	"github.com/dark-enstein/vault/internal/model"
+	"github.com/dark-enstein/vault/internal/tokenize"
 	"github.com/dark-enstein/vault/internal/vlog"
 	"github.com/dark-enstein/vault/vaught/cmd/helper"
 	"github.com/rs/zerolog/log"
@@ -16,6 +19,11 @@ import (
 )
 
 type ListOptions struct {
+	prefix   string
+	cursor   string
+	limit    int
+	selector string
+	keysOnly bool
 }
 
 // NewListCmd represents the CLI command for listing all stored tokens
@@ -31,37 +39,69 @@ This command is useful for getting an overview of all the secrets managed by the
 
 Usage:
 
+  vault list [--prefix <prefix>] [--selector <selector>] [--limit <n> [--cursor <cursor>]] [--keys-only]
+
+Examples:
+List every token:
   vault list
 
-This will output all the tokens stored, formatted as JSON for easy reading and integration with other tools. Ensure you have the appropriate permissions and the vault is correctly configured before running this command.`,
+List the first 50 tokens of the app ID, then the next 50:
+  vault list --prefix app/ --limit 50
+  vault list --prefix app/ --limit 50 --cursor <next cursor>
+
+List the keys of the tokens labelled for production, outside of the payments team:
+  vault list --selector 'env=prod,team!=payments' --keys-only
+
+This will output the tokens stored, formatted as JSON for easy reading and integration with other tools. When a limit is given and more tokens remain, the cursor of the next page is printed after them. Ensure you have the appropriate permissions and the vault is correctly configured before running this command.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println("Listing records in vault")
 			debug, err := cmd.Flags().GetBool("debug")
 			if err != nil {
-				log.Error().Msgf("error retrieving persistent flag: %s: %w", "debug", err)
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
 			}
 
 			ctx := context.Background()
 			logger := vlog.New(debug)
 
-			bytes, err := lop.Run(ctx, logger)
+			all, err := lop.Run(ctx, logger)
 			if err != nil {
 				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
 					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
 					os.Exit(1)
 				}
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			// keys only lists keys, not tokens
+			var listing any = all.Tokens
+			if lop.keysOnly {
+				listing = all.Keys
+			}
+			bytes, err := json.Marshal(listing)
+			if err != nil {
+				logger.Logger().Error().Msgf("error marshalling tokens into json: %s", err)
+				os.Exit(1)
 			}
 
 			fmt.Println("All Tokens:")
 			fmt.Println(string(bytes))
+			if all.Next != "" {
+				fmt.Println("Next cursor:", all.Next)
+			}
 		},
 	}
 
+	listCmd.Flags().StringVarP(&lop.prefix, "prefix", "p", "", "only list the tokens whose key starts with prefix, e.g. an ID.")
+	listCmd.Flags().StringVarP(&lop.selector, "selector", "l", "", "only list the tokens whose labels match the selector, e.g. 'env=prod,team!=payments,pii,!legacy'.")
+	listCmd.Flags().IntVarP(&lop.limit, "limit", "n", 0, "list at most limit tokens, and print the cursor of the next page.")
+	listCmd.Flags().StringVarP(&lop.cursor, "cursor", "c", "", "continue listing from the cursor printed with the previous page.")
+	listCmd.Flags().BoolVar(&lop.keysOnly, "keys-only", false, "only list the keys of the tokens.")
 	return listCmd
 
 }
 
-func (lop *ListOptions) Run(ctx context.Context, logger *vlog.Logger) ([]byte, error) {
+func (lop *ListOptions) Run(ctx context.Context, logger *vlog.Logger) (*model.All, error) {
 	fmt.Println("Initializing vault cli")
 	var err error
 
@@ -78,17 +118,22 @@ func (lop *ListOptions) Run(ctx context.Context, logger *vlog.Logger) ([]byte, e
 		return nil, err
 	}
 
-	tokens, err := manager.GetAllTokens(ctx)
+	selector, err := labels.Parse(lop.selector)
 	if err != nil {
-		logger.Logger().Error().Msgf("error retrieving tokens from store: %s", err)
 		return nil, err
 	}
 
-	bytesResult, err := json.Marshal(&tokens)
+	all, err := manager.ListTokens(ctx, tokenize.ListOptions{
+		Prefix:   lop.prefix,
+		Cursor:   lop.cursor,
+		Limit:    lop.limit,
+		Selector: selector,
+		KeysOnly: lop.keysOnly,
+	})
 	if err != nil {
-		logger.Logger().Error().Msgf("error marshalling tokens into json: %s", err)
+		logger.Logger().Error().Msgf("error retrieving tokens from store: %s", err)
 		return nil, err
 	}
 
-	return bytesResult, nil
+	return all, nil
 }
@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/namespace"
+	intstore "github.com/dark-enstein/vault/internal/store"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	FlagMatch = "match"
+)
+
+type MigrateOptions struct {
+	match string
+	debug bool
+}
+
+// NewMigrateCmd represents the cli command for moving the entries of previous layouts of the store
+func NewMigrateCmd() *cobra.Command {
+
+	mop := &MigrateOptions{}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Moves the entries stored by previous releases into the current layout of the store",
+		Long: `The 'migrate' command moves the entries stored by previous releases of the vault into the layout of the current one.
+
+Usage:
+
+  vault migrate [ --match <pattern> ]
+
+With the Redis store, it first moves the entries stored as top-level keys, before the vault had a key prefix, into the
+hashes under its prefix. Only keys matching '--match' whose value is a token the vault can decrypt are moved, so the keys
+of other apps sharing the database are left alone.
+
+With every store, it then moves the keys stored as 'id__key', before paths were introduced, to their path 'id/key'. Keys
+whose path already holds another value are left in place, and reported. Both steps scan the whole store, so they are only
+run by this command, once after upgrading.
+
+Examples:
+Move the entries stored by previous releases:
+  vault migrate
+
+Only look at the keys of the 'app' ID:
+  vault migrate --match 'app*'`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			mop.debug = debug
+
+			n, err := mop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+
+			fmt.Printf("Migrated %d entries\n", n)
+		},
+	}
+
+	migrateCmd.Flags().StringVarP(&mop.match, FlagMatch, "m", "*", "specify the pattern of the Redis keys to look at")
+	return migrateCmd
+}
+
+func (mop *MigrateOptions) Run(ctx context.Context, logger *vlog.Logger) (int, error) {
+	fmt.Println("Migrating vault")
+
+	ic := helper.NewInstanceConfig()
+	err := ic.JsonDecode()
+	if err != nil {
+		return 0, err
+	}
+
+	s, err := ic.Store(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close(ctx)
+
+	// the manager of the root namespace connects the store
+	root, err := ic.ManagerOf(ctx, s, namespace.Root)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = intstore.Ping(ctx, s); err != nil {
+		logger.Logger().Error().Msgf("error connecting to store: %s", err)
+		return 0, err
+	}
+
+	var n int
+	if r, ok := s.(*intstore.Redis); ok {
+		// every namespace has a data key of its own, so its entries are told by its manager
+		managers := map[string]*tokenize.Manager{namespace.Root: root}
+		owns := func(key, value string) bool {
+			ns := namespace.Of(key)
+			if namespace.Validate(ns) != nil {
+				return false
+			}
+			m, ok := managers[ns]
+			if !ok {
+				if m, err = ic.ManagerOf(ctx, s, ns); err != nil {
+					logger.Logger().Error().Msgf("error initializing token manager of namespace %q: %s", ns, err)
+					return false
+				}
+				managers[ns] = m
+			}
+			return m.Owns(value)
+		}
+
+		if n, err = r.MigrateKeys(ctx, mop.match, owns); err != nil {
+			logger.Logger().Error().Msgf("error migrating redis keys: %s", err)
+			return n, err
+		}
+	}
+
+	// the keys stored as id__key predate namespaces, so they are all in the root one
+	moved, err := root.MigrateLegacyKeys(ctx)
+	n += moved
+	if err != nil {
+		logger.Logger().Error().Msgf("error migrating keys stored as id__key: %s", err)
+		return n, err
+	}
+	return n, nil
+}
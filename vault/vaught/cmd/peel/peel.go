@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/dark-enstein/vault/internal/mask"
 	"github.com/dark-enstein/vault/internal/model"
 	"github.com/dark-enstein/vault/internal/vlog"
 	"github.com/dark-enstein/vault/vaught/cmd/helper"
@@ -18,6 +19,7 @@ import (
 
 type PeelOptions struct {
 	id    string
+	mask  string
 	debug bool
 }
 
@@ -46,12 +48,15 @@ Examples:
 Decrypt and retrieve token data:
   vault peel --id 1234abcd
 
+Reveal only the last 4 characters of the token data:
+  vault peel --id 1234abcd --mask last:4
+
 Make sure to run 'vault init' before attempting to peel a token, to ensure that the vault is properly configured and ready for secure operations.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Printf("Peeking record with ID %s\n", pop.id)
 			debug, err := cmd.Flags().GetBool("debug")
 			if err != nil {
-				log.Error().Msgf("error retrieving persistent flag: %s: %w", "debug", err)
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
 			}
 
 			ctx := context.Background()
@@ -63,6 +68,8 @@ Make sure to run 'vault init' before attempting to peel a token, to ensure that
 					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
 					os.Exit(1)
 				}
+				fmt.Println(err)
+				os.Exit(1)
 			}
 
 			fmt.Println("All Tokens:")
@@ -71,6 +78,7 @@ Make sure to run 'vault init' before attempting to peel a token, to ensure that
 	}
 
 	peelCmd.Flags().StringVarP(&pop.id, "id", "i", "", "specify token ID to be peeled")
+	peelCmd.Flags().StringVarP(&pop.mask, "mask", "m", "full", "specify the masking policy of the peeled data. Options: full, redact, email, first:<n>, last:<n>")
 	return peelCmd
 }
 
@@ -78,6 +86,11 @@ func (pop *PeelOptions) Run(ctx context.Context, logger *vlog.Logger) ([]byte, e
 	fmt.Println("Initializing vault cli")
 	var err error
 
+	policy, err := mask.Parse(pop.mask)
+	if err != nil {
+		return nil, err
+	}
+
 	ic := helper.NewInstanceConfig()
 	err = ic.JsonDecode()
 	if err != nil {
@@ -108,8 +121,9 @@ func (pop *PeelOptions) Run(ctx context.Context, logger *vlog.Logger) ([]byte, e
 	children = append(children, &model.ChildReceipt{
 		Key: pop.id,
 		Value: &model.ChildResp{
-			Found: b,
-			Datum: decrypted,
+			Found:    b,
+			Datum:    policy.Apply(decrypted),
+			Revision: token.Data[0].Revision,
 		},
 	})
 
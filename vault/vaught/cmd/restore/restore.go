@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	intbackup "github.com/dark-enstein/vault/internal/backup"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/backup"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	FlagIn    = "in"
+	FlagForce = "force"
+)
+
+type RestoreOptions struct {
+	in         string
+	passphrase string
+	force      bool
+	debug      bool
+}
+
+// NewRestoreCmd represents the cli command for restoring the vault from a backup archive
+func NewRestoreCmd() *cobra.Command {
+
+	rop := &RestoreOptions{}
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restores the vault from an encrypted backup archive",
+		Long: `The 'restore' command decrypts a backup archive created by 'vault backup', verifies its integrity,
+and rebuilds the configured store from it, whichever backend it is. The cipher stored in the archive replaces the local cipher, so restored tokens can be peeled as before.
+
+Usage:
+
+  vault restore --in <path to archive> [ --passphrase <passphrase> ] [ --force ]
+
+If '--passphrase' isn't set, the passphrase is read from the VAULT_BACKUP_PASSPHRASE environment variable.
+Without '--force', restore refuses to override existing keys, or to write into a non-empty store that uses a different cipher. With '--force', the archive replaces the contents of the store. Either way, the store is left as it was if the restore fails.
+
+Examples:
+Restore the vault into a freshly initialized store:
+  VAULT_BACKUP_PASSPHRASE=<passphrase> vault restore --in ./vault.bak`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			rop.debug = debug
+
+			n, err := rop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+
+			fmt.Printf("Restored %d entries from %s\n", n, rop.in)
+		},
+	}
+
+	restoreCmd.Flags().StringVarP(&rop.in, FlagIn, "i", "", "specify the backup archive to restore from")
+	restoreCmd.Flags().StringVarP(&rop.passphrase, backup.FlagPassphrase, "p", "", "specify the passphrase used to encrypt the archive")
+	restoreCmd.Flags().BoolVar(&rop.force, FlagForce, false, "replace the contents of the store with the archive")
+	restoreCmd.MarkFlagRequired(FlagIn)
+	return restoreCmd
+}
+
+func (rop *RestoreOptions) Run(ctx context.Context, logger *vlog.Logger) (int, error) {
+	fmt.Println("Restoring vault from", rop.in)
+	var err error
+
+	passphrase, err := backup.Passphrase(rop.passphrase)
+	if err != nil {
+		return 0, err
+	}
+
+	sealed, err := os.ReadFile(rop.in)
+	if err != nil {
+		logger.Logger().Error().Msgf("error reading backup archive %s: %s", rop.in, err)
+		return 0, err
+	}
+
+	archive, err := intbackup.Open(sealed, []byte(passphrase))
+	if err != nil {
+		logger.Logger().Error().Msgf("error opening backup archive: %s", err)
+		return 0, err
+	}
+
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return 0, err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return 0, err
+	}
+
+	err = archive.Restore(ctx, manager, intbackup.RestoreOptions{Force: rop.force})
+	if err != nil {
+		logger.Logger().Error().Msgf("error restoring backup archive: %s", err)
+		return 0, err
+	}
+
+	return len(archive.Entries), nil
+}
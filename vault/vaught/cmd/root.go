@@ -5,20 +5,33 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/dark-enstein/vault/internal/namespace"
+	"github.com/dark-enstein/vault/vaught/cmd/backup"
 	del "github.com/dark-enstein/vault/vaught/cmd/delete"
+	"github.com/dark-enstein/vault/vaught/cmd/execer"
+	"github.com/dark-enstein/vault/vaught/cmd/export"
+	"github.com/dark-enstein/vault/vaught/cmd/generate"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/dark-enstein/vault/vaught/cmd/importer"
 	"github.com/dark-enstein/vault/vaught/cmd/initer"
 	"github.com/dark-enstein/vault/vaught/cmd/list"
+	"github.com/dark-enstein/vault/vaught/cmd/migrate"
 	"github.com/dark-enstein/vault/vaught/cmd/peek"
 	"github.com/dark-enstein/vault/vaught/cmd/peel"
+	"github.com/dark-enstein/vault/vaught/cmd/restore"
 	"github.com/dark-enstein/vault/vaught/cmd/service"
 	"github.com/dark-enstein/vault/vaught/cmd/store"
+	tmpl "github.com/dark-enstein/vault/vaught/cmd/template"
+	"github.com/dark-enstein/vault/vaught/cmd/transform"
+	"github.com/dark-enstein/vault/vaught/cmd/wrap"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
 const (
-	FlagDebug = "debug"
+	FlagDebug     = "debug"
+	FlagNamespace = "namespace"
 )
 
 type RootOptions struct {
@@ -59,6 +72,36 @@ Examples:
   - List all stored tokens:
     vault list
 
+  - Import secrets in bulk from a dotenv, JSON or CSV file:
+    vault import --id "myapp" --file .env
+
+  - Export the decrypted secrets of an ID as env, dotenv, JSON or a Kubernetes Secret:
+    vault export --id "myapp" --format k8s-secret --out secret.yaml
+
+  - Run a command with the secrets of an ID injected as environment variables:
+    vault exec --id "myapp" -- ./server
+
+  - Tokenize the fields of a JSON document selected by JSONPath, keeping its structure:
+    vault transform --id "order-17" --path '$.customer.email' --in order.json
+
+  - Generate a database password in the vault, keeping only its token:
+    vault generate --id "myapp" --key "db/password" --type password --length 40 --symbols
+
+  - Hand a secret off once, under a single-use wrapping token, and unwrap it:
+    vault wrap --id "myapp" --key "db/password" --ttl 10m
+    vault unwrap --token "<wrapping token>"
+
+  - Render a config template referencing vault secrets, e.g. {{ vault "db" "password" }}:
+    vault template render -i app.tmpl -o app.conf
+
+  - Operate in a namespace, isolated from the keys of other teams:
+    vault store --namespace payments --id "myTokenID" --secret <sensitive value>
+    vault list --namespace payments
+
+  - Back up the whole vault into an encrypted archive, and restore it:
+    vault backup --out ./vault.bak
+    vault restore --in ./vault.bak
+
   To run vault as a service:
     vault service run [--port <port>]
 
@@ -75,7 +118,22 @@ Use "vault [command] --help" for more information about a command.`,
 	rootCmd.AddCommand(list.NewListCmd())
 	rootCmd.AddCommand(del.NewDeleteCmd())
 	rootCmd.AddCommand(initer.NewInitCmd())
+	rootCmd.AddCommand(importer.NewImportCmd())
+	rootCmd.AddCommand(export.NewExportCmd())
+	rootCmd.AddCommand(execer.NewExecCmd())
+	rootCmd.AddCommand(tmpl.NewTemplateCmd())
+	rootCmd.AddCommand(transform.NewTransformCmd())
+	rootCmd.AddCommand(generate.NewGenerateCmd())
+	rootCmd.AddCommand(wrap.NewWrapCmd())
+	rootCmd.AddCommand(wrap.NewUnwrapCmd())
+	rootCmd.AddCommand(backup.NewBackupCmd())
+	rootCmd.AddCommand(restore.NewRestoreCmd())
+	rootCmd.AddCommand(migrate.NewMigrateCmd())
 	rootCmd.PersistentFlags().BoolVarP(&rop.debug, FlagDebug, "d", false, "Enable or disable debug mode.")
+	rootCmd.PersistentFlags().StringVar(&helper.Namespace, FlagNamespace, "", "Specify the namespace to operate in, whose keys and data key are isolated from other namespaces. Defaults to the root namespace.")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return namespace.Validate(helper.Namespace)
+	}
 
 	return rootCmd
 }
@@ -0,0 +1,189 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package tmpl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/render"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	FlagIn       = "in"
+	FlagOut      = "out"
+	FlagMode     = "mode"
+	FlagWatch    = "watch"
+	FlagInterval = "interval"
+	FlagDryRun   = "dry-run"
+)
+
+var (
+	ErrOutRequired = errors.New("--out is required when rendering, unless --dry-run is set")
+)
+
+type RenderOptions struct {
+	in       string
+	out      string
+	mode     string
+	watch    bool
+	interval time.Duration
+	dryRun   bool
+	debug    bool
+}
+
+// newRenderCmd represents the template render command
+func newRenderCmd() *cobra.Command {
+
+	rop := &RenderOptions{}
+
+	renderCmd := &cobra.Command{
+		Use:   "render",
+		Short: "Renders a template, resolving its vault references",
+		Long: `The 'render' command resolves every vault reference in a template through the vault, and writes the result to a file.
+The file is written atomically, with the permissions given by --mode (0600 by default), so secrets are never readable by other users.
+
+With --watch, the command keeps running and re-renders the file whenever one of the referenced tokens is patched.
+With --dry-run, the command only reports which references resolve, without decrypting anything or writing the file.
+
+Usage:
+
+  vault template render --in <template> --out <path> [ --mode <octal permissions> ] [ --watch [ --interval <duration> ] ] [ --dry-run ]
+
+Examples:
+Render a config file:
+  vault template render -i app.tmpl -o app.conf
+
+Check a template before deploying it:
+  vault template render -i app.tmpl --dry-run
+
+Keep a config file up to date:
+  vault template render -i app.tmpl -o app.conf --watch --interval 10s`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			rop.debug = debug
+
+			// stop watching on interrupt
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			err = rop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Println("config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+		},
+	}
+
+	renderCmd.Flags().StringVarP(&rop.in, FlagIn, "i", "", "specify the template to render")
+	renderCmd.Flags().StringVarP(&rop.out, FlagOut, "o", "", "specify the file the rendered template is written to")
+	renderCmd.Flags().StringVarP(&rop.mode, FlagMode, "m", "0600", "specify the permissions of the rendered file, in octal")
+	renderCmd.Flags().BoolVarP(&rop.watch, FlagWatch, "w", false, "re-render the file whenever a referenced token is patched")
+	renderCmd.Flags().DurationVar(&rop.interval, FlagInterval, 5*time.Second, "specify how often referenced tokens are checked for changes in watch mode")
+	renderCmd.Flags().BoolVar(&rop.dryRun, FlagDryRun, false, "only report which references resolve")
+	renderCmd.MarkFlagRequired(FlagIn)
+	renderCmd.MarkFlagsMutuallyExclusive(FlagDryRun, FlagWatch)
+	return renderCmd
+}
+
+func (rop *RenderOptions) Run(ctx context.Context, logger *vlog.Logger) error {
+	var err error
+
+	if len(rop.out) == 0 && !rop.dryRun {
+		return ErrOutRequired
+	}
+	mode, err := strconv.ParseUint(rop.mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid --mode %s: %w", rop.mode, err)
+	}
+
+	src, err := os.ReadFile(rop.in)
+	if err != nil {
+		return err
+	}
+
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return err
+	}
+
+	t, err := render.New(rop.in, string(src), manager)
+	if err != nil {
+		return err
+	}
+
+	if rop.dryRun {
+		return rop.report(ctx, t)
+	}
+
+	write := func() error {
+		rendered, err := t.Render(ctx)
+		if err != nil {
+			return err
+		}
+		if err = render.WriteFile(rop.out, rendered, os.FileMode(mode)); err != nil {
+			return err
+		}
+		logger.Logger().Info().Msgf("rendered %s to %s", rop.in, rop.out)
+		return nil
+	}
+
+	if err = write(); err != nil {
+		return err
+	}
+	if !rop.watch {
+		return nil
+	}
+
+	logger.Logger().Info().Msgf("watching references of %s every %s", rop.in, rop.interval)
+	return t.Watch(ctx, rop.interval, write)
+}
+
+// report prints the resolution status of every reference in the template
+func (rop *RenderOptions) report(ctx context.Context, t *render.Template) error {
+	statuses, err := t.DryRun(ctx)
+	if err != nil {
+		return err
+	}
+
+	jsonByte, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonByte))
+
+	for _, status := range statuses {
+		if !status.Resolves {
+			return fmt.Errorf("%w: %s", render.ErrRefUnresolved, tokenize.GetCombinedKey(append([]string{status.Ref.ID}, status.Ref.Keys...)...))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package tmpl
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// NewTemplateCmd represents the template command
+func NewTemplateCmd() *cobra.Command {
+	templateCmd := &cobra.Command{
+		Use:   "template",
+		Short: "Renders config files from templates referencing vault secrets",
+		Long: `Manages config templates that reference secrets in the vault, so templates can be kept in git while the secrets stay in the vault.
+
+References use the 'vault' template function, taking an ID followed by one or more child keys:
+
+  password = {{ vault "db" "password" }}
+
+Examples of usage include:
+
+- Rendering a template into a config file
+- Checking which references in a template resolve, without decrypting anything
+- Re-rendering a config file whenever one of its secrets is patched`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	templateCmd.AddCommand(newRenderCmd())
+	return templateCmd
+}
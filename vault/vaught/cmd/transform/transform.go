@@ -0,0 +1,153 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package transform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/transform"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+const (
+	FlagID         = "id"
+	FlagPath       = "path"
+	FlagIn         = "in"
+	FlagOut        = "out"
+	FlagDetokenize = "detokenize"
+	// Stdio reads from stdin, or writes to stdout
+	Stdio = "-"
+)
+
+type TransformOptions struct {
+	id         string
+	paths      []string
+	in         string
+	out        string
+	detokenize bool
+	debug      bool
+}
+
+// NewTransformCmd represents the cli command for tokenizing the fields of JSON documents selected by JSONPath
+func NewTransformCmd() *cobra.Command {
+
+	top := &TransformOptions{}
+
+	transformCmd := &cobra.Command{
+		Use:   "transform",
+		Short: "Tokenizes, or detokenizes, the fields of a JSON document selected by JSONPath",
+		Long: `The 'transform' command reads a JSON document, and replaces the fields selected by the JSONPath expressions with their tokens, keeping the rest of the document as it is. With --detokenize, it replaces the tokens of the selected fields with their values instead.
+
+The tokens are stored under the document's ID and the location of each field, e.g. order-17/customer/email. Selecting an object or an array transforms every value nested in it. Numbers and booleans are tokenized as their JSON text, and so come back as strings. Either every selected field is transformed, or none is.
+
+Supported JSONPath expressions: $.a.b, $['a/b'], $.items[0], $.items[-1], $.items[*].card, $..email
+
+Usage:
+
+  vault transform --id <id> --path <jsonpath> [--path <jsonpath>...] [--in <file>] [--out <file>] [--detokenize]
+
+Examples:
+Tokenize the email and card numbers of an order:
+  vault transform --id order-17 --path '$.customer.email' --path '$.items[*].card' --in order.json --out order.tokenized.json
+
+Reveal them again:
+  vault transform --id order-17 --path '$.customer.email' --path '$.items[*].card' --detokenize < order.tokenized.json`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Resolve persistent flags
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			top.debug = debug
+
+			err = top.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Fprintln(os.Stderr, "config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+		},
+	}
+
+	transformCmd.Flags().StringVarP(&top.id, FlagID, "i", "", "specify the ID of the document, under which its fields are stored")
+	transformCmd.Flags().StringArrayVarP(&top.paths, FlagPath, "p", nil, "specify a JSONPath selecting fields to transform. repeat for more fields")
+	transformCmd.Flags().StringVarP(&top.in, FlagIn, "f", Stdio, "specify the file to read the document from, or - for stdin")
+	transformCmd.Flags().StringVarP(&top.out, FlagOut, "o", Stdio, "specify the file to write the transformed document to, or - for stdout")
+	transformCmd.Flags().BoolVar(&top.detokenize, FlagDetokenize, false, "detokenize the selected fields instead of tokenizing them")
+	transformCmd.MarkFlagRequired(FlagID)
+	transformCmd.MarkFlagRequired(FlagPath)
+	return transformCmd
+}
+
+func (top *TransformOptions) Run(ctx context.Context, logger *vlog.Logger) error {
+	var err error
+
+	// check the paths before anything is read
+	paths, err := transform.ParsePaths(top.paths)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if top.in != Stdio {
+		f, err := os.Open(top.in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	doc, err := transform.Decode(in)
+	if err != nil {
+		return fmt.Errorf("error decoding document: %w", err)
+	}
+
+	ic := helper.NewInstanceConfig()
+	err = ic.JsonDecode()
+	if err != nil {
+		return err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return err
+	}
+
+	var fields []string
+	if top.detokenize {
+		fields, err = transform.Detokenize(ctx, manager, top.id, doc, paths)
+	} else {
+		fields, err = transform.Tokenize(ctx, manager, top.id, doc, paths)
+	}
+	if err != nil {
+		logger.Logger().Error().Msgf("error transforming document %s: %s", top.id, err)
+		return err
+	}
+	logger.Logger().Debug().Msgf("transformed %d fields of document %s", len(fields), top.id)
+
+	var out bytes.Buffer
+	if err = transform.Encode(&out, doc); err != nil {
+		return err
+	}
+	if top.out == Stdio {
+		_, err = os.Stdout.Write(out.Bytes())
+		return err
+	}
+
+	// detokenized documents hold plaintext secrets, so keep them private
+	return os.WriteFile(top.out, out.Bytes(), 0600)
+}
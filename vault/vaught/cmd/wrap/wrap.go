@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 Ayobami Bamigboye <ayo@greystein.com>
+*/
+package wrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/vault/internal/keys"
+	"github.com/dark-enstein/vault/internal/tokenize"
+	"github.com/dark-enstein/vault/internal/vlog"
+	"github.com/dark-enstein/vault/internal/wrap"
+	"github.com/dark-enstein/vault/vaught/cmd/helper"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+const (
+	FlagID    = "id"
+	FlagKey   = "key"
+	FlagTTL   = "ttl"
+	FlagToken = "token"
+)
+
+type WrapOptions struct {
+	id    string
+	key   string
+	ttl   time.Duration
+	debug bool
+}
+
+type UnwrapOptions struct {
+	token string
+	debug bool
+}
+
+// NewWrapCmd represents the cli command for handing a secret off under a single-use wrapping token
+func NewWrapCmd() *cobra.Command {
+
+	wop := &WrapOptions{}
+
+	wrapCmd := &cobra.Command{
+		Use:   "wrap",
+		Short: "Hands a secret off once, under a single-use wrapping token",
+		Long: `The 'wrap' command reads the value of an ID, or of a key under it, and wraps it under a random wrapping token, which it prints. The token can be unwrapped exactly once, before its TTL runs out, with 'vault unwrap' or the /v1/unwrap endpoint of the service; after that, it is useless.
+
+Hand the wrapping token to a person or a CI job instead of the secret itself: if it was intercepted and used, the intended recipient finds out when unwrapping fails.
+
+Usage:
+
+  vault wrap --id <id> [--key <key>] [--ttl <duration>]
+
+Examples:
+Wrap a token stored with 'vault store', for the default 5 minutes:
+  vault wrap --id mytoken
+
+Wrap the database password of an app for 10 minutes:
+  vault wrap --id myapp --key db/password --ttl 10m`,
+		Run: func(cmd *cobra.Command, args []string) {
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			wop.debug = debug
+
+			err = wop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Fprintln(os.Stderr, "config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				log.Fatal().Msgf("%s", err)
+			}
+		},
+	}
+
+	wrapCmd.Flags().StringVarP(&wop.id, FlagID, "i", "", "specify the ID the key is stored under")
+	wrapCmd.Flags().StringVarP(&wop.key, FlagKey, "k", "", "specify the key under the ID whose value to wrap, if the token isn't stored by ID alone")
+	wrapCmd.Flags().DurationVarP(&wop.ttl, FlagTTL, "t", wrap.DefaultTTL, "specify how long the wrapping token is valid for, up to 24h")
+	wrapCmd.MarkFlagRequired(FlagID)
+	return wrapCmd
+}
+
+func (wop *WrapOptions) Run(ctx context.Context, logger *vlog.Logger) error {
+	ic := helper.NewInstanceConfig()
+	err := ic.JsonDecode()
+	if err != nil {
+		return err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return err
+	}
+
+	// a token stored by ID alone, as with 'vault store', has no key
+	key, err := keys.Normalize(wop.id)
+	if wop.key != "" {
+		key, err = tokenize.ChildKey(wop.id, wop.key)
+	}
+	if err != nil {
+		return err
+	}
+	token, _, err := manager.GetToken(ctx, key)
+	if err != nil {
+		return fmt.Errorf("error retrieving token of %s: %w", key, err)
+	}
+	_, value, err := manager.Detokenize(ctx, key, token)
+	if err != nil {
+		return err
+	}
+
+	wrapToken, wrapped, err := wrap.Wrap(ctx, manager, wrap.Wrapped{ID: wop.id, Key: wop.key, Value: value}, wop.ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrapping token valid until %s, for a single unwrap:\n", wrapped.Expires.Format(time.RFC3339))
+	fmt.Println(wrapToken)
+	return nil
+}
+
+// NewUnwrapCmd represents the cli command for unwrapping a secret handed off with wrap
+func NewUnwrapCmd() *cobra.Command {
+
+	uop := &UnwrapOptions{}
+
+	unwrapCmd := &cobra.Command{
+		Use:   "unwrap",
+		Short: "Reveals a secret handed off under a wrapping token, once",
+		Long: `The 'unwrap' command prints the value wrapped under a wrapping token, and deletes it, so no one can unwrap it again. Unwrapping an expired or already unwrapped token fails.
+
+Usage:
+
+  vault unwrap --token <wrapping token>
+
+Examples:
+Unwrap a password into an environment variable:
+  DB_PASSWORD=$(vault unwrap --token "$WRAP_TOKEN")`,
+		Run: func(cmd *cobra.Command, args []string) {
+			debug, err := cmd.Flags().GetBool("debug")
+			if err != nil {
+				log.Error().Msgf("error retrieving persistent flag: %s: %s", "debug", err)
+			}
+			logger := vlog.New(debug)
+			ctx := context.Background()
+			uop.debug = debug
+
+			err = uop.Run(ctx, logger)
+			if err != nil {
+				if errors.Is(err, helper.ErrConfigEmpty) || errors.Is(err, helper.ErrStoreTypeEmpty) {
+					fmt.Fprintln(os.Stderr, "config empty run `vault init` first. see more by running `vault init --help`")
+					os.Exit(1)
+				}
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	unwrapCmd.Flags().StringVarP(&uop.token, FlagToken, "t", "", "specify the wrapping token to unwrap")
+	unwrapCmd.MarkFlagRequired(FlagToken)
+	return unwrapCmd
+}
+
+func (uop *UnwrapOptions) Run(ctx context.Context, logger *vlog.Logger) error {
+	ic := helper.NewInstanceConfig()
+	err := ic.JsonDecode()
+	if err != nil {
+		return err
+	}
+
+	// initialize token manager
+	manager, err := ic.Manager(ctx)
+	if err != nil {
+		logger.Logger().Debug().Msgf("error initializing token manager: %s", err)
+		return err
+	}
+
+	wrapped, err := wrap.Unwrap(ctx, manager, uop.token)
+	if err != nil {
+		return err
+	}
+	logger.Logger().Debug().Msgf("unwrapped %s/%s", wrapped.ID, wrapped.Key)
+	fmt.Println(wrapped.Value)
+	return nil
+}